@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"biblio/internal/export"
+	"biblio/internal/repository"
+)
+
+// runExport - команда "biblio export": выгружает каталог в файл или в стандартный вывод.
+// Фильтры те же, что у выгрузки из панели администратора
+func runExport(ctx context.Context, repo *repository.Repository, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "marc21", "формат: marc21, marcxml, dc, csv или jsonl")
+	output := fs.String("o", "", "файл выгрузки, по умолчанию стандартный вывод")
+	var f repository.BookFilter
+	fs.StringVar(&f.Category, "category", "", "жанр")
+	fs.StringVar(&f.Author, "author", "", "автор")
+	fs.StringVar(&f.Series, "series", "", "серия")
+	fs.StringVar(&f.Name, "name", "", "название")
+	fs.StringVar(&f.Query, "q", "", "поиск по названию, автору, серии и аннотации")
+	fs.StringVar(&f.Sort, "sort", "", "поле сортировки, с минусом впереди - по убыванию")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w, err := export.NewWriter(*format, out)
+	if err != nil {
+		return
+	}
+	err = repo.EachBook(ctx, f, w.Write)
+	if err != nil {
+		return fmt.Errorf("failed to export books: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"biblio/internal/importer"
+	"biblio/internal/repository"
+)
+
+// runImport - команда "biblio import": загружает книги из файла MARC21, MARCXML, CSV или из каталога
+// библиотеки Calibre. Без -apply только печатает план
+func runImport(ctx context.Context, repo *repository.Repository, args []string) (err error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "marc21", "источник: marc21, marcxml, csv или calibre")
+	rule := fs.String("rule", "skip", "если книга уже есть в каталоге и отличается: skip, update или duplicate")
+	category := fs.String("category", "", "жанр для записей, рубрики которых не совпали с жанрами каталога")
+	access := fs.String("access", "Нет", "доступ к новым книгам с файлом, если источник его не задает")
+	mapping := fs.String("map", "", "колонки CSV: поле=заголовок или номер колонки через запятую, например name=Название,author=2")
+	apply := fs.Bool("apply", false, "выполнить импорт, а не только показать план")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Использование: biblio import [флаги] <файл или каталог Calibre>")
+		fs.PrintDefaults()
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("source is required")
+	}
+	source := fs.Arg(0)
+
+	r, ok := importer.ParseRule(*rule)
+	if !ok {
+		return fmt.Errorf("unknown rule %q", *rule)
+	}
+
+	var records []importer.Record
+	if *format == "calibre" {
+		records, err = importer.ReadCalibre(source)
+	} else {
+		records, err = readImportFile(*format, source, *mapping)
+	}
+	if err != nil {
+		return
+	}
+
+	x, err := importer.LoadIndex(ctx, repo)
+	if err != nil {
+		return
+	}
+	items := importer.Plan(records, x, importer.Options{Rule: r, Category: *category, Access: *access})
+	for _, it := range items {
+		author, name := it.Book.Author, it.Book.Name
+		if author == "" && name == "" {
+			author, name = it.Record.Author, it.Record.Name
+		}
+		fmt.Printf("%d\t%s\t%s\t%s", it.Record.Line, it.Action, author, name)
+		if len(it.Changes) > 0 {
+			fmt.Printf("\t[%s]", strings.Join(it.Changes, ", "))
+		}
+		if len(it.Notes) > 0 {
+			fmt.Printf("\t%s", strings.Join(it.Notes, "; "))
+		}
+		fmt.Println()
+	}
+	fmt.Println("План:", importer.Summarize(items))
+	if !*apply {
+		return
+	}
+
+	s, err := importer.Apply(ctx, repo, items, nil)
+	fmt.Println("Итог:", s.Applied())
+	return
+}
+
+func readImportFile(format, path, mapping string) (records []importer.Record, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	switch format {
+	case "marc21":
+		return importer.ReadMARC(bytes.NewReader(data))
+	case "marcxml":
+		return importer.ReadMARCXML(bytes.NewReader(data))
+	case "csv":
+		columns, err := importer.CSVColumns(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		m := importer.GuessMapping(columns)
+		if mapping != "" {
+			m, err = parseMapping(mapping, columns)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return importer.ReadCSV(data, m)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// parseMapping разбирает -map: колонка задается заголовком или номером с единицы
+func parseMapping(s string, columns []string) (importer.Mapping, error) {
+	m := make(importer.Mapping)
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		known := false
+		for _, f := range importer.Fields {
+			known = known || f == field
+		}
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid mapping %q, fields: %s", pair, strings.Join(importer.Fields, ", "))
+		}
+		if n, err := strconv.Atoi(column); err == nil && n >= 1 && n <= len(columns) {
+			m[field] = n - 1
+			continue
+		}
+		found := false
+		for i, c := range columns {
+			if !found && strings.EqualFold(strings.TrimSpace(c), column) {
+				m[field], found = i, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no csv column %q", column)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,211 @@
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// baseURL используется в ссылках из писем, задается переменной BIBLIO_BASE_URL
+var baseURL = strings.TrimRight(envOr("BIBLIO_BASE_URL", "http://localhost:9090"), "/")
+
+const (
+	verifyTokenTTL = 72 * time.Hour
+	resetTokenTTL  = time.Hour
+	// письмо для смены пароля уходит на один адрес не чаще resetMailInterval,
+	// а с одного IP-адреса запросы принимаются не чаще resetIPInterval
+	resetMailInterval = 5 * time.Minute
+	resetIPInterval   = 30 * time.Second
+)
+
+// userTokenStore хранит хеши одноразовых токенов. ConsumeUserToken принимает токен один раз и только до срока
+type userTokenStore interface {
+	AddUserToken(ctx context.Context, tokenHash, userId string, purpose string, expires time.Time) error
+	ConsumeUserToken(ctx context.Context, tokenHash string, purpose string) (userId string, err error)
+}
+
+// issueUserToken выдает подписанный одноразовый токен. В базе хранится только его хеш,
+// поэтому утечка таблицы user_tokens не дает действующих ссылок
+func (a app) issueUserToken(userId string, purpose string, ttl time.Duration) (token string, err error) {
+	nonce := randomHex(16)
+	token = nonce + "." + userTokenSign(purpose, nonce)
+
+	err = a.userTokens.AddUserToken(a.ctx, userTokenHash(token), userId, purpose, time.Now().Add(ttl))
+	return
+}
+
+func (a app) consumeUserToken(token string, purpose string) (userId string, err error) {
+	nonce, sign, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sign), []byte(userTokenSign(purpose, nonce))) {
+		err = errors.New("invalid token signature")
+		return
+	}
+
+	return a.userTokens.ConsumeUserToken(a.ctx, userTokenHash(token), purpose)
+}
+
+func userTokenSign(purpose, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(purpose + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func userTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (a app) sendVerification(user repository.User) (err error) {
+	token, err := a.issueUserToken(user.User_Id.String(), string(repository.VERIFY_EMAIL), verifyTokenTTL)
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("Здравствуйте, %s!\n\nПодтвердите адрес электронной почты, перейдя по ссылке:\n%s/verify?token=%s\n\nСсылка действует %v.",
+		user.FullName, baseURL, url.QueryEscape(token), verifyTokenTTL)
+	return a.mailer.Send(a.ctx, user.Email, "Изба - читальня: подтверждение адреса", body)
+}
+
+func (a app) VerifyEmail(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userId, err := a.consumeUserToken(r.URL.Query().Get("token"), string(repository.VERIFY_EMAIL))
+	if err != nil {
+		a.LoginPage(rw, r, "Ссылка недействительна или устарела")
+		return
+	}
+
+	err = a.repo.SetEmailVerified(a.ctx, userId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.LoginPage(rw, r, "Адрес электронной почты подтвержден")
+}
+
+func (a app) ResetPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "reset.html")
+
+	tmpl, err := parseTemplates(r, lp, head)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Message string
+	}
+	data := answer{message}
+
+	err = tmpl.ExecuteTemplate(rw, "reset", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) RequestReset(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	login := strings.TrimSpace(r.FormValue("login"))
+	if login == "" {
+		a.ResetPage(rw, r, "Укажите логин или адрес электронной почты")
+		return
+	}
+
+	if !a.guard.allowReset("ip:"+clientIP(r), resetIPInterval) {
+		a.ResetPage(rw, r, "Слишком частые запросы, повторите через минуту")
+		return
+	}
+
+	// ответ не зависит от того, найден ли пользователь, чтобы не раскрывать существующие логины
+	const sent = "Если такой пользователь существует и его адрес подтвержден, на него отправлено письмо со ссылкой для смены пароля"
+
+	user, err := a.repo.GetUserByLoginOrEmail(a.ctx, login)
+	if err != nil || !user.Active || user.Email == "" || !user.EmailVerified {
+		a.ResetPage(rw, r, sent)
+		return
+	}
+	// повторный запрос на тот же адрес молча не отправляется, чтобы через форму нельзя было завалить ящик письмами
+	if !a.guard.allowReset("mail:"+strings.ToLower(user.Email), resetMailInterval) {
+		a.ResetPage(rw, r, sent)
+		return
+	}
+
+	token, err := a.issueUserToken(user.User_Id.String(), string(repository.RESET_PASSWORD), resetTokenTTL)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := fmt.Sprintf("Здравствуйте, %s!\n\nДля смены пароля перейдите по ссылке:\n%s/reset/confirm?token=%s\n\nСсылка действует %v. Если вы не запрашивали смену пароля, просто удалите это письмо.",
+		user.FullName, baseURL, url.QueryEscape(token), resetTokenTTL)
+	err = a.mailer.Send(a.ctx, user.Email, "Изба - читальня: смена пароля", body)
+	if err != nil {
+		log.Println(err)
+	}
+
+	a.ResetPage(rw, r, sent)
+}
+
+func (a app) ResetConfirmPage(rw http.ResponseWriter, r *http.Request, token, message string) {
+	lp := filepath.Join("public", "html", "reset-confirm.html")
+
+	tmpl, err := parseTemplates(r, lp, head)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Token   string
+		Message string
+	}
+	data := answer{token, message}
+
+	err = tmpl.ExecuteTemplate(rw, "reset-confirm", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) ResetConfirm(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	token := r.FormValue("token")
+	password := strings.TrimSpace(r.FormValue("password"))
+	password2 := strings.TrimSpace(r.FormValue("password2"))
+
+	if password == "" || password != password2 {
+		a.ResetConfirmPage(rw, r, token, "Пароли не совпадают или не заполнены! Попробуйте еще")
+		return
+	}
+
+	userId, err := a.consumeUserToken(token, string(repository.RESET_PASSWORD))
+	if err != nil {
+		a.ResetPage(rw, r, "Ссылка недействительна или устарела, запросите новую")
+		return
+	}
+
+	err = a.repo.SetPassword(a.ctx, userId, hashPassword(password))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// пароль меняют и тогда, когда учетную запись украли: все сеансы со старым паролем завершаются
+	a.sessions.removeUser(userId, "")
+	if user, err := a.repo.GetUserById(a.ctx, userId); err == nil {
+		a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_PASSWORD_RESET}, user))
+	}
+
+	a.LoginPage(rw, r, "Пароль изменен, теперь можно войти с новым паролем")
+}
@@ -0,0 +1,95 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+// memUserTokens - userTokenStore в памяти с теми же правилами, что и запрос в ConsumeUserToken
+type memUserTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*memUserToken
+}
+
+type memUserToken struct {
+	userId, purpose string
+	expires         time.Time
+	used            bool
+}
+
+func (m *memUserTokens) AddUserToken(ctx context.Context, tokenHash, userId string, purpose string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens[tokenHash] = &memUserToken{userId: userId, purpose: purpose, expires: expires}
+	return nil
+}
+
+func (m *memUserTokens) ConsumeUserToken(ctx context.Context, tokenHash string, purpose string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[tokenHash]
+	if !ok || t.used || t.purpose != purpose || !time.Now().Before(t.expires) {
+		return "", errors.New("failed to query data: no rows in result set")
+	}
+	t.used = true
+	return t.userId, nil
+}
+
+func tokenApp() app {
+	return app{ctx: context.Background(), userTokens: &memUserTokens{tokens: map[string]*memUserToken{}}}
+}
+
+func TestUserTokenSingleUse(t *testing.T) {
+	a := tokenApp()
+	for _, purpose := range []string{string(repository.VERIFY_EMAIL), string(repository.RESET_PASSWORD)} {
+		token, err := a.issueUserToken("user-1", purpose, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := a.consumeUserToken(token, purpose); err != nil || id != "user-1" {
+			t.Fatalf("%s: first use: %q, %v", purpose, id, err)
+		}
+		if _, err := a.consumeUserToken(token, purpose); err == nil {
+			t.Errorf("%s: token accepted twice", purpose)
+		}
+	}
+}
+
+func TestUserTokenRejected(t *testing.T) {
+	a := tokenApp()
+
+	expired, _ := a.issueUserToken("user-1", string(repository.RESET_PASSWORD), -time.Second)
+	if _, err := a.consumeUserToken(expired, string(repository.RESET_PASSWORD)); err == nil {
+		t.Error("expired token accepted")
+	}
+
+	verify, _ := a.issueUserToken("user-1", string(repository.VERIFY_EMAIL), time.Hour)
+	if _, err := a.consumeUserToken(verify, string(repository.RESET_PASSWORD)); err == nil {
+		t.Error("verification token accepted for password reset")
+	}
+
+	token, _ := a.issueUserToken("user-1", string(repository.RESET_PASSWORD), time.Hour)
+	nonce, sign, _ := strings.Cut(token, ".")
+	for name, bad := range map[string]string{
+		"no signature":    nonce,
+		"other signature": nonce + "." + userTokenSign(string(repository.VERIFY_EMAIL), nonce),
+		"changed nonce":   strings.Repeat("0", len(nonce)) + "." + sign,
+		"changed sign":    nonce + "." + strings.ToUpper(sign),
+	} {
+		if _, err := a.consumeUserToken(bad, string(repository.RESET_PASSWORD)); err == nil {
+			t.Errorf("%s: tampered token accepted", name)
+		}
+	}
+	// неудачные попытки не расходуют настоящий токен
+	if _, err := a.consumeUserToken(token, string(repository.RESET_PASSWORD)); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+const (
+	apiDefaultPerPage = 20
+	apiMaxPerPage     = 100
+	apiMaxBody        = 1 << 20
+)
+
+// apiStore - запросы на чтение, которые делают обработчики API
+type apiStore interface {
+	FindBooks(ctx context.Context, f repository.BookFilter) ([]repository.Book, int, error)
+	GetBookVersion(ctx context.Context, id string) (repository.Book, int, error)
+	FindUsers(ctx context.Context, limit, offset int) ([]repository.User, int, error)
+}
+
+// apiError - тело любого ответа API с ошибкой: {"error": {...}}.
+// Fields заполняется при ошибках проверки и содержит сообщение для каждого неверного поля
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// apiList - страница списка
+type apiList struct {
+	Items   interface{} `json:"items"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func apiFail(rw http.ResponseWriter, status int, code, message string) {
+	writeJSON(rw, status, map[string]apiError{"error": {Code: code, Message: message}})
+}
+
+func apiInvalid(rw http.ResponseWriter, fields map[string]string) {
+	writeJSON(rw, http.StatusUnprocessableEntity, map[string]apiError{"error": {
+		Code:    "validation_failed",
+		Message: "Данные не прошли проверку",
+		Fields:  fields,
+	}})
+}
+
+// api - вариант withRole для API: пользователь определяется по токену или куке сессии,
+// ошибки возвращаются в формате API, а не переадресацией. Пустая роль - любой вошедший пользователь
+func (a *app) api(role UserRole, next httprouter.Handle) httprouter.Handle {
+	return a.apiScope(role, "", next)
+}
+
+// apiScope - api с областью токена, которая не зависит от метода запроса; пустая - по методу, как в api
+func (a *app) apiScope(role UserRole, scope string, next httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if raw := bearerToken(r); raw != "" {
+			req, status, message := a.tokenRequestScope(r, raw, scope)
+			switch status {
+			case http.StatusOK:
+				r = req
+			case http.StatusUnauthorized:
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="biblio"`)
+				apiFail(rw, status, "unauthorized", message)
+				return
+			default:
+				code := "forbidden"
+				if message == twoFactorRequired {
+					code = "two_factor_required"
+				}
+				apiFail(rw, status, code, message)
+				return
+			}
+		} else {
+			token, _ := readCookie("token", r)
+			sess, ok := a.sessions.get(token)
+			if !ok {
+				apiFail(rw, http.StatusUnauthorized, "unauthorized", "Требуется вход или API-токен")
+				return
+			}
+			if a.needsTwoFactor(sess.User) {
+				apiFail(rw, http.StatusForbidden, "two_factor_required", twoFactorRequired)
+				return
+			}
+			ctx := context.WithValue(r.Context(), "role", UserRole(sess.User.Role))
+			ctx = context.WithValue(ctx, "user", sess.User)
+			r = r.WithContext(ctx)
+		}
+
+		if role != "" && r.Context().Value("role").(UserRole) != role {
+			apiFail(rw, http.StatusForbidden, "forbidden", "Недостаточно прав")
+			return
+		}
+		next(rw, r, ps)
+	}
+}
+
+// OpenAPI отдает спецификацию API, ее можно посмотреть в /public/api/index.html
+func (a app) OpenAPI(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	http.ServeFile(rw, r, filepath.Join("public", "api", "openapi.json"))
+}
+
+// apiPage разбирает параметры page и per_page
+func apiPage(r *http.Request, fields map[string]string) (page, perPage int) {
+	page, perPage = 1, apiDefaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			fields["page"] = "Номер страницы должен быть положительным числом"
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			fields["per_page"] = "Размер страницы должен быть от 1 до " + strconv.Itoa(apiMaxPerPage)
+		}
+		perPage = n
+	}
+	return
+}
+
+// etag строит ETag по номеру версии записи
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// etagMatches проверяет заголовок If-Match или If-None-Match: список ETag через запятую или *
+func etagMatches(header, tag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == tag {
+			return true
+		}
+	}
+	return false
+}
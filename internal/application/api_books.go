@@ -0,0 +1,291 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// bookInput - книга в теле POST и PUT. book_id, даты создания и изменения и видимость задает сервер,
+// из тела они не читаются. Без publication новая книга публикуется сразу, а при замене дата не меняется
+type bookInput struct {
+	Category    string     `json:"category"`
+	Author      string     `json:"author"`
+	Series      string     `json:"series"`
+	Name        string     `json:"name"`
+	Annotation  string     `json:"annotation"`
+	Link        string     `json:"link"`
+	Access      string     `json:"access"`
+	Publication *time.Time `json:"publication"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// bookAccess - допустимые значения поля access, как в форме книги
+var bookAccess = []string{"Да", "Нет"}
+
+// readBook читает и проверяет книгу из тела запроса. publication - дата публикации, если ее нет в теле.
+// Если вернулось false, ответ уже отправлен
+func readBook(rw http.ResponseWriter, r *http.Request, publication time.Time) (b repository.Book, ok bool) {
+	var in bookInput
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, apiMaxBody)).Decode(&in)
+	if err != nil {
+		apiFail(rw, http.StatusBadRequest, "invalid_json", "Тело запроса должно быть объектом JSON: "+err.Error())
+		return
+	}
+
+	fields := make(map[string]string)
+	required := map[string]*string{
+		"category": &in.Category, "author": &in.Author, "series": &in.Series, "name": &in.Name,
+		"annotation": &in.Annotation, "link": &in.Link, "access": &in.Access,
+	}
+	for name, v := range required {
+		*v = strings.TrimSpace(*v)
+		if *v == "" {
+			fields[name] = "Обязательное поле"
+		}
+	}
+
+	for _, c := range repository.Categories {
+		if string(c) == in.Category {
+			b.Category = c
+		}
+	}
+	if in.Category != "" && b.Category == "" {
+		fields["category"] = "Неизвестный жанр"
+	}
+	if in.Access != "" {
+		known := false
+		for _, v := range bookAccess {
+			known = known || v == in.Access
+		}
+		if !known {
+			fields["access"] = "Допустимые значения: " + strings.Join(bookAccess, ", ")
+		}
+	}
+
+	b.Publication, b.UnpublishAt = publication, in.UnpublishAt
+	if in.Publication != nil {
+		b.Publication = *in.Publication
+	}
+	if b.UnpublishAt != nil && !b.UnpublishAt.After(b.Publication) {
+		fields["unpublish_at"] = errUnpublishOrder.Error()
+	}
+
+	if len(fields) > 0 {
+		apiInvalid(rw, fields)
+		return
+	}
+
+	b.Author, b.Series, b.Name = in.Author, in.Series, in.Name
+	b.Annotation, b.Link, b.Access = in.Annotation, in.Link, in.Access
+	return b, true
+}
+
+// findBook загружает книгу по :id. Скрытые от читателей книги находит только администратор.
+// Если вернулось false, ответ уже отправлен
+func (a app) findBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) (b repository.Book, version int, ok bool) {
+	id, err := uuid.Parse(p.ByName("id"))
+	if err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Книга не найдена")
+		return
+	}
+	b, version, err = a.apiRepo.GetBookVersion(a.ctx, id.String())
+	if err == nil && !b.Visible && r.Context().Value("role").(UserRole) != "ADMIN" {
+		err = pgx.ErrNoRows
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		apiFail(rw, http.StatusNotFound, "not_found", "Книга не найдена")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	return b, version, true
+}
+
+func (a app) listBooks(rw http.ResponseWriter, r *http.Request, f repository.BookFilter, fields map[string]string) {
+	page, perPage := apiPage(r, fields)
+
+	f.Sort = r.URL.Query().Get("sort")
+	if f.Sort != "" {
+		known := false
+		for _, s := range repository.BookSortFields {
+			known = known || s == strings.TrimPrefix(f.Sort, "-")
+		}
+		if !known {
+			fields["sort"] = "Сортировка возможна по полям: " + strings.Join(repository.BookSortFields, ", ")
+		}
+	}
+
+	if len(fields) > 0 {
+		apiInvalid(rw, fields)
+		return
+	}
+
+	f.Limit, f.Offset = perPage, (page-1)*perPage
+	admin := r.Context().Value("role").(UserRole) == "ADMIN"
+	f.Hidden = admin
+	books, total, err := a.apiRepo.FindBooks(a.ctx, f)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	var items interface{} = books
+	if !admin {
+		public := make([]publicBook, len(books))
+		for i, b := range books {
+			public[i] = publicBook{Book: b}
+		}
+		items = public
+	} else if books == nil {
+		items = []repository.Book{}
+	}
+
+	writeJSON(rw, http.StatusOK, apiList{Items: items, Total: total, Page: page, PerPage: perPage})
+}
+
+// APIBooks - GET /api/v1/books?category=&author=&series=&name=&sort=&page=&per_page=
+func (a app) APIBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := r.URL.Query()
+	a.listBooks(rw, r, repository.BookFilter{
+		Category: q.Get("category"),
+		Author:   q.Get("author"),
+		Series:   q.Get("series"),
+		Name:     q.Get("name"),
+	}, make(map[string]string))
+}
+
+// APISearch - GET /api/v1/search?q=, поиск по названию, автору, серии и аннотации
+func (a app) APISearch(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	fields := make(map[string]string)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		fields["q"] = "Обязательный параметр"
+	}
+	a.listBooks(rw, r, repository.BookFilter{Query: q}, fields)
+}
+
+func (a app) APIBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, version, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+
+	rw.Header().Set("ETag", etag(version))
+	if etagMatches(r.Header.Get("If-None-Match"), etag(version)) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Context().Value("role").(UserRole) != "ADMIN" {
+		writeJSON(rw, http.StatusOK, publicBook{Book: b})
+		return
+	}
+	writeJSON(rw, http.StatusOK, b)
+}
+
+func (a app) APICreateBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, ok := readBook(rw, r, time.Now())
+	if !ok {
+		return
+	}
+
+	b, version, err := a.repo.AddBook(a.ctx, b)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	a.events.publish(string(repository.EVENT_BOOK_CREATED), b)
+	a.visibilityChanged(false, b)
+	a.recordRevision(r, nil, repository.Revision{Action: repository.REVISION_CREATE, Book: b})
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_CREATE}, nil, &b))
+
+	rw.Header().Set("Location", "/api/v1/books/"+b.Book_Id.String())
+	rw.Header().Set("ETag", etag(version))
+	writeJSON(rw, http.StatusCreated, b)
+}
+
+// APIUpdateBook заменяет книгу целиком. Заголовок If-Match с ETag из последнего GET обязателен:
+// если книгу за это время изменили, ответ 412 и изменения не сохраняются
+func (a app) APIUpdateBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		apiFail(rw, http.StatusPreconditionRequired, "precondition_required", "Укажите заголовок If-Match с ETag книги")
+		return
+	}
+
+	old, version, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	if !etagMatches(match, etag(version)) {
+		rw.Header().Set("ETag", etag(version))
+		apiFail(rw, http.StatusPreconditionFailed, "version_conflict", "Книга изменена другим пользователем, загрузите ее заново")
+		return
+	}
+
+	b, ok := readBook(rw, r, old.Publication)
+	if !ok {
+		return
+	}
+	b.Book_Id = old.Book_Id
+
+	b, version, err := a.repo.PutBookVersion(a.ctx, b, version)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		apiFail(rw, http.StatusPreconditionFailed, "version_conflict", "Книга изменена другим пользователем, загрузите ее заново")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	a.events.publish(string(repository.EVENT_BOOK_UPDATED), b)
+	a.visibilityChanged(old.Visible, b)
+	a.recordRevision(r, &old, repository.Revision{Action: repository.REVISION_UPDATE, Book: b})
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_UPDATE}, &old, &b))
+
+	rw.Header().Set("ETag", etag(version))
+	writeJSON(rw, http.StatusOK, b)
+}
+
+// APIDeleteBook убирает книгу в корзину; с заголовком If-Match - только если она не менялась
+func (a app) APIDeleteBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, version, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+
+	var err error
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, etag(version)) {
+			apiFail(rw, http.StatusPreconditionFailed, "version_conflict", "Книга изменена другим пользователем, загрузите ее заново")
+			return
+		}
+		err = a.repo.DeleteBookVersion(a.ctx, b.Book_Id.String(), version)
+	} else {
+		err = a.repo.DeleteBookById(a.ctx, b.Book_Id.String())
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		apiFail(rw, http.StatusPreconditionFailed, "version_conflict", "Книга изменена другим пользователем, загрузите ее заново")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	a.bookTrashed(b)
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_DELETE}, &b, nil))
+
+	rw.WriteHeader(http.StatusNoContent)
+}
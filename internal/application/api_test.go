@@ -0,0 +1,217 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// memAPI - apiStore в памяти: одна книга с версией и список пользователей
+type memAPI struct {
+	book    repository.Book
+	version int
+	users   []repository.User
+	// limit и offset последнего запроса FindUsers
+	limit, offset int
+}
+
+func (m *memAPI) FindBooks(ctx context.Context, f repository.BookFilter) ([]repository.Book, int, error) {
+	if !m.book.Visible && !f.Hidden {
+		return nil, 0, nil
+	}
+	return []repository.Book{m.book}, 1, nil
+}
+
+func (m *memAPI) GetBookVersion(ctx context.Context, id string) (repository.Book, int, error) {
+	if id != m.book.Book_Id.String() {
+		return repository.Book{}, 0, fmt.Errorf("failed to query data: %w", pgx.ErrNoRows)
+	}
+	return m.book, m.version, nil
+}
+
+func (m *memAPI) FindUsers(ctx context.Context, limit, offset int) ([]repository.User, int, error) {
+	m.limit, m.offset = limit, offset
+	if offset >= len(m.users) {
+		return nil, len(m.users), nil
+	}
+	end := offset + limit
+	if end > len(m.users) {
+		end = len(m.users)
+	}
+	return m.users[offset:end], len(m.users), nil
+}
+
+func apiTestApp() (app, *memAPI) {
+	store := &memAPI{
+		book: repository.Book{Book_Id: uuid.New(), Category: repository.Categories[0], Author: "Толстой", Name: "Война и мир",
+			Link: "/srv/books/war-and-peace.fb2", Access: "Да", Visible: true},
+		version: 2,
+	}
+	for _, name := range []string{"anna", "boris", "vera", "gleb", "dina"} {
+		store.users = append(store.users, repository.User{User_Id: uuid.New(), Username: name, Role: repository.USER, Active: true})
+	}
+	return app{ctx: context.Background(), apiRepo: store}, store
+}
+
+// serveAPI вызывает обработчик от имени пользователя с ролью role и разбирает ответ JSON
+func serveAPI(t *testing.T, h httprouter.Handle, role UserRole, req *http.Request, p httprouter.Params) (*httptest.ResponseRecorder, object) {
+	t.Helper()
+	req = req.WithContext(context.WithValue(req.Context(), "role", role))
+	rw := httptest.NewRecorder()
+	h(rw, req, p)
+
+	var body object
+	if rw.Body.Len() > 0 {
+		if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+			t.Fatalf("response is not JSON: %v: %s", err, rw.Body)
+		}
+	}
+	return rw, body
+}
+
+// apiErrorOf проверяет конверт {"error": {"code", "message"}} и возвращает ошибку
+func apiErrorOf(t *testing.T, body object, code string) object {
+	t.Helper()
+	e, ok := body["error"].(object)
+	if !ok {
+		t.Fatalf("no error envelope: %v", body)
+	}
+	if e["code"] != code || e["message"] == "" {
+		t.Errorf("error %v, want code %s with a message", e, code)
+	}
+	return e
+}
+
+func TestAPIBookLinkOnlyForAdmin(t *testing.T) {
+	a, store := apiTestApp()
+	id := httprouter.Params{{Key: "id", Value: store.book.Book_Id.String()}}
+
+	for role, want := range map[UserRole]bool{"USER": false, "ADMIN": true} {
+		_, body := serveAPI(t, a.APIBook, role, httptest.NewRequest("GET", "/api/v1/books/x", nil), id)
+		if _, ok := body["link"]; ok != want {
+			t.Errorf("%s: book has link %v, want %v", role, ok, want)
+		}
+
+		_, body = serveAPI(t, a.APIBooks, role, httptest.NewRequest("GET", "/api/v1/books", nil), nil)
+		items, _ := body["items"].([]interface{})
+		if len(items) != 1 {
+			t.Fatalf("%s: items %v", role, body["items"])
+		}
+		if _, ok := items[0].(object)["link"]; ok != want {
+			t.Errorf("%s: list item has link %v, want %v", role, ok, want)
+		}
+	}
+}
+
+func TestAPIBookETag(t *testing.T) {
+	a, store := apiTestApp()
+	id := httprouter.Params{{Key: "id", Value: store.book.Book_Id.String()}}
+
+	rw, _ := serveAPI(t, a.APIBook, "USER", httptest.NewRequest("GET", "/api/v1/books/x", nil), id)
+	if rw.Header().Get("ETag") != `"2"` {
+		t.Fatalf("ETag %q", rw.Header().Get("ETag"))
+	}
+	req := httptest.NewRequest("GET", "/api/v1/books/x", nil)
+	req.Header.Set("If-None-Match", `"2"`)
+	if rw, _ = serveAPI(t, a.APIBook, "USER", req, id); rw.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with current ETag: status %d", rw.Code)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/books/x", strings.NewReader("{}"))
+	req.Header.Set("If-Match", `"1"`)
+	rw, body := serveAPI(t, a.APIUpdateBook, "ADMIN", req, id)
+	if rw.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: status %d", rw.Code)
+	}
+	apiErrorOf(t, body, "version_conflict")
+	if rw.Header().Get("ETag") != `"2"` {
+		t.Errorf("conflict does not report the current ETag: %q", rw.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/books/x", nil)
+	req.Header.Set("If-Match", `"1"`)
+	if rw, body = serveAPI(t, a.APIDeleteBook, "ADMIN", req, id); rw.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match on delete: status %d", rw.Code)
+	}
+	apiErrorOf(t, body, "version_conflict")
+
+	store.book.Visible = false
+	rw, body = serveAPI(t, a.APIBook, "USER", httptest.NewRequest("GET", "/api/v1/books/x", nil), id)
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("hidden book for reader: status %d", rw.Code)
+	}
+	apiErrorOf(t, body, "not_found")
+}
+
+func TestAPIBookValidation(t *testing.T) {
+	a, _ := apiTestApp()
+
+	rw, body := serveAPI(t, a.APICreateBook, "ADMIN", httptest.NewRequest("POST", "/api/v1/books",
+		strings.NewReader(`{"category": "Поэзия", "author": " ", "access": "Может быть",
+			"publication": "2026-05-01T00:00:00Z", "unpublish_at": "2026-04-01T00:00:00Z"}`)), nil)
+	if rw.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d", rw.Code)
+	}
+	fields, _ := apiErrorOf(t, body, "validation_failed")["fields"].(object)
+	for _, name := range []string{"category", "author", "series", "name", "annotation", "link", "access", "unpublish_at"} {
+		if fields[name] == nil {
+			t.Errorf("no message for %s: %v", name, fields)
+		}
+	}
+	if fields["publication"] != nil {
+		t.Errorf("valid publication reported: %v", fields["publication"])
+	}
+
+	rw, body = serveAPI(t, a.APICreateBook, "ADMIN", httptest.NewRequest("POST", "/api/v1/books", strings.NewReader(`["book"]`)), nil)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("array body: status %d", rw.Code)
+	}
+	apiErrorOf(t, body, "invalid_json")
+}
+
+func TestAPIUsersPagination(t *testing.T) {
+	a, store := apiTestApp()
+
+	rw, body := serveAPI(t, a.APIUsers, "ADMIN", httptest.NewRequest("GET", "/api/v1/users?page=2&per_page=2", nil), nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d: %v", rw.Code, body)
+	}
+	if store.limit != 2 || store.offset != 2 {
+		t.Errorf("query limit %d offset %d, want 2 and 2", store.limit, store.offset)
+	}
+	items, _ := body["items"].([]interface{})
+	if len(items) != 2 || items[0].(object)["login"] != "vera" || body["total"] != float64(5) {
+		t.Errorf("page 2: %v", body)
+	}
+
+	_, body = serveAPI(t, a.APIUsers, "ADMIN", httptest.NewRequest("GET", "/api/v1/users?page=9", nil), nil)
+	if items, ok := body["items"].([]interface{}); !ok || len(items) != 0 {
+		t.Errorf("page past the end: items %v", body["items"])
+	}
+
+	for _, query := range []string{"per_page=101", "per_page=0", "page=0", "page=x"} {
+		store.limit = 0
+		rw, body = serveAPI(t, a.APIUsers, "ADMIN", httptest.NewRequest("GET", "/api/v1/users?"+query, nil), nil)
+		if rw.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status %d", query, rw.Code)
+			continue
+		}
+		fields, _ := apiErrorOf(t, body, "validation_failed")["fields"].(object)
+		if param, _, _ := strings.Cut(query, "="); fields[param] == nil {
+			t.Errorf("%s: no message for %s: %v", query, param, fields)
+		}
+		if store.limit != 0 {
+			t.Errorf("%s: invalid page reached the store", query)
+		}
+	}
+}
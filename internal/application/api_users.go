@@ -0,0 +1,53 @@
+package application
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// APIUsers - GET /api/v1/users, только для администраторов
+func (a app) APIUsers(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	fields := make(map[string]string)
+	page, perPage := apiPage(r, fields)
+	if len(fields) > 0 {
+		apiInvalid(rw, fields)
+		return
+	}
+
+	users, total, err := a.apiRepo.FindUsers(a.ctx, perPage, (page-1)*perPage)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if users == nil {
+		users = []repository.User{}
+	}
+
+	writeJSON(rw, http.StatusOK, apiList{Items: users, Total: total, Page: page, PerPage: perPage})
+}
+
+func (a app) APIUser(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := uuid.Parse(p.ByName("id"))
+	if err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Пользователь не найден")
+		return
+	}
+
+	user, err := a.repo.GetUserById(a.ctx, id.String())
+	if errors.Is(err, pgx.ErrNoRows) {
+		apiFail(rw, http.StatusNotFound, "not_found", "Пользователь не найден")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, user)
+}
@@ -0,0 +1,229 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// apiTokenPrefix помогает узнать токен библиотеки, например при поиске утечек в репозиториях
+const apiTokenPrefix = "biblio_"
+
+// twoFactorRequired - отказ администратору без 2FA, когда она обязательна; api отдает его с кодом two_factor_required
+const twoFactorRequired = "Подключите двухфакторную аутентификацию в профиле"
+
+// needsTwoFactor - администратор без 2FA при включенном требовании, ему доступно только подключение 2FA
+func (a *app) needsTwoFactor(u repository.User) bool {
+	return u.Role == repository.ADMIN && !u.TOTPEnabled && a.require2FA.Load()
+}
+
+// bearerToken возвращает токен из заголовка Authorization: Bearer или пустую строку
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// apiToken возвращает токен, которым авторизован запрос; ok ложно для входа через браузер
+func apiToken(r *http.Request) (t repository.APIToken, ok bool) {
+	t, ok = r.Context().Value("token").(repository.APIToken)
+	return
+}
+
+// tokenRequest находит владельца токена и кладет его в контекст запроса, как authorized для сессии.
+// При ошибке возвращает код ответа: 401 для неверного токена, 403 если токену не хватает области.
+// Безопасные методы требуют области read, изменяющие - write
+func (a *app) tokenRequest(r *http.Request, raw string) (*http.Request, int, string) {
+	return a.tokenRequestScope(r, raw, "")
+}
+
+// tokenRequestScope - tokenRequest с явно заданной областью, для POST-запросов, которые ничего не меняют.
+// Пустая область выбирается по методу
+func (a *app) tokenRequestScope(r *http.Request, raw, need string) (*http.Request, int, string) {
+	if need == "" {
+		need = string(repository.SCOPE_WRITE)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			need = string(repository.SCOPE_READ)
+		}
+	}
+
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, http.StatusUnauthorized, "Неверный или отозванный токен"
+	}
+
+	token, user, err := a.repo.UseAPIToken(a.ctx, userTokenHash(raw))
+	if err != nil || !user.Active {
+		return nil, http.StatusUnauthorized, "Неверный или отозванный токен"
+	}
+
+	if !token.HasScope(need) {
+		return nil, http.StatusForbidden, "Токену не разрешена область " + need
+	}
+	// токен не обходит требование 2FA, действующее для входа через браузер
+	if a.needsTwoFactor(user) {
+		return nil, http.StatusForbidden, twoFactorRequired
+	}
+
+	// без области admin токен администратора действует с правами читателя
+	role := UserRole(user.Role)
+	if role == "ADMIN" && !token.HasScope(string(repository.SCOPE_ADMIN)) {
+		role = "USER"
+	}
+	ctx := context.WithValue(r.Context(), "role", role)
+	ctx = context.WithValue(ctx, "user", user)
+	ctx = context.WithValue(ctx, "token", token)
+	return r.WithContext(ctx), http.StatusOK, ""
+}
+
+func (a app) TokensPage(rw http.ResponseWriter, r *http.Request, message, created string) {
+	lp := filepath.Join("public", "html", "tokens.html")
+
+	user := currentUser(r)
+	tokens, err := a.repo.APITokens(a.ctx, user.User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Tokens  []repository.APIToken
+		Scopes  []string
+		Created string
+		Message string
+	}
+	data := answer{Tokens: tokens, Created: created, Message: message}
+	for _, s := range repository.Scopes {
+		if s != repository.SCOPE_ADMIN || user.Role == repository.ADMIN {
+			data.Scopes = append(data.Scopes, string(s))
+		}
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "tokens", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// CreateToken выпускает токен. Сам токен показывается один раз, в базе хранится только его хеш
+func (a app) CreateToken(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	user := currentUser(r)
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		a.TokensPage(rw, r, "Укажите название токена!", "")
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var scopes []string
+	for _, s := range repository.Scopes {
+		if s == repository.SCOPE_ADMIN && user.Role != repository.ADMIN {
+			continue
+		}
+		for _, v := range r.Form["scopes"] {
+			if v == string(s) {
+				scopes = append(scopes, v)
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		a.TokensPage(rw, r, "Выберите хотя бы одну область действия!", "")
+		return
+	}
+	if a.needsTwoFactor(user) {
+		for _, s := range scopes {
+			if s == string(repository.SCOPE_ADMIN) {
+				a.TokensPage(rw, r, "Токен с областью admin можно выпустить только после подключения двухфакторной аутентификации!", "")
+				return
+			}
+		}
+	}
+
+	var expires *time.Time
+	if days := r.FormValue("days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			a.TokensPage(rw, r, "Срок действия должен быть положительным числом дней!", "")
+			return
+		}
+		t := time.Now().AddDate(0, 0, n)
+		expires = &t
+	}
+
+	token := apiTokenPrefix + randomHex(20)
+	err = a.repo.AddAPIToken(a.ctx, user.User_Id.String(), name, userTokenHash(token), scopes, expires)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_TOKEN_CREATE}, "token", "", name, nil,
+		map[string]interface{}{"name": name, "scopes": scopes, "expires_at": expires}))
+
+	a.TokensPage(rw, r, "", token)
+}
+
+func (a app) RevokeToken(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.RevokeAPIToken(a.ctx, p.ByName("id"), currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_TOKEN_REVOKE}, "token", p.ByName("id"), "", nil, nil))
+
+	http.Redirect(rw, r, "/user/profile/tokens", http.StatusSeeOther)
+}
+
+// AdminTokensPage - токены всех пользователей
+func (a app) AdminTokensPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lp := filepath.Join("public", "html", "tokens-admin.html")
+
+	tokens, err := a.repo.APITokens(a.ctx, "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "tokens-admin", tokens)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) AdminRevokeToken(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.RevokeAPIToken(a.ctx, p.ByName("id"), "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_TOKEN_REVOKE}, "token", p.ByName("id"), "", nil, nil))
+
+	http.Redirect(rw, r, "/admin/tokens", http.StatusSeeOther)
+}
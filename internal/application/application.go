@@ -6,27 +6,57 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/text/encoding/charmap"
 
+	"biblio/internal/citation"
+	"biblio/internal/feed"
+	"biblio/internal/mailer"
 	"biblio/internal/repository"
 )
 
 type app struct {
-	ctx   context.Context
-	repo  *repository.Repository
-	cache map[string]repository.User
+	ctx      context.Context
+	repo     *repository.Repository
+	sessions *sessionStore
+	pending  *pendingLogins
+	guard    *loginGuard
+	mailer   mailer.Mailer
+	// userTokens - одноразовые токены из писем; это repo, тесты подставляют хранилище в памяти
+	userTokens userTokenStore
+	// secondFactors - секреты TOTP и резервные коды; это repo, тесты подставляют хранилище в памяти
+	secondFactors secondFactorStore
+	// apiRepo - чтение книг и пользователей для API; это repo, тесты подставляют хранилище в памяти
+	apiRepo apiStore
+	// reviews - очередь модерации отзывов; это repo, тесты подставляют хранилище в памяти
+	reviews reviewQueue
+	// shelves - полки пользователей; это repo, тесты подставляют хранилище в памяти
+	shelves shelfStore
+	// catalog - страницы каталога книг; это repo, тесты подставляют каталог в памяти
+	catalog bookCatalog
+	// require2FA - обязательна ли двухфакторная аутентификация для администраторов
+	require2FA *atomic.Bool
+	// sso - вход через OpenID Connect, nil если не настроен
+	sso *ssoLogin
+	// authenticators - источники проверки логина и пароля: база и, если настроен, каталог LDAP
+	authenticators []authenticator
+	// hooks - очередь исходящих вебхуков
+	hooks *webhookQueue
+	// events - шина событий для живой ленты и вебхуков
+	events *eventBus
 }
 type BookM struct {
 	Author  string
@@ -40,17 +70,43 @@ var header = filepath.Join("public", "html", "header.html")
 var headera = filepath.Join("public", "html", "headera.html")
 var pager = filepath.Join("public", "html", "pager.html")
 
-func (a app) Routes(r *httprouter.Router) {
+// router - методы httprouter.Router, через которые Routes регистрирует маршруты.
+// Тест спецификации OpenAPI подставляет сюда обертку, которая запоминает маршруты
+type router interface {
+	GET(path string, handle httprouter.Handle)
+	POST(path string, handle httprouter.Handle)
+	PUT(path string, handle httprouter.Handle)
+	DELETE(path string, handle httprouter.Handle)
+	ServeFiles(path string, root http.FileSystem)
+}
+
+func (a app) Routes(r router) {
 	r.ServeFiles("/public/*filepath", http.Dir("public"))
 	r.GET("/", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		a.LoginPage(rw, "")
+		a.LoginPage(rw, r, "")
 	})
 	r.POST("/", a.Login)
-	r.GET("/logout", a.Logout)
+	r.POST("/logout", a.Logout)
 	r.GET("/signup", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		a.SignupPage(rw, "")
+		a.SignupPage(rw, r, "")
 	})
 	r.POST("/signup", a.Signup)
+	r.GET("/verify", a.VerifyEmail)
+	r.GET("/reset", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ResetPage(rw, r, "")
+	})
+	r.POST("/reset", a.RequestReset)
+	r.GET("/reset/confirm", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ResetConfirmPage(rw, r, r.URL.Query().Get("token"), "")
+	})
+	r.POST("/reset/confirm", a.ResetConfirm)
+
+	r.GET("/login/totp", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.SecondFactorPage(rw, r, "")
+	})
+	r.POST("/login/totp", a.SecondFactor)
+	r.GET("/login/oidc", a.SSOStart)
+	r.GET("/login/oidc/callback", a.SSOCallback)
 
 	r.GET("/redir", a.Redir)
 	r.GET("/user", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -71,12 +127,12 @@ func (a app) Routes(r *httprouter.Router) {
 
 	r.GET("/user/books/search", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "USER" {
-			a.GetBooksSearch(rw, "")
+			a.GetBooksSearch(rw, r, "")
 		} else {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.POST("/user/books/search", a.PostBooksSearch)
+	r.POST("/user/books/search", a.withRole("USER", a.PostBooksSearch))
 
 	r.GET("/user/books/open/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "USER" {
@@ -94,6 +150,52 @@ func (a app) Routes(r *httprouter.Router) {
 		}
 	}))
 
+	r.GET("/user/profile", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ProfilePage(rw, r, "")
+	}))
+	r.POST("/user/profile", a.authorized(a.PutProfile))
+	r.POST("/user/profile/password", a.authorized(a.ChangePassword))
+	r.POST("/user/profile/sessions/end/:id", a.authorized(a.EndSession))
+	r.POST("/user/profile/delete", a.authorized(a.RequestDeletion))
+	r.GET("/user/profile/2fa", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.TwoFactorPage(rw, r, "", nil)
+	}))
+	r.POST("/user/profile/2fa/enable", a.authorized(a.EnableTwoFactor))
+	r.POST("/user/profile/2fa/disable", a.authorized(a.DisableTwoFactor))
+	r.POST("/user/profile/2fa/recovery", a.authorized(a.RegenerateRecoveryCodes))
+	r.GET("/user/profile/tokens", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.TokensPage(rw, r, "", "")
+	}))
+	r.POST("/user/profile/tokens", a.authorized(a.CreateToken))
+	r.POST("/user/profile/tokens/revoke/:id", a.authorized(a.RevokeToken))
+	r.GET("/user/books/cite/:id", a.authorized(a.CiteBook))
+	r.POST("/user/books/review/:id", a.authorized(a.SaveReview))
+	r.POST("/user/books/review/:id/delete", a.authorized(a.DeleteReview))
+	r.POST("/user/books/shelve/:id", a.authorized(a.shelveBook(true)))
+	r.POST("/user/books/unshelve/:id", a.authorized(a.shelveBook(false)))
+	r.GET("/user/shelves", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ShelvesPage(rw, r, "")
+	}))
+	r.POST("/user/shelves", a.authorized(a.CreateShelf))
+	r.GET("/user/shelves/:id", a.authorized(a.ShelfPage))
+	r.POST("/user/shelves/:id/rename", a.authorized(a.RenameShelf))
+	r.POST("/user/shelves/:id/delete", a.authorized(a.DeleteShelf))
+	r.POST("/user/shelves/:id/share", a.authorized(a.shareShelf(true)))
+	r.POST("/user/shelves/:id/unshare", a.authorized(a.shareShelf(false)))
+	r.GET("/shelf/:token", a.SharedShelfPage)
+	r.GET("/user/new", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.NewArrivalsPage(rw, r, "")
+	}))
+	r.POST("/user/follows", a.authorized(a.Follow))
+	r.POST("/user/follows/:id/delete", a.authorized(a.Unfollow))
+	r.GET("/user/basket", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.BasketPage(rw, r, "")
+	}))
+	r.POST("/user/basket/add/:id", a.authorized(a.AddToBasket))
+	r.POST("/user/basket/remove/:id", a.authorized(a.RemoveFromBasket))
+	r.POST("/user/basket/clear", a.authorized(a.ClearBasket))
+	r.GET("/user/basket/export", a.authorized(a.ExportBasket))
+
 	r.GET("/admin", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
 			a.StartPagea(rw, r, p)
@@ -108,13 +210,8 @@ func (a app) Routes(r *httprouter.Router) {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.GET("/admin/users/delete/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		if r.Context().Value("role").(UserRole) == "ADMIN" {
-			a.DeleteUser(rw, r, p)
-		} else {
-			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
-		}
-	}))
+	r.POST("/admin/users/delete/:id", a.withRole("ADMIN", a.DeleteUser))
+	r.DELETE("/admin/users/delete/:id", a.withRole("ADMIN", a.DeleteUser))
 	r.GET("/admin/users/edit/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
 			a.EditUserPage(rw, r, p)
@@ -122,7 +219,82 @@ func (a app) Routes(r *httprouter.Router) {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.POST("/admin/users/edit/:id", a.EditUser)
+	r.POST("/admin/users/edit/:id", a.withRole("ADMIN", a.EditUser))
+	r.POST("/admin/users/unlock/:id", a.withRole("ADMIN", a.UnlockUser))
+	r.POST("/admin/settings/2fa", a.withRole("ADMIN", a.PutRequire2FA))
+	r.GET("/admin/tokens", a.withRole("ADMIN", a.AdminTokensPage))
+	r.POST("/admin/tokens/revoke/:id", a.withRole("ADMIN", a.AdminRevokeToken))
+	r.GET("/admin/activity", a.withRole("ADMIN", a.ActivityPage))
+	r.GET("/admin/reviews", a.withRole("ADMIN", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ReviewsPage(rw, r, "")
+	}))
+	r.POST("/admin/reviews/approve/:id", a.withRole("ADMIN", a.moderateReview(true)))
+	r.POST("/admin/reviews/reject/:id", a.withRole("ADMIN", a.moderateReview(false)))
+	r.GET("/admin/webhooks", a.withRole("ADMIN", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.WebhooksPage(rw, r, "")
+	}))
+	r.POST("/admin/webhooks", a.withRole("ADMIN", a.CreateWebhook))
+	r.POST("/admin/webhooks/toggle/:id", a.withRole("ADMIN", a.ToggleWebhook))
+	r.POST("/admin/webhooks/ping/:id", a.withRole("ADMIN", a.PingWebhook))
+	r.POST("/admin/webhooks/delete/:id", a.withRole("ADMIN", a.DeleteWebhook))
+	r.GET("/admin/webhooks/log/:id", a.withRole("ADMIN", a.WebhookLogPage))
+	r.POST("/admin/webhooks/redeliver/:id", a.withRole("ADMIN", a.RedeliverWebhook))
+	r.GET("/admin/trash", a.withRole("ADMIN", a.TrashPage))
+	r.POST("/admin/trash/books/restore/:id", a.withRole("ADMIN", a.RestoreBook))
+	r.POST("/admin/trash/books/purge/:id", a.withRole("ADMIN", a.PurgeBook))
+	r.POST("/admin/trash/users/restore/:id", a.withRole("ADMIN", a.RestoreUser))
+	r.POST("/admin/trash/users/purge/:id", a.withRole("ADMIN", a.PurgeUser))
+	r.GET("/admin/audit", a.withRole("ADMIN", a.AuditPage))
+	r.GET("/admin/audit/export", a.withRole("ADMIN", a.AuditExport))
+	r.GET("/admin/audit/verify", a.withRole("ADMIN", a.AuditVerify))
+
+	r.GET("/api/v1/books", a.api("", a.APIBooks))
+	r.POST("/api/v1/books", a.api("ADMIN", a.APICreateBook))
+	r.GET("/api/v1/books/:id", a.api("", a.APIBook))
+	r.PUT("/api/v1/books/:id", a.api("ADMIN", a.APIUpdateBook))
+	r.DELETE("/api/v1/books/:id", a.api("ADMIN", a.APIDeleteBook))
+	r.GET("/api/v1/books/:id/revisions", a.api("ADMIN", a.APIBookRevisions))
+	r.POST("/api/v1/books/:id/revisions/:rev/revert", a.api("ADMIN", a.APIRevertBook))
+	r.GET("/api/v1/books/:id/cite", a.api("", a.APICiteBook))
+	r.GET("/api/v1/books/:id/reviews", a.api("", a.APIBookReviews))
+	r.PUT("/api/v1/books/:id/review", a.api("", a.APISaveReview))
+	r.DELETE("/api/v1/books/:id/review", a.api("", a.APIDeleteReview))
+	r.GET("/api/v1/books/:id/similar", a.api("", a.APISimilarBooks))
+	r.GET("/api/v1/recommendations", a.api("", a.APIRecommendations))
+	r.GET("/api/v1/follows", a.api("", a.APIFollows))
+	r.POST("/api/v1/follows", a.api("", a.APIFollow))
+	r.DELETE("/api/v1/follows/:id", a.api("", a.APIUnfollow))
+	r.GET("/api/v1/shelves", a.api("", a.APIShelves))
+	r.GET("/api/v1/shelves/:id/books", a.api("", a.APIShelfBooks))
+	r.PUT("/api/v1/shelves/:id/books/:book", a.api("", a.APIShelveBook))
+	r.DELETE("/api/v1/shelves/:id/books/:book", a.api("", a.APIUnshelveBook))
+	r.GET("/api/v1/basket", a.api("", a.APIBasket))
+	r.PUT("/api/v1/basket/:id", a.api("", a.APIAddToBasket))
+	r.DELETE("/api/v1/basket/:id", a.api("", a.APIRemoveFromBasket))
+	r.GET("/api/v1/basket/export", a.api("", a.APIExportBasket))
+	r.GET("/api/v1/search", a.api("", a.APISearch))
+	r.GET("/api/v1/users", a.api("ADMIN", a.APIUsers))
+	r.GET("/api/v1/users/:id", a.api("ADMIN", a.APIUser))
+	r.GET("/api/openapi.json", a.OpenAPI)
+	r.GET("/api/events", a.api("", a.Events))
+	gql := a.graphQLSchema()
+	r.GET("/api/graphql", a.api("", a.GraphQL(gql)))
+	r.POST("/api/graphql", a.apiScope("", string(repository.SCOPE_READ), a.GraphQL(gql)))
+
+	r.GET("/opds", a.opdsAuthorized(a.OPDSRoot))
+	r.GET("/opds/genres", a.opdsAuthorized(a.opdsFacets("category", "Жанры")))
+	r.GET("/opds/authors", a.opdsAuthorized(a.opdsFacets("author", "Авторы")))
+	r.GET("/opds/series", a.opdsAuthorized(a.opdsFacets("series", "Серии")))
+	r.GET("/opds/new", a.opdsAuthorized(a.OPDSNew))
+	r.GET("/opds/books", a.opdsAuthorized(a.OPDSBooks))
+	r.GET("/opds/books/:id/file", a.opdsAuthorized(a.OPDSFile))
+	r.GET("/opds/search", a.opdsAuthorized(a.OPDSSearch))
+	r.GET("/opds/opensearch.xml", a.OpenSearch)
+	for _, f := range feed.Formats {
+		r.GET("/feed/new."+f.Name, a.opdsAuthorized(a.NewArrivalsFeed(f)))
+		r.GET("/feed/follows."+f.Name, a.opdsAuthorized(a.FollowsFeed(f)))
+	}
+
 	r.GET("/admin/books", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
 			a.GetBooksa(rw, r, p)
@@ -130,15 +302,22 @@ func (a app) Routes(r *httprouter.Router) {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.POST("/admin/books", a.PostBooks)
+	r.POST("/admin/books", a.withRole("ADMIN", a.PostBooks))
 	r.GET("/admin/books/new", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
-			a.AddNewBookPage(rw, "")
+			a.AddNewBookPage(rw, r, "")
 		} else {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.POST("/admin/books/new", a.AddNewBook)
+	r.POST("/admin/books/new", a.withRole("ADMIN", a.AddNewBook))
+	r.GET("/admin/books/export", a.withRole("ADMIN", a.ExportBooks))
+	r.GET("/admin/books/import", a.withRole("ADMIN", func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a.ImportPage(rw, r, "")
+	}))
+	r.POST("/admin/books/import", a.withRole("ADMIN", a.UploadImport))
+	r.POST("/admin/books/import/preview", a.withRole("ADMIN", a.PreviewImport))
+	r.POST("/admin/books/import/apply", a.withRole("ADMIN", a.ApplyImport))
 	r.GET("/admin/books/open/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
 			a.GetBooksOpenID(rw, r, p)
@@ -153,13 +332,8 @@ func (a app) Routes(r *httprouter.Router) {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.GET("/admin/books/delete/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		if r.Context().Value("role").(UserRole) == "ADMIN" {
-			a.DeleteBook(rw, r, p)
-		} else {
-			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
-		}
-	}))
+	r.POST("/admin/books/delete/:id", a.withRole("ADMIN", a.DeleteBook))
+	r.DELETE("/admin/books/delete/:id", a.withRole("ADMIN", a.DeleteBook))
 
 	r.GET("/admin/books/edit/:id", a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		if r.Context().Value("role").(UserRole) == "ADMIN" {
@@ -168,7 +342,9 @@ func (a app) Routes(r *httprouter.Router) {
 			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
 		}
 	}))
-	r.POST("/admin/books/edit/:id", a.EditBook)
+	r.POST("/admin/books/edit/:id", a.withRole("ADMIN", a.EditBook))
+	r.GET("/admin/books/history/:id", a.withRole("ADMIN", a.BookHistoryPage))
+	r.POST("/admin/books/history/:id/revert/:rev", a.withRole("ADMIN", a.RevertBook))
 }
 
 /*func (a app) authorized(next httprouter.Handle) httprouter.Handle {
@@ -190,6 +366,25 @@ func (a app) Routes(r *httprouter.Router) {
 
 func (a *app) authorized(next httprouter.Handle) httprouter.Handle {
 	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// запросы со скриптов авторизуются только токеном, кука при этом не учитывается.
+		// Профиль, в том числе выпуск новых токенов, доступен только из браузера
+		if raw := bearerToken(r); raw != "" {
+			if strings.HasPrefix(r.URL.Path, "/user/profile") {
+				http.Error(rw, "Профиль недоступен по API-токену", http.StatusForbidden)
+				return
+			}
+			r, status, message := a.tokenRequest(r, raw)
+			if status != http.StatusOK {
+				if status == http.StatusUnauthorized {
+					rw.Header().Set("WWW-Authenticate", `Bearer realm="biblio"`)
+				}
+				http.Error(rw, message, status)
+				return
+			}
+			next(rw, r, ps)
+			return
+		}
+
 		token, err := readCookie("token", r)
 
 		if err != nil {
@@ -197,16 +392,40 @@ func (a *app) authorized(next httprouter.Handle) httprouter.Handle {
 
 			return
 		}
-		role := UserRole(a.cache[token].Role)
+		sess, ok := a.sessions.get(token)
+		if !ok {
+			http.Redirect(rw, r, "/", http.StatusSeeOther)
+			return
+		}
+		// администратор без 2FA при включенном требовании может только подключить ее в профиле
+		if a.needsTwoFactor(sess.User) && !strings.HasPrefix(r.URL.Path, "/user/profile/2fa") {
+			http.Redirect(rw, r, "/user/profile/2fa", http.StatusSeeOther)
+			return
+		}
+		role := UserRole(sess.User.Role)
 		ctx := context.WithValue(r.Context(), "role", role)
+		ctx = context.WithValue(ctx, "user", sess.User)
 		next(rw, r.WithContext(ctx), ps)
 	}
 }
 
+// withRole пропускает к обработчику только авторизованного пользователя с указанной ролью
+func (a *app) withRole(role UserRole, next httprouter.Handle) httprouter.Handle {
+	return a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if r.Context().Value("role").(UserRole) == role {
+			next(rw, r, p)
+		} else if _, ok := apiToken(r); ok {
+			http.Error(rw, "Недостаточно прав", http.StatusForbidden)
+		} else {
+			http.Redirect(rw, r, "/redir", http.StatusSeeOther)
+		}
+	})
+}
+
 func (a app) Redir(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	lp := filepath.Join("public", "html", "redir.html")
 
-	tmpl, err := template.ParseFiles(lp, head)
+	tmpl, err := parseTemplates(r, lp, head)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -219,19 +438,28 @@ func (a app) Redir(rw http.ResponseWriter, r *http.Request, p httprouter.Params)
 	}
 }
 
-func (a app) LoginPage(rw http.ResponseWriter, message string) {
+func (a app) LoginPage(rw http.ResponseWriter, r *http.Request, message string) {
 	lp := filepath.Join("public", "html", "login.html")
 
-	tmpl, err := template.ParseFiles(lp, head)
+	tmpl, err := parseTemplates(r, lp, head)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	type answer struct {
-		Message string
+		Message  string
+		Captcha  *captcha
+		SSOLabel string
+	}
+	data := answer{Message: message}
+	if a.sso != nil {
+		data.SSOLabel = a.sso.label
+	}
+	if a.guard.needsCaptcha(r.FormValue("login"), clientIP(r)) {
+		c := newCaptcha()
+		data.Captcha = &c
 	}
-	data := answer{message}
 
 	err = tmpl.ExecuteTemplate(rw, "login", data)
 	if err != nil {
@@ -245,61 +473,75 @@ func (a app) Login(rw http.ResponseWriter, r *http.Request, p httprouter.Params)
 	password := r.FormValue("password")
 
 	if login == "" || password == "" {
-		a.LoginPage(rw, "Необходимо указать логин и пароль!")
+		a.LoginPage(rw, r, "Необходимо указать логин и пароль!")
 		return
 	}
 
-	hash := md5.Sum([]byte(password))
-	hashedPass := hex.EncodeToString(hash[:])
+	ip := clientIP(r)
+	if wait := a.guard.wait(login, ip); wait > 0 {
+		a.loginFailed(login, ip, "locked")
+		a.LoginPage(rw, r, fmt.Sprintf("Слишком много неудачных попыток входа. Повторите через %v", wait.Round(time.Second)))
+		return
+	}
+	if a.guard.needsCaptcha(login, ip) && !a.guard.checkCaptcha(r.FormValue("captcha_token"), r.FormValue("captcha")) {
+		a.guard.fail(login, ip)
+		a.loginFailed(login, ip, "captcha")
+		a.LoginPage(rw, r, "Неверный ответ на проверочный вопрос!")
+		return
+	}
 
-	user, err := a.repo.Login(a.ctx, login, hashedPass)
+	user, err := a.authenticate(login, password)
+	if errors.Is(err, errAccessDenied) {
+		a.guard.fail(login, ip)
+		a.loginFailed(login, ip, "denied")
+		a.LoginPage(rw, r, "Вашей учетной записи не разрешен доступ к библиотеке")
+		return
+	}
 	if err != nil {
-		a.LoginPage(rw, "Вы ввели неверный логин или пароль!")
+		a.guard.fail(login, ip)
+		a.loginFailed(login, ip, "password")
+		a.LoginPage(rw, r, "Вы ввели неверный логин или пароль!")
 		return
 	}
 	if !user.Active {
-		a.LoginPage(rw, "Пользователь заблокирован!")
+		a.guard.fail(login, ip)
+		a.loginFailed(login, ip, "inactive")
+		a.LoginPage(rw, r, "Пользователь заблокирован!")
 		return
 	}
 
-	//логин и пароль совпадают, поэтому генерируем токен, пишем его в кеш и в куки
-	time64 := time.Now().Unix()
-	timeInt := string(time64)
-	token := login + password + timeInt
-
-	hashToken := md5.Sum([]byte(token))
-	hashedToken := hex.EncodeToString(hashToken[:])
-
-	a.cache[hashedToken] = user
-
-	livingTime := 60 * time.Minute
-	expiration := time.Now().Add(livingTime)
-	//кука будет жить 1 час
-	cookie := http.Cookie{Name: "token", Value: url.QueryEscape(hashedToken), Expires: expiration}
-	http.SetCookie(rw, &cookie)
-	if user.Role == "ADMIN" {
-		http.Redirect(rw, r, "/admin", http.StatusSeeOther)
-	} else {
-		http.Redirect(rw, r, "/user", http.StatusSeeOther)
-
+	// с 2FA вход считается успешным только после верного кода: иначе, зная пароль,
+	// можно было бы подбирать коды без блокировки, обнуляя счетчик входом по паролю
+	if user.TOTPEnabled {
+		a.startSecondFactor(rw, r, user)
+		return
 	}
 
+	a.loginSucceeded(login, ip)
+	a.startSession(rw, r, user)
 }
 
 func (a app) Logout(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if token, err := readCookie("token", r); err == nil {
+		if sess, ok := a.sessions.get(token); ok {
+			a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_LOGOUT}, sess.User))
+		}
+		a.sessions.remove(token)
+	}
 	for _, v := range r.Cookies() {
 		c := http.Cookie{
 			Name:   v.Name,
+			Path:   "/",
 			MaxAge: -1}
 		http.SetCookie(rw, &c)
 	}
 	http.Redirect(rw, r, "/", http.StatusSeeOther)
 }
 
-func (a app) SignupPage(rw http.ResponseWriter, message string) {
+func (a app) SignupPage(rw http.ResponseWriter, r *http.Request, message string) {
 	sp := filepath.Join("public", "html", "signup.html")
 
-	tmpl, err := template.ParseFiles(sp, head)
+	tmpl, err := parseTemplates(r, sp, head)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -322,40 +564,72 @@ func (a app) Signup(rw http.ResponseWriter, r *http.Request, p httprouter.Params
 	password := strings.TrimSpace(r.FormValue("password"))
 	password2 := strings.TrimSpace(r.FormValue("password2"))
 	fullName := strings.TrimSpace(r.FormValue("fullName"))
+	email := strings.TrimSpace(r.FormValue("email"))
+
+	if username == "" || fullName == "" || email == "" || password == "" || password2 == "" {
+		a.SignupPage(rw, r, "Все поля должны быть заполнены!")
+		return
+	}
 
-	if username == "" || fullName == "" || password == "" || password2 == "" {
-		a.SignupPage(rw, "Все поля должны быть заполнены!")
+	if _, err := mail.ParseAddress(email); err != nil || strings.ContainsAny(email, "<> ") {
+		a.SignupPage(rw, r, "Неверный адрес электронной почты!")
 		return
 	}
 
 	if password != password2 {
-		a.SignupPage(rw, "Пароли не совпадают! Попробуйте еще")
+		a.SignupPage(rw, r, "Пароли не совпадают! Попробуйте еще")
 		return
 	}
 
-	hash := md5.Sum([]byte(password))
-	hashedPass := hex.EncodeToString(hash[:])
+	// по адресу восстанавливается пароль, поэтому он должен быть у одного пользователя
+	used, err := a.repo.EmailInUse(a.ctx, email)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if used {
+		a.SignupPage(rw, r, "Этот адрес электронной почты уже указан у другого пользователя!")
+		return
+	}
 
-	err := a.repo.AddNewUser(a.ctx, username, fullName, hashedPass)
+	err = a.repo.AddNewUser(a.ctx, username, fullName, hashPassword(password), email)
 	if err != nil {
-		a.SignupPage(rw, fmt.Sprintf("Ошибка создания пользователя: %v", err))
+		a.SignupPage(rw, r, fmt.Sprintf("Ошибка создания пользователя: %v", err))
 		return
 	}
 
-	a.LoginPage(rw, fmt.Sprintf("%s, вы успешно зарегистрированы! Теперь вам доступен вход через страницу авторизации", fullName))
+	user, err := a.repo.GetUserByLoginOrEmail(a.ctx, username)
+	if err == nil {
+		a.events.publish(string(repository.EVENT_USER_CREATED), user)
+		a.audit(r, auditUser(repository.AuditEntry{Action: repository.AUDIT_SIGNUP, Actor_Id: &user.User_Id, Actor: user.Username}, nil, &user))
+		err = a.sendVerification(user)
+	}
+	if err != nil {
+		log.Println(err)
+		a.LoginPage(rw, r, fmt.Sprintf("%s, вы успешно зарегистрированы! Теперь вам доступен вход через страницу авторизации. Письмо для подтверждения адреса отправить не удалось: %v", fullName, err))
+		return
+	}
+
+	a.LoginPage(rw, r, fmt.Sprintf("%s, вы успешно зарегистрированы! Теперь вам доступен вход через страницу авторизации. На %s отправлено письмо для подтверждения адреса", fullName, email))
 }
 
 func (a app) StartPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	lp := filepath.Join("public", "html", "index.html")
 
-	tmpl, err := template.ParseFiles(lp, head, header)
+	books, err := a.recommendations(r, recommendShown)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, header)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "index", nil)
+	err = tmpl.ExecuteTemplate(rw, "index", bookLinks(r, books))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -366,14 +640,20 @@ func (a app) StartPage(rw http.ResponseWriter, r *http.Request, p httprouter.Par
 func (a app) StartPagea(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	lp := filepath.Join("public", "html", "index.html")
 
-	tmpl, err := template.ParseFiles(lp, head, headera)
+	books, err := a.recommendations(r, recommendShown)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "index", nil)
+	err = tmpl.ExecuteTemplate(rw, "index", bookLinks(r, books))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -389,14 +669,20 @@ func (a app) GetUsers(rw http.ResponseWriter, r *http.Request, p httprouter.Para
 		return
 	}
 
-	tmpl, err := template.ParseFiles(lp, head, headera)
+	tmpl, err := parseTemplates(r, lp, head, headera)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "userlist", users)
+	type answer struct {
+		Users      []repository.User
+		Require2FA bool
+	}
+	data := answer{users, a.require2FA.Load()}
+
+	err = tmpl.ExecuteTemplate(rw, "userlist", data)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -404,12 +690,20 @@ func (a app) GetUsers(rw http.ResponseWriter, r *http.Request, p httprouter.Para
 
 }
 
+// DeleteUser убирает пользователя в корзину и завершает его сеансы
 func (a app) DeleteUser(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	err := a.repo.DeleteUserById(a.ctx, p.ByName("id"))
+	old, err := a.repo.GetUserById(a.ctx, p.ByName("id"))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	err = a.repo.DeleteUserById(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.sessions.removeUser(p.ByName("id"), "")
+	a.audit(r, auditUser(repository.AuditEntry{Action: repository.AUDIT_USER_DELETE}, &old, nil))
 	http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
 
 }
@@ -424,13 +718,29 @@ func (a app) EditUserPage(rw http.ResponseWriter, r *http.Request, p httprouter.
 		return
 	}
 
-	tmpl, err := template.ParseFiles(sp, head, headera)
+	attempts, err := a.repo.FailedLoginAttempts(a.ctx, user.Username, 20)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "usersedit", user)
+	tmpl, err := parseTemplates(r, sp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		repository.User
+		Attempts    []repository.LoginAttempt
+		LockedUntil time.Time
+	}
+	data := answer{User: user, Attempts: attempts}
+	if until := a.guard.locked(user.Username); until.After(time.Now()) {
+		data.LockedUntil = until
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "usersedit", data)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -445,148 +755,150 @@ func (a app) EditUser(rw http.ResponseWriter, r *http.Request, p httprouter.Para
 		act = "true"
 	}
 
-	err := a.repo.PutUserById(a.ctx, r.FormValue("role"), act, p.ByName("id"))
+	old, err := a.repo.GetUserById(a.ctx, p.ByName("id"))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
-}
-
-func (a app) GetBooksa(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	var pages repository.Page
-	var err error = nil
-	pageNumber := 1
-	queryValues := r.URL.Query()
 
-	pageNumberStr := queryValues.Get("page")
-
-	if pageNumberStr != "" {
-		// Convert the query parameter to integer
-		convertedPageNumber, err := strconv.Atoi(pageNumberStr)
-		if err == nil {
-			pageNumber = convertedPageNumber
-		}
-	}
-	pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, "", "", "", "", "")
-	pages.PageUrl = "/admin/books?page="
+	err = a.repo.PutUserById(a.ctx, r.FormValue("role"), act, p.ByName("id"))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	link := queryValues.Get("link")
-
-	if link != "" {
-		pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, "", "", "", "", link)
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-		pages.PageUrl = "/admin/books?link=" + link + "&page="
+	if old.Active && act == "false" {
+		a.publishUserBlocked(p.ByName("id"))
 	}
-
-	lp := filepath.Join("public", "html", "all-booka.html")
-
-	tmpl, err := template.ParseFiles(lp, head, headera, pager)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
+	// сессии хранят роль и состояние на момент входа: заблокированного пользователя или пользователя
+	// с другой ролью нужно разлогинить сразу
+	if act == "false" || r.FormValue("role") != string(old.Role) {
+		a.sessions.removeUser(p.ByName("id"), "")
 	}
+	if user, err := a.repo.GetUserById(a.ctx, p.ByName("id")); err == nil {
+		a.audit(r, auditUser(repository.AuditEntry{Action: repository.AUDIT_USER_UPDATE}, &old, &user))
+	}
+	http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
+}
 
-	err = tmpl.ExecuteTemplate(rw, "all-booka", pages)
+func (a app) UnlockUser(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	user, err := a.repo.GetUserById(a.ctx, p.ByName("id"))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-
+	a.guard.unlock(user.Username)
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_USER_UNLOCK}, "user", user.User_Id.String(), user.Username, nil, nil))
+	http.Redirect(rw, r, "/admin/users/edit/"+p.ByName("id"), http.StatusSeeOther)
 }
 
-func (a app) GetBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	var pages repository.Page
-	var err error = nil
-	pageNumber := 1
-	queryValues := r.URL.Query()
-
-	pageNumberStr := queryValues.Get("page")
+// bookCatalog - каталог книг для страниц списка. Фильтры AllBook: жанр, автор, серия, название, путь к файлу,
+// затем порядок ("rating") и "hidden", чтобы показать скрытые книги
+type bookCatalog interface {
+	AllBook(ctx context.Context, pageNumber, pageSize int, s ...string) (repository.Page, error)
+}
 
-	if pageNumberStr != "" {
-		// Convert the query parameter to integer
-		convertedPageNumber, err := strconv.Atoi(pageNumberStr)
-		if err == nil {
-			pageNumber = convertedPageNumber
+// bookListValues - заданные в запросе фильтры keys и порядок списка для ссылок на его страницы
+func bookListValues(q url.Values, keys ...string) url.Values {
+	v := url.Values{}
+	for _, k := range keys {
+		if s := strings.TrimSpace(q.Get(k)); s != "" {
+			v.Set(k, s)
 		}
 	}
-
-	category := queryValues.Get("category")
-	author := queryValues.Get("author")
-	series := queryValues.Get("series")
-	name := queryValues.Get("name")
-
-	if category != "" {
-		pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, category, "", "", "", "")
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-		pages.PageUrl = "/user/books?category=" + category + "&page="
+	if q.Get("sort") == "rating" {
+		v.Set("sort", "rating")
 	}
+	return v
+}
 
-	if author != "" {
-		pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, "", author, "", "", "")
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-		pages.PageUrl = "/user/books?author=" + author + "&page="
+// bookPageURL - адрес страницы списка без номера: шаблон pager дописывает его в конец
+func bookPageURL(path string, v url.Values) string {
+	if len(v) == 0 {
+		return path + "?page="
 	}
+	return path + "?" + v.Encode() + "&page="
+}
 
-	if series != "" {
-		pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, "", "", series, "", "")
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-		pages.PageUrl = "/user/books?series=" + series + "&page="
+// sortToggle - адрес первой страницы того же списка в другом порядке: по оценке или по жанру и автору
+func sortToggle(path string, v url.Values) string {
+	t := url.Values{}
+	for k, s := range v {
+		t[k] = s
 	}
-
-	if name != "" {
-		pages, err = a.repo.AllBook(a.ctx, pageNumber, 12, "", "", "", name, "")
-		if err != nil {
-			http.Error(rw, err.Error(), http.StatusBadRequest)
-			return
-		}
-		pages.PageUrl = "/user/books?name=" + name + "&page="
+	if v.Get("sort") == "rating" {
+		t.Del("sort")
+	} else {
+		t.Set("sort", "rating")
 	}
+	return bookPageURL(path, t) + "1"
+}
 
-	lp := filepath.Join("public", "html", "all-book.html")
+// listBooksPage показывает страницу каталога path с фильтрами keys из запроса в шаблоне name.
+// Все фильтры передаются в одном запросе к каталогу и сочетаются через and
+func (a app) listBooksPage(rw http.ResponseWriter, r *http.Request, path, name string, hidden bool, keys ...string) {
+	q := r.URL.Query()
+	pageNumber := 1
+	if n, err := strconv.Atoi(q.Get("page")); err == nil && n > 0 {
+		pageNumber = n
+	}
 
-	tmpl, err := template.ParseFiles(lp, head, header, pager)
+	v := bookListValues(q, keys...)
+	filter := []string{v.Get("category"), v.Get("author"), v.Get("series"), v.Get("name"), v.Get("link"), v.Get("sort"), ""}
+	if hidden {
+		filter[6] = "hidden"
+	}
+	pages, err := a.catalog.AllBook(a.ctx, pageNumber, 12, filter...)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pages.PageUrl = bookPageURL(path, v)
+	pages.Sort, pages.SortUrl = v.Get("sort"), sortToggle(path, v)
+	pages.Shelves, err = a.shelves.Shelves(a.ctx, currentUser(r).User_Id.String())
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "all-book", pages)
+	hdr := header
+	if hidden {
+		hdr = headera
+	}
+	lp := filepath.Join("public", "html", name+".html")
+	tmpl, err := parseTemplates(r, lp, head, hdr, pager)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	err = tmpl.ExecuteTemplate(rw, name, pages)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 }
 
-func (a app) PostBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	link := strings.TrimSpace(r.FormValue("link"))
+// GetBooksa - GET /admin/books?link=&sort=&page=, все книги вместе со скрытыми
+func (a app) GetBooksa(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.listBooksPage(rw, r, "/admin/books", "all-booka", true, "link")
+}
 
-	http.Redirect(rw, r, "/admin/books?link="+link+"&page=1", http.StatusSeeOther)
+// GetBooks - GET /user/books?category=&author=&series=&name=&sort=&page=, опубликованные книги
+func (a app) GetBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.listBooksPage(rw, r, "/user/books", "all-book", false, "category", "author", "series", "name")
+}
 
+func (a app) PostBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	r.ParseForm()
+	v := bookListValues(r.PostForm, "link")
+	http.Redirect(rw, r, bookPageURL("/admin/books", v)+"1", http.StatusSeeOther)
 }
 
-func (a app) GetBooksSearch(rw http.ResponseWriter, message string) {
+func (a app) GetBooksSearch(rw http.ResponseWriter, r *http.Request, message string) {
 
 	sp := filepath.Join("public", "html", "user-search.html")
 
-	tmpl, err := template.ParseFiles(sp, head)
+	tmpl, err := parseTemplates(r, sp, head)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -603,49 +915,125 @@ func (a app) GetBooksSearch(rw http.ResponseWriter, message string) {
 		return
 	}
 }
+
+// PostBooksSearch переходит к каталогу с заполненными полями поиска; поля сочетаются через and
 func (a app) PostBooksSearch(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	category := strings.TrimSpace(r.FormValue("category"))
-	author := strings.TrimSpace(r.FormValue("author"))
-	series := strings.TrimSpace(r.FormValue("series"))
-	name := strings.TrimSpace(r.FormValue("name"))
-	if category != "" && author == "" && series == "" && name == "" {
-		http.Redirect(rw, r, "/user/books?category="+category+"&page=1", http.StatusSeeOther)
-	} else {
-		if category == "" && author != "" && series == "" && name == "" {
-			http.Redirect(rw, r, "/user/books?author="+author+"&page=1", http.StatusSeeOther)
-		} else {
-			if category == "" && author == "" && series != "" && name == "" {
-				http.Redirect(rw, r, "/user/books?series="+series+"&page=1", http.StatusSeeOther)
-			} else {
-				if category == "" && author == "" && series == "" && name != "" {
-					http.Redirect(rw, r, "/user/books?name="+name+"&page=1", http.StatusSeeOther)
-				} else {
-					a.GetBooksSearch(rw, "Должно быть заполнено только одно поле.")
-					return
-				}
-			}
-		}
+	r.ParseForm()
+	v := bookListValues(r.PostForm, "category", "author", "series", "name")
+	if len(v) == 0 {
+		a.GetBooksSearch(rw, r, "Заполните хотя бы одно поле.")
+		return
 	}
+	http.Redirect(rw, r, bookPageURL("/user/books", v)+"1", http.StatusSeeOther)
 }
 
 func (a app) GetBooksOpenID(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.bookInfoPage(rw, r, p.ByName("id"), "")
+}
+
+// bookInfoPage - карточка книги с описанием для списка литературы и отзывами
+func (a app) bookInfoPage(rw http.ResponseWriter, r *http.Request, id, message string) {
 
 	sp := filepath.Join("public", "html", "book-info.html")
 
-	book, err := a.repo.GetBookById(a.ctx, p.ByName("id"))
+	book, err := a.visibleBook(r, id)
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	tmpl, err := template.ParseFiles(sp, head)
+	rating, err := a.repo.BookRating(a.ctx, id)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	reviews, err := a.repo.BookReviews(a.ctx, id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shelves, err := a.repo.Shelves(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	onShelf, err := a.repo.BookShelves(a.ctx, currentUser(r).User_Id.String(), id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	similar, err := a.repo.SimilarBooks(a.ctx, id, similarShown)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	follows, err := a.repo.Follows(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var followAuthor, followSeries int64
+	for _, f := range follows {
+		if f.Kind == repository.FOLLOW_AUTHOR && strings.EqualFold(f.Value, book.Author) {
+			followAuthor = f.Follow_Id
+		}
+		if f.Kind == repository.FOLLOW_SERIES && strings.EqualFold(f.Value, book.Series) {
+			followSeries = f.Follow_Id
+		}
+	}
+	var own *repository.Review
+	v, err := a.repo.UserReview(a.ctx, currentUser(r).User_Id.String(), id)
+	if err == nil {
+		own = &v
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	err = tmpl.ExecuteTemplate(rw, "book-info", book)
+	tmpl, err := parseTemplates(r, sp, head)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		repository.Book
+		Citation string
+		Formats  []citation.Format
+		InBasket bool
+		Rating   repository.Rating
+		Reviews  []repository.Review
+		Own      *repository.Review
+		Stars    []int
+		Shelves  []repository.Shelf
+		OnShelf  map[int64]bool
+		Similar  []bookLink
+		// FollowAuthor и FollowSeries - номера подписок на автора и серию книги, 0 - подписки нет
+		FollowAuthor int64
+		FollowSeries int64
+		Back         string
+		Message      string
+	}
+	data := answer{
+		Book:         book,
+		Citation:     citation.GOST(book, citationOptions()),
+		Formats:      citation.Formats,
+		InBasket:     r.URL.Query().Get("basket") != "",
+		Rating:       rating,
+		Reviews:      reviews,
+		Own:          own,
+		Stars:        []int{5, 4, 3, 2, 1},
+		Shelves:      shelves,
+		OnShelf:      onShelf,
+		Similar:      bookLinks(r, similar),
+		FollowAuthor: followAuthor,
+		FollowSeries: followSeries,
+		Back:         bookPage(r, id),
+		Message:      message,
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "book-info", data)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -657,22 +1045,25 @@ func (a app) GetBooksReadID(rw http.ResponseWriter, r *http.Request, p httproute
 	var con BookM
 	sp := filepath.Join("public", "html", "book-read.html")
 
-	book, err := a.repo.GetBookById(a.ctx, p.ByName("id"))
+	book, err := a.visibleBook(r, p.ByName("id"))
 
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	tmpl, err := template.ParseFiles(sp, head, header)
+	tmpl, err := parseTemplates(r, sp, head, header)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// у импортированных книг файла может не быть
 	data, err := os.ReadFile(book.Link)
 	if err != nil {
-		log.Fatal(err)
+		http.Error(rw, "Файл книги недоступен", http.StatusNotFound)
+		return
 	}
+	a.bookOpened(r, book, "web")
 	dec := charmap.Windows1251.NewDecoder()
 	out, _ := dec.Bytes(data)
 	con.Author = book.Author
@@ -687,10 +1078,10 @@ func (a app) GetBooksReadID(rw http.ResponseWriter, r *http.Request, p httproute
 
 }
 
-func (a app) AddNewBookPage(rw http.ResponseWriter, message string) {
+func (a app) AddNewBookPage(rw http.ResponseWriter, r *http.Request, message string) {
 	lp := filepath.Join("public", "html", "book.html")
 
-	tmpl, err := template.ParseFiles(lp, head)
+	tmpl, err := parseTemplates(r, lp, head)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -717,24 +1108,42 @@ func (a app) AddNewBook(rw http.ResponseWriter, r *http.Request, p httprouter.Pa
 	access := strings.TrimSpace(r.FormValue("access"))
 	link := strings.TrimSpace(r.FormValue("link"))
 	if category == "" || author == "" || series == "" || name == "" || annotation == "" || access == "" || link == "" {
-		a.AddNewBookPage(rw, "Все поля должны быть заполнены")
-
+		a.AddNewBookPage(rw, r, "Все поля должны быть заполнены")
+		return
+	}
+	publication, unpublish, err := readSchedule(r, time.Now())
+	if err != nil {
+		a.AddNewBookPage(rw, r, err.Error())
+		return
 	}
 
-	err := a.repo.AddNewBook(a.ctx, category, author, series, name, annotation, link, access, time.Now())
+	book, err := a.repo.AddNewBook(a.ctx, category, author, series, name, annotation, link, access, publication, unpublish)
 	if err != nil {
-		a.AddNewBookPage(rw, fmt.Sprintf("Ошибка создания книги: %v", err))
+		a.AddNewBookPage(rw, r, fmt.Sprintf("Ошибка создания книги: %v", err))
 		return
 	}
+	a.events.publish(string(repository.EVENT_BOOK_CREATED), book)
+	a.visibilityChanged(false, book)
+	a.recordRevision(r, nil, repository.Revision{Action: repository.REVISION_CREATE, Book: book})
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_CREATE}, nil, &book))
 	http.Redirect(rw, r, "/admin/books", http.StatusSeeOther)
 }
 
+// DeleteBook убирает книгу в корзину, откуда ее можно восстановить до окончательного удаления
 func (a app) DeleteBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	err := a.repo.DeleteBookById(a.ctx, p.ByName("id"))
+	book, err := a.repo.GetBookById(a.ctx, p.ByName("id"))
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	err = a.repo.DeleteBookById(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.bookTrashed(book)
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_DELETE}, &book, nil))
 	http.Redirect(rw, r, "/admin/books", http.StatusSeeOther)
 
 }
@@ -749,13 +1158,20 @@ func (a app) EditBookPage(rw http.ResponseWriter, r *http.Request, p httprouter.
 		return
 	}
 
-	tmpl, err := template.ParseFiles(sp, head, headera)
+	tmpl, err := parseTemplates(r, sp, head, headera)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(rw, "bookedit", book)
+	data := struct {
+		repository.Book
+		// PublicationValue и UnpublishValue - даты для полей datetime-local
+		PublicationValue string
+		UnpublishValue   string
+	}{book, scheduleValue(&book.Publication), scheduleValue(book.UnpublishAt)}
+
+	err = tmpl.ExecuteTemplate(rw, "bookedit", data)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
@@ -771,13 +1187,36 @@ func (a app) EditBook(rw http.ResponseWriter, r *http.Request, p httprouter.Para
 	access := strings.TrimSpace(r.FormValue("access"))
 	link := strings.TrimSpace(r.FormValue("link"))
 
-	err := a.repo.PutBookById(a.ctx, p.ByName("id"), category, author, series, name, annotation, link, access, time.Now())
+	old, err := a.repo.GetBookById(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// дата публикации меняется только явно: правка книги не делает ее новинкой
+	publication, unpublish, err := readSchedule(r, old.Publication)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.repo.PutBookById(a.ctx, p.ByName("id"), category, author, series, name, annotation, link, access, publication, unpublish)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if book, err := a.repo.GetBookById(a.ctx, p.ByName("id")); err == nil {
+		a.events.publish(string(repository.EVENT_BOOK_UPDATED), book)
+		a.visibilityChanged(old.Visible, book)
+		a.recordRevision(r, &old, repository.Revision{Action: repository.REVISION_UPDATE, Book: book})
+		a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_UPDATE}, &old, &book))
+	}
 	http.Redirect(rw, r, "/admin/books", http.StatusSeeOther)
 }
+func hashPassword(password string) string {
+	hash := md5.Sum([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
 func readCookie(name string, r *http.Request) (value string, err error) {
 	if name == "" {
 		return value, errors.New("you are trying to read empty cookie")
@@ -791,6 +1230,33 @@ func readCookie(name string, r *http.Request) (value string, err error) {
 	return value, err
 }
 
-func NewApp(ctx context.Context, dbpool *pgxpool.Pool) *app {
-	return &app{ctx, repository.NewRepository(dbpool), make(map[string]repository.User)}
+func NewApp(ctx context.Context, dbpool *pgxpool.Pool, m mailer.Mailer) *app {
+	a := &app{
+		ctx:        ctx,
+		repo:       repository.NewRepository(dbpool),
+		sessions:   newSessionStore(),
+		pending:    newPendingLogins(),
+		guard:      newLoginGuard(),
+		mailer:     m,
+		require2FA: new(atomic.Bool),
+		sso:        ssoFromEnv(),
+	}
+	a.userTokens = a.repo
+	a.secondFactors = a.repo
+	a.apiRepo = a.repo
+	a.reviews = a.repo
+	a.shelves = a.repo
+	a.catalog = a.repo
+	a.hooks = newWebhookQueue(a.repo)
+	a.events = newEventBus()
+	a.events.listen(a.hooks.listener(ctx))
+	a.authenticators = authenticatorsFromEnv(a.repo, a.events)
+
+	require, err := a.repo.GetSetting(ctx, repository.REQUIRE_ADMIN_2FA)
+	if err != nil {
+		log.Println(err)
+	}
+	a.require2FA.Store(require == "true")
+
+	return a
 }
@@ -0,0 +1,144 @@
+package application
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// memCatalog - bookCatalog в памяти: фильтры сочетаются через and и ищут подстроку без учета регистра, как ilike в AllBook
+type memCatalog struct {
+	books []repository.Book
+	calls int
+}
+
+func (m *memCatalog) AllBook(ctx context.Context, pageNumber, pageSize int, s ...string) (p repository.Page, err error) {
+	m.calls++
+	for _, b := range m.books {
+		if !b.Visible && (len(s) < 7 || s[6] != "hidden") {
+			continue
+		}
+		match := true
+		for i, field := range []string{string(b.Category), b.Author, b.Series, b.Name, b.Link} {
+			if i < len(s) && !strings.Contains(strings.ToLower(field), strings.ToLower(s[i])) {
+				match = false
+			}
+		}
+		if match {
+			p.Books = append(p.Books, b)
+		}
+	}
+	p.Number, p.PageCount = pageNumber, 1
+	return
+}
+
+func catalogTestApp() (app, *memCatalog) {
+	catalog := &memCatalog{}
+	for _, b := range []repository.Book{
+		{Author: "Пушкин", Series: "Проза", Name: "Дубровский", Link: "/srv/pushkin/dubrovsky.fb2", Visible: true},
+		{Author: "Пушкин", Series: "Сказки", Name: "Сказка о рыбаке и рыбке", Link: "/srv/pushkin/fish.fb2", Visible: true},
+		{Author: "Гоголь", Series: "Проза", Name: "Мертвые души", Link: "/srv/gogol/souls.fb2", Visible: true},
+		{Author: "Пушкин", Series: "Проза", Name: "Пиковая дама", Link: "/srv/pushkin/queen.fb2", Visible: false},
+	} {
+		b.Book_Id, b.Category = uuid.New(), repository.Categories[1]
+		catalog.books = append(catalog.books, b)
+	}
+	// полки нужны для кнопок в строках списка
+	shelved, _, _ := shelfTestApp()
+	return app{ctx: context.Background(), catalog: catalog, shelves: shelved.shelves}, catalog
+}
+
+func getCatalog(a app, h httprouter.Handle, role UserRole, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r = r.WithContext(context.WithValue(r.Context(), "role", role))
+	rw := httptest.NewRecorder()
+	h(rw, r, nil)
+	return rw
+}
+
+func TestGetBooksCombinesFilters(t *testing.T) {
+	a, catalog := catalogTestApp()
+
+	for target, want := range map[string][]string{
+		"/user/books?series=Проза":                  {"Дубровский", "Мертвые души"},
+		"/user/books?author=пушкин":                 {"Дубровский", "Сказка о рыбаке и рыбке"},
+		"/user/books?author=Пушкин&series=Проза":    {"Дубровский"},
+		"/user/books?author=Гоголь&name=Дубровский": nil,
+	} {
+		catalog.calls = 0
+		rw := getCatalog(a, a.GetBooks, "USER", target)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rw.Code, rw.Body)
+		}
+		if catalog.calls != 1 {
+			t.Errorf("%s: %d catalog queries, want 1", target, catalog.calls)
+		}
+		body := rw.Body.String()
+		for _, b := range catalog.books {
+			shown := strings.Contains(body, b.Name)
+			wanted := false
+			for _, name := range want {
+				wanted = wanted || name == b.Name
+			}
+			if shown != wanted {
+				t.Errorf("%s: %q shown %v, want %v", target, b.Name, shown, wanted)
+			}
+		}
+	}
+
+	rw := getCatalog(a, a.GetBooksa, "ADMIN", "/admin/books?link=pushkin")
+	for _, name := range []string{"Дубровский", "Пиковая дама"} {
+		if !strings.Contains(rw.Body.String(), name) {
+			t.Errorf("admin list by link: %q not shown", name)
+		}
+	}
+}
+
+func TestGetBooksLinksKeepFilters(t *testing.T) {
+	a, _ := catalogTestApp()
+
+	rw := getCatalog(a, a.GetBooks, "USER", "/user/books?author=Пушкин&series=Проза+и+поэзия&sort=rating&page=1")
+	body := html.UnescapeString(rw.Body.String())
+	want := "/user/books?author=" + url.QueryEscape("Пушкин") + "&series=" + url.QueryEscape("Проза и поэзия") + "&page=1"
+	if !strings.Contains(body, want) {
+		t.Errorf("sort link %q not found", want)
+	}
+}
+
+func TestPostBooksSearch(t *testing.T) {
+	a, _ := catalogTestApp()
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/user/search", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rw := httptest.NewRecorder()
+		a.PostBooksSearch(rw, r, nil)
+		return rw
+	}
+
+	rw := post(url.Values{"author": {" Пушкин "}, "name": {"Сказка & рыбка"}, "series": {""}})
+	if rw.Code != http.StatusSeeOther {
+		t.Fatalf("status %d", rw.Code)
+	}
+	loc, err := url.Parse(rw.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	if loc.Path != "/user/books" || q.Get("author") != "Пушкин" || q.Get("name") != "Сказка & рыбка" || q.Has("series") || q.Get("page") != "1" {
+		t.Errorf("redirect to %s", loc)
+	}
+
+	if rw := post(url.Values{"author": {" "}}); rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "Заполните хотя бы одно поле") {
+		t.Errorf("empty search: status %d", rw.Code)
+	}
+}
@@ -0,0 +1,418 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/feed"
+	"biblio/internal/repository"
+)
+
+const (
+	// arrivalsWeeks - за сколько недель показывать новинки по умолчанию, arrivalsMaxWeeks - наибольший выбор
+	arrivalsWeeks    = 4
+	arrivalsMaxWeeks = 26
+	// arrivalsLimit - предел книг на странице новинок
+	arrivalsLimit = 1000
+	// feedSize - книг в ленте RSS/Atom
+	feedSize = 50
+	// followValueLength - предел длины имени автора или названия серии в подписке
+	followValueLength = 200
+	// followsLimit - сколько подписок может завести пользователь
+	followsLimit = 100
+	// followInterval - как часто проверять новые книги по подпискам и рассылать письма
+	followInterval = 15 * time.Minute
+)
+
+// arrivalWeeks - варианты периода на странице новинок
+var arrivalWeeks = []int{1, 2, 4, 8, 12, 26}
+
+type arrivalGenre struct {
+	Category string
+	Books    []bookLink
+}
+
+type arrivalWeek struct {
+	Start  time.Time
+	End    time.Time
+	Genres []arrivalGenre
+}
+
+// weekStart - начало недели (понедельник, 00:00) по местному времени
+func weekStart(t time.Time) time.Time {
+	t = t.Local()
+	day := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-day, 0, 0, 0, 0, time.Local)
+}
+
+// groupArrivals раскладывает книги, последние первыми, по неделям и жанрам. Любимые жанры пользователя
+// идут первыми, остальные - в порядке списка жанров
+func groupArrivals(books []bookLink, preferred []string) (weeks []arrivalWeek) {
+	rank := func(category string) int {
+		for i, c := range preferred {
+			if c == category {
+				return i - len(preferred)
+			}
+		}
+		for i, c := range repository.Categories {
+			if string(c) == category {
+				return i
+			}
+		}
+		return len(repository.Categories)
+	}
+
+	for _, b := range books {
+		start := weekStart(b.Publication)
+		if len(weeks) == 0 || !weeks[len(weeks)-1].Start.Equal(start) {
+			weeks = append(weeks, arrivalWeek{Start: start, End: start.AddDate(0, 0, 6)})
+		}
+		w := &weeks[len(weeks)-1]
+		i := 0
+		for i < len(w.Genres) && w.Genres[i].Category != string(b.Category) {
+			i++
+		}
+		if i == len(w.Genres) {
+			w.Genres = append(w.Genres, arrivalGenre{Category: string(b.Category)})
+		}
+		w.Genres[i].Books = append(w.Genres[i].Books, b)
+	}
+	for _, w := range weeks {
+		sort.SliceStable(w.Genres, func(i, j int) bool { return rank(w.Genres[i].Category) < rank(w.Genres[j].Category) })
+	}
+	return
+}
+
+// arrivalsCategory читает жанр из запроса; незнакомый жанр - все жанры
+func arrivalsCategory(r *http.Request) string {
+	category := r.URL.Query().Get("category")
+	for _, c := range repository.Categories {
+		if string(c) == category {
+			return category
+		}
+	}
+	return ""
+}
+
+// NewArrivalsPage - "Новинки": книги за последние недели по неделям и жанрам, ленты и подписки
+func (a app) NewArrivalsPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "new.html")
+
+	weeks, err := strconv.Atoi(r.URL.Query().Get("weeks"))
+	if err != nil || weeks < 1 || weeks > arrivalsMaxWeeks {
+		weeks = arrivalsWeeks
+	}
+	category := arrivalsCategory(r)
+	since := weekStart(time.Now()).AddDate(0, 0, -7*(weeks-1))
+
+	books, err := a.repo.NewArrivals(a.ctx, since, category, arrivalsLimit)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := a.repo.GetUserById(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	follows, err := a.repo.Follows(a.ctx, user.User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := ""
+	if category != "" {
+		query = "?" + url.Values{"category": {category}}.Encode()
+	}
+	type answer struct {
+		Weeks      []arrivalWeek
+		Period     int
+		Periods    []int
+		Category   string
+		Categories []string
+		Total      int
+		FeedQuery  string
+		Follows    []repository.Follow
+		Message    string
+	}
+	data := answer{
+		Weeks:     groupArrivals(bookLinks(r, books), user.Preferences),
+		Period:    weeks,
+		Periods:   arrivalWeeks,
+		Category:  category,
+		Total:     len(books),
+		FeedQuery: query,
+		Follows:   follows,
+		Message:   message,
+	}
+	for _, c := range repository.Categories {
+		data.Categories = append(data.Categories, string(c))
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "new", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// follow проверяет и сохраняет подписку. Ошибка - текст для пользователя
+func (a app) follow(userId, kind, value string) (f repository.Follow, status int, err error) {
+	k := repository.FOLLOW_AUTHOR
+	switch kind {
+	case string(repository.FOLLOW_AUTHOR):
+	case string(repository.FOLLOW_SERIES):
+		k = repository.FOLLOW_SERIES
+	default:
+		return f, http.StatusUnprocessableEntity, errors.New("Подписаться можно на автора (author) или серию (series)")
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" || value == "-" {
+		return f, http.StatusUnprocessableEntity, errors.New("Укажите автора или серию")
+	}
+	if utf8.RuneCountInString(value) > followValueLength {
+		return f, http.StatusUnprocessableEntity, fmt.Errorf("Длиннее %d символов", followValueLength)
+	}
+	follows, err := a.repo.Follows(a.ctx, userId)
+	if err != nil {
+		return f, http.StatusInternalServerError, err
+	}
+	if len(follows) >= followsLimit {
+		return f, http.StatusConflict, fmt.Errorf("Можно подписаться не больше чем на %d авторов и серий", followsLimit)
+	}
+	f, err = a.repo.AddFollow(a.ctx, userId, k, value)
+	if err != nil {
+		return f, http.StatusInternalServerError, err
+	}
+	return f, http.StatusOK, nil
+}
+
+// Follow - POST /user/follows, подписка на новые книги автора или серии. Поле back - куда вернуться
+func (a app) Follow(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	_, status, err := a.follow(currentUser(r).User_Id.String(), r.FormValue("kind"), r.FormValue("value"))
+	if status == http.StatusInternalServerError {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		a.NewArrivalsPage(rw, r, err.Error())
+		return
+	}
+	http.Redirect(rw, r, localPath(r.FormValue("back"), "/user/new"), http.StatusSeeOther)
+}
+
+func (a app) Unfollow(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, _ := strconv.ParseInt(p.ByName("id"), 10, 64)
+	err := a.repo.DeleteFollow(a.ctx, currentUser(r).User_Id.String(), id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, localPath(r.FormValue("back"), "/user/new"), http.StatusSeeOther)
+}
+
+func feedItems(books []repository.Book) []feed.Item {
+	items := make([]feed.Item, len(books))
+	for i, b := range books {
+		items[i] = feed.Item{
+			ID:        "urn:uuid:" + b.Book_Id.String(),
+			Title:     b.Name,
+			Link:      baseURL + "/user/books/open/" + b.Book_Id.String(),
+			Author:    b.Author,
+			Category:  string(b.Category),
+			Summary:   b.Annotation,
+			Published: b.Publication,
+		}
+	}
+	return items
+}
+
+// writeNewsFeed отдает ленту целиком, чтобы ошибку можно было вернуть кодом ответа
+func writeNewsFeed(rw http.ResponseWriter, r *http.Request, f feed.Format, ch feed.Channel, books []repository.Book) {
+	ch.Self = baseURL + r.URL.RequestURI()
+	ch.Updated = time.Now()
+	if len(books) > 0 {
+		ch.Updated = books[0].Publication
+	}
+	var buf bytes.Buffer
+	err := feed.Write(&buf, f.Name, ch, feedItems(books))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", f.ContentType+";charset=utf-8")
+	rw.Write(buf.Bytes())
+}
+
+// NewArrivalsFeed - GET /feed/new.rss и /feed/new.atom?category=, последние книги каталога или жанра.
+// Вход как в OPDS: программы чтения лент не умеют входить через форму
+func (a app) NewArrivalsFeed(f feed.Format) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		category := arrivalsCategory(r)
+		books, err := a.repo.NewArrivals(a.ctx, time.Time{}, category, feedSize)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ch := feed.Channel{
+			ID:          "urn:biblio:feed:new",
+			Title:       "Изба - читальня: новинки",
+			Description: "Книги, добавленные в библиотеку последними",
+			Link:        baseURL + "/user/new",
+		}
+		if category != "" {
+			q := "?" + url.Values{"category": {category}}.Encode()
+			ch.ID += ":" + url.QueryEscape(category)
+			ch.Title += ", " + category
+			ch.Description = "Книги жанра «" + category + "», добавленные последними"
+			ch.Link += q
+		}
+		writeNewsFeed(rw, r, f, ch, books)
+	}
+}
+
+// FollowsFeed - GET /feed/follows.rss и /feed/follows.atom, новые книги по подпискам пользователя
+func (a app) FollowsFeed(f feed.Format) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		user := currentUser(r)
+		books, err := a.repo.FollowedBooks(a.ctx, user.User_Id.String(), feedSize)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeNewsFeed(rw, r, f, feed.Channel{
+			ID:          "urn:biblio:feed:follows:" + user.User_Id.String(),
+			Title:       "Изба - читальня: мои подписки",
+			Description: "Новые книги авторов и серий, на которые вы подписаны",
+			Link:        baseURL + "/user/new",
+		}, books)
+	}
+}
+
+// notifyFollowers рассылает письма о новых книгах по подпискам: одно письмо на пользователя за проверку.
+// Тем, у кого адрес не подтвержден, писем нет, новые книги остаются в ленте подписок
+func (a app) notifyFollowers() {
+	until := time.Now()
+	notices, err := a.repo.FollowNotices(a.ctx, until)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for len(notices) > 0 {
+		n := 1
+		for n < len(notices) && notices[n].User_Id == notices[0].User_Id {
+			n++
+		}
+		user := notices[:n]
+		notices = notices[n:]
+
+		if user[0].Mailable {
+			err = a.mailer.Send(a.ctx, user[0].Email, "Изба - читальня: новые книги по подпискам", followLetter(user))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+		}
+		err = a.repo.MarkFollowsNotified(a.ctx, user[0].User_Id.String(), until)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// followLetter - текст письма о новых книгах одного пользователя. Книга, подходящая под несколько
+// подписок, упоминается один раз
+func followLetter(notices []repository.FollowNotice) string {
+	var b strings.Builder
+	b.WriteString("Здравствуйте!\n\nВ библиотеке появились книги авторов и серий, на которые вы подписаны:\n")
+	seen := make(map[string]bool)
+	for _, n := range notices {
+		id := n.Book.Book_Id.String()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		fmt.Fprintf(&b, "\n%s - %s\n%s/user/books/open/%s\n", n.Book.Author, n.Book.Name, baseURL, id)
+	}
+	fmt.Fprintf(&b, "\nУправлять подписками можно на странице %s/user/new", baseURL)
+	return b.String()
+}
+
+type followInput struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// APIFollows - GET /api/v1/follows, подписки текущего пользователя
+func (a app) APIFollows(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	follows, err := a.repo.Follows(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if follows == nil {
+		follows = []repository.Follow{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": follows})
+}
+
+// APIFollow - POST /api/v1/follows, подписка на автора или серию. Повторная подписка возвращает прежнюю
+func (a app) APIFollow(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var in followInput
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, apiMaxBody)).Decode(&in)
+	if err != nil {
+		apiFail(rw, http.StatusBadRequest, "invalid_json", "Тело запроса должно быть объектом JSON: "+err.Error())
+		return
+	}
+	f, status, err := a.follow(currentUser(r).User_Id.String(), in.Kind, in.Value)
+	switch status {
+	case http.StatusOK:
+		writeJSON(rw, http.StatusCreated, f)
+	case http.StatusUnprocessableEntity:
+		field := "value"
+		if in.Kind != string(repository.FOLLOW_AUTHOR) && in.Kind != string(repository.FOLLOW_SERIES) {
+			field = "kind"
+		}
+		apiInvalid(rw, map[string]string{field: err.Error()})
+	case http.StatusConflict:
+		apiFail(rw, status, "conflict", err.Error())
+	default:
+		apiFail(rw, status, "internal", err.Error())
+	}
+}
+
+// APIUnfollow - DELETE /api/v1/follows/:id. Удалять несуществующую подписку не ошибка
+func (a app) APIUnfollow(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := strconv.ParseInt(p.ByName("id"), 10, 64)
+	if err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Подписка не найдена")
+		return
+	}
+	err = a.repo.DeleteFollow(a.ctx, currentUser(r).User_Id.String(), id)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package application
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/repository"
+)
+
+func TestWeekStart(t *testing.T) {
+	for _, day := range []int{19, 22, 25} {
+		got := weekStart(time.Date(2026, 10, day, 15, 4, 5, 0, time.Local))
+		if want := time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+			t.Errorf("October %d: got %v, want %v", day, got, want)
+		}
+	}
+}
+
+func TestGroupArrivals(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2026, 10, day, 12, 0, 0, 0, time.Local) }
+	books := []bookLink{
+		{Book: repository.Book{Name: "1", Category: repository.CLASSIC, Publication: at(21)}},
+		{Book: repository.Book{Name: "2", Category: repository.HUMOR, Publication: at(20)}},
+		{Book: repository.Book{Name: "3", Category: repository.CLASSIC, Publication: at(19)}},
+		{Book: repository.Book{Name: "4", Category: repository.FANTASY, Publication: at(14)}},
+	}
+
+	weeks := groupArrivals(books, []string{string(repository.HUMOR)})
+	if len(weeks) != 2 || weeks[0].Start.Day() != 19 || weeks[1].Start.Day() != 12 || weeks[1].End.Day() != 18 {
+		t.Fatalf("bad weeks: %+v", weeks)
+	}
+	genres := weeks[0].Genres
+	if len(genres) != 2 || genres[0].Category != string(repository.HUMOR) || genres[1].Category != string(repository.CLASSIC) {
+		t.Fatalf("preferred genre must go first: %+v", genres)
+	}
+	if len(genres[1].Books) != 2 || genres[1].Books[0].Name != "1" || genres[1].Books[1].Name != "3" {
+		t.Errorf("books of a genre keep their order: %+v", genres[1].Books)
+	}
+
+	weeks = groupArrivals(books, nil)
+	if weeks[0].Genres[0].Category != string(repository.CLASSIC) {
+		t.Errorf("without preferences genres follow the list of genres: %+v", weeks[0].Genres)
+	}
+}
+
+func TestFollowLetter(t *testing.T) {
+	b := repository.Book{Book_Id: uuid.New(), Author: "Пушкин А. С.", Series: "Повести Белкина", Name: "Выстрел"}
+	letter := followLetter([]repository.FollowNotice{
+		{Kind: repository.FOLLOW_AUTHOR, Value: b.Author, Book: b},
+		{Kind: repository.FOLLOW_SERIES, Value: b.Series, Book: b},
+	})
+	if n := strings.Count(letter, b.Book_Id.String()); n != 1 {
+		t.Errorf("book mentioned %d times:\n%s", n, letter)
+	}
+	if !strings.Contains(letter, "Пушкин А. С. - Выстрел") {
+		t.Errorf("no book in letter:\n%s", letter)
+	}
+}
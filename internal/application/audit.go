@@ -0,0 +1,311 @@
+package application
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// auditPageSize - сколько записей журнала аудита на странице
+const auditPageSize = 50
+
+// auditLabels - подписи действий журнала аудита
+var auditLabels = map[string]string{
+	string(repository.AUDIT_LOGIN):           "Вход",
+	string(repository.AUDIT_LOGOUT):          "Выход",
+	string(repository.AUDIT_SIGNUP):          "Регистрация",
+	string(repository.AUDIT_PASSWORD_CHANGE): "Смена пароля",
+	string(repository.AUDIT_PASSWORD_RESET):  "Сброс пароля",
+	string(repository.AUDIT_2FA_ENABLE):      "Включение 2FA",
+	string(repository.AUDIT_2FA_DISABLE):     "Отключение 2FA",
+	string(repository.AUDIT_2FA_RECOVERY):    "Новые коды восстановления",
+	string(repository.AUDIT_SESSION_END):     "Завершение сеанса",
+	string(repository.AUDIT_TOKEN_CREATE):    "Выпуск токена",
+	string(repository.AUDIT_TOKEN_REVOKE):    "Отзыв токена",
+	string(repository.AUDIT_USER_UPDATE):     "Изменение пользователя",
+	string(repository.AUDIT_USER_UNLOCK):     "Разблокировка входа",
+	string(repository.AUDIT_USER_DELETE):     "Удаление пользователя",
+	string(repository.AUDIT_USER_RESTORE):    "Восстановление пользователя",
+	string(repository.AUDIT_USER_PURGE):      "Окончательное удаление пользователя",
+	string(repository.AUDIT_SETTINGS_UPDATE): "Изменение настроек",
+	string(repository.AUDIT_BOOK_CREATE):     "Создание книги",
+	string(repository.AUDIT_BOOK_UPDATE):     "Изменение книги",
+	string(repository.AUDIT_BOOK_REVERT):     "Возврат книги к ревизии",
+	string(repository.AUDIT_BOOK_DELETE):     "Удаление книги",
+	string(repository.AUDIT_BOOK_RESTORE):    "Восстановление книги",
+	string(repository.AUDIT_BOOK_PURGE):      "Окончательное удаление книги",
+	string(repository.AUDIT_BOOK_IMPORT):     "Импорт книг",
+	string(repository.AUDIT_REVIEW_APPROVE):  "Публикация отзыва",
+	string(repository.AUDIT_REVIEW_REJECT):   "Отклонение отзыва",
+	string(repository.AUDIT_WEBHOOK_CREATE):  "Создание вебхука",
+	string(repository.AUDIT_WEBHOOK_TOGGLE):  "Включение или приостановка вебхука",
+	string(repository.AUDIT_WEBHOOK_DELETE):  "Удаление вебхука",
+	string(repository.AUDIT_WEBHOOK_PING):    "Проверка вебхука",
+	string(repository.AUDIT_WEBHOOK_RESEND):  "Повторная доставка",
+}
+
+// audit записывает действие в журнал аудита. Исполнитель - пользователь запроса, если в e он не задан,
+// адрес - адрес клиента. Действие к этому моменту уже выполнено, поэтому ошибка только пишется в журнал сервера
+func (a app) audit(r *http.Request, e repository.AuditEntry) {
+	if e.Actor == "" {
+		if u := currentUser(r); u.User_Id != uuid.Nil {
+			e.Actor_Id, e.Actor = &u.User_Id, u.Username
+		}
+	}
+	if e.IP == "" {
+		e.IP = clientIP(r)
+	}
+	_, err := a.repo.AddAuditEntry(a.ctx, e)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// auditState - состояние объекта для Before и After в JSON, пусто для nil
+func auditState(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	return string(data)
+}
+
+// auditTarget дополняет запись объектом действия и его состоянием до и после
+func auditTarget(e repository.AuditEntry, kind, id, name string, before, after interface{}) repository.AuditEntry {
+	e.TargetType, e.TargetId, e.TargetName = kind, id, name
+	e.Before, e.After = auditState(before), auditState(after)
+	return e
+}
+
+// auditBook - запись о действии с книгой. old - книга до действия, b - после; nil, если книги нет
+func auditBook(e repository.AuditEntry, old, b *repository.Book) repository.AuditEntry {
+	cur := b
+	if cur == nil {
+		cur = old
+	}
+	return auditTarget(e, "book", cur.Book_Id.String(), cur.Author+" - "+cur.Name, old, b)
+}
+
+// auditUser - запись о действии с пользователем, как auditBook
+func auditUser(e repository.AuditEntry, old, u *repository.User) repository.AuditEntry {
+	cur := u
+	if cur == nil {
+		cur = old
+	}
+	return auditTarget(e, "user", cur.User_Id.String(), cur.Username, old, u)
+}
+
+// auditSelf - запись о действии пользователя u со своей учетной записью: вход, смена пароля и т.п.
+func auditSelf(e repository.AuditEntry, u repository.User) repository.AuditEntry {
+	e.Actor_Id, e.Actor = &u.User_Id, u.Username
+	return auditTarget(e, "user", u.User_Id.String(), u.Username, nil, nil)
+}
+
+// auditChain проверяет цепочку хэшей, получая записи журнала по порядку
+type auditChain struct {
+	prev    string
+	Checked int
+	// Broken - первая запись, на которой цепочка нарушена, 0 - цепочка цела
+	Broken int64
+}
+
+func (c *auditChain) check(e repository.AuditEntry) error {
+	if c.Broken == 0 && (e.PrevHash != c.prev || e.ChainHash() != e.Hash) {
+		c.Broken = e.Entry_Id
+	}
+	c.prev = e.Hash
+	c.Checked++
+	return nil
+}
+
+// readAuditFilter читает отбор журнала из параметров запроса. Даты from и to - дни включительно
+func readAuditFilter(q url.Values) (f repository.AuditFilter, err error) {
+	f = repository.AuditFilter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: q.Get("action"),
+		Target: strings.TrimSpace(q.Get("target")),
+	}
+	if v := q.Get("from"); v != "" {
+		f.From, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			err = fmt.Errorf("Неверная дата: %q", v)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		f.To, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			err = fmt.Errorf("Неверная дата: %q", v)
+			return
+		}
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// auditQueryString - параметры отбора для ссылок на страницы и выгрузку
+func auditQueryString(q url.Values) string {
+	v := url.Values{}
+	for _, k := range []string{"actor", "action", "target", "from", "to"} {
+		if q.Get(k) != "" {
+			v.Set(k, q.Get(k))
+		}
+	}
+	return v.Encode()
+}
+
+// AuditPage - GET /admin/audit, журнал аудита с отбором по исполнителю, действию, объекту и датам
+func (a app) AuditPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.auditPage(rw, r, nil)
+}
+
+// AuditVerify - GET /admin/audit/verify, проверка цепочки хэшей всего журнала
+func (a app) AuditVerify(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var chain auditChain
+	err := a.repo.EachAuditEntry(a.ctx, repository.AuditFilter{}, chain.check)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.auditPage(rw, r, &chain)
+}
+
+func (a app) auditPage(rw http.ResponseWriter, r *http.Request, chain *auditChain) {
+	lp := filepath.Join("public", "html", "audit.html")
+
+	q := r.URL.Query()
+	f, err := readAuditFilter(q)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageNumber, _ := strconv.Atoi(q.Get("page"))
+	if pageNumber < 1 {
+		pageNumber = 1
+	}
+	f.Limit, f.Offset = auditPageSize, (pageNumber-1)*auditPageSize
+
+	entries, total, err := a.repo.AuditEntries(a.ctx, f)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var page repository.Page
+	page.PageCount = int(math.Ceil(float64(total) / float64(auditPageSize)))
+	for i := 1; i <= page.PageCount; i++ {
+		page.Str = append(page.Str, i)
+	}
+	page.Number, page.NextNumber, page.PrevNumber = pageNumber, pageNumber+1, pageNumber-1
+	query := auditQueryString(q)
+	page.PageUrl = "/admin/audit?" + query
+	if query != "" {
+		page.PageUrl += "&"
+	}
+	page.PageUrl += "page="
+
+	tmpl, err := parseTemplates(r, lp, head, headera, pager)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type action struct {
+		Value string
+		Label string
+	}
+	type answer struct {
+		Entries []repository.AuditEntry
+		Labels  map[string]string
+		Actions []action
+		Query   url.Values
+		Export  string
+		Page    repository.Page
+		Total   int
+		Chain   *auditChain
+	}
+	data := answer{Entries: entries, Labels: auditLabels, Query: q, Export: "/admin/audit/export?" + query, Page: page, Total: total, Chain: chain}
+	for _, v := range repository.AuditActions {
+		data.Actions = append(data.Actions, action{string(v), auditLabels[string(v)]})
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "audit", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// auditHeader - столбцы выгрузки журнала. Хэши выгружаются, чтобы цепочку можно было проверить и по файлу.
+// Перед такой проверкой у значений, начинающихся с апострофа перед = + - @, апостроф нужно убрать, см. csvCell
+var auditHeader = []string{"entry_id", "created_at", "actor_id", "actor", "action", "target_type", "target_id", "target_name",
+	"before", "after", "ip", "prev_hash", "hash"}
+
+func auditRecord(e repository.AuditEntry) []string {
+	actorId := ""
+	if e.Actor_Id != nil {
+		actorId = e.Actor_Id.String()
+	}
+	record := []string{strconv.FormatInt(e.Entry_Id, 10), e.CreatedAt.UTC().Format(time.RFC3339Nano), actorId, e.Actor, string(e.Action),
+		e.TargetType, e.TargetId, e.TargetName, e.Before, e.After, e.IP, e.PrevHash, e.Hash}
+	for i, v := range record {
+		record[i] = csvCell(v)
+	}
+	return record
+}
+
+// csvCell не дает табличному редактору принять значение за формулу: логины и названия задают пользователи,
+// и ячейка вида =HYPERLINK(...) выполнилась бы у администратора, открывшего выгрузку
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// AuditExport - GET /admin/audit/export, журнал с тем же отбором в CSV в порядке добавления записей
+func (a app) AuditExport(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f, err := readAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := &countingWriter{w: rw}
+	w := csv.NewWriter(out)
+	rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	rw.Header().Set("Content-Disposition", `attachment; filename="biblio-audit-`+time.Now().Format("20060102")+`.csv"`)
+
+	// как и в выгрузке каталога, после первых байтов ошибка только пишется в журнал
+	err = w.Write(auditHeader)
+	if err == nil {
+		err = a.repo.EachAuditEntry(r.Context(), f, func(e repository.AuditEntry) error {
+			return w.Write(auditRecord(e))
+		})
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil && out.n == 0 {
+		rw.Header().Del("Content-Disposition")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		log.Printf("audit export: %v", err)
+	}
+}
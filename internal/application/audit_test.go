@@ -0,0 +1,83 @@
+package application
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+func TestAuditChain(t *testing.T) {
+	var entries []repository.AuditEntry
+	prev := ""
+	for i, actor := range []string{"alice", "bob", "carol"} {
+		e := repository.AuditEntry{
+			Entry_Id:  int64(i + 1),
+			CreatedAt: time.Date(2026, 1, 2, 3, 4, i, 0, time.UTC),
+			Actor:     actor,
+			Action:    repository.AUDIT_LOGIN,
+			PrevHash:  prev,
+		}
+		e.Hash = e.ChainHash()
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+
+	var chain auditChain
+	for _, e := range entries {
+		chain.check(e)
+	}
+	if chain.Broken != 0 || chain.Checked != 3 {
+		t.Fatalf("intact chain: broken %d, checked %d", chain.Broken, chain.Checked)
+	}
+
+	entries[1].Actor = "mallory"
+	chain = auditChain{}
+	for _, e := range entries {
+		chain.check(e)
+	}
+	if chain.Broken != 2 {
+		t.Errorf("tampered chain: broken %d, want 2", chain.Broken)
+	}
+
+	entries[1].Actor = "bob"
+	chain = auditChain{}
+	for _, e := range append(entries[:1:1], entries[2]) {
+		chain.check(e)
+	}
+	if chain.Broken != 3 {
+		t.Errorf("chain with removed entry: broken %d, want 3", chain.Broken)
+	}
+}
+
+func TestReadAuditFilter(t *testing.T) {
+	f, err := readAuditFilter(url.Values{"actor": {" admin "}, "from": {"2026-03-01"}, "to": {"2026-03-31"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Actor != "admin" {
+		t.Errorf("actor %q", f.Actor)
+	}
+	if want := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local); !f.From.Equal(want) {
+		t.Errorf("from %v, want %v", f.From, want)
+	}
+	if want := time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local); !f.To.Equal(want) {
+		t.Errorf("to %v, want %v", f.To, want)
+	}
+
+	if _, err := readAuditFilter(url.Values{"to": {"31.03.2026"}}); err == nil {
+		t.Error("bad date accepted")
+	}
+}
+
+func TestAuditRecordEscapesFormulas(t *testing.T) {
+	e := repository.AuditEntry{Actor: "=HYPERLINK(\"http://evil\")", TargetName: "-1+2", IP: "10.0.0.1", Before: `{"name":"x"}`}
+	record := auditRecord(e)
+	if record[3] != `'=HYPERLINK("http://evil")` || record[7] != "'-1+2" {
+		t.Errorf("formulas not escaped: %q, %q", record[3], record[7])
+	}
+	if record[8] != e.Before || record[10] != e.IP {
+		t.Errorf("plain values changed: %q, %q", record[8], record[10])
+	}
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"context"
+	"errors"
+
+	"biblio/internal/repository"
+)
+
+// errAccessDenied - пароль верный, но группам пользователя не разрешен доступ к библиотеке
+var errAccessDenied = errors.New("access denied")
+
+// authenticator проверяет логин и пароль. Login опрашивает их по очереди,
+// первый успешный ответ определяет пользователя
+type authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (repository.User, error)
+}
+
+// dbAuthenticator - пользователи, зарегистрированные в самой библиотеке
+type dbAuthenticator struct {
+	repo *repository.Repository
+}
+
+func (d dbAuthenticator) Authenticate(ctx context.Context, login, password string) (repository.User, error) {
+	return d.repo.Login(ctx, login, hashPassword(password))
+}
+
+func authenticatorsFromEnv(repo *repository.Repository, events *eventBus) []authenticator {
+	list := []authenticator{dbAuthenticator{repo}}
+	if l := ldapFromEnv(repo, events); l != nil {
+		list = append(list, l)
+	}
+	return list
+}
+
+// authenticate возвращает errAccessDenied, если хотя бы один источник подтвердил пароль,
+// но запретил вход, и последнюю ошибку, если пароль не подошел нигде
+func (a app) authenticate(login, password string) (user repository.User, err error) {
+	denied := false
+	for _, auth := range a.authenticators {
+		user, err = auth.Authenticate(a.ctx, login, password)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errAccessDenied) {
+			denied = true
+		}
+	}
+	if denied {
+		err = errAccessDenied
+	}
+	return
+}
+
+// mapRole сопоставляет группы внешнего каталога ролям библиотеки; пустая строка - вход запрещен.
+// Если список групп пользователей пуст, входить могут все, кто не попал в администраторы
+func mapRole(groups, adminGroups, userGroups []string) string {
+	in := func(list []string) bool {
+		for _, g := range groups {
+			for _, l := range list {
+				if g == l {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch {
+	case in(adminGroups):
+		return string(repository.ADMIN)
+	case len(userGroups) == 0 || in(userGroups):
+		return string(repository.USER)
+	}
+	return ""
+}
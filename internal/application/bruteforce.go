@@ -0,0 +1,243 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// после стольких неудачных попыток включается задержка и проверочный вопрос
+	backoffAfter = 3
+	// после стольких неудачных попыток учетная запись или адрес блокируются
+	lockoutAfter = 10
+	backoffBase  = 2 * time.Second
+	backoffMax   = 5 * time.Minute
+	lockoutTime  = 30 * time.Minute
+	// счетчик неудач сбрасывается, если попыток не было дольше этого времени
+	failureTTL = time.Hour
+	// сколько действует проверочный вопрос
+	captchaTTL = 10 * time.Minute
+)
+
+type failures struct {
+	count   int
+	last    time.Time
+	blocked time.Time
+}
+
+// loginGuard считает неудачные входы отдельно по логину и по IP-адресу
+type loginGuard struct {
+	mu      sync.Mutex
+	account map[string]*failures
+	ip      map[string]*failures
+	// captchas - уже проверенные проверочные вопросы до истечения их срока, чтобы ответ нельзя было повторить
+	captchas map[string]time.Time
+	// resets - когда по ключу (адресу почты или IP) последний раз запрашивали смену пароля
+	resets map[string]time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{account: make(map[string]*failures), ip: make(map[string]*failures), captchas: make(map[string]time.Time),
+		resets: make(map[string]time.Time)}
+}
+
+// expired - счетчик устарел и его можно забыть
+func (f *failures) expired(now time.Time) bool {
+	return now.Sub(f.last) > failureTTL && now.After(f.blocked)
+}
+
+// get возвращает счетчик для записи неудачи, заводя его при необходимости
+func (g *loginGuard) get(m map[string]*failures, key string, now time.Time) *failures {
+	f, ok := m[key]
+	if !ok || f.expired(now) {
+		f = &failures{}
+		m[key] = f
+	}
+	return f
+}
+
+// peek возвращает счетчик только для чтения: отсутствующий или устаревший - пустой, в карту ничего не добавляется
+func (g *loginGuard) peek(m map[string]*failures, key string, now time.Time) failures {
+	f, ok := m[key]
+	if !ok || f.expired(now) {
+		return failures{}
+	}
+	return *f
+}
+
+// wait возвращает, сколько еще нужно подождать до следующей попытки входа
+func (g *loginGuard) wait(login, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var d time.Duration
+	for _, f := range []failures{g.peek(g.account, login, now), g.peek(g.ip, ip, now)} {
+		if w := f.blocked.Sub(now); w > d {
+			d = w
+		}
+	}
+	return d
+}
+
+// needsCaptcha сообщает, что с логина или адреса уже было достаточно неудач для проверочного вопроса
+func (g *loginGuard) needsCaptcha(login, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	return g.peek(g.account, login, now).count >= backoffAfter || g.peek(g.ip, ip, now).count >= backoffAfter
+}
+
+func (g *loginGuard) fail(login, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for _, f := range []*failures{g.get(g.account, login, now), g.get(g.ip, ip, now)} {
+		f.count++
+		f.last = now
+		switch {
+		case f.count >= lockoutAfter:
+			f.blocked = now.Add(lockoutTime)
+		case f.count >= backoffAfter:
+			d := backoffBase << (f.count - backoffAfter)
+			if d > backoffMax {
+				d = backoffMax
+			}
+			f.blocked = now.Add(d)
+		}
+	}
+}
+
+// success сбрасывает счетчик учетной записи. Счетчик адреса не сбрасывается: иначе с одного адреса можно
+// перебирать пароли, обнуляя его входами в свою учетную запись
+func (g *loginGuard) success(login string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.account, login)
+}
+
+// unlock снимает блокировку с учетной записи, вызывается администратором
+func (g *loginGuard) unlock(login string) {
+	g.success(login)
+}
+
+func (g *loginGuard) locked(login string) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.peek(g.account, login, time.Now()).blocked
+}
+
+// sweep забывает устаревшие счетчики и использованные проверочные вопросы с истекшим сроком
+func (g *loginGuard) sweep() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for _, m := range []map[string]*failures{g.account, g.ip} {
+		for k, f := range m {
+			if f.expired(now) {
+				delete(m, k)
+			}
+		}
+	}
+	for nonce, expires := range g.captchas {
+		if now.After(expires) {
+			delete(g.captchas, nonce)
+		}
+	}
+	for key, last := range g.resets {
+		if now.Sub(last) > resetMailInterval {
+			delete(g.resets, key)
+		}
+	}
+}
+
+// allowReset разрешает запрос смены пароля по ключу не чаще interval
+func (g *loginGuard) allowReset(key string, interval time.Duration) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := g.resets[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	g.resets[key] = now
+	return true
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type captcha struct {
+	Question string
+	Token    string
+}
+
+// newCaptcha генерирует простой арифметический вопрос. Ответ не хранится на сервере:
+// он подписан вместе со сроком действия и случайным номером и передается в скрытом поле формы.
+// Номер делает вопрос одноразовым, см. checkCaptcha
+func newCaptcha() captcha {
+	x, y := rand.Intn(9)+1, rand.Intn(9)+1
+	expires := strconv.FormatInt(time.Now().Add(captchaTTL).Unix(), 10)
+	nonce := uuid.NewString()
+	return captcha{
+		Question: fmt.Sprintf("Сколько будет %d + %d?", x, y),
+		Token:    expires + "." + nonce + "." + captchaSign(strconv.Itoa(x+y), expires, nonce),
+	}
+}
+
+func captchaSign(answer, expires, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte("captcha:" + answer + ":" + expires + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCaptcha проверяет ответ. Решенный вопрос принимается один раз, повторить его с тем же ответом нельзя;
+// неверные ответы считаются неудачными попытками входа
+func (g *loginGuard) checkCaptcha(token, answer string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	expires, nonce, sign := parts[0], parts[1], parts[2]
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	if !hmac.Equal([]byte(sign), []byte(captchaSign(strings.TrimSpace(answer), expires, nonce))) {
+		return false
+	}
+	return g.useCaptcha(nonce, unix)
+}
+
+// useCaptcha отмечает вопрос использованным; false, если он уже был использован
+func (g *loginGuard) useCaptcha(nonce string, expires int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, used := g.captchas[nonce]; used {
+		return false
+	}
+	g.captchas[nonce] = time.Unix(expires, 0)
+	return true
+}
@@ -0,0 +1,71 @@
+package application
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	g := newLoginGuard()
+	if g.needsCaptcha("alice", "10.0.0.1") || g.wait("alice", "10.0.0.1") != 0 {
+		t.Fatal("clean guard asks for captcha or delay")
+	}
+	if len(g.account) != 0 || len(g.ip) != 0 {
+		t.Fatalf("reads added entries: %d accounts, %d addresses", len(g.account), len(g.ip))
+	}
+
+	for i := 0; i < backoffAfter; i++ {
+		g.fail("alice", "10.0.0.1")
+	}
+	g.success("mallory")
+	g.success("alice")
+	if !g.needsCaptcha("bob", "10.0.0.1") {
+		t.Error("successful login reset the address counter")
+	}
+	if g.needsCaptcha("alice", "10.0.0.2") {
+		t.Error("successful login kept the account counter")
+	}
+}
+
+func TestCaptchaSingleUse(t *testing.T) {
+	g := newLoginGuard()
+	c := newCaptcha()
+	var x, y int
+	if _, err := fmt.Sscanf(c.Question, "Сколько будет %d + %d?", &x, &y); err != nil {
+		t.Fatal(err)
+	}
+	answer := strconv.Itoa(x + y)
+
+	if g.checkCaptcha(c.Token, strconv.Itoa(x+y+1)) {
+		t.Error("wrong answer accepted")
+	}
+	if !g.checkCaptcha(c.Token, answer) {
+		t.Fatal("right answer rejected")
+	}
+	if g.checkCaptcha(c.Token, answer) {
+		t.Error("solved captcha replayed")
+	}
+	if g.checkCaptcha(strings.Replace(c.Token, ".", ".x", 1), answer) {
+		t.Error("token with another nonce accepted")
+	}
+}
+
+func TestAllowReset(t *testing.T) {
+	g := newLoginGuard()
+	if !g.allowReset("mail:reader@test", time.Minute) {
+		t.Fatal("first request refused")
+	}
+	if g.allowReset("mail:reader@test", time.Minute) {
+		t.Error("second request within interval allowed")
+	}
+	if !g.allowReset("mail:other@test", time.Minute) {
+		t.Error("other address refused")
+	}
+	g.resets["mail:reader@test"] = time.Now().Add(-2 * time.Minute)
+	if !g.allowReset("mail:reader@test", time.Minute) {
+		t.Error("request after interval refused")
+	}
+}
@@ -0,0 +1,290 @@
+package application
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/citation"
+	"biblio/internal/repository"
+)
+
+// citationLibrary - название библиотеки в библиографических ссылках
+const citationLibrary = "Изба-читальня"
+
+// basketLimit - сколько книг можно собрать в списке литературы
+const basketLimit = 500
+
+func citationOptions() citation.Options {
+	return citation.Options{
+		Library: citationLibrary,
+		URL: func(b repository.Book) string {
+			return baseURL + "/user/books/open/" + b.Book_Id.String()
+		},
+		Accessed: time.Now(),
+	}
+}
+
+// citationFormat читает параметр format; по умолчанию - BibTeX
+func citationFormat(r *http.Request) (citation.Format, bool) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = citation.Formats[0].Name
+	}
+	return citation.Lookup(name)
+}
+
+func citationFormatNames() string {
+	names := make([]string, len(citation.Formats))
+	for i, f := range citation.Formats {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeCitation отдает описание книг файлом. Описание собирается целиком, чтобы ошибку можно было вернуть кодом ответа
+func writeCitation(rw http.ResponseWriter, f citation.Format, filename string, books []repository.Book) error {
+	var buf bytes.Buffer
+	err := citation.Write(&buf, f.Name, books, citationOptions())
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", f.ContentType)
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+filename+f.Ext+`"`)
+	_, err = rw.Write(buf.Bytes())
+	return err
+}
+
+// bookPage - страница книги для роли пользователя
+func bookPage(r *http.Request, id string) string {
+	if r.Context().Value("role").(UserRole) == "ADMIN" {
+		return "/admin/books/open/" + id
+	}
+	return "/user/books/open/" + id
+}
+
+// CiteBook - GET /user/books/cite/:id?format=, описание одной книги файлом
+func (a app) CiteBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f, ok := citationFormat(r)
+	if !ok {
+		http.Error(rw, "Неизвестный формат, допустимые: "+citationFormatNames(), http.StatusBadRequest)
+		return
+	}
+	book, err := a.visibleBook(r, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, "Книга не найдена", http.StatusNotFound)
+		return
+	}
+	err = writeCitation(rw, f, "book-"+book.Book_Id.String(), []repository.Book{book})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a app) BasketPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "basket.html")
+
+	books, err := a.repo.Basket(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type item struct {
+		N        int
+		Book     repository.Book
+		Page     string
+		Citation string
+	}
+	type answer struct {
+		Items   []item
+		Formats []citation.Format
+		Message string
+	}
+	data := answer{Formats: citation.Formats, Message: message}
+	o := citationOptions()
+	for i, b := range books {
+		data.Items = append(data.Items, item{i + 1, b, bookPage(r, b.Book_Id.String()), citation.GOST(b, o)})
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "basket", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// addToBasket проверяет книгу и предел списка. Ошибка - текст для пользователя
+func (a app) addToBasket(userId, bookId string) (status int, err error) {
+	if _, err = uuid.Parse(bookId); err != nil {
+		return http.StatusNotFound, errors.New("Книга не найдена")
+	}
+	_, err = a.repo.GetBookById(a.ctx, bookId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return http.StatusNotFound, errors.New("Книга не найдена")
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	books, err := a.repo.Basket(a.ctx, userId)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(books) >= basketLimit {
+		for _, b := range books {
+			if b.Book_Id.String() == bookId {
+				return http.StatusOK, nil
+			}
+		}
+		return http.StatusConflict, errors.New("В списке литературы уже предельное число книг")
+	}
+	err = a.repo.AddToBasket(a.ctx, userId, bookId)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// AddToBasket - POST /user/basket/add/:id, после добавления возвращает на страницу книги
+func (a app) AddToBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	_, err := a.addToBasket(currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		a.BasketPage(rw, r, err.Error())
+		return
+	}
+	http.Redirect(rw, r, bookPage(r, p.ByName("id"))+"?basket=1", http.StatusSeeOther)
+}
+
+func (a app) RemoveFromBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.RemoveFromBasket(a.ctx, currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/user/basket", http.StatusSeeOther)
+}
+
+func (a app) ClearBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.ClearBasket(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/user/basket", http.StatusSeeOther)
+}
+
+// ExportBasket - GET /user/basket/export?format=, весь список литературы одним файлом
+func (a app) ExportBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f, ok := citationFormat(r)
+	if !ok {
+		http.Error(rw, "Неизвестный формат, допустимые: "+citationFormatNames(), http.StatusBadRequest)
+		return
+	}
+	books, err := a.repo.Basket(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(books) == 0 {
+		a.BasketPage(rw, r, "Список литературы пуст")
+		return
+	}
+	err = writeCitation(rw, f, "bibliography-"+time.Now().Format("20060102"), books)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// APICiteBook - GET /api/v1/books/:id/cite?format=
+func (a app) APICiteBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f, ok := citationFormat(r)
+	if !ok {
+		apiInvalid(rw, map[string]string{"format": "Допустимые форматы: " + citationFormatNames()})
+		return
+	}
+	b, _, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	err := writeCitation(rw, f, "book-"+b.Book_Id.String(), []repository.Book{b})
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+}
+
+// APIBasket - GET /api/v1/basket, книги списка литературы в порядке добавления
+func (a app) APIBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	books, err := a.repo.Basket(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if books == nil {
+		books = []repository.Book{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": books, "total": len(books)})
+}
+
+// APIAddToBasket - PUT /api/v1/basket/:id
+func (a app) APIAddToBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	status, err := a.addToBasket(currentUser(r).User_Id.String(), p.ByName("id"))
+	switch status {
+	case http.StatusOK:
+		rw.WriteHeader(http.StatusNoContent)
+	case http.StatusNotFound:
+		apiFail(rw, status, "not_found", err.Error())
+	case http.StatusConflict:
+		apiFail(rw, status, "conflict", err.Error())
+	default:
+		apiFail(rw, status, "internal", err.Error())
+	}
+}
+
+// APIRemoveFromBasket - DELETE /api/v1/basket/:id. Книги, которой нет в списке, удалять не ошибка
+func (a app) APIRemoveFromBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if _, err := uuid.Parse(p.ByName("id")); err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Книга не найдена")
+		return
+	}
+	err := a.repo.RemoveFromBasket(a.ctx, currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// APIExportBasket - GET /api/v1/basket/export?format=
+func (a app) APIExportBasket(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f, ok := citationFormat(r)
+	if !ok {
+		apiInvalid(rw, map[string]string{"format": "Допустимые форматы: " + citationFormatNames()})
+		return
+	}
+	books, err := a.repo.Basket(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	err = writeCitation(rw, f, "bibliography-"+time.Now().Format("20060102"), books)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+}
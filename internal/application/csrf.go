@@ -0,0 +1,89 @@
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const csrfFieldName = "csrf_token"
+
+// appSecret подписывает CSRF-токены и ссылки из писем. Задается переменной BIBLIO_SECRET,
+// иначе генерируется при запуске, и выданные до перезапуска ссылки перестают действовать
+var appSecret = envOr("BIBLIO_SECRET", randomHex(32))
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("failed to read random bytes: %w", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// csrfToken привязывает токен к сессии: для вошедших пользователей это кука token,
+// для анонимных (вход, регистрация) - отдельная кука csrf
+func csrfToken(r *http.Request) string {
+	key, _ := r.Context().Value("csrf").(string)
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFProtect выдает ключ сессии каждому запросу и отклоняет изменяющие запросы без верного токена.
+// Запросы с API-токеном в заголовке Authorization не проверяются
+func (a *app) CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key, err := readCookie("token", r)
+		if err != nil || key == "" {
+			key, err = readCookie("csrf", r)
+			if err != nil || key == "" {
+				key = randomHex(16)
+				http.SetCookie(rw, &http.Cookie{Name: "csrf", Value: key, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), "csrf", key))
+
+		// заголовок Authorization браузер сам не подставляет, поэтому запросам с токеном CSRF не грозит
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		case bearerToken(r) != "":
+		default:
+			sent := r.Header.Get("X-CSRF-Token")
+			if sent == "" {
+				sent = r.FormValue(csrfFieldName)
+			}
+			if !hmac.Equal([]byte(sent), []byte(csrfToken(r))) {
+				http.Error(rw, "Неверный CSRF-токен, обновите страницу и повторите попытку", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// parseTemplates разбирает шаблоны страницы и подключает общие функции, в том числе csrfField для форм
+func parseTemplates(r *http.Request, filenames ...string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + csrfToken(r) + `">`)
+		},
+		"csrfToken": func() string {
+			return csrfToken(r)
+		},
+	}
+	return template.New(filepath.Base(filenames[0])).Funcs(funcs).ParseFiles(filenames...)
+}
@@ -0,0 +1,275 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+const (
+	// liveHistory - сколько последних событий шина помнит для переподключившихся по Last-Event-ID
+	liveHistory = 200
+	// liveBuffer - сколько событий ждут медленного подписчика; при переполнении он отключается
+	// и, переподключившись, получает пропущенное из истории
+	liveBuffer = 64
+	// liveHeartbeat - как часто отправлять комментарий, чтобы прокси не закрывали тихое соединение
+	liveHeartbeat = 25 * time.Second
+)
+
+// liveAudience - роли, которым событие видно в ленте. Не перечисленные события видят только администраторы
+var liveAudience = map[string][]UserRole{
+	string(repository.EVENT_BOOK_CREATED):     {"ADMIN", "USER"},
+	string(repository.EVENT_BOOK_UPDATED):     {"ADMIN", "USER"},
+	string(repository.EVENT_BOOK_DELETED):     {"ADMIN", "USER"},
+	string(repository.EVENT_BOOK_PUBLISHED):   {"ADMIN", "USER"},
+	string(repository.EVENT_BOOK_UNPUBLISHED): {"ADMIN", "USER"},
+}
+
+// liveVisible - событие видно роли. О книгах, скрытых от читателей, читатели узнают только
+// снятие с публикации
+func liveVisible(ev liveEvent, role UserRole) bool {
+	if role == "ADMIN" {
+		return true
+	}
+	if b, ok := ev.Data.(repository.Book); ok && !b.Visible && ev.Type != string(repository.EVENT_BOOK_UNPUBLISHED) {
+		return false
+	}
+	for _, r := range liveAudience[ev.Type] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// publicBook - книга для читателей: без пути к файлу, как и в GraphQL
+type publicBook struct {
+	repository.Book
+	Link string `json:"link,omitempty"`
+}
+
+type liveEvent struct {
+	Id   uint64
+	Type string
+	Time time.Time
+	Data interface{}
+}
+
+// payload - тело события для роли. Читатели не видят служебных полей
+func (ev liveEvent) payload(role UserRole) ([]byte, error) {
+	data := ev.Data
+	if b, ok := data.(repository.Book); ok && role != "ADMIN" {
+		data = publicBook{Book: b}
+	}
+	return json.Marshal(struct {
+		Type string      `json:"type"`
+		Time time.Time   `json:"time"`
+		Data interface{} `json:"data"`
+	}{ev.Type, ev.Time, data})
+}
+
+type liveSubscriber struct {
+	role UserRole
+	ch   chan liveEvent
+}
+
+// eventBus раздает события обработчиков подписчикам живой ленты и слушателям вроде очереди вебхуков.
+// Слушатели вызываются синхронно при публикации, подписчики получают события через канал
+type eventBus struct {
+	mu        sync.Mutex
+	lastId    uint64
+	history   []liveEvent
+	subs      map[*liveSubscriber]struct{}
+	listeners []func(ev liveEvent)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*liveSubscriber]struct{})}
+}
+
+// listen добавляет слушателя, который получает каждое событие в горутине публикующего
+func (b *eventBus) listen(f func(ev liveEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.listeners = append(b.listeners, f)
+}
+
+func (b *eventBus) publish(event string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.lastId++
+	ev := liveEvent{Id: b.lastId, Type: event, Time: time.Now(), Data: data}
+	b.history = append(b.history, ev)
+	if len(b.history) > liveHistory {
+		b.history = b.history[len(b.history)-liveHistory:]
+	}
+	for s := range b.subs {
+		if !liveVisible(ev, s.role) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	for _, f := range listeners {
+		f(ev)
+	}
+}
+
+// subscribe возвращает подписчика и видимые ему события после lastId из истории.
+// Если lastId больше последнего номера (приложение перезапущено), история не отдается
+func (b *eventBus) subscribe(role UserRole, lastId uint64) (s *liveSubscriber, missed []liveEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s = &liveSubscriber{role: role, ch: make(chan liveEvent, liveBuffer)}
+	b.subs[s] = struct{}{}
+	if lastId > 0 && lastId <= b.lastId {
+		for _, ev := range b.history {
+			if ev.Id > lastId && liveVisible(ev, role) {
+				missed = append(missed, ev)
+			}
+		}
+	}
+	return
+}
+
+func (b *eventBus) unsubscribe(s *liveSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Events - GET /api/events, поток server-sent events. Параметр types ограничивает типы событий
+// списком через запятую. Читатели получают только события каталога
+func (a app) Events(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	role := r.Context().Value("role").(UserRole)
+
+	var types map[string]bool
+	if v := r.URL.Query().Get("types"); v != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+	lastId, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	rc := http.NewResponseController(rw)
+	// поток живет дольше обычного запроса, поэтому общий таймаут записи сервера к нему не относится
+	rc.SetWriteDeadline(time.Time{})
+
+	sub, missed := a.events.subscribe(role, lastId)
+	defer a.events.unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	send := func(ev liveEvent) error {
+		if types != nil && !types[ev.Type] {
+			return nil
+		}
+		data, err := ev.payload(role)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", ev.Id, ev.Type, data)
+		return err
+	}
+
+	_, err := fmt.Fprint(rw, "retry: 3000\n\n")
+	for _, ev := range missed {
+		if err == nil {
+			err = send(ev)
+		}
+	}
+	if err == nil {
+		err = rc.Flush()
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-a.ctx.Done():
+			return
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			err = send(ev)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(rw, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+	}
+}
+
+// ActivityPage - живая лента событий библиотеки для администратора
+func (a app) ActivityPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lp := filepath.Join("public", "html", "activity.html")
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "activity", nil)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// loginSucceeded записывает успешный вход и сбрасывает счетчик неудач учетной записи.
+// Вызывается, только когда сессия действительно выдается, то есть после второго фактора
+func (a app) loginSucceeded(login, ip string) {
+	a.guard.success(login)
+	a.repo.AddLoginAttempt(a.ctx, login, ip, true)
+}
+
+// loginFailed записывает неудачную попытку входа и сообщает о ней в ленту
+func (a app) loginFailed(login, ip, reason string) {
+	a.repo.AddLoginAttempt(a.ctx, login, ip, false)
+	a.events.publish(string(repository.EVENT_LOGIN_FAILED), map[string]string{"login": login, "ip": ip, "reason": reason})
+}
+
+// bookOpened сообщает в ленту, что пользователь открыл книгу для чтения
+func (a app) bookOpened(r *http.Request, book repository.Book, via string) {
+	user := currentUser(r)
+	a.events.publish(string(repository.EVENT_BOOK_OPENED), map[string]string{
+		"book_id": book.Book_Id.String(), "name": book.Name, "author": book.Author, "login": user.Username, "via": via,
+	})
+}
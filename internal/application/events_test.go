@@ -0,0 +1,137 @@
+package application
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+func TestEventBusAudience(t *testing.T) {
+	bus := newEventBus()
+	var heard []string
+	bus.listen(func(ev liveEvent) { heard = append(heard, ev.Type) })
+
+	admin, _ := bus.subscribe("ADMIN", 0)
+	reader, _ := bus.subscribe("USER", 0)
+
+	bus.publish(string(repository.EVENT_LOGIN_FAILED), map[string]string{"login": "x"})
+	bus.publish(string(repository.EVENT_BOOK_CREATED), repository.Book{Name: "Book", Link: "/srv/books/1.txt", Visible: true})
+	bus.publish(string(repository.EVENT_BOOK_CREATED), repository.Book{Name: "Scheduled"})
+
+	if ev := <-admin.ch; ev.Type != "login.failed" {
+		t.Errorf("admin got %s first, want login.failed", ev.Type)
+	}
+	for i := 0; i < 2; i++ {
+		if ev := <-admin.ch; ev.Type != "book.created" {
+			t.Errorf("admin got %s, want book.created", ev.Type)
+		}
+	}
+	ev := <-reader.ch
+	if ev.Type != "book.created" {
+		t.Fatalf("reader got %s, want book.created", ev.Type)
+	}
+	if len(reader.ch) != 0 {
+		t.Error("reader received an admin-only event or a hidden book")
+	}
+	if len(heard) != 3 {
+		t.Errorf("listener heard %v", heard)
+	}
+
+	data, _ := ev.payload("USER")
+	if strings.Contains(string(data), "/srv/books") {
+		t.Errorf("reader payload exposes the file path: %s", data)
+	}
+	data, _ = ev.payload("ADMIN")
+	if !strings.Contains(string(data), "/srv/books") {
+		t.Errorf("admin payload lacks the file path: %s", data)
+	}
+}
+
+func TestEventBusReplayAndSlowSubscriber(t *testing.T) {
+	bus := newEventBus()
+	for i := 0; i < 3; i++ {
+		bus.publish(string(repository.EVENT_BOOK_UPDATED), nil)
+	}
+	bus.publish(string(repository.EVENT_BOOK_OPENED), nil)
+
+	if _, missed := bus.subscribe("USER", 1); len(missed) != 2 || missed[0].Id != 2 {
+		t.Errorf("USER after 1 missed %v, want events 2 and 3", missed)
+	}
+	if _, missed := bus.subscribe("ADMIN", 1); len(missed) != 3 {
+		t.Errorf("ADMIN after 1 missed %d events, want 3", len(missed))
+	}
+	if _, missed := bus.subscribe("ADMIN", 100); missed != nil {
+		t.Errorf("unknown Last-Event-ID replayed %v", missed)
+	}
+
+	slow, _ := bus.subscribe("ADMIN", 0)
+	for i := 0; i <= liveBuffer; i++ {
+		bus.publish(string(repository.EVENT_BOOK_UPDATED), nil)
+	}
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != liveBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, liveBuffer)
+	}
+	bus.unsubscribe(slow)
+}
+
+func TestEventsStream(t *testing.T) {
+	a := app{ctx: context.Background(), events: newEventBus()}
+	a.events.publish(string(repository.EVENT_BOOK_DELETED), repository.Book{Name: "Старая", Visible: true})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		role := UserRole(r.URL.Query().Get("role"))
+		ctx := context.WithValue(r.Context(), "role", role)
+		ctx = context.WithValue(ctx, "user", repository.User{User_Id: uuid.New(), Role: repository.USER})
+		a.Events(rw, r.WithContext(ctx), httprouter.Params{})
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"?role=USER&types=book.created,book.deleted", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if l := lines.Text(); strings.HasPrefix(l, "event: ") || strings.HasPrefix(l, "data: ") {
+				return l
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	// события до подключения без Last-Event-ID не повторяются, невидимые и неотобранные не приходят
+	a.events.publish(string(repository.EVENT_LOGIN_FAILED), map[string]string{"login": "x"})
+	a.events.publish(string(repository.EVENT_BOOK_UPDATED), repository.Book{Name: "Другая", Visible: true})
+	a.events.publish(string(repository.EVENT_BOOK_CREATED), repository.Book{Name: "Новая", Visible: true})
+
+	if l := next(); l != "event: book.created" {
+		t.Errorf("got %q, want event: book.created", l)
+	}
+	if l := next(); !strings.Contains(l, `"Новая"`) {
+		t.Errorf("got %q, want the new book", l)
+	}
+}
@@ -0,0 +1,77 @@
+package application
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/export"
+	"biblio/internal/repository"
+)
+
+// ExportBooks - GET /admin/books/export?format=, выгрузка каталога. Фильтры category, author, series,
+// name, q и sort - те же, что у поиска в API. Файл отдается по мере чтения из базы
+func (a app) ExportBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := r.URL.Query()
+	format, ok := export.Lookup(q.Get("format"))
+	if !ok {
+		names := make([]string, len(export.Formats))
+		for i, f := range export.Formats {
+			names[i] = f.Name
+		}
+		http.Error(rw, "Неизвестный формат выгрузки, допустимые: "+strings.Join(names, ", "), http.StatusBadRequest)
+		return
+	}
+
+	f := repository.BookFilter{
+		Category: q.Get("category"),
+		Author:   q.Get("author"),
+		Series:   q.Get("series"),
+		Name:     q.Get("name"),
+		Query:    strings.TrimSpace(q.Get("q")),
+		Sort:     q.Get("sort"),
+		// выгрузка - копия всего каталога, в том числе книг, еще не открытых читателям
+		Hidden: true,
+	}
+
+	out := &countingWriter{w: rw}
+	w, err := export.NewWriter(format.Name, out)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", format.ContentType)
+	rw.Header().Set("Content-Disposition", `attachment; filename="biblio-`+time.Now().Format("20060102")+format.Ext+`"`)
+
+	// после первых байтов код ответа уже не изменить, поэтому ошибка посреди выгрузки
+	// только пишется в журнал, а файл остается без окончания
+	err = a.repo.EachBook(r.Context(), f, w.Write)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil && out.n == 0 {
+		rw.Header().Del("Content-Disposition")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		log.Printf("export %s: %v", format.Name, err)
+	}
+}
+
+// countingWriter считает отправленные байты, чтобы понять, можно ли еще ответить ошибкой
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,519 @@
+package application
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/graphql"
+	"biblio/internal/repository"
+)
+
+// лимиты запросов GraphQL, задаются переменными GRAPHQL_MAX_DEPTH и GRAPHQL_MAX_COMPLEXITY.
+// Стоимость поля - 1 плюс стоимость вложенных полей, у связей она умножается на first
+var (
+	graphQLMaxDepth      = envInt("GRAPHQL_MAX_DEPTH", 10)
+	graphQLMaxComplexity = envInt("GRAPHQL_MAX_COMPLEXITY", 2000)
+)
+
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(envOr(name, strconv.Itoa(def)))
+	if err != nil {
+		log.Printf("%s: %v, using %d", name, err, def)
+		return def
+	}
+	return n
+}
+
+func gqlError(code, message string) error {
+	return &graphql.Error{Message: message, Extensions: map[string]interface{}{"code": code}}
+}
+
+func gqlRole(ctx context.Context) UserRole {
+	role, _ := ctx.Value("role").(UserRole)
+	return role
+}
+
+func gqlUser(ctx context.Context) repository.User {
+	user, _ := ctx.Value("user").(repository.User)
+	return user
+}
+
+// adminOnly - проверка прав поля, доступного только администратору
+func adminOnly(p graphql.Params) error {
+	if gqlRole(p.Context) != "ADMIN" {
+		return gqlError("FORBIDDEN", "Недостаточно прав")
+	}
+	return nil
+}
+
+// selfOrAdmin - личные данные пользователя видят он сам и администратор
+func selfOrAdmin(p graphql.Params) error {
+	if gqlRole(p.Context) == "ADMIN" || p.Source.(repository.User).User_Id == gqlUser(p.Context).User_Id {
+		return nil
+	}
+	return gqlError("FORBIDDEN", "Недостаточно прав")
+}
+
+// курсор связи - номер записи в выборке, закодированный, чтобы клиенты не собирали его сами
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (offset int, err error) {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil {
+		offset, err = strconv.Atoi(strings.TrimPrefix(string(data), "offset:"))
+	}
+	if err != nil || !strings.HasPrefix(string(data), "offset:") || offset < 0 {
+		return 0, gqlError("BAD_USER_INPUT", "Неверный курсор")
+	}
+	return offset, nil
+}
+
+// page - окно связи по аргументам first и after
+type page struct {
+	offset, limit int
+}
+
+func connectionPage(args map[string]interface{}) (p page, err error) {
+	p.limit = args["first"].(int)
+	if p.limit < 0 || p.limit > apiMaxPerPage {
+		return p, gqlError("BAD_USER_INPUT", "first должен быть от 0 до "+strconv.Itoa(apiMaxPerPage))
+	}
+	if after, ok := args["after"].(string); ok {
+		if p.offset, err = decodeCursor(after); err != nil {
+			return
+		}
+		p.offset++
+	}
+	return
+}
+
+// connection - значение связи: ребра с курсорами, узлы, сведения о странице и общее число записей
+func connection(nodes []interface{}, p page, total int) map[string]interface{} {
+	edges := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		edges[i] = map[string]interface{}{"cursor": encodeCursor(p.offset + i), "node": n}
+	}
+	info := map[string]interface{}{
+		"hasNextPage":     p.offset+len(nodes) < total,
+		"hasPreviousPage": p.offset > 0,
+	}
+	if len(nodes) > 0 {
+		info["startCursor"] = encodeCursor(p.offset)
+		info["endCursor"] = encodeCursor(p.offset + len(nodes) - 1)
+	}
+	return map[string]interface{}{"edges": edges, "nodes": nodes, "pageInfo": info, "totalCount": total}
+}
+
+// slicePage - страница связи для выборки, которая уже целиком в памяти
+func slicePage(all []interface{}, p page) map[string]interface{} {
+	from, to := p.offset, p.offset+p.limit
+	if from > len(all) {
+		from = len(all)
+	}
+	if to > len(all) {
+		to = len(all)
+	}
+	return connection(all[from:to], p, len(all))
+}
+
+var pageInfoType = &graphql.Object{
+	Name:        "PageInfo",
+	Description: "Сведения о странице связи",
+	Fields: []*graphql.Field{
+		{Name: "hasNextPage", Type: graphql.NonNullOf(graphql.Boolean)},
+		{Name: "hasPreviousPage", Type: graphql.NonNullOf(graphql.Boolean)},
+		{Name: "startCursor", Type: graphql.String},
+		{Name: "endCursor", Type: graphql.String},
+	},
+}
+
+// connectionType строит типы XConnection и XEdge для постраничного списка узлов node
+func connectionType(node *graphql.Object) *graphql.Object {
+	edge := &graphql.Object{
+		Name: node.Name + "Edge",
+		Fields: []*graphql.Field{
+			{Name: "cursor", Type: graphql.NonNullOf(graphql.String), Description: "Передайте в after, чтобы получить записи после этой"},
+			{Name: "node", Type: graphql.NonNullOf(node)},
+		},
+	}
+	return &graphql.Object{
+		Name: node.Name + "Connection",
+		Fields: []*graphql.Field{
+			{Name: "edges", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(edge)))},
+			{Name: "nodes", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(node)))},
+			{Name: "pageInfo", Type: graphql.NonNullOf(pageInfoType)},
+			{Name: "totalCount", Type: graphql.NonNullOf(graphql.Int)},
+		},
+	}
+}
+
+func connectionArgs(extra ...*graphql.Arg) []*graphql.Arg {
+	return append([]*graphql.Arg{
+		{Name: "first", Type: graphql.Int, Default: apiDefaultPerPage, Description: "Число записей, не больше " + strconv.Itoa(apiMaxPerPage)},
+		{Name: "after", Type: graphql.String, Description: "Курсор записи, после которой начинается страница"},
+	}, extra...)
+}
+
+func connectionComplexity(args map[string]interface{}, child int) int {
+	first, _ := args["first"].(int)
+	return 1 + first*child
+}
+
+var dateTimeType = &graphql.Scalar{
+	Name:        "DateTime",
+	Description: "Дата и время в формате RFC 3339",
+	Serialize: func(v interface{}) (interface{}, error) {
+		switch t := v.(type) {
+		case time.Time:
+			return t.Format(time.RFC3339), nil
+		case *time.Time:
+			return t.Format(time.RFC3339), nil
+		}
+		return nil, errors.New("DateTime cannot represent value")
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("DateTime must be a string")
+		}
+		return time.Parse(time.RFC3339, s)
+	},
+}
+
+// authorNode и seriesNode - узлы схемы для автора и серии; count < 0 - число книг еще не известно
+type authorNode struct {
+	name  string
+	count int
+}
+
+type seriesNode struct {
+	name  string
+	count int
+}
+
+// graphQLSchema описывает каталог для GraphQL: книги, авторов, серии, пользователей и цитаты.
+// Схема только для чтения; изменять книги по-прежнему нужно через /api/v1
+func (a app) graphQLSchema() *graphql.Schema {
+	book := &graphql.Object{Name: "Book", Description: "Книга каталога"}
+	author := &graphql.Object{Name: "Author", Description: "Автор и его книги"}
+	series := &graphql.Object{Name: "Series", Description: "Серия и ее книги"}
+	user := &graphql.Object{Name: "User", Description: "Пользователь библиотеки"}
+	rating := &graphql.Object{Name: "Rating", Description: "Средняя оценка книги по опубликованным отзывам"}
+	role := &graphql.Enum{Name: "Role", Values: []graphql.EnumValue{
+		{Name: string(repository.ADMIN), Description: "Администратор"},
+		{Name: string(repository.USER), Description: "Читатель"},
+	}}
+	bookSort := &graphql.Enum{Name: "BookSort", Description: "Сортировка книг; с суффиксом _DESC - по убыванию"}
+	for _, f := range repository.BookSortFields {
+		bookSort.Values = append(bookSort.Values,
+			graphql.EnumValue{Name: strings.ToUpper(f)}, graphql.EnumValue{Name: strings.ToUpper(f) + "_DESC"})
+	}
+	bookConnection := connectionType(book)
+
+	// books выбирает страницу книг по фильтру
+	books := func(f repository.BookFilter, args map[string]interface{}) (interface{}, error) {
+		p, err := connectionPage(args)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := args["sort"].(string); ok {
+			f.Sort = strings.ToLower(s)
+			if strings.HasSuffix(f.Sort, "_desc") {
+				f.Sort = "-" + strings.TrimSuffix(f.Sort, "_desc")
+			}
+		}
+		f.Limit, f.Offset = p.limit, p.offset
+
+		list, total := []repository.Book(nil), 0
+		if p.limit > 0 {
+			list, total, err = a.repo.FindBooks(a.ctx, f)
+		} else {
+			total, err = a.countBooks(f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes := make([]interface{}, len(list))
+		for i, b := range list {
+			nodes[i] = b
+		}
+		return connection(nodes, p, total), nil
+	}
+	bookField := func(get func(b repository.Book) interface{}) graphql.ResolveFunc {
+		return func(p graphql.Params) (interface{}, error) { return get(p.Source.(repository.Book)), nil }
+	}
+	userField := func(get func(u repository.User) interface{}) graphql.ResolveFunc {
+		return func(p graphql.Params) (interface{}, error) { return get(p.Source.(repository.User)), nil }
+	}
+	str := graphql.NonNullOf(graphql.String)
+
+	book.Fields = []*graphql.Field{
+		{Name: "id", Type: graphql.NonNullOf(graphql.ID), Resolve: bookField(func(b repository.Book) interface{} { return b.Book_Id })},
+		{Name: "name", Type: str, Resolve: bookField(func(b repository.Book) interface{} { return b.Name })},
+		{Name: "category", Type: str, Description: "Жанр", Resolve: bookField(func(b repository.Book) interface{} { return string(b.Category) })},
+		{Name: "author", Type: graphql.NonNullOf(author), Resolve: bookField(func(b repository.Book) interface{} {
+			return authorNode{name: b.Author, count: -1}
+		})},
+		{Name: "series", Type: series, Description: "null, если книга не входит в серию", Resolve: bookField(func(b repository.Book) interface{} {
+			if b.Series == "" || b.Series == "-" {
+				return nil
+			}
+			return seriesNode{name: b.Series, count: -1}
+		})},
+		{Name: "annotation", Type: str, Resolve: bookField(func(b repository.Book) interface{} { return b.Annotation })},
+		{Name: "access", Type: str, Description: "Да - книгу можно читать и скачивать", Resolve: bookField(func(b repository.Book) interface{} { return b.Access })},
+		{Name: "publication", Type: graphql.NonNullOf(dateTimeType), Description: "Дата публикации",
+			Resolve: bookField(func(b repository.Book) interface{} { return b.Publication })},
+		{Name: "link", Type: graphql.String, Description: "Путь к файлу книги на сервере, только для администратора",
+			Authorize: adminOnly, Resolve: bookField(func(b repository.Book) interface{} { return b.Link })},
+		{Name: "rating", Type: graphql.NonNullOf(rating), Resolve: func(p graphql.Params) (interface{}, error) {
+			return a.repo.BookRating(a.ctx, p.Source.(repository.Book).Book_Id.String())
+		}},
+	}
+
+	rating.Fields = []*graphql.Field{
+		{Name: "average", Type: graphql.NonNullOf(graphql.Float), Description: "От 1 до 5, 0 - оценок нет",
+			Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(repository.Rating).Average, nil }},
+		{Name: "count", Type: graphql.NonNullOf(graphql.Int),
+			Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(repository.Rating).Count, nil }},
+	}
+
+	author.Fields = []*graphql.Field{
+		{Name: "name", Type: str, Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(authorNode).name, nil }},
+		{Name: "bookCount", Type: graphql.NonNullOf(graphql.Int), Resolve: func(p graphql.Params) (interface{}, error) {
+			n := p.Source.(authorNode)
+			if n.count >= 0 {
+				return n.count, nil
+			}
+			return a.countBooks(repository.BookFilter{Author: n.name, Exact: true})
+		}},
+		{Name: "books", Type: graphql.NonNullOf(bookConnection), Args: connectionArgs(), Complexity: connectionComplexity,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				return books(repository.BookFilter{Author: p.Source.(authorNode).name, Exact: true, Sort: "name"}, p.Args)
+			}},
+	}
+
+	series.Fields = []*graphql.Field{
+		{Name: "name", Type: str, Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(seriesNode).name, nil }},
+		{Name: "bookCount", Type: graphql.NonNullOf(graphql.Int), Resolve: func(p graphql.Params) (interface{}, error) {
+			n := p.Source.(seriesNode)
+			if n.count >= 0 {
+				return n.count, nil
+			}
+			return a.countBooks(repository.BookFilter{Series: n.name, Exact: true})
+		}},
+		{Name: "books", Type: graphql.NonNullOf(bookConnection), Args: connectionArgs(), Complexity: connectionComplexity,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				return books(repository.BookFilter{Series: p.Source.(seriesNode).name, Exact: true, Sort: "name"}, p.Args)
+			}},
+	}
+
+	user.Fields = []*graphql.Field{
+		{Name: "id", Type: graphql.NonNullOf(graphql.ID), Resolve: userField(func(u repository.User) interface{} { return u.User_Id })},
+		{Name: "login", Type: str, Resolve: userField(func(u repository.User) interface{} { return u.Username })},
+		{Name: "fullName", Type: str, Resolve: userField(func(u repository.User) interface{} { return u.FullName })},
+		{Name: "role", Type: graphql.NonNullOf(role), Resolve: userField(func(u repository.User) interface{} { return string(u.Role) })},
+		{Name: "active", Type: graphql.NonNullOf(graphql.Boolean), Resolve: userField(func(u repository.User) interface{} { return u.Active })},
+		{Name: "createdAt", Type: graphql.NonNullOf(dateTimeType), Resolve: userField(func(u repository.User) interface{} { return u.CreatedAt })},
+		{Name: "email", Type: graphql.String, Authorize: selfOrAdmin, Resolve: userField(func(u repository.User) interface{} { return u.Email })},
+		{Name: "emailVerified", Type: graphql.Boolean, Authorize: selfOrAdmin, Resolve: userField(func(u repository.User) interface{} { return u.EmailVerified })},
+		{Name: "preferences", Type: graphql.ListOf(str), Description: "Любимые жанры", Authorize: selfOrAdmin,
+			Resolve: userField(func(u repository.User) interface{} { return u.Preferences })},
+		{Name: "totpEnabled", Type: graphql.Boolean, Description: "Включена ли двухфакторная аутентификация", Authorize: selfOrAdmin,
+			Resolve: userField(func(u repository.User) interface{} { return u.TOTPEnabled })},
+		{Name: "deletionRequestedAt", Type: dateTimeType, Description: "Когда пользователь попросил удалить учетную запись", Authorize: selfOrAdmin,
+			Resolve: userField(func(u repository.User) interface{} { return u.DeletionRequestedAt })},
+	}
+
+	motivation := &graphql.Object{Name: "Motivation", Description: "Цитата для стартовой страницы", Fields: []*graphql.Field{
+		{Name: "id", Type: graphql.NonNullOf(graphql.ID), Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(repository.Motivation).Id, nil }},
+		{Name: "content", Type: str, Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(repository.Motivation).Content, nil }},
+		{Name: "author", Type: str, Resolve: func(p graphql.Params) (interface{}, error) { return p.Source.(repository.Motivation).Author, nil }},
+	}}
+
+	// facets - связь по значениям поля книг: авторам или сериям
+	facets := func(field string, node func(f repository.Facet) interface{}) graphql.ResolveFunc {
+		return func(p graphql.Params) (interface{}, error) {
+			pg, err := connectionPage(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			list, err := a.repo.BookFacets(a.ctx, field)
+			if err != nil {
+				return nil, err
+			}
+			var all []interface{}
+			for _, f := range list {
+				if f.Value != "-" {
+					all = append(all, node(f))
+				}
+			}
+			return slicePage(all, pg), nil
+		}
+	}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "book", Type: book, Args: []*graphql.Arg{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				id, err := uuid.Parse(p.Args["id"].(string))
+				if err != nil {
+					return nil, nil
+				}
+				b, err := a.repo.GetBookById(a.ctx, id.String())
+				if err == nil && !b.Visible && gqlRole(p.Context) != "ADMIN" {
+					return nil, nil
+				}
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil, nil
+				}
+				return b, err
+			}},
+		{Name: "books", Type: graphql.NonNullOf(bookConnection), Description: "Книги с отбором по вхождению строки в поле",
+			Args: connectionArgs(
+				&graphql.Arg{Name: "category", Type: graphql.String},
+				&graphql.Arg{Name: "author", Type: graphql.String},
+				&graphql.Arg{Name: "series", Type: graphql.String},
+				&graphql.Arg{Name: "name", Type: graphql.String},
+				&graphql.Arg{Name: "sort", Type: bookSort},
+			),
+			Complexity: connectionComplexity,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				arg := func(name string) string { s, _ := p.Args[name].(string); return s }
+				return books(repository.BookFilter{Category: arg("category"), Author: arg("author"), Series: arg("series"), Name: arg("name")}, p.Args)
+			}},
+		{Name: "search", Type: graphql.NonNullOf(bookConnection), Description: "Поиск по названию, автору, серии и аннотации",
+			Args:       connectionArgs(&graphql.Arg{Name: "query", Type: str}, &graphql.Arg{Name: "sort", Type: bookSort}),
+			Complexity: connectionComplexity,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				q := strings.TrimSpace(p.Args["query"].(string))
+				if q == "" {
+					return nil, gqlError("BAD_USER_INPUT", "Пустой поисковый запрос")
+				}
+				return books(repository.BookFilter{Query: q}, p.Args)
+			}},
+		{Name: "author", Type: author, Args: []*graphql.Arg{{Name: "name", Type: str}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				name := p.Args["name"].(string)
+				n, err := a.countBooks(repository.BookFilter{Author: name, Exact: true})
+				if err != nil || n == 0 {
+					return nil, err
+				}
+				return authorNode{name: name, count: n}, nil
+			}},
+		{Name: "authors", Type: graphql.NonNullOf(connectionType(author)), Args: connectionArgs(), Complexity: connectionComplexity,
+			Resolve: facets("author", func(f repository.Facet) interface{} { return authorNode{name: f.Value, count: f.Count} })},
+		{Name: "seriesByName", Type: series, Args: []*graphql.Arg{{Name: "name", Type: str}},
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				name := p.Args["name"].(string)
+				n, err := a.countBooks(repository.BookFilter{Series: name, Exact: true})
+				if err != nil || n == 0 || name == "-" {
+					return nil, err
+				}
+				return seriesNode{name: name, count: n}, nil
+			}},
+		{Name: "series", Type: graphql.NonNullOf(connectionType(series)), Args: connectionArgs(), Complexity: connectionComplexity,
+			Resolve: facets("series", func(f repository.Facet) interface{} { return seriesNode{name: f.Value, count: f.Count} })},
+		{Name: "me", Type: graphql.NonNullOf(user), Description: "Текущий пользователь",
+			Resolve: func(p graphql.Params) (interface{}, error) { return gqlUser(p.Context), nil }},
+		{Name: "user", Type: user, Args: []*graphql.Arg{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}}, Authorize: adminOnly,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				id, err := uuid.Parse(p.Args["id"].(string))
+				if err != nil {
+					return nil, nil
+				}
+				u, err := a.repo.GetUserById(a.ctx, id.String())
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil, nil
+				}
+				return u, err
+			}},
+		{Name: "users", Type: connectionType(user), Args: connectionArgs(), Authorize: adminOnly, Complexity: connectionComplexity,
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				pg, err := connectionPage(p.Args)
+				if err != nil {
+					return nil, err
+				}
+				list, err := a.repo.AllUser(a.ctx)
+				if err != nil {
+					return nil, err
+				}
+				all := make([]interface{}, len(list))
+				for i, u := range list {
+					all[i] = u
+				}
+				return slicePage(all, pg), nil
+			}},
+		{Name: "motivation", Type: motivation, Description: "Случайная цитата",
+			Resolve: func(p graphql.Params) (interface{}, error) {
+				m, err := a.repo.GetRandomMotivation(a.ctx)
+				if errors.Is(err, pgx.ErrNoRows) {
+					return nil, nil
+				}
+				return m, err
+			}},
+	}}
+
+	schema, err := graphql.NewSchema(query)
+	if err != nil {
+		// схема собирается из кода выше, ошибка здесь - ошибка программиста
+		panic(err)
+	}
+	schema.MaxDepth = graphQLMaxDepth
+	schema.MaxComplexity = graphQLMaxComplexity
+	return schema
+}
+
+// countBooks возвращает число книг под фильтром
+func (a app) countBooks(f repository.BookFilter) (int, error) {
+	f.Limit, f.Offset = 1, 0
+	_, total, err := a.repo.FindBooks(a.ctx, f)
+	return total, err
+}
+
+// GraphQL - POST /api/graphql с телом {"query", "variables", "operationName"} или GET /api/graphql?query=.
+// Ошибки разбора, проверки и лимитов возвращаются с кодом 400, ошибки полей - рядом с данными с кодом 200
+func (a app) GraphQL(schema *graphql.Schema) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		var req graphql.Request
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					apiFail(rw, http.StatusBadRequest, "invalid_json", "variables должен быть объектом JSON: "+err.Error())
+					return
+				}
+			}
+		} else {
+			err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, apiMaxBody)).Decode(&req)
+			if err != nil {
+				apiFail(rw, http.StatusBadRequest, "invalid_json", "Тело запроса должно быть объектом JSON: "+err.Error())
+				return
+			}
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			apiFail(rw, http.StatusBadRequest, "invalid_request", "Укажите запрос в поле query")
+			return
+		}
+
+		resp := schema.Exec(r.Context(), req)
+		status := http.StatusOK
+		if !resp.Executed() {
+			status = http.StatusBadRequest
+		}
+		writeJSON(rw, status, resp)
+	}
+}
@@ -0,0 +1,396 @@
+package application
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/importer"
+	"biblio/internal/repository"
+)
+
+const (
+	// importMaxSize - предел размера загружаемого файла импорта
+	importMaxSize = 64 << 20
+	// importPreviewRows - сколько строк плана показывать в предпросмотре; итоги считаются по всем
+	importPreviewRows = 500
+	// importTTL - сколько хранится загруженный файл между предпросмотром и импортом
+	importTTL = 6 * time.Hour
+	// importPrefix - начало имени загруженного файла во временном каталоге
+	importPrefix = "biblio-import-"
+)
+
+var importActions = map[string]string{
+	string(importer.ACTION_CREATE):  "Создать",
+	string(importer.ACTION_UPDATE):  "Обновить",
+	string(importer.ACTION_MATCH):   "Без изменений",
+	string(importer.ACTION_SKIP):    "Пропустить",
+	string(importer.ACTION_INVALID): "Ошибка",
+}
+
+var importRules = []struct {
+	Name  string
+	Title string
+}{
+	{string(importer.RULE_SKIP), "Оставить книгу каталога"},
+	{string(importer.RULE_UPDATE), "Обновить книгу каталога данными файла"},
+	{string(importer.RULE_DUPLICATE), "Создать копию"},
+}
+
+// bookFieldTitles - подписи полей книги в сопоставлении колонок и списке изменений
+var bookFieldTitles = map[string]string{
+	"book_id": "Идентификатор", "category": "Жанр", "author": "Автор", "series": "Серия", "name": "Название",
+	"annotation": "Аннотация", "link": "Файл", "access": "Доступ",
+}
+
+// importRequest - что и как импортировать. Между шагами передается скрытыми полями форм
+type importRequest struct {
+	Format   string
+	Token    string
+	Dir      string
+	Rule     string
+	Category string
+	Access   string
+	Mapping  importer.Mapping
+}
+
+type importMapping struct {
+	Field  string
+	Title  string
+	Column int
+}
+
+func (req importRequest) path() string {
+	return filepath.Join(os.TempDir(), importPrefix+req.Token)
+}
+
+// readImportRequest читает параметры импорта из формы. Колонки CSV приходят полями map_<поле>
+func readImportRequest(r *http.Request) (req importRequest, err error) {
+	req = importRequest{
+		Format:   r.FormValue("format"),
+		Token:    r.FormValue("token"),
+		Dir:      strings.TrimSpace(r.FormValue("dir")),
+		Rule:     r.FormValue("rule"),
+		Category: r.FormValue("category"),
+		Access:   r.FormValue("access"),
+	}
+	if _, ok := importer.ParseRule(req.Rule); !ok {
+		return req, errors.New("Неизвестное правило для найденных книг")
+	}
+	if req.Format == "csv" {
+		req.Mapping = make(importer.Mapping)
+		for _, f := range importer.Fields {
+			if v, err := strconv.Atoi(r.FormValue("map_" + f)); err == nil && v >= 0 {
+				req.Mapping[f] = v
+			}
+		}
+	}
+	return
+}
+
+func (req importRequest) options() importer.Options {
+	rule, _ := importer.ParseRule(req.Rule)
+	return importer.Options{Rule: rule, Category: req.Category, Access: req.Access}
+}
+
+func (req importRequest) mappings() (list []importMapping) {
+	for _, f := range importer.Fields {
+		column, ok := req.Mapping[f]
+		if !ok {
+			column = -1
+		}
+		list = append(list, importMapping{Field: f, Title: bookFieldTitles[f], Column: column})
+	}
+	return
+}
+
+// data возвращает загруженный файл. Токен - случайная строка из UploadImport, другие имена не принимаются
+func (req importRequest) data() ([]byte, error) {
+	if _, err := hex.DecodeString(req.Token); err != nil || len(req.Token) != 32 {
+		return nil, errors.New("Файл импорта не найден, загрузите его снова")
+	}
+	data, err := os.ReadFile(req.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("Файл импорта не найден, загрузите его снова")
+	}
+	return data, err
+}
+
+func (req importRequest) records() ([]importer.Record, error) {
+	if req.Format == "calibre" {
+		return importer.ReadCalibre(req.Dir)
+	}
+	data, err := req.data()
+	if err != nil {
+		return nil, err
+	}
+	switch req.Format {
+	case "marc21":
+		return importer.ReadMARC(bytes.NewReader(data))
+	case "marcxml":
+		return importer.ReadMARCXML(bytes.NewReader(data))
+	case "csv":
+		return importer.ReadCSV(data, req.Mapping)
+	}
+	return nil, errors.New("Неизвестный формат импорта")
+}
+
+func (a app) ImportPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "import.html")
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Formats    interface{}
+		Rules      interface{}
+		Categories []string
+		Message    string
+	}
+	data := answer{Formats: importer.Formats, Rules: importRules, Message: message}
+	for _, c := range repository.Categories {
+		data.Categories = append(data.Categories, string(c))
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "import", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// UploadImport принимает файл импорта или каталог библиотеки Calibre. Для CSV следующий шаг -
+// сопоставление колонок, для остальных форматов - предпросмотр
+func (a app) UploadImport(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	req, err := readImportRequest(r)
+	if err != nil {
+		a.ImportPage(rw, r, err.Error())
+		return
+	}
+
+	if req.Format == "calibre" {
+		if req.Dir == "" {
+			a.ImportPage(rw, r, "Укажите каталог библиотеки Calibre на сервере")
+			return
+		}
+		a.importPreview(rw, r, req)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		a.ImportPage(rw, r, "Выберите файл для импорта")
+		return
+	}
+	defer file.Close()
+	if header.Size > importMaxSize {
+		a.ImportPage(rw, r, fmt.Sprintf("Файл больше %d МБ", importMaxSize>>20))
+		return
+	}
+
+	req.Token = randomHex(16)
+	out, err := os.OpenFile(req.path(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		a.ImportPage(rw, r, fmt.Sprintf("Ошибка сохранения файла: %v", err))
+		return
+	}
+	_, err = io.Copy(out, io.LimitReader(file, importMaxSize))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(req.path())
+		a.ImportPage(rw, r, fmt.Sprintf("Ошибка сохранения файла: %v", err))
+		return
+	}
+
+	if req.Format == "csv" {
+		a.importMappingPage(rw, r, req)
+		return
+	}
+	a.importPreview(rw, r, req)
+}
+
+func (a app) importMappingPage(rw http.ResponseWriter, r *http.Request, req importRequest) {
+	data, err := req.data()
+	if err != nil {
+		a.ImportPage(rw, r, err.Error())
+		return
+	}
+	columns, err := importer.CSVColumns(data)
+	if err != nil {
+		a.ImportPage(rw, r, fmt.Sprintf("Не удалось прочитать заголовок CSV: %v", err))
+		return
+	}
+	req.Mapping = importer.GuessMapping(columns)
+
+	lp := filepath.Join("public", "html", "import-map.html")
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Request  importRequest
+		Columns  []string
+		Mappings []importMapping
+	}
+	err = tmpl.ExecuteTemplate(rw, "import-map", answer{req, columns, req.mappings()})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PreviewImport - предпросмотр после сопоставления колонок CSV
+func (a app) PreviewImport(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	req, err := readImportRequest(r)
+	if err != nil {
+		a.ImportPage(rw, r, err.Error())
+		return
+	}
+	a.importPreview(rw, r, req)
+}
+
+// importPlan читает источник и сопоставляет его с каталогом
+func (a app) importPlan(req importRequest) ([]importer.Item, error) {
+	records, err := req.records()
+	if err != nil {
+		return nil, err
+	}
+	x, err := importer.LoadIndex(a.ctx, a.repo)
+	if err != nil {
+		return nil, err
+	}
+	return importer.Plan(records, x, req.options()), nil
+}
+
+func (a app) importPreview(rw http.ResponseWriter, r *http.Request, req importRequest) {
+	items, err := a.importPlan(req)
+	if err != nil {
+		a.ImportPage(rw, r, fmt.Sprintf("Ошибка чтения источника: %v", err))
+		return
+	}
+
+	lp := filepath.Join("public", "html", "import-preview.html")
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type row struct {
+		Line     int
+		Action   string
+		Label    string
+		Book     repository.Book
+		Existing bool
+		Changes  string
+		Notes    string
+	}
+	type answer struct {
+		Request  importRequest
+		Mappings []importMapping
+		Summary  string
+		Total    int
+		Rows     []row
+		Pending  bool
+	}
+	data := answer{Request: req, Summary: importer.Summarize(items).String(), Total: len(items)}
+	if req.Format == "csv" {
+		data.Mappings = req.mappings()
+	}
+	for i, it := range items {
+		if it.Action == importer.ACTION_CREATE || it.Action == importer.ACTION_UPDATE {
+			data.Pending = true
+		}
+		if i >= importPreviewRows {
+			continue
+		}
+		changes := make([]string, len(it.Changes))
+		for j, c := range it.Changes {
+			changes[j] = bookFieldTitles[c]
+		}
+		book := it.Book
+		if book.Author == "" && book.Name == "" {
+			book.Author, book.Name = it.Record.Author, it.Record.Name
+		}
+		data.Rows = append(data.Rows, row{
+			Line: it.Record.Line, Action: string(it.Action), Label: importActions[string(it.Action)], Book: book,
+			Existing: book.Book_Id != uuid.Nil,
+			Changes:  strings.Join(changes, ", "), Notes: strings.Join(it.Notes, "; "),
+		})
+	}
+	if data.Rows == nil && len(items) == 0 {
+		data.Summary = "В источнике нет записей"
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "import-preview", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// ApplyImport заново строит план, потому что каталог мог измениться после предпросмотра, и выполняет его
+func (a app) ApplyImport(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	req, err := readImportRequest(r)
+	if err != nil {
+		a.ImportPage(rw, r, err.Error())
+		return
+	}
+	items, err := a.importPlan(req)
+	if err != nil {
+		a.ImportPage(rw, r, fmt.Sprintf("Ошибка чтения источника: %v", err))
+		return
+	}
+
+	s, err := importer.Apply(a.ctx, a.repo, items, func(old *repository.Book, b repository.Book) {
+		if old == nil {
+			a.events.publish(string(repository.EVENT_BOOK_CREATED), b)
+			a.visibilityChanged(false, b)
+		} else {
+			a.events.publish(string(repository.EVENT_BOOK_UPDATED), b)
+		}
+		a.recordRevision(r, old, repository.Revision{Action: repository.REVISION_IMPORT, Book: b})
+		a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_IMPORT}, old, &b))
+	})
+	if err != nil {
+		a.ImportPage(rw, r, fmt.Sprintf("Импорт прерван (%s): %v", s.Applied(), err))
+		return
+	}
+	if req.Token != "" {
+		os.Remove(req.path())
+	}
+	a.ImportPage(rw, r, "Импорт завершен: "+s.Applied())
+}
+
+// pruneImports удаляет загруженные файлы, импорт которых так и не выполнили
+func (a app) pruneImports() {
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), importPrefix+"*"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && time.Since(fi.ModTime()) > importTTL {
+			os.Remove(f)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package application
+
+import "time"
+
+// StartJobs запускает фоновые задачи и возвращает управление сразу
+func (a app) StartJobs() {
+	for _, auth := range a.authenticators {
+		if l, ok := auth.(*ldapAuthenticator); ok && l.syncInterval > 0 {
+			go a.every(l.syncInterval, func() { a.syncLDAP(l) })
+		}
+	}
+	go a.runWebhooks()
+	go a.every(24*time.Hour, a.pruneWebhooks)
+	go a.every(time.Hour, a.pruneImports)
+	go a.every(recommendInterval, a.refreshRecommendations)
+	go a.every(followInterval, a.notifyFollowers)
+	go a.every(scheduleInterval, a.publishScheduled)
+	go a.every(time.Hour, a.purgeTrash)
+	go a.every(10*time.Minute, a.guard.sweep)
+}
+
+// every выполняет f с заданным интервалом, пока не отменен контекст приложения
+func (a app) every(interval time.Duration, f func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		f()
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
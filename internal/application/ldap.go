@@ -0,0 +1,173 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"biblio/internal/ldap"
+	"biblio/internal/repository"
+)
+
+// ldapAuthenticator - вход сотрудников по учетным записям каталога. Настраивается переменными окружения:
+// LDAP_URL, LDAP_BIND_DN, LDAP_BIND_PASSWORD, LDAP_BASE_DN, LDAP_USER_FILTER (по умолчанию (uid=%s)),
+// LDAP_GROUP_FILTER и LDAP_GROUP_BASE_DN для поиска групп вместо memberOf, LDAP_ACTIVE_FILTER,
+// LDAP_ADMIN_GROUPS и LDAP_USER_GROUPS - списки групп через запятую,
+// LDAP_SYNC_INTERVAL - как часто сверять блокировки с каталогом (по умолчанию 1h, 0 - не сверять),
+// LDAP_STARTTLS - включать ли TLS на адресе ldap:// (по умолчанию true: иначе пароли идут открытым текстом)
+type ldapAuthenticator struct {
+	dir          *ldap.Directory
+	repo         *repository.Repository
+	adminGroups  []string
+	userGroups   []string
+	syncInterval time.Duration
+	providerName string
+	events       *eventBus
+}
+
+func ldapFromEnv(repo *repository.Repository, events *eventBus) *ldapAuthenticator {
+	url := os.Getenv("LDAP_URL")
+	if url == "" {
+		return nil
+	}
+
+	interval, err := time.ParseDuration(envOr("LDAP_SYNC_INTERVAL", "1h"))
+	if err != nil {
+		log.Printf("invalid LDAP_SYNC_INTERVAL: %v", err)
+		interval = time.Hour
+	}
+
+	startTLS := false
+	if strings.HasPrefix(strings.ToLower(url), "ldap://") {
+		startTLS, err = strconv.ParseBool(envOr("LDAP_STARTTLS", "true"))
+		if err != nil {
+			log.Printf("invalid LDAP_STARTTLS: %v", err)
+			startTLS = true
+		}
+		if !startTLS {
+			log.Printf("warning: LDAP_STARTTLS is off, passwords are sent to %s in cleartext; use ldaps:// or StartTLS", url)
+		}
+	}
+
+	return &ldapAuthenticator{
+		dir: ldap.NewDirectory(ldap.Config{
+			URL:          url,
+			StartTLS:     startTLS,
+			BindDN:       os.Getenv("LDAP_BIND_DN"),
+			BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:       os.Getenv("LDAP_BASE_DN"),
+			UserFilter:   os.Getenv("LDAP_USER_FILTER"),
+			GroupBaseDN:  os.Getenv("LDAP_GROUP_BASE_DN"),
+			GroupFilter:  os.Getenv("LDAP_GROUP_FILTER"),
+			ActiveFilter: os.Getenv("LDAP_ACTIVE_FILTER"),
+		}),
+		repo:         repo,
+		adminGroups:  splitList(os.Getenv("LDAP_ADMIN_GROUPS")),
+		userGroups:   splitList(os.Getenv("LDAP_USER_GROUPS")),
+		syncInterval: interval,
+		providerName: "ldap:" + url,
+		events:       events,
+	}
+}
+
+// Authenticate проверяет пароль в каталоге. При первом входе пользователь создается,
+// при каждом следующем роль и блокировка приводятся к данным каталога
+func (l *ldapAuthenticator) Authenticate(ctx context.Context, login, password string) (user repository.User, err error) {
+	acc, err := l.dir.Authenticate(ctx, login, password)
+	if err != nil {
+		if !errors.Is(err, ldap.ErrInvalidCredentials) && !errors.Is(err, ldap.ErrNoSuchUser) {
+			log.Println(err)
+		}
+		return
+	}
+
+	role := mapRole(acc.Groups, l.adminGroups, l.userGroups)
+	if role == "" {
+		err = errAccessDenied
+		return
+	}
+
+	user, err = l.repo.GetUserByIdentity(ctx, l.providerName, acc.Login)
+	if err == nil {
+		if string(user.Role) == role && user.Active == acc.Active {
+			return
+		}
+		err = l.apply(ctx, user, role, acc.Active)
+		if err != nil {
+			return
+		}
+		return l.repo.GetUserByIdentity(ctx, l.providerName, acc.Login)
+	}
+
+	if !acc.Active {
+		return repository.User{Username: acc.Login, Active: false}, nil
+	}
+
+	// как и при едином входе, локальную учетную запись с тем же логином не присоединяем
+	if existing, e := l.repo.GetUserByLoginOrEmail(ctx, acc.Login); e == nil && existing.Username == acc.Login {
+		err = fmt.Errorf("ldap: login %s is taken by a local user", acc.Login)
+		log.Println(err)
+		return
+	}
+
+	fullName := acc.FullName
+	if fullName == "" {
+		fullName = acc.Login
+	}
+	user, err = l.repo.AddExternalUser(ctx, l.providerName, acc.Login, acc.Login, fullName, acc.Email, acc.Email != "", role)
+	if err == nil {
+		l.events.publish(string(repository.EVENT_USER_CREATED), user)
+	}
+	return
+}
+
+func (l *ldapAuthenticator) apply(ctx context.Context, user repository.User, role string, active bool) error {
+	err := l.repo.PutUserById(ctx, role, strconv.FormatBool(active), user.User_Id.String())
+	if err == nil && user.Active && !active {
+		if blocked, e := l.repo.GetUserById(ctx, user.User_Id.String()); e == nil {
+			l.events.publish(string(repository.EVENT_USER_BLOCKED), blocked)
+		}
+	}
+	return err
+}
+
+// syncLDAP сверяет пользователей каталога: удаленные и заблокированные в каталоге блокируются
+// в библиотеке. Если роль или блокировка изменились, сеансы пользователя завершаются.
+// Если каталог недоступен, ничего не меняется
+func (a app) syncLDAP(l *ldapAuthenticator) {
+	users, err := a.repo.ExternalUsers(a.ctx, l.providerName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for login, user := range users {
+		role, active := string(user.Role), false
+		acc, err := l.dir.Lookup(a.ctx, login)
+		switch {
+		case errors.Is(err, ldap.ErrNoSuchUser):
+		case err != nil:
+			log.Printf("ldap sync stopped: %v", err)
+			return
+		default:
+			if r := mapRole(acc.Groups, l.adminGroups, l.userGroups); r != "" {
+				role, active = r, acc.Active
+			}
+		}
+
+		if string(user.Role) == role && user.Active == active {
+			continue
+		}
+		err = l.apply(a.ctx, user, role, active)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		a.sessions.removeUser(user.User_Id.String(), "")
+	}
+}
@@ -0,0 +1,334 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/encoding/charmap"
+
+	"biblio/internal/opds"
+	"biblio/internal/repository"
+)
+
+const opdsPerPage = 30
+
+// opdsFileTypes - форматы файлов книг, которые отдаются читалкам
+var opdsFileTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".fb2":  "application/x-fictionbook+xml",
+	".epub": "application/epub+zip",
+	".pdf":  "application/pdf",
+}
+
+// opdsAuthorized - вариант authorized для каталога OPDS. Читалки не умеют входить через форму,
+// поэтому пользователь определяется по API-токену (Bearer или вместо пароля в Basic) или по логину
+// и паролю в Basic. Пользователям с 2FA пароль здесь не подходит, им нужен токен с областью read.
+// Неверный пароль, пароль пользователя с 2FA и закрытый доступ дают один и тот же ответ 401
+func (a *app) opdsAuthorized(next httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		unauthorized := func(message string) {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="biblio", charset="UTF-8"`)
+			http.Error(rw, message, http.StatusUnauthorized)
+		}
+
+		raw := bearerToken(r)
+		login, password, basic := r.BasicAuth()
+		if basic && strings.HasPrefix(password, apiTokenPrefix) {
+			raw = password
+		}
+
+		if raw != "" {
+			req, status, message := a.tokenRequest(r, raw)
+			switch status {
+			case http.StatusOK:
+				next(rw, req, ps)
+			case http.StatusUnauthorized:
+				unauthorized(message)
+			default:
+				http.Error(rw, message, status)
+			}
+			return
+		}
+
+		if !basic || login == "" || password == "" {
+			unauthorized("Укажите логин и пароль или API-токен")
+			return
+		}
+
+		ip := clientIP(r)
+		if wait := a.guard.wait(login, ip); wait > 0 {
+			a.loginFailed(login, ip, "locked")
+			rw.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(rw, fmt.Sprintf("Слишком много неудачных попыток входа. Повторите через %v", wait.Round(time.Second)), http.StatusTooManyRequests)
+			return
+		}
+
+		// Basic не защищен проверочным вопросом, поэтому на любой отказ ответ одинаковый: иначе по нему можно
+		// узнать, что пароль верен, например у пользователя с 2FA. Успех здесь не сбрасывает счетчики неудач,
+		// на которые опирается вход через форму
+		user, err := a.authenticate(login, password)
+		switch {
+		case errors.Is(err, errAccessDenied):
+			a.loginFailed(login, ip, "denied")
+		case err != nil:
+			a.loginFailed(login, ip, "password")
+		case !user.Active:
+			a.loginFailed(login, ip, "inactive")
+		case user.TOTPEnabled:
+			a.loginFailed(login, ip, "totp")
+		}
+		if err != nil || !user.Active || user.TOTPEnabled {
+			a.guard.fail(login, ip)
+			unauthorized("Неверный логин или пароль")
+			return
+		}
+		a.repo.AddLoginAttempt(a.ctx, login, ip, true)
+
+		ctx := context.WithValue(r.Context(), "role", UserRole(user.Role))
+		ctx = context.WithValue(ctx, "user", user)
+		next(rw, r.WithContext(ctx), ps)
+	}
+}
+
+func writeFeed(rw http.ResponseWriter, kind string, f *opds.Feed) {
+	rw.Header().Set("Content-Type", kind+";charset=utf-8")
+	err := f.Write(rw)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// opdsFeed создает ленту со ссылками на корень каталога и поиск, которые нужны на каждой странице
+func opdsFeed(path, title string) *opds.Feed {
+	f := opds.NewFeed("urn:biblio:opds:"+strings.TrimPrefix(path, "/"), title, time.Now())
+	f.Author = &opds.Person{Name: "Библиотека", URI: baseURL}
+	f.Links = []opds.Link{
+		{Rel: opds.RelSelf, Href: path, Type: opds.NavigationType},
+		{Rel: opds.RelStart, Href: "/opds", Type: opds.NavigationType, Title: "Каталог"},
+		{Rel: opds.RelSearch, Href: "/opds/opensearch.xml", Type: opds.OpenSearchType, Title: "Поиск"},
+	}
+	return f
+}
+
+func navEntry(id, title, content, href, kind string) opds.Entry {
+	return opds.Entry{
+		ID:      "urn:biblio:opds:" + id,
+		Title:   title,
+		Updated: opds.Time(time.Now()),
+		Content: &opds.Text{Type: "text", Body: content},
+		Links:   []opds.Link{{Rel: opds.RelSubsection, Href: href, Type: kind}},
+	}
+}
+
+// bookEntry - книга в ленте получения. Ссылка на файл есть только у книг с открытым доступом
+func bookEntry(b repository.Book) opds.Entry {
+	e := opds.Entry{
+		ID:         "urn:uuid:" + b.Book_Id.String(),
+		Title:      b.Name,
+		Updated:    opds.Time(b.Publication),
+		Authors:    []opds.Person{{Name: b.Author, URI: "/opds/books?" + url.Values{"author": {b.Author}}.Encode()}},
+		Issued:     b.Publication.Format("2006-01-02"),
+		Language:   "ru",
+		Categories: []opds.Category{{Term: string(b.Category), Label: string(b.Category)}},
+		Summary:    &opds.Text{Type: "text", Body: b.Annotation},
+	}
+	if b.Series != "" && b.Series != "-" {
+		e.Content = &opds.Text{Type: "text", Body: "Серия: " + b.Series}
+	}
+	if kind, ok := opdsFileTypes[strings.ToLower(filepath.Ext(b.Link))]; ok && b.Access == "Да" {
+		e.Links = append(e.Links, opds.Link{
+			Rel:  opds.RelAcquisition,
+			Href: "/opds/books/" + b.Book_Id.String() + "/file",
+			Type: strings.Split(kind, ";")[0],
+		})
+	}
+	return e
+}
+
+// OPDSRoot - корневая навигационная лента каталога, ее адрес указывают в читалке
+func (a app) OPDSRoot(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	f := opdsFeed("/opds", "Библиотека")
+	f.Entries = []opds.Entry{
+		navEntry("new", "Новые поступления", "Книги, добавленные последними", "/opds/new", opds.AcquisitionType),
+		navEntry("genres", "По жанрам", "Книги, сгруппированные по жанрам", "/opds/genres", opds.NavigationType),
+		navEntry("authors", "По авторам", "Книги, сгруппированные по авторам", "/opds/authors", opds.NavigationType),
+		navEntry("series", "По сериям", "Книги, сгруппированные по сериям", "/opds/series", opds.NavigationType),
+	}
+	f.Entries[0].Links[0].Rel = opds.RelNew
+	writeFeed(rw, opds.NavigationType, f)
+}
+
+// opdsFacets строит навигационную ленту по значениям поля книги: жанру, автору или серии
+func (a app) opdsFacets(field, title string) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		facets, err := a.repo.BookFacets(a.ctx, field)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		path := r.URL.Path
+		f := opdsFeed(path, title)
+		f.Links = append(f.Links, opds.Link{Rel: opds.RelUp, Href: "/opds", Type: opds.NavigationType})
+		for _, v := range facets {
+			if field == "series" && v.Value == "-" {
+				continue
+			}
+			e := navEntry(field+":"+url.QueryEscape(v.Value), v.Value, "Книг: "+strconv.Itoa(v.Count),
+				"/opds/books?"+url.Values{field: {v.Value}}.Encode(), opds.AcquisitionType)
+			e.Links[0].Count = v.Count
+			f.Entries = append(f.Entries, e)
+		}
+		writeFeed(rw, opds.NavigationType, f)
+	}
+}
+
+// opdsBooks отдает страницу ленты получения с книгами под фильтр и ссылками на соседние страницы
+func (a app) opdsBooks(rw http.ResponseWriter, r *http.Request, title string, filter repository.BookFilter) {
+	page := 1
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(rw, "Номер страницы должен быть положительным числом", http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+
+	filter.Limit, filter.Offset = opdsPerPage, (page-1)*opdsPerPage
+	books, total, err := a.repo.FindBooks(a.ctx, filter)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pageURL := func(n int) string {
+		q := r.URL.Query()
+		q.Del("page")
+		if n > 1 {
+			q.Set("page", strconv.Itoa(n))
+		}
+		if len(q) == 0 {
+			return r.URL.Path
+		}
+		return r.URL.Path + "?" + q.Encode()
+	}
+
+	f := opdsFeed(pageURL(page), title)
+	f.Links[0].Type = opds.AcquisitionType
+	f.Links = append(f.Links, opds.Link{Rel: opds.RelUp, Href: "/opds", Type: opds.NavigationType})
+	if page > 1 {
+		f.Links = append(f.Links, opds.Link{Rel: opds.RelPrevious, Href: pageURL(page - 1), Type: opds.AcquisitionType})
+	}
+	if page*opdsPerPage < total {
+		f.Links = append(f.Links, opds.Link{Rel: opds.RelNext, Href: pageURL(page + 1), Type: opds.AcquisitionType})
+	}
+	f.TotalResults, f.ItemsPerPage, f.StartIndex = total, opdsPerPage, filter.Offset+1
+	for _, b := range books {
+		f.Entries = append(f.Entries, bookEntry(b))
+	}
+	writeFeed(rw, opds.AcquisitionType, f)
+}
+
+// OPDSBooks - GET /opds/books?category=&author=&series=, книги раздела навигации
+func (a app) OPDSBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := r.URL.Query()
+	filter := repository.BookFilter{Category: q.Get("category"), Author: q.Get("author"), Series: q.Get("series"), Exact: true}
+
+	title := "Все книги"
+	for _, v := range []string{filter.Category, filter.Author, filter.Series} {
+		if v != "" {
+			title = v
+		}
+	}
+	a.opdsBooks(rw, r, title, filter)
+}
+
+func (a app) OPDSNew(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.opdsBooks(rw, r, "Новые поступления", repository.BookFilter{Sort: "-publication"})
+}
+
+// OPDSSearch - GET /opds/search?q=, тот же поиск, что и /api/v1/search
+func (a app) OPDSSearch(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(rw, "Укажите поисковый запрос", http.StatusBadRequest)
+		return
+	}
+	a.opdsBooks(rw, r, "Поиск: "+q, repository.BookFilter{Query: q})
+}
+
+// OpenSearch - описание поиска, по которому читалка строит адрес запроса
+func (a app) OpenSearch(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	d := opds.NewOpenSearch("Библиотека", "Поиск по названию, автору, серии и аннотации", "/opds/search?q={searchTerms}")
+	rw.Header().Set("Content-Type", opds.OpenSearchType+";charset=utf-8")
+	err := d.Write(rw)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// OPDSFile отдает файл книги. Текстовые файлы хранятся в windows-1251, читалкам они отдаются в UTF-8
+func (a app) OPDSFile(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, err := uuid.Parse(p.ByName("id"))
+	if err != nil {
+		http.Error(rw, "Книга не найдена", http.StatusNotFound)
+		return
+	}
+	book, err := a.visibleBook(r, id.String())
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(rw, "Книга не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if book.Access != "Да" {
+		http.Error(rw, "Книга недоступна для скачивания", http.StatusForbidden)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(book.Link))
+	kind, ok := opdsFileTypes[ext]
+	if !ok {
+		http.Error(rw, "Формат файла не поддерживается", http.StatusNotFound)
+		return
+	}
+	data, err := os.ReadFile(book.Link)
+	if err != nil {
+		http.Error(rw, "Файл книги не найден", http.StatusNotFound)
+		return
+	}
+	if ext == ".txt" {
+		data, err = charmap.Windows1251.NewDecoder().Bytes(data)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// докачка частями - продолжение того же открытия книги
+	if r.Header.Get("Range") == "" {
+		a.bookOpened(r, book, "opds")
+	}
+
+	name := strings.Trim(book.Author+" - "+book.Name, " -") + ext
+	rw.Header().Set("Content-Type", kind)
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(rw, r, "", book.Publication, bytes.NewReader(data))
+}
@@ -0,0 +1,354 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// обработчики читают шаблоны и спецификацию по путям от корня репозитория
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// routeRecorder регистрирует маршруты в настоящем роутере и запоминает их в записи OpenAPI
+type routeRecorder struct {
+	*httprouter.Router
+	routes []string
+}
+
+var routeParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+func (rr *routeRecorder) add(method, path string) {
+	rr.routes = append(rr.routes, method+" "+routeParam.ReplaceAllString(path, "{$1}"))
+}
+
+func (rr *routeRecorder) GET(path string, h httprouter.Handle) {
+	rr.add("get", path)
+	rr.Router.GET(path, h)
+}
+
+func (rr *routeRecorder) POST(path string, h httprouter.Handle) {
+	rr.add("post", path)
+	rr.Router.POST(path, h)
+}
+
+func (rr *routeRecorder) PUT(path string, h httprouter.Handle) {
+	rr.add("put", path)
+	rr.Router.PUT(path, h)
+}
+
+func (rr *routeRecorder) DELETE(path string, h httprouter.Handle) {
+	rr.add("delete", path)
+	rr.Router.DELETE(path, h)
+}
+
+func (rr *routeRecorder) ServeFiles(path string, root http.FileSystem) {
+	rr.add("get", path)
+	rr.Router.ServeFiles(path, root)
+}
+
+type object = map[string]interface{}
+
+func loadSpec(t *testing.T) object {
+	data, err := os.ReadFile("public/api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec object
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return spec
+}
+
+// testApp - приложение без базы: сессии в памяти позволяют проверить ответы, которые не доходят до хранилища
+func testApp() *routeRecorder {
+	a := app{
+		ctx:        context.Background(),
+		sessions:   newSessionStore(),
+		require2FA: new(atomic.Bool),
+	}
+	expires := time.Now().Add(time.Hour)
+	a.sessions.add("user-session", session{User: repository.User{User_Id: uuid.New(), Username: "reader", Role: repository.USER, Active: true}, Expires: expires})
+	a.sessions.add("admin-session", session{User: repository.User{User_Id: uuid.New(), Username: "admin", Role: repository.ADMIN, Active: true}, Expires: expires})
+
+	rr := &routeRecorder{Router: httprouter.New()}
+	a.Routes(rr)
+	return rr
+}
+
+func TestSpecCoversRoutes(t *testing.T) {
+	spec := loadSpec(t)
+	rr := testApp()
+
+	registered := make(map[string]bool)
+	for _, r := range rr.routes {
+		registered[r] = true
+		method, path, _ := strings.Cut(r, " ")
+		item, _ := spec["paths"].(object)[path].(object)
+		if item[method] == nil {
+			t.Errorf("route %s is not described in openapi.json", r)
+		}
+	}
+
+	var documented []string
+	for path, item := range spec["paths"].(object) {
+		for method := range item.(object) {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	sort.Strings(documented)
+	for _, r := range documented {
+		if !registered[r] {
+			t.Errorf("openapi.json describes %s, but Routes does not register it", r)
+		}
+	}
+}
+
+func TestAPIResponsesMatchSpec(t *testing.T) {
+	spec := loadSpec(t)
+	rr := testApp()
+
+	book := "/api/v1/books/" + uuid.NewString()
+	for _, c := range []struct {
+		method, url, route string
+		session            string
+		header             map[string]string
+		body               string
+		status             int
+	}{
+		{"GET", "/api/v1/books", "/api/v1/books", "", nil, "", http.StatusUnauthorized},
+		{"GET", "/api/v1/books", "/api/v1/books", "", map[string]string{"Authorization": "Bearer nope"}, "", http.StatusUnauthorized},
+		{"GET", "/api/v1/books?page=0&per_page=500", "/api/v1/books", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/books?sort=price", "/api/v1/books", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"POST", "/api/v1/books", "/api/v1/books", "user-session", nil, "{}", http.StatusForbidden},
+		{"POST", "/api/v1/books", "/api/v1/books", "admin-session", nil, "{", http.StatusBadRequest},
+		{"POST", "/api/v1/books", "/api/v1/books", "admin-session", nil, `{"category": "Поэзия", "access": "Может быть"}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/books/42", "/api/v1/books/{id}", "user-session", nil, "", http.StatusNotFound},
+		{"PUT", book, "/api/v1/books/{id}", "admin-session", nil, "{}", http.StatusPreconditionRequired},
+		{"PUT", book, "/api/v1/books/{id}", "user-session", map[string]string{"If-Match": `"1"`}, "{}", http.StatusForbidden},
+		{"DELETE", "/api/v1/books/42", "/api/v1/books/{id}", "admin-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/books/42/revisions", "/api/v1/books/{id}/revisions", "admin-session", nil, "", http.StatusNotFound},
+		{"GET", book + "/revisions", "/api/v1/books/{id}/revisions", "user-session", nil, "", http.StatusForbidden},
+		{"POST", "/api/v1/books/42/revisions/1/revert", "/api/v1/books/{id}/revisions/{rev}/revert", "admin-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/search", "/api/v1/search", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"GET", book + "/cite?format=doc", "/api/v1/books/{id}/cite", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"GET", book + "/reviews", "/api/v1/books/{id}/reviews", "", nil, "", http.StatusUnauthorized},
+		{"PUT", book + "/review", "/api/v1/books/{id}/review", "user-session", nil, "{", http.StatusBadRequest},
+		{"DELETE", "/api/v1/books/42/review", "/api/v1/books/{id}/review", "user-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/basket", "/api/v1/basket", "", nil, "", http.StatusUnauthorized},
+		{"PUT", "/api/v1/basket/42", "/api/v1/basket/{id}", "user-session", nil, "", http.StatusNotFound},
+		{"DELETE", "/api/v1/basket/42", "/api/v1/basket/{id}", "user-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/basket/export?format=doc", "/api/v1/basket/export", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/books/42/similar", "/api/v1/books/{id}/similar", "user-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/recommendations", "/api/v1/recommendations", "", nil, "", http.StatusUnauthorized},
+		{"GET", "/api/v1/follows", "/api/v1/follows", "", nil, "", http.StatusUnauthorized},
+		{"POST", "/api/v1/follows", "/api/v1/follows", "user-session", nil, "{", http.StatusBadRequest},
+		{"DELETE", "/api/v1/follows/x", "/api/v1/follows/{id}", "user-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/shelves", "/api/v1/shelves", "", nil, "", http.StatusUnauthorized},
+		{"GET", "/api/v1/shelves/1/books?page=0", "/api/v1/shelves/{id}/books", "user-session", nil, "", http.StatusUnprocessableEntity},
+		{"PUT", "/api/v1/shelves/x/books/42", "/api/v1/shelves/{id}/books/{book}", "user-session", nil, "", http.StatusNotFound},
+		{"DELETE", "/api/v1/shelves/x/books/42", "/api/v1/shelves/{id}/books/{book}", "user-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/v1/users", "/api/v1/users", "user-session", nil, "", http.StatusForbidden},
+		{"GET", "/api/v1/users?per_page=0", "/api/v1/users", "admin-session", nil, "", http.StatusUnprocessableEntity},
+		{"GET", "/api/v1/users/42", "/api/v1/users/{id}", "admin-session", nil, "", http.StatusNotFound},
+		{"GET", "/api/openapi.json", "/api/openapi.json", "", nil, "", http.StatusOK},
+		{"GET", "/api/graphql?query=%7B__typename%7D", "/api/graphql", "", nil, "", http.StatusUnauthorized},
+		{"GET", "/api/graphql?query=%7B__typename%7D", "/api/graphql", "user-session", nil, "", http.StatusOK},
+		{"POST", "/api/graphql", "/api/graphql", "user-session", nil, `{"query": "{ me { login role email } }"}`, http.StatusOK},
+		{"POST", "/api/graphql", "/api/graphql", "user-session", nil, `{"query": "{ users { totalCount } }"}`, http.StatusOK},
+		{"POST", "/api/graphql", "/api/graphql", "user-session", nil, `{"query": "{ me { login "}`, http.StatusBadRequest},
+		{"POST", "/api/graphql", "/api/graphql", "user-session", nil, `{"query": "{ books(first: 100) { nodes { author { books(first: 100) { totalCount } } } } }"}`, http.StatusBadRequest},
+		{"POST", "/api/graphql", "/api/graphql", "user-session", nil, `[]`, http.StatusBadRequest},
+		{"GET", "/api/events", "/api/events", "", nil, "", http.StatusUnauthorized},
+	} {
+		name := c.method + " " + c.url
+		req := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if c.session != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: c.session})
+		}
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		rr.ServeHTTP(rw, req)
+
+		if rw.Code != c.status {
+			t.Errorf("%s: status %d, want %d: %s", name, rw.Code, c.status, rw.Body)
+			continue
+		}
+
+		op, _ := spec["paths"].(object)[c.route].(object)[strings.ToLower(c.method)].(object)
+		if op == nil {
+			t.Errorf("%s: operation is not described", name)
+			continue
+		}
+		resp, ok := op["responses"].(object)[fmt.Sprint(rw.Code)]
+		if !ok {
+			t.Errorf("%s: status %d is not declared", name, rw.Code)
+			continue
+		}
+		content, _ := deref(spec, resp)["content"].(object)
+		media, _ := content["application/json"].(object)
+		if media == nil {
+			continue
+		}
+		if ct := rw.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: content type %q, want application/json", name, ct)
+		}
+		var body interface{}
+		if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: invalid JSON: %v", name, err)
+			continue
+		}
+		if err := validate(spec, media["schema"], body, "body"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestModelsMatchSpec проверяет ответы, для которых нужна база, по тем же структурам, что отдают обработчики
+func TestModelsMatchSpec(t *testing.T) {
+	spec := loadSpec(t)
+	deleted := time.Now()
+	b := repository.Book{Book_Id: uuid.New(), Category: repository.CLASSIC, Author: "Пушкин", Series: "-", Name: "Капитанская дочка",
+		Annotation: "-", Link: "books/1.txt", Access: "Да", Publication: time.Now()}
+	u := repository.User{User_Id: uuid.New(), Username: "reader", Role: repository.USER, FullName: "Читатель", HashedPassword: "secret",
+		Active: true, Email: "r@example.com", Preferences: []string{"Классика"}, DeletionRequestedAt: &deleted, TOTPSecret: "secret"}
+
+	for name, v := range map[string]interface{}{
+		"Book":     b,
+		"User":     u,
+		"BookList": apiList{Items: []repository.Book{b}, Total: 1, Page: 1, PerPage: 20},
+		"UserList": apiList{Items: []repository.User{u, {User_Id: uuid.New(), Username: "admin", Role: repository.ADMIN}}, Total: 2, Page: 1, PerPage: 20},
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded interface{}
+		json.Unmarshal(data, &decoded)
+		if err := validate(spec, object{"$ref": "#/components/schemas/" + name}, decoded, name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func deref(spec object, v interface{}) object {
+	o, _ := v.(object)
+	for o != nil && o["$ref"] != nil {
+		var cur interface{} = spec
+		for _, k := range strings.Split(strings.TrimPrefix(o["$ref"].(string), "#/"), "/") {
+			cur = cur.(object)[k]
+		}
+		o, _ = cur.(object)
+	}
+	return o
+}
+
+// validate - проверка значения по схеме OpenAPI в объеме, который используется в openapi.json
+func validate(spec object, schema interface{}, v interface{}, at string) error {
+	s := deref(spec, schema)
+	if s == nil {
+		return fmt.Errorf("%s: unknown schema", at)
+	}
+	if v == nil {
+		if s["nullable"] == true || s["type"] == nil {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, v, enum)
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		o, ok := v.(object)
+		if !ok {
+			return fmt.Errorf("%s: want object, got %T", at, v)
+		}
+		for _, r := range asList(s["required"]) {
+			if _, ok := o[r.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %s", at, r)
+			}
+		}
+		props, _ := s["properties"].(object)
+		for k, pv := range o {
+			if ps, ok := props[k]; ok {
+				if err := validate(spec, ps, pv, at+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := s["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property %s", at, k)
+				}
+			case object:
+				if err := validate(spec, extra, pv, at+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want array, got %T", at, v)
+		}
+		for i, item := range list {
+			if err := validate(spec, s["items"], item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: want string, got %T", at, v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return fmt.Errorf("%s: want integer, got %v", at, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: want number, got %T", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: want boolean, got %T", at, v)
+		}
+	}
+	return nil
+}
+
+func asList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
@@ -0,0 +1,183 @@
+package application
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// headerFor выбирает шапку страницы по роли, для страниц, общих для читателей и администраторов
+func headerFor(r *http.Request) string {
+	if r.Context().Value("role").(UserRole) == "ADMIN" {
+		return headera
+	}
+	return header
+}
+
+func currentUser(r *http.Request) repository.User {
+	u, _ := r.Context().Value("user").(repository.User)
+	return u
+}
+
+func (a app) ProfilePage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "profile.html")
+
+	user, err := a.repo.GetUserById(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, _ := readCookie("token", r)
+	preferred := make(map[string]bool)
+	for _, c := range user.Preferences {
+		preferred[c] = true
+	}
+
+	type answer struct {
+		User       repository.User
+		Sessions   []sessionInfo
+		Categories []string
+		Preferred  map[string]bool
+		Message    string
+	}
+	data := answer{
+		User:      user,
+		Sessions:  a.sessions.forUser(user.User_Id.String(), token),
+		Preferred: preferred,
+		Message:   message,
+	}
+	for _, c := range repository.Categories {
+		data.Categories = append(data.Categories, string(c))
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "profile", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) PutProfile(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	fullName := strings.TrimSpace(r.FormValue("fullName"))
+	if fullName == "" {
+		a.ProfilePage(rw, r, "Имя не может быть пустым!")
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	preferences := []string{}
+	for _, c := range repository.Categories {
+		for _, v := range r.PostForm["preferences"] {
+			if v == string(c) {
+				preferences = append(preferences, v)
+			}
+		}
+	}
+
+	user := currentUser(r)
+	err = a.repo.PutProfile(a.ctx, user.User_Id.String(), fullName, preferences)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user.FullName = fullName
+	a.sessions.updateUser(user)
+	a.ProfilePage(rw, r, "Профиль сохранен")
+}
+
+// checkPassword сверяет введенный пароль пользователя запроса с сохраненным хешем. Украденной сессией
+// нельзя подобрать пароль перебором: неудачи считаются по логину и адресу, как при входе, и приводят
+// к той же задержке и блокировке. Возвращает пустую строку для верного пароля, иначе сообщение
+// для страницы: wrong или просьбу подождать
+func (a app) checkPassword(r *http.Request, password, wrong string) string {
+	user, ip := currentUser(r), clientIP(r)
+	if wait := a.guard.wait(user.Username, ip); wait > 0 {
+		return fmt.Sprintf("Слишком много неудачных попыток. Повторите через %v", wait.Round(time.Second))
+	}
+
+	stored, err := a.repo.GetUserById(a.ctx, user.User_Id.String())
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashPassword(password)), []byte(stored.HashedPassword)) != 1 {
+		a.guard.fail(user.Username, ip)
+		a.loginFailed(user.Username, ip, "current_password")
+		return wrong
+	}
+	return ""
+}
+
+func (a app) ChangePassword(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	current := r.FormValue("current")
+	password := strings.TrimSpace(r.FormValue("password"))
+	password2 := strings.TrimSpace(r.FormValue("password2"))
+	userId := currentUser(r).User_Id.String()
+
+	if message := a.checkPassword(r, current, "Текущий пароль указан неверно!"); message != "" {
+		a.ProfilePage(rw, r, message)
+		return
+	}
+	if password == "" || password != password2 {
+		a.ProfilePage(rw, r, "Пароли не совпадают! Попробуйте еще")
+		return
+	}
+
+	err := a.repo.SetPassword(a.ctx, userId, hashPassword(password))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_PASSWORD_CHANGE}, currentUser(r)))
+
+	// после смены пароля остальные сессии завершаются
+	token, _ := readCookie("token", r)
+	a.sessions.removeUser(userId, token)
+	a.ProfilePage(rw, r, "Пароль изменен, остальные сессии завершены")
+}
+
+func (a app) EndSession(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	a.sessions.removeById(currentUser(r).User_Id.String(), p.ByName("id"))
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_SESSION_END}, currentUser(r)))
+	http.Redirect(rw, r, "/user/profile", http.StatusSeeOther)
+}
+
+func (a app) RequestDeletion(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userId := currentUser(r).User_Id.String()
+	requested := r.FormValue("cancel") == ""
+
+	if requested {
+		if message := a.checkPassword(r, r.FormValue("current"), "Текущий пароль указан неверно!"); message != "" {
+			a.ProfilePage(rw, r, message)
+			return
+		}
+	}
+
+	err := a.repo.RequestDeletion(a.ctx, userId, requested)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if requested {
+		a.ProfilePage(rw, r, "Запрос на удаление аккаунта отправлен администратору")
+	} else {
+		a.ProfilePage(rw, r, "Запрос на удаление аккаунта отменен")
+	}
+}
@@ -0,0 +1,100 @@
+package application
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/recommend"
+	"biblio/internal/repository"
+)
+
+const (
+	// recommendInterval - как часто пересчитывать близость книг
+	recommendInterval = 6 * time.Hour
+	// similarStored - сколько похожих книг хранить для каждой книги
+	similarStored = 20
+	// recommendShown - книг в блоке "Вам может понравиться" на стартовой странице
+	recommendShown = 8
+	// similarShown - книг в блоке "Похожие книги" на странице книги
+	similarShown = 6
+)
+
+// refreshRecommendations пересчитывает близость книг по всему каталогу
+func (a app) refreshRecommendations() {
+	var books []repository.Book
+	err := a.repo.EachBook(a.ctx, repository.BookFilter{}, func(b repository.Book) error {
+		books = append(books, b)
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	signals, err := a.repo.ReadingSignals(a.ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = a.repo.SaveSimilarities(a.ctx, recommend.Similarities(books, signals, similarStored))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// recommendations подбирает книги читателю. О новом читателе ничего не известно, ему предлагаются
+// книги, которые интересны многим
+func (a app) recommendations(r *http.Request, limit int) (books []repository.Book, err error) {
+	userId := currentUser(r).User_Id.String()
+	books, err = a.repo.Recommendations(a.ctx, userId, limit)
+	if err != nil || len(books) > 0 {
+		return
+	}
+	return a.repo.PopularBooks(a.ctx, userId, limit)
+}
+
+// bookLink - книга со ссылкой на ее страницу для роли пользователя
+type bookLink struct {
+	repository.Book
+	Page string
+}
+
+func bookLinks(r *http.Request, books []repository.Book) []bookLink {
+	links := make([]bookLink, len(books))
+	for i, b := range books {
+		links[i] = bookLink{b, bookPage(r, b.Book_Id.String())}
+	}
+	return links
+}
+
+// APIRecommendations - GET /api/v1/recommendations, книги, которые могут понравиться текущему пользователю
+func (a app) APIRecommendations(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	books, err := a.recommendations(r, similarStored)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if books == nil {
+		books = []repository.Book{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": books})
+}
+
+// APISimilarBooks - GET /api/v1/books/:id/similar, самые похожие первыми
+func (a app) APISimilarBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, _, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	books, err := a.repo.SimilarBooks(a.ctx, b.Book_Id.String(), similarStored)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if books == nil {
+		books = []repository.Book{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": books})
+}
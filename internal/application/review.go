@@ -0,0 +1,226 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// reviewMaxLength - предел длины текста отзыва в символах
+const reviewMaxLength = 5000
+
+// checkReview проверяет оценку и текст отзыва. Ошибка - текст для пользователя
+func checkReview(rating int, body string) error {
+	if rating < 1 || rating > 5 {
+		return errors.New("Оценка - от 1 до 5 звезд")
+	}
+	if utf8.RuneCountInString(body) > reviewMaxLength {
+		return fmt.Errorf("Отзыв длиннее %d символов", reviewMaxLength)
+	}
+	return nil
+}
+
+// saveReview проверяет книгу и сохраняет отзыв текущего пользователя
+func (a app) saveReview(r *http.Request, bookId string, rating int, body string) (status int, err error) {
+	if _, err = uuid.Parse(bookId); err != nil {
+		return http.StatusNotFound, errors.New("Книга не найдена")
+	}
+	_, err = a.visibleBook(r, bookId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return http.StatusNotFound, errors.New("Книга не найдена")
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	body = strings.TrimSpace(body)
+	if err = checkReview(rating, body); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	// оценку без текста и отзывы администраторов публикуем сразу, остальное - после модерации
+	user := currentUser(r)
+	moderation := repository.REVIEW_PENDING
+	if body == "" || user.Role == repository.ADMIN {
+		moderation = repository.REVIEW_APPROVED
+	}
+	err = a.repo.SaveReview(a.ctx, user.User_Id.String(), bookId, rating, body, moderation)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// SaveReview - POST /user/books/review/:id, оценка и отзыв о книге. Новый отзыв заменяет прежний
+func (a app) SaveReview(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	rating, _ := strconv.Atoi(r.FormValue("rating"))
+	_, err := a.saveReview(r, p.ByName("id"), rating, r.FormValue("body"))
+	if err != nil {
+		a.bookInfoPage(rw, r, p.ByName("id"), err.Error())
+		return
+	}
+	http.Redirect(rw, r, bookPage(r, p.ByName("id"))+"#reviews", http.StatusSeeOther)
+}
+
+func (a app) DeleteReview(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.DeleteReview(a.ctx, currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, bookPage(r, p.ByName("id"))+"#reviews", http.StatusSeeOther)
+}
+
+// reviewQueue - очередь модерации. ModerateReview меняет только ожидающий отзыв, который не менялся после seen
+type reviewQueue interface {
+	PendingReviews(ctx context.Context) ([]repository.Review, error)
+	ModerateReview(ctx context.Context, id int64, approve bool, seen time.Time) (bool, error)
+}
+
+// ReviewsPage - очередь модерации отзывов
+func (a app) ReviewsPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "reviews.html")
+
+	reviews, err := a.reviews.PendingReviews(a.ctx)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Reviews []repository.Review
+		Message string
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "reviews", answer{reviews, message})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// moderateReview меняет состояние отзыва из очереди. Поле seen - время отзыва, который видел модератор:
+// если автор изменил отзыв позже, решение не применяется
+func (a app) moderateReview(approve bool) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		id, err := strconv.ParseInt(p.ByName("id"), 10, 64)
+		if err != nil {
+			a.ReviewsPage(rw, r, "Отзыв не найден")
+			return
+		}
+		// без seen нельзя проверить, что автор не изменил отзыв после просмотра, поэтому решение не принимается
+		seen, err := time.Parse(time.RFC3339Nano, r.FormValue("seen"))
+		if err != nil {
+			http.Error(rw, "Не указано, какую версию отзыва видел модератор", http.StatusBadRequest)
+			return
+		}
+		ok, err := a.reviews.ModerateReview(a.ctx, id, approve, seen)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !ok {
+			a.ReviewsPage(rw, r, "Отзыв уже изменен автором или рассмотрен, проверьте его еще раз")
+			return
+		}
+		action, status := repository.AUDIT_REVIEW_REJECT, repository.REVIEW_REJECTED
+		if approve {
+			action, status = repository.AUDIT_REVIEW_APPROVE, repository.REVIEW_APPROVED
+		}
+		a.audit(r, auditTarget(repository.AuditEntry{Action: action}, "review", p.ByName("id"), "",
+			map[string]string{"status": string(repository.REVIEW_PENDING)}, map[string]string{"status": string(status)}))
+		http.Redirect(rw, r, "/admin/reviews", http.StatusSeeOther)
+	}
+}
+
+type reviewInput struct {
+	Rating int    `json:"rating"`
+	Body   string `json:"body"`
+}
+
+// APIBookReviews - GET /api/v1/books/:id/reviews, средняя оценка и опубликованные отзывы
+func (a app) APIBookReviews(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, _, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	rating, err := a.repo.BookRating(a.ctx, b.Book_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	reviews, err := a.repo.BookReviews(a.ctx, b.Book_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if reviews == nil {
+		reviews = []repository.Review{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"rating": rating, "items": reviews})
+}
+
+// APISaveReview - PUT /api/v1/books/:id/review, отзыв текущего пользователя
+func (a app) APISaveReview(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	var in reviewInput
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, apiMaxBody)).Decode(&in)
+	if err != nil {
+		apiFail(rw, http.StatusBadRequest, "invalid_json", "Тело запроса должно быть объектом JSON: "+err.Error())
+		return
+	}
+	status, err := a.saveReview(r, p.ByName("id"), in.Rating, in.Body)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		apiFail(rw, status, "not_found", err.Error())
+		return
+	case http.StatusUnprocessableEntity:
+		field := "rating"
+		if in.Rating >= 1 && in.Rating <= 5 {
+			field = "body"
+		}
+		apiInvalid(rw, map[string]string{field: err.Error()})
+		return
+	default:
+		apiFail(rw, status, "internal", err.Error())
+		return
+	}
+
+	v, err := a.repo.UserReview(a.ctx, currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, v)
+}
+
+// APIDeleteReview - DELETE /api/v1/books/:id/review
+func (a app) APIDeleteReview(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if _, err := uuid.Parse(p.ByName("id")); err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Книга не найдена")
+		return
+	}
+	err := a.repo.DeleteReview(a.ctx, currentUser(r).User_Id.String(), p.ByName("id"))
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
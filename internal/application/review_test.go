@@ -0,0 +1,127 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+func TestCheckReview(t *testing.T) {
+	for _, c := range []struct {
+		rating int
+		body   string
+		ok     bool
+	}{
+		{5, "", true},
+		{1, "Скучно", true},
+		{0, "", false},
+		{6, "Отлично", false},
+		{4, strings.Repeat("я", reviewMaxLength), true},
+		{4, strings.Repeat("я", reviewMaxLength+1), false},
+	} {
+		if err := checkReview(c.rating, c.body); (err == nil) != c.ok {
+			t.Errorf("rating %d, %d chars: got %v", c.rating, len([]rune(c.body)), err)
+		}
+	}
+}
+
+func TestSortToggle(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		v    url.Values
+		want string
+	}{
+		{"/user/books", url.Values{"author": {"Пушкин"}}, "/user/books?author=%D0%9F%D1%83%D1%88%D0%BA%D0%B8%D0%BD&sort=rating&page=1"},
+		{"/user/books", url.Values{"author": {"Пушкин"}, "sort": {"rating"}}, "/user/books?author=%D0%9F%D1%83%D1%88%D0%BA%D0%B8%D0%BD&page=1"},
+		{"/admin/books", url.Values{"sort": {"rating"}}, "/admin/books?page=1"},
+		{"/admin/books", url.Values{}, "/admin/books?sort=rating&page=1"},
+	} {
+		if got := sortToggle(c.path, c.v); got != c.want {
+			t.Errorf("sortToggle(%q, %v) = %q, want %q", c.path, c.v, got, c.want)
+		}
+	}
+	if v := (url.Values{"sort": {"rating"}}); sortToggle("/user/books", v) == "" || v.Get("sort") != "rating" {
+		t.Error("sortToggle changed its argument")
+	}
+}
+
+// memReviews - reviewQueue в памяти с тем же условием, что и запрос в ModerateReview
+type memReviews struct {
+	mu      sync.Mutex
+	reviews []repository.Review
+}
+
+func (m *memReviews) PendingReviews(ctx context.Context) (pending []repository.Review, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range m.reviews {
+		if v.Status == repository.REVIEW_PENDING {
+			pending = append(pending, v)
+		}
+	}
+	return
+}
+
+func (m *memReviews) ModerateReview(ctx context.Context, id int64, approve bool, seen time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, v := range m.reviews {
+		if v.Review_Id == id && v.Status == repository.REVIEW_PENDING && !v.UpdatedAt.After(seen) {
+			m.reviews[i].Status = repository.REVIEW_APPROVED
+			if !approve {
+				m.reviews[i].Status = repository.REVIEW_REJECTED
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestModerateStaleReview(t *testing.T) {
+	seen := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := &memReviews{reviews: []repository.Review{
+		// автор изменил отзыв после того, как модератор открыл очередь
+		{Review_Id: 1, Rating: 1, Body: "Изменено", Status: repository.REVIEW_PENDING, UpdatedAt: seen.Add(time.Second)},
+		{Review_Id: 2, Rating: 5, Status: repository.REVIEW_APPROVED, UpdatedAt: seen},
+	}}
+	a := app{ctx: context.Background(), reviews: store}
+
+	for _, c := range []struct {
+		id, seen string
+		status   int
+	}{
+		{"1", seen.Format(time.RFC3339Nano), http.StatusOK},
+		{"1", "", http.StatusBadRequest},
+		{"1", "вчера", http.StatusBadRequest},
+		{"2", seen.Format(time.RFC3339Nano), http.StatusOK},
+	} {
+		for _, approve := range []bool{true, false} {
+			r := httptest.NewRequest("POST", "/admin/reviews/approve/"+c.id, strings.NewReader(url.Values{"seen": {c.seen}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rw := httptest.NewRecorder()
+			a.moderateReview(approve)(rw, r, httprouter.Params{{Key: "id", Value: c.id}})
+
+			if rw.Code != c.status {
+				t.Errorf("review %s, seen %q: status %d, want %d", c.id, c.seen, rw.Code, c.status)
+			}
+			if c.status == http.StatusOK && !strings.Contains(rw.Body.String(), "Отзыв уже изменен автором или рассмотрен") {
+				t.Errorf("review %s: no message in the queue page", c.id)
+			}
+		}
+	}
+
+	if store.reviews[0].Status != repository.REVIEW_PENDING || store.reviews[1].Status != repository.REVIEW_APPROVED {
+		t.Errorf("stale decision applied: %s, %s", store.reviews[0].Status, store.reviews[1].Status)
+	}
+}
@@ -0,0 +1,217 @@
+package application
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// revisionLabels - названия полей книги в истории изменений
+var revisionLabels = map[string]string{
+	"category":     "Жанр",
+	"author":       "Автор",
+	"series":       "Серия",
+	"name":         "Наименование",
+	"annotation":   "Описание",
+	"link":         "Ссылка",
+	"access":       "Доступ",
+	"publication":  "Дата публикации",
+	"unpublish_at": "Снятие с публикации",
+}
+
+// revisionActions - подписи действий в истории
+var revisionActions = map[string]string{
+	string(repository.REVISION_INITIAL): "Исходное состояние",
+	string(repository.REVISION_CREATE):  "Создание",
+	string(repository.REVISION_UPDATE):  "Изменение",
+	string(repository.REVISION_IMPORT):  "Импорт",
+	string(repository.REVISION_REVERT):  "Возврат",
+}
+
+// recordRevision записывает в историю книгу после изменения. old - книга до изменения, nil для новой.
+// Сохранение, которое ничего не поменяло, в историю не попадает. Книга к этому моменту уже записана,
+// поэтому ошибка только пишется в журнал
+func (a app) recordRevision(r *http.Request, old *repository.Book, rev repository.Revision) {
+	rev.Book_Id = rev.Book.Book_Id
+	if u := currentUser(r); u.User_Id != uuid.Nil {
+		rev.User_Id = &u.User_Id
+	}
+	if old != nil {
+		rev.Changed = repository.BookChanges(*old, rev.Book)
+		if len(rev.Changed) == 0 {
+			return
+		}
+	} else {
+		rev.Changed = repository.BookChanges(repository.Book{}, rev.Book)
+	}
+
+	err := a.repo.AddRevision(a.ctx, old, rev)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// fieldDiff - значение поля до и после изменения
+type fieldDiff struct {
+	Field string
+	Label string
+	Old   string
+	New   string
+}
+
+// revisionRow - ревизия с изменениями относительно предыдущей
+type revisionRow struct {
+	repository.Revision
+	Label string
+	Diff  []fieldDiff
+	// Latest - ревизия совпадает с текущим состоянием книги, возвращаться к ней незачем
+	Latest bool
+}
+
+// revisionRows сопоставляет каждую ревизию с предыдущей. revisions - последние первыми
+func revisionRows(revisions []repository.Revision) []revisionRow {
+	rows := make([]revisionRow, len(revisions))
+	for i, rev := range revisions {
+		row := revisionRow{Revision: rev, Label: revisionActions[string(rev.Action)], Latest: i == 0}
+		for _, f := range rev.Changed {
+			d := fieldDiff{Field: f, Label: revisionLabels[f], New: rev.Book.FieldValue(f)}
+			if i+1 < len(revisions) {
+				d.Old = revisions[i+1].Book.FieldValue(f)
+			}
+			row.Diff = append(row.Diff, d)
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// revertBook возвращает книге поля ревизии revId и записывает возврат как новую ревизию
+func (a app) revertBook(r *http.Request, bookId string, revId int64) (book repository.Book, err error) {
+	old, err := a.repo.GetBookById(a.ctx, bookId)
+	if err != nil {
+		return
+	}
+	rev, err := a.repo.GetRevision(a.ctx, bookId, revId)
+	if err != nil {
+		return
+	}
+
+	s := rev.Book
+	err = a.repo.PutBookById(a.ctx, bookId, string(s.Category), s.Author, s.Series, s.Name, s.Annotation, s.Link, s.Access, s.Publication, s.UnpublishAt)
+	if err != nil {
+		return
+	}
+	book, err = a.repo.GetBookById(a.ctx, bookId)
+	if err != nil {
+		return
+	}
+
+	a.events.publish(string(repository.EVENT_BOOK_UPDATED), book)
+	a.visibilityChanged(old.Visible, book)
+	a.recordRevision(r, &old, repository.Revision{Action: repository.REVISION_REVERT, Source: &revId, Book: book})
+	a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_REVERT}, &old, &book))
+	return
+}
+
+// BookHistoryPage - GET /admin/books/history/:id, история изменений книги
+func (a app) BookHistoryPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lp := filepath.Join("public", "html", "book-history.html")
+
+	book, err := a.repo.GetBookById(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, "Книга не найдена", http.StatusNotFound)
+		return
+	}
+	revisions, err := a.repo.BookRevisions(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Book      repository.Book
+		Revisions []revisionRow
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "book-history", answer{book, revisionRows(revisions)})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// RevertBook - POST /admin/books/history/:id/revert/:rev
+func (a app) RevertBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	revId, err := strconv.ParseInt(p.ByName("rev"), 10, 64)
+	if err != nil {
+		http.Error(rw, "Ревизия не найдена", http.StatusNotFound)
+		return
+	}
+	_, err = a.revertBook(r, p.ByName("id"), revId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(rw, "Ревизия не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/admin/books/history/"+p.ByName("id"), http.StatusSeeOther)
+}
+
+// APIBookRevisions - GET /api/v1/books/:id/revisions, история книги, последние изменения первыми
+func (a app) APIBookRevisions(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, _, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	revisions, err := a.repo.BookRevisions(a.ctx, b.Book_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if revisions == nil {
+		revisions = []repository.Revision{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": revisions})
+}
+
+// APIRevertBook - POST /api/v1/books/:id/revisions/:rev/revert, возврат книги к ревизии
+func (a app) APIRevertBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	b, _, ok := a.findBook(rw, r, p)
+	if !ok {
+		return
+	}
+	revId, err := strconv.ParseInt(p.ByName("rev"), 10, 64)
+	if err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Ревизия не найдена")
+		return
+	}
+	b, err = a.revertBook(r, b.Book_Id.String(), revId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		apiFail(rw, http.StatusNotFound, "not_found", "Ревизия не найдена")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if _, version, err := a.repo.GetBookVersion(a.ctx, b.Book_Id.String()); err == nil {
+		rw.Header().Set("ETag", etag(version))
+	}
+	writeJSON(rw, http.StatusOK, b)
+}
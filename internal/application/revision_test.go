@@ -0,0 +1,47 @@
+package application
+
+import (
+	"testing"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+func TestBookChanges(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	old := repository.Book{Author: "Гоголь Н. В.", Name: "Нос", Publication: at}
+	b := old
+	b.Name = "Шинель"
+	b.UnpublishAt = &at
+	if got := repository.BookChanges(old, b); len(got) != 2 || got[0] != "name" || got[1] != "unpublish_at" {
+		t.Errorf("changes %v, want [name unpublish_at]", got)
+	}
+	b = old
+	b.Publication = at.In(time.UTC)
+	if got := repository.BookChanges(old, b); got != nil {
+		t.Errorf("same moment in another zone is not a change: %v", got)
+	}
+}
+
+func TestRevisionRows(t *testing.T) {
+	v1 := repository.Book{Author: "Гоголь Н. В.", Name: "Нос"}
+	v2 := v1
+	v2.Name = "Шинель"
+	rows := revisionRows([]repository.Revision{
+		{Revision_Id: 3, Action: repository.REVISION_UPDATE, Changed: []string{"name"}, Book: v2},
+		{Revision_Id: 2, Action: repository.REVISION_CREATE, Changed: []string{"author", "name"}, Book: v1},
+	})
+	if !rows[0].Latest || rows[1].Latest {
+		t.Error("only the newest revision is the current state")
+	}
+	d := rows[0].Diff
+	if len(d) != 1 || d[0].Label != "Наименование" || d[0].Old != "Нос" || d[0].New != "Шинель" {
+		t.Errorf("bad diff: %+v", d)
+	}
+	if d := rows[1].Diff; len(d) != 2 || d[0].Old != "" || d[0].New != "Гоголь Н. В." {
+		t.Errorf("created book diffs against nothing: %+v", d)
+	}
+	if rows[1].Label != "Создание" {
+		t.Errorf("label %q", rows[1].Label)
+	}
+}
@@ -0,0 +1,101 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"biblio/internal/repository"
+)
+
+const (
+	// scheduleInterval - как часто проверять даты публикации. На столько может опоздать открытие книги
+	scheduleInterval = time.Minute
+	// scheduleLayout - формат поля datetime-local в формах книги, время местное
+	scheduleLayout = "2006-01-02T15:04"
+)
+
+// errUnpublishOrder - дата снятия с публикации не позже даты публикации
+var errUnpublishOrder = errors.New("Дата снятия с публикации должна быть позже даты публикации")
+
+// publishScheduled открывает книги, у которых наступила дата публикации, и скрывает снятые с публикации.
+// Открытие книги - событие book.published, по нему читатели узнают о новинке
+func (a app) publishScheduled() {
+	published, withdrawn, err := a.repo.PublishScheduled(a.ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, b := range published {
+		a.events.publish(string(repository.EVENT_BOOK_PUBLISHED), b)
+	}
+	for _, b := range withdrawn {
+		a.events.publish(string(repository.EVENT_BOOK_UNPUBLISHED), b)
+	}
+}
+
+// visibilityChanged сообщает о публикации или снятии книги, если сохранение изменило ее видимость
+func (a app) visibilityChanged(wasVisible bool, b repository.Book) {
+	switch {
+	case b.Visible && !wasVisible:
+		a.events.publish(string(repository.EVENT_BOOK_PUBLISHED), b)
+	case !b.Visible && wasVisible:
+		a.events.publish(string(repository.EVENT_BOOK_UNPUBLISHED), b)
+	}
+}
+
+// bookTrashed сообщает об удалении книги в корзину. Если читатели ее видели, она для них еще и снята с публикации
+func (a app) bookTrashed(b repository.Book) {
+	a.events.publish(string(repository.EVENT_BOOK_DELETED), b)
+	hidden := b
+	hidden.Visible = false
+	a.visibilityChanged(b.Visible, hidden)
+}
+
+// visibleBook загружает книгу по id. Скрытую от читателей книгу видит только администратор,
+// остальным она не найдена, как и несуществующая
+func (a app) visibleBook(r *http.Request, id string) (b repository.Book, err error) {
+	b, err = a.repo.GetBookById(a.ctx, id)
+	if role, _ := r.Context().Value("role").(UserRole); err == nil && !b.Visible && role != "ADMIN" {
+		err = fmt.Errorf("book %s is not published: %w", id, pgx.ErrNoRows)
+	}
+	return
+}
+
+// readSchedule читает из формы книги даты публикации и снятия с публикации.
+// Пустая дата публикации - publication, пустая дата снятия - не снимать
+func readSchedule(r *http.Request, publication time.Time) (pub time.Time, unpublish *time.Time, err error) {
+	pub = publication
+	if v := strings.TrimSpace(r.FormValue("publication")); v != "" {
+		pub, err = time.ParseInLocation(scheduleLayout, v, time.Local)
+		if err != nil {
+			err = fmt.Errorf("Неверная дата публикации: %q", v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(r.FormValue("unpublish_at")); v != "" {
+		t, perr := time.ParseInLocation(scheduleLayout, v, time.Local)
+		if perr != nil {
+			err = fmt.Errorf("Неверная дата снятия с публикации: %q", v)
+			return
+		}
+		unpublish = &t
+	}
+	if unpublish != nil && !unpublish.After(pub) {
+		err = errUnpublishOrder
+	}
+	return
+}
+
+// scheduleValue - значение поля datetime-local формы, пустое для nil
+func scheduleValue(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.In(time.Local).Format(scheduleLayout)
+}
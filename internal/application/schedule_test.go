@@ -0,0 +1,99 @@
+package application
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+func TestReadSchedule(t *testing.T) {
+	old := time.Date(2026, 1, 5, 10, 0, 0, 0, time.Local)
+	form := func(v url.Values) (time.Time, *time.Time, error) {
+		r := httptest.NewRequest("POST", "/admin/books/new", strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return readSchedule(r, old)
+	}
+
+	pub, unpublish, err := form(url.Values{})
+	if err != nil || !pub.Equal(old) || unpublish != nil {
+		t.Errorf("empty form: %v %v %v", pub, unpublish, err)
+	}
+
+	pub, unpublish, err = form(url.Values{"publication": {"2026-11-01T09:30"}, "unpublish_at": {"2026-12-01T00:00"}})
+	if err != nil || !pub.Equal(time.Date(2026, 11, 1, 9, 30, 0, 0, time.Local)) || unpublish == nil || unpublish.Month() != 12 {
+		t.Errorf("both dates: %v %v %v", pub, unpublish, err)
+	}
+	if got := scheduleValue(&pub); got != "2026-11-01T09:30" {
+		t.Errorf("scheduleValue = %q", got)
+	}
+
+	if _, _, err = form(url.Values{"unpublish_at": {"2025-12-31T23:59"}}); err != errUnpublishOrder {
+		t.Errorf("unpublish before publication: %v", err)
+	}
+	if _, _, err = form(url.Values{"publication": {"01.11.2026"}}); err == nil {
+		t.Error("bad date accepted")
+	}
+}
+
+func TestPublishedAt(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	for _, c := range []struct {
+		b    repository.Book
+		want bool
+	}{
+		{repository.Book{Publication: earlier}, true},
+		{repository.Book{Publication: now}, true},
+		{repository.Book{Publication: later}, false},
+		{repository.Book{Publication: earlier, UnpublishAt: &later}, true},
+		{repository.Book{Publication: earlier, UnpublishAt: &now}, false},
+	} {
+		if got := c.b.PublishedAt(now); got != c.want {
+			t.Errorf("publication %v, unpublish %v: got %v", c.b.Publication, c.b.UnpublishAt, got)
+		}
+	}
+}
+
+func TestLiveVisibleHiddenBook(t *testing.T) {
+	hidden := repository.Book{Name: "Скоро"}
+	cases := []struct {
+		event string
+		book  repository.Book
+		want  bool
+	}{
+		{string(repository.EVENT_BOOK_CREATED), hidden, false},
+		{string(repository.EVENT_BOOK_UPDATED), hidden, false},
+		{string(repository.EVENT_BOOK_UNPUBLISHED), hidden, true},
+		{string(repository.EVENT_BOOK_PUBLISHED), repository.Book{Name: "Вышла", Visible: true}, true},
+	}
+	for _, c := range cases {
+		ev := liveEvent{Type: c.event, Data: c.book}
+		if got := liveVisible(ev, "USER"); got != c.want {
+			t.Errorf("%s of %+v: reader sees %v", c.event, c.book, got)
+		}
+		if !liveVisible(ev, "ADMIN") {
+			t.Errorf("%s hidden from admin", c.event)
+		}
+	}
+}
+
+func TestBookTrashedUnpublishes(t *testing.T) {
+	a := app{events: newEventBus()}
+	var heard []string
+	a.events.listen(func(ev liveEvent) {
+		b := ev.Data.(repository.Book)
+		heard = append(heard, ev.Type+" "+b.Name+" "+strconv.FormatBool(b.Visible))
+	})
+
+	a.bookTrashed(repository.Book{Name: "Вышла", Visible: true})
+	a.bookTrashed(repository.Book{Name: "Скоро"})
+	want := []string{"book.deleted Вышла true", "book.unpublished Вышла false", "book.deleted Скоро false"}
+	if strings.Join(heard, "; ") != strings.Join(want, "; ") {
+		t.Errorf("events %q, want %q", heard, want)
+	}
+}
@@ -0,0 +1,157 @@
+package application
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+// sessionTTL совпадает со сроком жизни куки token
+const sessionTTL = 60 * time.Minute
+
+type session struct {
+	User      repository.User
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+	IP        string
+	UserAgent string
+}
+
+// sessionInfo - сессия в том виде, в каком ее видит пользователь в профиле.
+// Вместо самого токена показывается его хеш, чтобы токен не попадал в разметку
+type sessionInfo struct {
+	Id      string
+	Current bool
+	session
+}
+
+// sessionStore хранит вошедших пользователей по токену из куки
+type sessionStore struct {
+	mu sync.Mutex
+	m  map[string]*session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{m: make(map[string]*session)}
+}
+
+func sessionId(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:8])
+}
+
+func (s *sessionStore) add(token string, sess session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.m[token] = &sess
+}
+
+// get возвращает живую сессию и отмечает время последнего обращения
+func (s *sessionStore) get(token string) (sess session, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.m[token]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if now.After(p.Expires) {
+		delete(s.m, token)
+		return sess, false
+	}
+	p.LastSeen = now
+	return *p, true
+}
+
+func (s *sessionStore) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.m, token)
+}
+
+// removeById завершает сессию пользователя по идентификатору из профиля
+func (s *sessionStore) removeById(userId, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, p := range s.m {
+		if p.User.User_Id.String() == userId && sessionId(token) == id {
+			delete(s.m, token)
+		}
+	}
+}
+
+// removeUser завершает все сессии пользователя, кроме except
+func (s *sessionStore) removeUser(userId, except string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, p := range s.m {
+		if p.User.User_Id.String() == userId && token != except {
+			delete(s.m, token)
+		}
+	}
+}
+
+// updateUser обновляет данные пользователя во всех его сессиях, например после смены имени
+func (s *sessionStore) updateUser(u repository.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.m {
+		if p.User.User_Id == u.User_Id {
+			p.User = u
+		}
+	}
+}
+
+func (s *sessionStore) forUser(userId, current string) (list []sessionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for token, p := range s.m {
+		if p.User.User_Id.String() == userId && now.Before(p.Expires) {
+			list = append(list, sessionInfo{Id: sessionId(token), Current: token == current, session: *p})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return
+}
+
+// startSession вызывается после успешной проверки пользователя: генерирует токен,
+// пишет его в хранилище сессий и в куки, затем отправляет на стартовую страницу роли
+func (a app) startSession(rw http.ResponseWriter, r *http.Request, user repository.User) {
+	token := randomHex(32)
+	now := time.Now()
+	expiration := now.Add(sessionTTL)
+
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_LOGIN}, user))
+	a.sessions.add(token, session{
+		User:      user,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   expiration,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+
+	//кука будет жить столько же, сколько сессия
+	cookie := http.Cookie{Name: "token", Value: url.QueryEscape(token), Expires: expiration, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	http.SetCookie(rw, &cookie)
+	if user.Role == "ADMIN" {
+		http.Redirect(rw, r, "/admin", http.StatusSeeOther)
+	} else {
+		http.Redirect(rw, r, "/user", http.StatusSeeOther)
+	}
+}
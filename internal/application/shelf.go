@@ -0,0 +1,430 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+const (
+	// shelfNameLength - предел длины названия полки в символах
+	shelfNameLength = 100
+	// shelvesLimit - сколько своих полок может завести пользователь
+	shelvesLimit = 50
+	// shelfPageSize - книг на странице полки, как в каталоге
+	shelfPageSize = 12
+)
+
+// shelfStore - полки пользователей. Встроенные полки хранилище не переименовывает и не удаляет
+type shelfStore interface {
+	Shelves(ctx context.Context, userId string) ([]repository.Shelf, error)
+	UserShelf(ctx context.Context, userId string, id int64) (repository.Shelf, error)
+	SharedShelf(ctx context.Context, token string) (repository.Shelf, error)
+	AddShelf(ctx context.Context, userId, name string) error
+	RenameShelf(ctx context.Context, userId string, id int64, name string) error
+	DeleteShelf(ctx context.Context, userId string, id int64) error
+	ShareShelf(ctx context.Context, userId string, id int64, token string) error
+	ShelfBooks(ctx context.Context, id int64, pageNumber, pageSize int) (repository.Page, int, error)
+}
+
+// shelfURL - публичная ссылка на полку
+func shelfURL(s repository.Shelf) string {
+	if !s.Shared {
+		return ""
+	}
+	return baseURL + "/shelf/" + s.Token
+}
+
+// localPath пропускает только адреса этого сайта, чтобы форма не могла увести пользователя на чужой
+func localPath(s, fallback string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return fallback
+	}
+	return s
+}
+
+func (a app) ShelvesPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "shelves.html")
+
+	shelves, err := a.shelves.Shelves(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type item struct {
+		repository.Shelf
+		URL string
+	}
+	type answer struct {
+		Shelves []item
+		Message string
+	}
+	data := answer{Message: message}
+	for _, s := range shelves {
+		data.Shelves = append(data.Shelves, item{s, shelfURL(s)})
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "shelves", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// shelfName приводит название полки к виду для хранения. Если название не подходит, message объясняет почему
+func shelfName(r *http.Request) (name, message string) {
+	name = strings.Join(strings.Fields(r.FormValue("name")), " ")
+	if name == "" {
+		return "", "Укажите название полки!"
+	}
+	if utf8.RuneCountInString(name) > shelfNameLength {
+		return "", "Название полки длиннее " + strconv.Itoa(shelfNameLength) + " символов"
+	}
+	return name, ""
+}
+
+// shelfNameTaken ищет среди полок пользователя, кроме полки except, полку с тем же названием без учета регистра
+func shelfNameTaken(shelves []repository.Shelf, name string, except int64) (repository.Shelf, bool) {
+	for _, s := range shelves {
+		if s.Shelf_Id != except && strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return repository.Shelf{}, false
+}
+
+func (a app) CreateShelf(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userId := currentUser(r).User_Id.String()
+	name, message := shelfName(r)
+	if message != "" {
+		a.ShelvesPage(rw, r, message)
+		return
+	}
+
+	shelves, err := a.shelves.Shelves(a.ctx, userId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s, ok := shelfNameTaken(shelves, name, 0); ok {
+		a.ShelvesPage(rw, r, "Полка «"+s.Name+"» уже есть")
+		return
+	}
+	custom := 0
+	for _, s := range shelves {
+		if !s.Builtin() {
+			custom++
+		}
+	}
+	if custom >= shelvesLimit {
+		a.ShelvesPage(rw, r, "Можно завести не больше "+strconv.Itoa(shelvesLimit)+" своих полок")
+		return
+	}
+
+	err = a.shelves.AddShelf(a.ctx, userId, name)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/user/shelves", http.StatusSeeOther)
+}
+
+// userShelf читает номер полки из пути и проверяет, что полка принадлежит пользователю
+func (a app) userShelf(r *http.Request, p httprouter.Params) (s repository.Shelf, err error) {
+	id, err := strconv.ParseInt(p.ByName("id"), 10, 64)
+	if err != nil {
+		return s, pgx.ErrNoRows
+	}
+	return a.shelves.UserShelf(a.ctx, currentUser(r).User_Id.String(), id)
+}
+
+// ShelfPage - книги полки с тем же листанием, что в каталоге
+func (a app) ShelfPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, err := a.userShelf(r, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		a.ShelvesPage(rw, r, "Полка не найдена")
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.shelfPage(rw, r, shelf, true)
+}
+
+// SharedShelfPage - GET /shelf/:token, полка, которой поделился владелец. Вход не нужен
+func (a app) SharedShelfPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, err := a.shelves.SharedShelf(a.ctx, p.ByName("token"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(rw, "Полка не найдена или владелец закрыл доступ к ней", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.shelfPage(rw, r, shelf, false)
+}
+
+// shelfPage показывает полку владельцу (owner) с кнопками или читателю по ссылке без них
+func (a app) shelfPage(rw http.ResponseWriter, r *http.Request, shelf repository.Shelf, owner bool) {
+	pageNumber, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page, _, err := a.shelves.ShelfBooks(a.ctx, shelf.Shelf_Id, pageNumber, shelfPageSize)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page.PageUrl = r.URL.Path + "?page="
+
+	lp := filepath.Join("public", "html", "shelf.html")
+	files := []string{lp, head, pager}
+	if owner {
+		files = append(files, headerFor(r))
+	}
+	tmpl, err := parseTemplates(r, files...)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Shelf repository.Shelf
+		Page  repository.Page
+		Owner bool
+		Admin bool
+		URL   string
+	}
+	data := answer{Shelf: shelf, Page: page, Owner: owner, URL: shelfURL(shelf)}
+	if owner {
+		data.Admin = r.Context().Value("role").(UserRole) == "ADMIN"
+	}
+
+	name := "shelf"
+	if !owner {
+		name = "shelf-public"
+	}
+	err = tmpl.ExecuteTemplate(rw, name, data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// RenameShelf - POST /user/shelves/:id/rename, новое название своей полки
+func (a app) RenameShelf(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, err := a.userShelf(r, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		a.ShelvesPage(rw, r, "Полка не найдена")
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if shelf.Builtin() {
+		a.ShelvesPage(rw, r, "Встроенную полку переименовать нельзя")
+		return
+	}
+	name, message := shelfName(r)
+	if message != "" {
+		a.ShelvesPage(rw, r, message)
+		return
+	}
+
+	userId := currentUser(r).User_Id.String()
+	shelves, err := a.shelves.Shelves(a.ctx, userId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s, ok := shelfNameTaken(shelves, name, shelf.Shelf_Id); ok {
+		a.ShelvesPage(rw, r, "Полка «"+s.Name+"» уже есть")
+		return
+	}
+
+	err = a.shelves.RenameShelf(a.ctx, userId, shelf.Shelf_Id, name)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/user/shelves", http.StatusSeeOther)
+}
+
+func (a app) DeleteShelf(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, err := a.userShelf(r, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		a.ShelvesPage(rw, r, "Полка не найдена")
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if shelf.Builtin() {
+		a.ShelvesPage(rw, r, "Встроенную полку удалить нельзя")
+		return
+	}
+	err = a.shelves.DeleteShelf(a.ctx, currentUser(r).User_Id.String(), shelf.Shelf_Id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/user/shelves", http.StatusSeeOther)
+}
+
+// shareShelf открывает полку по новой ссылке (share) или закрывает доступ. Старая ссылка перестает работать
+func (a app) shareShelf(share bool) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		shelf, err := a.userShelf(r, p)
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.ShelvesPage(rw, r, "Полка не найдена")
+			return
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token := ""
+		if share {
+			token = randomHex(16)
+		}
+		err = a.shelves.ShareShelf(a.ctx, currentUser(r).User_Id.String(), shelf.Shelf_Id, token)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(rw, r, "/user/shelves", http.StatusSeeOther)
+	}
+}
+
+// shelveBook ставит книгу на полку или снимает с нее. Номер полки - поле shelf, back - куда вернуться
+func (a app) shelveBook(add bool) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		userId := currentUser(r).User_Id.String()
+		bookId := p.ByName("id")
+		id, err := strconv.ParseInt(r.FormValue("shelf"), 10, 64)
+		if err != nil {
+			a.ShelvesPage(rw, r, "Выберите полку")
+			return
+		}
+		if _, err := uuid.Parse(bookId); err != nil {
+			a.ShelvesPage(rw, r, "Книга не найдена")
+			return
+		}
+		if add {
+			_, err = a.visibleBook(r, bookId)
+			if err != nil {
+				a.ShelvesPage(rw, r, "Книга не найдена")
+				return
+			}
+			_, err = a.repo.AddToShelf(a.ctx, userId, id, bookId)
+		} else {
+			err = a.repo.RemoveFromShelf(a.ctx, userId, id, bookId)
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(rw, r, localPath(r.FormValue("back"), bookPage(r, bookId)), http.StatusSeeOther)
+	}
+}
+
+// APIShelves - GET /api/v1/shelves, полки текущего пользователя
+func (a app) APIShelves(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelves, err := a.shelves.Shelves(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{"items": shelves})
+}
+
+// apiShelf находит полку текущего пользователя или отвечает 404
+func (a app) apiShelf(rw http.ResponseWriter, r *http.Request, p httprouter.Params) (s repository.Shelf, ok bool) {
+	s, err := a.userShelf(r, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		apiFail(rw, http.StatusNotFound, "not_found", "Полка не найдена")
+		return
+	}
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	return s, true
+}
+
+// APIShelfBooks - GET /api/v1/shelves/:id/books?page=&per_page=, последние добавленные первыми
+func (a app) APIShelfBooks(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	fields := make(map[string]string)
+	page, perPage := apiPage(r, fields)
+	if len(fields) > 0 {
+		apiInvalid(rw, fields)
+		return
+	}
+	shelf, ok := a.apiShelf(rw, r, p)
+	if !ok {
+		return
+	}
+	books, total, err := a.shelves.ShelfBooks(a.ctx, shelf.Shelf_Id, page, perPage)
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if books.Books == nil {
+		books.Books = []repository.Book{}
+	}
+	writeJSON(rw, http.StatusOK, apiList{Items: books.Books, Total: total, Page: page, PerPage: perPage})
+}
+
+// APIShelveBook - PUT /api/v1/shelves/:id/books/:book
+func (a app) APIShelveBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, ok := a.apiShelf(rw, r, p)
+	if !ok {
+		return
+	}
+	b, _, ok := a.findBook(rw, r, httprouter.Params{{Key: "id", Value: p.ByName("book")}})
+	if !ok {
+		return
+	}
+	_, err := a.repo.AddToShelf(a.ctx, currentUser(r).User_Id.String(), shelf.Shelf_Id, b.Book_Id.String())
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// APIUnshelveBook - DELETE /api/v1/shelves/:id/books/:book. Книги, которой нет на полке, удалять не ошибка
+func (a app) APIUnshelveBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	shelf, ok := a.apiShelf(rw, r, p)
+	if !ok {
+		return
+	}
+	if _, err := uuid.Parse(p.ByName("book")); err != nil {
+		apiFail(rw, http.StatusNotFound, "not_found", "Книга не найдена")
+		return
+	}
+	err := a.repo.RemoveFromShelf(a.ctx, currentUser(r).User_Id.String(), shelf.Shelf_Id, p.ByName("book"))
+	if err != nil {
+		apiFail(rw, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+func TestLocalPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"/user/books?page=2": "/user/books?page=2",
+		"/user/shelves/3":    "/user/shelves/3",
+		"":                   "/fallback",
+		"https://evil.test/": "/fallback",
+		"//evil.test/":       "/fallback",
+		"/\\evil.test/":      "/fallback",
+		"user/books":         "/fallback",
+	} {
+		if got := localPath(in, "/fallback"); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+// memShelves - shelfStore в памяти с теми же условиями, что и запросы в repository/shelf.go
+type memShelves struct {
+	mu      sync.Mutex
+	shelves []repository.Shelf
+	books   map[int64][]repository.Book
+}
+
+func (m *memShelves) find(match func(s repository.Shelf) bool) (repository.Shelf, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.shelves {
+		if match(s) {
+			return s, nil
+		}
+	}
+	return repository.Shelf{}, fmt.Errorf("failed to query data: %w", pgx.ErrNoRows)
+}
+
+// update меняет полку пользователя, если она подходит под условие запроса
+func (m *memShelves) update(userId string, id int64, custom bool, fn func(s *repository.Shelf)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.shelves {
+		if s.Shelf_Id == id && s.User_Id.String() == userId && (!custom || !s.Builtin()) {
+			fn(&m.shelves[i])
+		}
+	}
+}
+
+func (m *memShelves) Shelves(ctx context.Context, userId string) (shelves []repository.Shelf, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.shelves {
+		if s.User_Id.String() == userId {
+			shelves = append(shelves, s)
+		}
+	}
+	return
+}
+
+func (m *memShelves) UserShelf(ctx context.Context, userId string, id int64) (repository.Shelf, error) {
+	return m.find(func(s repository.Shelf) bool { return s.Shelf_Id == id && s.User_Id.String() == userId })
+}
+
+func (m *memShelves) SharedShelf(ctx context.Context, token string) (repository.Shelf, error) {
+	return m.find(func(s repository.Shelf) bool { return s.Token != "" && s.Token == token })
+}
+
+func (m *memShelves) AddShelf(ctx context.Context, userId, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.shelves {
+		if s.User_Id.String() == userId && strings.EqualFold(s.Name, name) {
+			return fmt.Errorf("failed to exec data: duplicate key value violates unique constraint \"shelves_name_idx\"")
+		}
+	}
+	m.shelves = append(m.shelves, repository.Shelf{Shelf_Id: int64(len(m.shelves) + 1), User_Id: uuid.MustParse(userId), Name: name, Kind: repository.SHELF_CUSTOM})
+	return nil
+}
+
+func (m *memShelves) RenameShelf(ctx context.Context, userId string, id int64, name string) error {
+	m.update(userId, id, true, func(s *repository.Shelf) { s.Name = name })
+	return nil
+}
+
+func (m *memShelves) DeleteShelf(ctx context.Context, userId string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.shelves {
+		if s.Shelf_Id == id && s.User_Id.String() == userId && !s.Builtin() {
+			m.shelves = append(m.shelves[:i], m.shelves[i+1:]...)
+			delete(m.books, id)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memShelves) ShareShelf(ctx context.Context, userId string, id int64, token string) error {
+	m.update(userId, id, false, func(s *repository.Shelf) { s.Token, s.Shared = token, token != "" })
+	return nil
+}
+
+func (m *memShelves) ShelfBooks(ctx context.Context, id int64, pageNumber, pageSize int) (repository.Page, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	books := m.books[id]
+	return repository.Page{Books: books, Number: 1, PageCount: 1, Str: []int{1}}, len(books), nil
+}
+
+// shelfTestApp - пользователь reader со встроенными полками 1-3 и своей полкой 4 «Дача»
+func shelfTestApp() (app, *memShelves, repository.User) {
+	user := repository.User{User_Id: uuid.New(), Username: "reader", Role: repository.USER, Active: true}
+	store := &memShelves{books: map[int64][]repository.Book{}}
+	for _, s := range []repository.Shelf{
+		{Name: "Избранное", Kind: repository.SHELF_FAVORITES},
+		{Name: "Хочу прочитать", Kind: repository.SHELF_WANT},
+		{Name: "Прочитано", Kind: repository.SHELF_READ},
+		{Name: "Дача", Kind: repository.SHELF_CUSTOM},
+	} {
+		s.Shelf_Id, s.User_Id = int64(len(store.shelves)+1), user.User_Id
+		store.shelves = append(store.shelves, s)
+	}
+	return app{ctx: context.Background(), shelves: store}, store, user
+}
+
+// postShelf отправляет форму от имени пользователя и возвращает ответ
+func postShelf(h httprouter.Handle, user repository.User, id string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/user/shelves", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := context.WithValue(r.Context(), "role", UserRole(user.Role))
+	r = r.WithContext(context.WithValue(ctx, "user", user))
+	rw := httptest.NewRecorder()
+	h(rw, r, httprouter.Params{{Key: "id", Value: id}})
+	return rw
+}
+
+func TestBuiltinShelvesImmutable(t *testing.T) {
+	a, store, user := shelfTestApp()
+
+	rw := postShelf(a.DeleteShelf, user, "1", nil)
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "Встроенную полку удалить нельзя") {
+		t.Errorf("delete built-in: status %d", rw.Code)
+	}
+	rw = postShelf(a.RenameShelf, user, "2", url.Values{"name": {"Отложено"}})
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "Встроенную полку переименовать нельзя") {
+		t.Errorf("rename built-in: status %d", rw.Code)
+	}
+	if len(store.shelves) != 4 || store.shelves[0].Name != "Избранное" || store.shelves[1].Name != "Хочу прочитать" {
+		t.Errorf("built-in shelves changed: %v", store.shelves)
+	}
+
+	if rw = postShelf(a.RenameShelf, user, "4", url.Values{"name": {"  Летняя   дача "}}); rw.Code != http.StatusSeeOther {
+		t.Errorf("rename custom: status %d", rw.Code)
+	}
+	if store.shelves[3].Name != "Летняя дача" {
+		t.Errorf("custom shelf name %q", store.shelves[3].Name)
+	}
+	if rw = postShelf(a.DeleteShelf, user, "4", nil); rw.Code != http.StatusSeeOther || len(store.shelves) != 3 {
+		t.Errorf("delete custom: status %d, %d shelves left", rw.Code, len(store.shelves))
+	}
+
+	other := repository.User{User_Id: uuid.New(), Username: "other", Role: repository.USER}
+	if rw = postShelf(a.DeleteShelf, other, "1", nil); !strings.Contains(rw.Body.String(), "Полка не найдена") {
+		t.Errorf("another user reached the shelf: status %d", rw.Code)
+	}
+}
+
+func TestShelfNamesCaseInsensitive(t *testing.T) {
+	a, store, user := shelfTestApp()
+
+	for _, name := range []string{"избранное", " ДАЧА "} {
+		rw := postShelf(a.CreateShelf, user, "", url.Values{"name": {name}})
+		if !strings.Contains(rw.Body.String(), "уже есть") {
+			t.Errorf("create %q: status %d, no duplicate message", name, rw.Code)
+		}
+	}
+	if rw := postShelf(a.RenameShelf, user, "4", url.Values{"name": {"хочу ПРОЧИТАТЬ"}}); !strings.Contains(rw.Body.String(), "Полка «Хочу прочитать» уже есть") {
+		t.Errorf("rename onto another shelf: status %d", rw.Code)
+	}
+	if len(store.shelves) != 4 || store.shelves[3].Name != "Дача" {
+		t.Fatalf("duplicate saved: %v", store.shelves)
+	}
+
+	if rw := postShelf(a.RenameShelf, user, "4", url.Values{"name": {"ДАЧА"}}); rw.Code != http.StatusSeeOther || store.shelves[3].Name != "ДАЧА" {
+		t.Errorf("changing the case of its own name: status %d, name %q", rw.Code, store.shelves[3].Name)
+	}
+	if rw := postShelf(a.CreateShelf, user, "", url.Values{"name": {"Отпуск"}}); rw.Code != http.StatusSeeOther || len(store.shelves) != 5 {
+		t.Errorf("create new shelf: status %d", rw.Code)
+	}
+}
+
+func TestSharedShelfToken(t *testing.T) {
+	a, store, user := shelfTestApp()
+	store.books[1] = []repository.Book{{Book_Id: uuid.New(), Category: repository.Categories[0], Author: "Пушкин", Name: "Дубровский"}}
+	store.books[4] = []repository.Book{{Book_Id: uuid.New(), Category: repository.Categories[0], Author: "Гоголь", Name: "Мертвые души"}}
+
+	if rw := postShelf(a.shareShelf(true), user, "4", nil); rw.Code != http.StatusSeeOther {
+		t.Fatalf("share: status %d", rw.Code)
+	}
+	token := store.shelves[3].Token
+	if token == "" || store.shelves[0].Token != "" {
+		t.Fatalf("share tokens: %q, %q", token, store.shelves[0].Token)
+	}
+
+	get := func(token string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		a.SharedShelfPage(rw, httptest.NewRequest("GET", "/shelf/"+token, nil), httprouter.Params{{Key: "token", Value: token}})
+		return rw
+	}
+	rw := get(token)
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "Мертвые души") {
+		t.Fatalf("shared shelf: status %d", rw.Code)
+	}
+	if strings.Contains(rw.Body.String(), "Дубровский") || strings.Contains(rw.Body.String(), "/user/books/unshelve") {
+		t.Error("shared page shows another shelf or owner buttons")
+	}
+	for _, bad := range []string{"", token[:len(token)-1], strings.ToUpper(token)} {
+		if rw := get(bad); rw.Code != http.StatusNotFound {
+			t.Errorf("token %q: status %d", bad, rw.Code)
+		}
+	}
+
+	postShelf(a.shareShelf(false), user, "4", nil)
+	if rw := get(token); rw.Code != http.StatusNotFound {
+		t.Errorf("closed shelf: status %d", rw.Code)
+	}
+}
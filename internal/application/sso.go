@@ -0,0 +1,249 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/oidc"
+	"biblio/internal/repository"
+)
+
+const ssoStateTTL = 10 * time.Minute
+
+type ssoRequest struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// ssoLogin - вход через OpenID Connect. Настраивается переменными окружения:
+// OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL,
+// OIDC_GROUPS_CLAIM (по умолчанию groups), OIDC_ADMIN_GROUPS и OIDC_USER_GROUPS -
+// списки групп через запятую. Если OIDC_USER_GROUPS пуст, входить могут все пользователи провайдера.
+type ssoLogin struct {
+	cfg          oidc.Config
+	label        string
+	groupsClaim  string
+	adminGroups  []string
+	userGroups   []string
+	providerName string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	requests map[string]ssoRequest
+}
+
+func ssoFromEnv() *ssoLogin {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	return &ssoLogin{
+		cfg: oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  envOr("OIDC_REDIRECT_URL", baseURL+"/login/oidc/callback"),
+		},
+		label:        envOr("OIDC_LABEL", "Войти через единый вход"),
+		groupsClaim:  envOr("OIDC_GROUPS_CLAIM", "groups"),
+		adminGroups:  splitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		userGroups:   splitList(os.Getenv("OIDC_USER_GROUPS")),
+		providerName: "oidc:" + issuer,
+		requests:     make(map[string]ssoRequest),
+	}
+}
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// getProvider обращается к провайдеру при первом входе, а не при запуске,
+// чтобы недоступность провайдера не мешала запуску библиотеки
+func (s *ssoLogin) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider == nil {
+		p, err := oidc.NewProvider(ctx, s.cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.provider = p
+	}
+	return s.provider, nil
+}
+
+func (s *ssoLogin) put(state string, req ssoRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.requests {
+		if now.After(v.expires) {
+			delete(s.requests, k)
+		}
+	}
+	s.requests[state] = req
+}
+
+func (s *ssoLogin) take(state string) (req ssoRequest, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok = s.requests[state]
+	delete(s.requests, state)
+	if ok && time.Now().After(req.expires) {
+		ok = false
+	}
+	return
+}
+
+// role сопоставляет группы из токена ролям библиотеки; пустая строка - вход запрещен
+func (s *ssoLogin) role(groups []string) string {
+	return mapRole(groups, s.adminGroups, s.userGroups)
+}
+
+func (a app) SSOStart(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if a.sso == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	provider, err := a.sso.getProvider(r.Context())
+	if err != nil {
+		log.Println(err)
+		a.LoginPage(rw, r, "Сервер единого входа недоступен, попробуйте позже")
+		return
+	}
+
+	state, nonce, verifier := oidc.NewVerifier(), oidc.NewVerifier(), oidc.NewVerifier()
+	a.sso.put(state, ssoRequest{nonce: nonce, verifier: verifier, expires: time.Now().Add(ssoStateTTL)})
+
+	// state в куке связывает ответ провайдера с браузером, который начал вход
+	http.SetCookie(rw, &http.Cookie{Name: "oidc_state", Value: state, Path: "/login/oidc", MaxAge: int(ssoStateTTL.Seconds()), HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(rw, r, provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (a app) SSOCallback(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if a.sso == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	http.SetCookie(rw, &http.Cookie{Name: "oidc_state", Path: "/login/oidc", MaxAge: -1})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		a.LoginPage(rw, r, "Вход через единый вход отменен: "+e)
+		return
+	}
+
+	state := q.Get("state")
+	cookie, err := readCookie("oidc_state", r)
+	req, ok := a.sso.take(state)
+	if err != nil || cookie != state || !ok {
+		a.LoginPage(rw, r, "Сеанс входа устарел, попробуйте еще раз")
+		return
+	}
+
+	provider, err := a.sso.getProvider(r.Context())
+	if err != nil {
+		log.Println(err)
+		a.LoginPage(rw, r, "Сервер единого входа недоступен, попробуйте позже")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), q.Get("code"), req.verifier, req.nonce)
+	if err != nil {
+		log.Println(err)
+		a.LoginPage(rw, r, "Не удалось выполнить единый вход")
+		return
+	}
+
+	user, err := a.ssoUser(claims)
+	if err != nil {
+		a.LoginPage(rw, r, err.Error())
+		return
+	}
+
+	ip := clientIP(r)
+	a.repo.AddLoginAttempt(a.ctx, user.Username, ip, true)
+	if !user.Active {
+		a.LoginPage(rw, r, "Пользователь заблокирован!")
+		return
+	}
+	if user.TOTPEnabled {
+		a.startSecondFactor(rw, r, user)
+		return
+	}
+	a.startSession(rw, r, user)
+}
+
+// ssoUser находит пользователя по subject из токена или создает его при первом входе.
+// Роль при каждом входе приводится к группам из токена
+func (a app) ssoUser(claims oidc.Claims) (user repository.User, err error) {
+	role := a.sso.role(claims.Strings(a.sso.groupsClaim))
+	if role == "" {
+		err = errors.New("Вашей учетной записи не разрешен доступ к библиотеке")
+		return
+	}
+
+	subject := claims.String("sub")
+	user, err = a.repo.GetUserByIdentity(a.ctx, a.sso.providerName, subject)
+	if err == nil {
+		if string(user.Role) != role {
+			err = a.repo.PutUserRole(a.ctx, user.User_Id.String(), role)
+			if err != nil {
+				log.Println(err)
+				err = errors.New("Не удалось обновить роль пользователя")
+				return
+			}
+			return a.repo.GetUserByIdentity(a.ctx, a.sso.providerName, subject)
+		}
+		return
+	}
+
+	username := claims.String("preferred_username")
+	if username == "" {
+		username = claims.String("email")
+	}
+	if username == "" {
+		username = subject
+	}
+	fullName := claims.String("name")
+	if fullName == "" {
+		fullName = username
+	}
+
+	// локальную учетную запись с тем же логином не присоединяем автоматически:
+	// иначе владелец учетной записи у провайдера получил бы чужой аккаунт
+	if existing, e := a.repo.GetUserByLoginOrEmail(a.ctx, username); e == nil && existing.Username == username {
+		err = fmt.Errorf("Логин %s уже занят локальной учетной записью, обратитесь к администратору", username)
+		return
+	}
+
+	user, err = a.repo.AddExternalUser(a.ctx, a.sso.providerName, subject, username, fullName, claims.String("email"), claims.Bool("email_verified"), role)
+	if err != nil {
+		log.Println(err)
+		err = errors.New("Не удалось создать пользователя")
+		return
+	}
+	a.events.publish(string(repository.EVENT_USER_CREATED), user)
+
+	return
+}
@@ -0,0 +1,59 @@
+package application
+
+import (
+	"testing"
+	"time"
+)
+
+// секрет из приложения B к RFC 6238 ("12345678901234567890") в base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// в RFC коды восьмизначные, у нас шесть последних цифр
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := totpCode(rfc6238Secret, unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("T=%d: code %s, want %s", unix, got, want)
+		}
+	}
+
+	if _, err := totpCode("не base32", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestTOTPValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	for offset, want := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, err := totpCode(rfc6238Secret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := totpValidate(rfc6238Secret, code, now)
+		if ok != want {
+			t.Errorf("step %+d: accepted %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("step %+d: got step %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	if _, ok := totpValidate(rfc6238Secret, "050 471", now); !ok {
+		t.Error("code with spaces rejected")
+	}
+	if _, ok := totpValidate(rfc6238Secret, "50471", now); ok {
+		t.Error("short code accepted")
+	}
+}
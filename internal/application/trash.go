@@ -0,0 +1,115 @@
+package application
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// trashRetention - сколько удаленные книги и пользователи лежат в корзине, прежде чем удалиться навсегда.
+// Задается переменной TRASH_RETENTION, например 720h; по умолчанию 30 дней
+var trashRetention = envDuration("TRASH_RETENTION", 30*24*time.Hour)
+
+// purgeTrash удаляет навсегда то, что пролежало в корзине дольше trashRetention
+func (a app) purgeTrash() {
+	books, users, err := a.repo.PurgeTrash(a.ctx, time.Now().Add(-trashRetention))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if books > 0 || users > 0 {
+		log.Printf("trash purged: %d books, %d users", books, users)
+	}
+}
+
+// TrashPage - GET /admin/trash, удаленные книги и пользователи
+func (a app) TrashPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lp := filepath.Join("public", "html", "trash.html")
+
+	books, err := a.repo.DeletedBooks(a.ctx)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	users, err := a.repo.DeletedUsers(a.ctx)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Books []repository.Book
+		Users []repository.User
+		// Days - через сколько дней после удаления записи удаляются навсегда
+		Days int
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "trash", answer{books, users, int(trashRetention.Hours() / 24)})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// trashDone возвращает на страницу корзины; не найденная в корзине запись - 404
+func trashDone(rw http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(rw, "В корзине такой записи нет", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// RestoreBook - POST /admin/trash/books/restore/:id. Книга с наступившей датой публикации снова видна читателям
+func (a app) RestoreBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	book, err := a.repo.RestoreBook(a.ctx, p.ByName("id"))
+	if err == nil {
+		a.visibilityChanged(false, book)
+		a.audit(r, auditBook(repository.AuditEntry{Action: repository.AUDIT_BOOK_RESTORE}, nil, &book))
+	}
+	trashDone(rw, r, err)
+}
+
+// PurgeBook - POST /admin/trash/books/purge/:id
+func (a app) PurgeBook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.PurgeBook(a.ctx, p.ByName("id"))
+	if err == nil {
+		a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_BOOK_PURGE}, "book", p.ByName("id"), "", nil, nil))
+	}
+	trashDone(rw, r, err)
+}
+
+// RestoreUser - POST /admin/trash/users/restore/:id
+func (a app) RestoreUser(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	user, err := a.repo.RestoreUser(a.ctx, p.ByName("id"))
+	if err == nil {
+		a.audit(r, auditUser(repository.AuditEntry{Action: repository.AUDIT_USER_RESTORE}, nil, &user))
+	}
+	trashDone(rw, r, err)
+}
+
+// PurgeUser - POST /admin/trash/users/purge/:id
+func (a app) PurgeUser(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	err := a.repo.PurgeUser(a.ctx, p.ByName("id"))
+	if err == nil {
+		a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_USER_PURGE}, "user", p.ByName("id"), "", nil, nil))
+	}
+	trashDone(rw, r, err)
+}
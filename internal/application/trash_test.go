@@ -0,0 +1,31 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestTrashDone(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusSeeOther},
+		{fmt.Errorf("book 42 is not in trash: %w", pgx.ErrNoRows), http.StatusNotFound},
+		{errors.New("failed to exec data"), http.StatusBadRequest},
+	} {
+		rw := httptest.NewRecorder()
+		trashDone(rw, httptest.NewRequest("POST", "/admin/trash/books/purge/42", nil), c.err)
+		if rw.Code != c.want {
+			t.Errorf("%v: status %d, want %d", c.err, rw.Code, c.want)
+		}
+		if c.err == nil && rw.Header().Get("Location") != "/admin/trash" {
+			t.Errorf("redirect to %q", rw.Header().Get("Location"))
+		}
+	}
+}
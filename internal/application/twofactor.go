@@ -0,0 +1,375 @@
+package application
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"biblio/internal/repository"
+)
+
+const (
+	// столько времени дается на ввод второго фактора после верного пароля
+	secondFactorTTL      = 5 * time.Minute
+	secondFactorAttempts = 5
+	recoveryCodesCount   = 10
+)
+
+type pendingLogin struct {
+	user     repository.User
+	expires  time.Time
+	attempts int
+}
+
+// pendingLogins хранит пользователей, которые ввели пароль, но еще не ввели код
+type pendingLogins struct {
+	mu sync.Mutex
+	m  map[string]*pendingLogin
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{m: make(map[string]*pendingLogin)}
+}
+
+func (p *pendingLogins) add(token string, user repository.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.m[token] = &pendingLogin{user: user, expires: time.Now().Add(secondFactorTTL)}
+}
+
+func (p *pendingLogins) get(token string) (user repository.User, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pl, ok := p.m[token]
+	if !ok || time.Now().After(pl.expires) {
+		delete(p.m, token)
+		return user, false
+	}
+	return pl.user, true
+}
+
+// fail учитывает неверный код; после нескольких ошибок пароль придется вводить заново
+func (p *pendingLogins) fail(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pl, ok := p.m[token]; ok {
+		pl.attempts++
+		if pl.attempts >= secondFactorAttempts {
+			delete(p.m, token)
+		}
+	}
+}
+
+func (p *pendingLogins) remove(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.m, token)
+}
+
+// startSecondFactor вызывается из Login вместо startSession, если у пользователя включена 2FA
+func (a app) startSecondFactor(rw http.ResponseWriter, r *http.Request, user repository.User) {
+	token := randomHex(32)
+	a.pending.add(token, user)
+
+	cookie := http.Cookie{Name: "mfa", Value: url.QueryEscape(token), Expires: time.Now().Add(secondFactorTTL), Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	http.SetCookie(rw, &cookie)
+	http.Redirect(rw, r, "/login/totp", http.StatusSeeOther)
+}
+
+func (a app) SecondFactorPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "login-totp.html")
+
+	tmpl, err := parseTemplates(r, lp, head)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Message string
+	}
+	data := answer{message}
+
+	err = tmpl.ExecuteTemplate(rw, "login-totp", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) SecondFactor(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	token, err := readCookie("mfa", r)
+	if err != nil {
+		http.Redirect(rw, r, "/", http.StatusSeeOther)
+		return
+	}
+	user, ok := a.pending.get(token)
+	if !ok {
+		a.LoginPage(rw, r, "Время на ввод кода истекло, войдите заново")
+		return
+	}
+
+	// неверные коды считаются неудачами учетной записи, как неверные пароли: после lockoutAfter
+	// вход блокируется, в том числе для уже начатых входов
+	ip := clientIP(r)
+	if wait := a.guard.wait(user.Username, ip); wait > 0 {
+		a.loginFailed(user.Username, ip, "locked")
+		a.SecondFactorPage(rw, r, fmt.Sprintf("Слишком много неудачных попыток входа. Повторите через %v", wait.Round(time.Second)))
+		return
+	}
+	if !a.checkSecondFactor(user.User_Id.String(), r.FormValue("code")) {
+		a.pending.fail(token)
+		a.guard.fail(user.Username, ip)
+		a.loginFailed(user.Username, ip, "totp")
+		a.SecondFactorPage(rw, r, "Неверный код!")
+		return
+	}
+
+	a.loginSucceeded(user.Username, ip)
+	a.pending.remove(token)
+	http.SetCookie(rw, &http.Cookie{Name: "mfa", Path: "/", MaxAge: -1})
+	a.startSession(rw, r, user)
+}
+
+// secondFactorStore проверяет второй фактор. UseTOTPStep принимает только шаг новее последнего
+// использованного, UseRecoveryCode - только еще не использованный код
+type secondFactorStore interface {
+	GetUserById(ctx context.Context, id string) (repository.User, error)
+	UseTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, id, hash string) (bool, error)
+}
+
+// checkSecondFactor принимает код из приложения или неиспользованный резервный код
+func (a app) checkSecondFactor(userId, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	if strings.Contains(code, "-") {
+		ok, err := a.secondFactors.UseRecoveryCode(a.ctx, userId, recoveryCodeHash(code))
+		if err != nil {
+			log.Println(err)
+		}
+		return ok
+	}
+
+	user, err := a.secondFactors.GetUserById(a.ctx, userId)
+	if err != nil || !user.TOTPEnabled {
+		return false
+	}
+	step, ok := totpValidate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	// код из того же 30-секундного окна второй раз не принимается
+	ok, err = a.secondFactors.UseTOTPStep(a.ctx, userId, step)
+	if err != nil {
+		log.Println(err)
+	}
+	return ok
+}
+
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodesCount; i++ {
+		c := randomHex(5)
+		c = c[:5] + "-" + c[5:]
+		codes = append(codes, c)
+		hashes = append(hashes, recoveryCodeHash(c))
+	}
+	return
+}
+
+func recoveryCodeHash(code string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(hash[:])
+}
+
+// TwoFactorPage показывает QR-код для подключения приложения или состояние уже включенной 2FA.
+// codes передаются только сразу после их генерации - второй раз их увидеть нельзя
+func (a app) TwoFactorPage(rw http.ResponseWriter, r *http.Request, message string, codes []string) {
+	lp := filepath.Join("public", "html", "twofactor.html")
+
+	user, err := a.repo.GetUserById(a.ctx, currentUser(r).User_Id.String())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		User          repository.User
+		Required      bool
+		QR            template.URL
+		Secret        string
+		RecoveryLeft  int
+		RecoveryCodes []string
+		Message       string
+	}
+	data := answer{
+		User:          user,
+		Required:      user.Role == repository.ADMIN && a.require2FA.Load(),
+		RecoveryCodes: codes,
+		Message:       message,
+	}
+
+	if user.TOTPEnabled {
+		data.RecoveryLeft, err = a.repo.CountRecoveryCodes(a.ctx, user.User_Id.String())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		// секрет сохраняется сразу, но включается только после ввода первого верного кода
+		if user.TOTPSecret == "" {
+			user.TOTPSecret = newTOTPSecret()
+			err = a.repo.PutTOTP(a.ctx, user.User_Id.String(), user.TOTPSecret, false)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		png, err := qrcode.Encode(totpURL(user.TOTPSecret, user.Username), qrcode.Medium, 256)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.QR = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		data.Secret = user.TOTPSecret
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headerFor(r))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "twofactor", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (a app) EnableTwoFactor(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sessionUser := currentUser(r)
+	userId := sessionUser.User_Id.String()
+
+	user, err := a.repo.GetUserById(a.ctx, userId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		http.Redirect(rw, r, "/user/profile/2fa", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := totpValidate(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if !ok {
+		a.TwoFactorPage(rw, r, "Неверный код, проверьте время на устройстве и попробуйте еще", nil)
+		return
+	}
+
+	err = a.repo.PutTOTP(a.ctx, userId, user.TOTPSecret, true)
+	if err == nil {
+		_, err = a.repo.UseTOTPStep(a.ctx, userId, step)
+	}
+	codes, hashes := newRecoveryCodes()
+	if err == nil {
+		err = a.repo.PutRecoveryCodes(a.ctx, userId, hashes)
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionUser.TOTPEnabled = true
+	a.sessions.updateUser(sessionUser)
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_2FA_ENABLE}, sessionUser))
+	a.TwoFactorPage(rw, r, "Двухфакторная аутентификация включена. Сохраните резервные коды", codes)
+}
+
+func (a app) DisableTwoFactor(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sessionUser := currentUser(r)
+	userId := sessionUser.User_Id.String()
+
+	if sessionUser.Role == repository.ADMIN && a.require2FA.Load() {
+		a.TwoFactorPage(rw, r, "Для администраторов двухфакторная аутентификация обязательна", nil)
+		return
+	}
+	if message := a.checkPassword(r, r.FormValue("current"), "Неверный пароль или код!"); message != "" {
+		a.TwoFactorPage(rw, r, message, nil)
+		return
+	}
+	if !a.checkSecondFactor(userId, r.FormValue("code")) {
+		a.guard.fail(sessionUser.Username, clientIP(r))
+		a.TwoFactorPage(rw, r, "Неверный пароль или код!", nil)
+		return
+	}
+
+	err := a.repo.PutTOTP(a.ctx, userId, "", false)
+	if err == nil {
+		err = a.repo.PutRecoveryCodes(a.ctx, userId, nil)
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionUser.TOTPEnabled = false
+	a.sessions.updateUser(sessionUser)
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_2FA_DISABLE}, sessionUser))
+	a.TwoFactorPage(rw, r, "Двухфакторная аутентификация отключена", nil)
+}
+
+func (a app) RegenerateRecoveryCodes(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userId := currentUser(r).User_Id.String()
+
+	if message := a.checkPassword(r, r.FormValue("current"), "Текущий пароль указан неверно!"); message != "" {
+		a.TwoFactorPage(rw, r, message, nil)
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err := a.repo.PutRecoveryCodes(a.ctx, userId, hashes)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditSelf(repository.AuditEntry{Action: repository.AUDIT_2FA_RECOVERY}, currentUser(r)))
+
+	a.TwoFactorPage(rw, r, "Новые резервные коды созданы, старые больше не действуют", codes)
+}
+
+// PutRequire2FA включает или отключает обязательную 2FA для роли ADMIN
+func (a app) PutRequire2FA(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	value := "false"
+	if r.FormValue("require") != "" {
+		value = "true"
+	}
+
+	err := a.repo.PutSetting(a.ctx, repository.REQUIRE_ADMIN_2FA, value)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_SETTINGS_UPDATE}, "setting", string(repository.REQUIRE_ADMIN_2FA), "",
+		map[string]bool{"require_admin_2fa": a.require2FA.Load()}, map[string]bool{"require_admin_2fa": value == "true"}))
+
+	a.require2FA.Store(value == "true")
+	http.Redirect(rw, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,135 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+func TestRequire2FAExemptsOnlyTwoFactorPages(t *testing.T) {
+	a := &app{ctx: context.Background(), sessions: newSessionStore(), require2FA: new(atomic.Bool)}
+	a.require2FA.Store(true)
+	a.sessions.add("admin-session", session{User: repository.User{User_Id: uuid.New(), Username: "admin", Role: repository.ADMIN, Active: true},
+		Expires: time.Now().Add(time.Hour)})
+
+	h := a.authorized(func(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	for path, want := range map[string]int{
+		"/user/profile/2fa":        http.StatusNoContent,
+		"/user/profile/2fa/enable": http.StatusNoContent,
+		"/user/profile":            http.StatusSeeOther,
+		"/user/profile/tokens":     http.StatusSeeOther,
+		"/admin/users":             http.StatusSeeOther,
+	} {
+		r := httptest.NewRequest("POST", path, nil)
+		r.AddCookie(&http.Cookie{Name: "token", Value: "admin-session"})
+		rw := httptest.NewRecorder()
+		h(rw, r, nil)
+		if rw.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rw.Code, want)
+		}
+	}
+}
+
+// memSecondFactors - secondFactorStore в памяти с теми же условиями, что и запросы в repository/twofactor.go
+type memSecondFactors struct {
+	mu       sync.Mutex
+	user     repository.User
+	lastStep int64
+	// codes - хеш резервного кода и признак того, что он уже использован
+	codes map[string]bool
+}
+
+func (m *memSecondFactors) GetUserById(ctx context.Context, id string) (repository.User, error) {
+	if id != m.user.User_Id.String() {
+		return repository.User{}, errors.New("failed to query data: no rows in result set")
+	}
+	return m.user, nil
+}
+
+func (m *memSecondFactors) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id != m.user.User_Id.String() || m.lastStep >= step {
+		return false, nil
+	}
+	m.lastStep = step
+	return true, nil
+}
+
+func (m *memSecondFactors) UseRecoveryCode(ctx context.Context, id, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.codes[hash]
+	if id != m.user.User_Id.String() || !ok || used {
+		return false, nil
+	}
+	m.codes[hash] = true
+	return true, nil
+}
+
+func secondFactorApp(codes []string) (app, string) {
+	store := &memSecondFactors{
+		user:  repository.User{User_Id: uuid.New(), Username: "reader", TOTPSecret: rfc6238Secret, TOTPEnabled: true},
+		codes: map[string]bool{},
+	}
+	for _, c := range codes {
+		store.codes[recoveryCodeHash(c)] = false
+	}
+	return app{ctx: context.Background(), secondFactors: store}, store.user.User_Id.String()
+}
+
+func TestTOTPReplayRejected(t *testing.T) {
+	a, id := secondFactorApp(nil)
+	code, err := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.checkSecondFactor(id, code) {
+		t.Fatal("fresh code rejected")
+	}
+	if a.checkSecondFactor(id, code) {
+		t.Error("code from the same step accepted twice")
+	}
+
+	previous, err := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.checkSecondFactor(id, previous) {
+		t.Error("code from an older step accepted after a newer one")
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	codes, _ := newRecoveryCodes()
+	a, id := secondFactorApp(codes)
+
+	if !a.checkSecondFactor(id, " "+strings.ToUpper(codes[0])+" ") {
+		t.Fatal("recovery code rejected")
+	}
+	if a.checkSecondFactor(id, codes[0]) {
+		t.Error("recovery code accepted twice")
+	}
+	if !a.checkSecondFactor(id, codes[1]) {
+		t.Error("other recovery code rejected after the first was used")
+	}
+	if a.checkSecondFactor(uuid.NewString(), codes[2]) {
+		t.Error("recovery code accepted for another user")
+	}
+}
@@ -0,0 +1,412 @@
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"biblio/internal/repository"
+)
+
+// Вебхуки отправляют события каталога и пользователей во внешние системы. Настройки:
+// WEBHOOK_TIMEOUT - сколько ждать ответа (по умолчанию 10s), WEBHOOK_MAX_ATTEMPTS - число попыток
+// до отказа (по умолчанию 10), WEBHOOK_POLL_INTERVAL - как часто проверять очередь (по умолчанию 15s)
+var (
+	webhookTimeout      = envDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	webhookMaxAttempts  = envInt("WEBHOOK_MAX_ATTEMPTS", 10)
+	webhookPollInterval = envDuration("WEBHOOK_POLL_INTERVAL", 15*time.Second)
+)
+
+const (
+	// webhookBatch - сколько доставок отправитель берет из очереди за раз
+	webhookBatch = 20
+	// webhookRetention - сколько хранится журнал завершенных доставок
+	webhookRetention = 30 * 24 * time.Hour
+	// webhookResponseLimit - сколько байт ответа получателя сохраняется в журнале
+	webhookResponseLimit = 1024
+	// webhookLogSize - сколько последних доставок показывает журнал
+	webhookLogSize = 100
+)
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s: %q", name, v)
+		return def
+	}
+	return d
+}
+
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	// переадресация считается ошибкой: получатель должен принимать события по настроенному адресу
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookQueue ставит события в очередь доставки и будит отправителя
+type webhookQueue struct {
+	repo *repository.Repository
+	wake chan struct{}
+}
+
+func newWebhookQueue(repo *repository.Repository) *webhookQueue {
+	return &webhookQueue{repo: repo, wake: make(chan struct{}, 1)}
+}
+
+// webhookEvent - тело запроса к получателю
+type webhookEvent struct {
+	Id        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func newWebhookPayload(event string, data interface{}) ([]byte, error) {
+	payload, err := json.Marshal(webhookEvent{Id: uuid.NewString(), Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	return payload, nil
+}
+
+// emit ставит событие в очередь подписанным вебхукам. Ошибка только пишется в журнал:
+// из-за недоступной очереди действие пользователя не должно отменяться
+func (q *webhookQueue) emit(ctx context.Context, event string, data interface{}) {
+	if q == nil {
+		return
+	}
+	payload, err := newWebhookPayload(event, data)
+	if err == nil {
+		var n int64
+		n, err = q.repo.EnqueueEvent(ctx, event, payload)
+		if n > 0 {
+			q.notify()
+		}
+	}
+	if err != nil {
+		log.Printf("webhook %s: %v", event, err)
+	}
+}
+
+// listener - слушатель шины событий, который ставит в очередь события, доступные для подписки
+func (q *webhookQueue) listener(ctx context.Context) func(ev liveEvent) {
+	return func(ev liveEvent) {
+		for _, e := range repository.Events {
+			if string(e) == ev.Type {
+				q.emit(ctx, ev.Type, ev.Data)
+				return
+			}
+		}
+	}
+}
+
+func (q *webhookQueue) notify() {
+	if q == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signWebhook подписывает тело вместе со временем отправки, чтобы получатель мог отбросить повтор
+// старого запроса: заголовок X-Biblio-Signature имеет вид t=<unix-время>,v1=<hex HMAC-SHA256("t.тело")>
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff - пауза перед следующей попыткой: 30 секунд, удваиваясь, но не больше 6 часов
+func webhookBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+// sendWebhook отправляет одну доставку и возвращает код ответа и начало тела ответа или текст ошибки
+func sendWebhook(ctx context.Context, d repository.WebhookDelivery) (code int, response string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err.Error(), err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "biblio-webhooks")
+	req.Header.Set("X-Biblio-Event", d.Event)
+	req.Header.Set("X-Biblio-Delivery", strconv.FormatInt(d.Delivery_Id, 10))
+	req.Header.Set("X-Biblio-Signature", signWebhook(d.Secret, time.Now(), []byte(d.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err.Error(), err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	for !utf8.Valid(body) && len(body) > 0 {
+		body = body[:len(body)-1]
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("webhook %d: unexpected status %s", d.Webhook_Id, resp.Status)
+	}
+	return resp.StatusCode, string(body), err
+}
+
+// deliverWebhooks отправляет все доставки, время которых пришло. Неудачная попытка откладывается
+// с растущей паузой, после webhookMaxAttempts попыток доставка помечается неудавшейся
+func (a app) deliverWebhooks() {
+	for a.ctx.Err() == nil {
+		batch, err := a.repo.ClaimDeliveries(a.ctx, webhookBatch, 2*webhookTimeout)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		for _, d := range batch {
+			code, response, err := sendWebhook(a.ctx, d)
+			d.ResponseCode, d.Response = code, response
+			switch {
+			case err == nil:
+				d.Status = repository.DELIVERY_DELIVERED
+			case d.Attempts >= webhookMaxAttempts:
+				d.Status = repository.DELIVERY_FAILED
+			default:
+				d.Status = repository.DELIVERY_PENDING
+				d.NextAttemptAt = time.Now().Add(webhookBackoff(d.Attempts))
+			}
+			if err := a.repo.FinishDelivery(a.ctx, d); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if len(batch) < webhookBatch {
+			return
+		}
+	}
+}
+
+// runWebhooks проверяет очередь по таймеру и сразу после новых событий
+func (a app) runWebhooks() {
+	t := time.NewTicker(webhookPollInterval)
+	defer t.Stop()
+
+	for {
+		a.deliverWebhooks()
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-t.C:
+		case <-a.hooks.wake:
+		}
+	}
+}
+
+func (a app) pruneWebhooks() {
+	if err := a.repo.PruneDeliveries(a.ctx, time.Now().Add(-webhookRetention)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (a app) WebhooksPage(rw http.ResponseWriter, r *http.Request, message string) {
+	lp := filepath.Join("public", "html", "webhooks.html")
+
+	hooks, err := a.repo.Webhooks(a.ctx)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Webhooks []repository.Webhook
+		Events   []string
+		Message  string
+	}
+	data := answer{Webhooks: hooks, Message: message}
+	for _, e := range repository.Events {
+		data.Events = append(data.Events, string(e))
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "webhooks", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// CreateWebhook добавляет подписку. Если секрет не указан, он генерируется и показывается в списке
+func (a app) CreateWebhook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	target := strings.TrimSpace(r.FormValue("url"))
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		a.WebhooksPage(rw, r, "Укажите адрес получателя, начинающийся с http:// или https://")
+		return
+	}
+
+	r.ParseForm()
+	var events []string
+	for _, e := range repository.Events {
+		for _, v := range r.Form["events"] {
+			if v == string(e) {
+				events = append(events, v)
+			}
+		}
+	}
+	if len(events) == 0 {
+		a.WebhooksPage(rw, r, "Выберите хотя бы одно событие!")
+		return
+	}
+
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		secret = randomHex(32)
+	}
+
+	err = a.repo.AddWebhook(a.ctx, target, secret, events)
+	if err != nil {
+		a.WebhooksPage(rw, r, fmt.Sprintf("Ошибка создания вебхука: %v", err))
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_WEBHOOK_CREATE}, "webhook", "", target, nil,
+		map[string]interface{}{"url": target, "events": events}))
+	http.Redirect(rw, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+func (a app) ToggleWebhook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	old, err := a.repo.GetWebhook(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = a.repo.SetWebhookActive(a.ctx, p.ByName("id"), r.FormValue("active") != "")
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook := old
+	hook.Active = r.FormValue("active") != ""
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_WEBHOOK_TOGGLE}, "webhook", p.ByName("id"), old.URL, old, hook))
+	http.Redirect(rw, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+func (a app) DeleteWebhook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	old, err := a.repo.GetWebhook(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = a.repo.DeleteWebhook(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_WEBHOOK_DELETE}, "webhook", p.ByName("id"), old.URL, old, nil))
+	http.Redirect(rw, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// PingWebhook отправляет получателю проверочное событие ping и открывает журнал
+func (a app) PingWebhook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	payload, err := newWebhookPayload(string(repository.EVENT_PING), map[string]string{"webhook_id": id})
+	if err == nil {
+		err = a.repo.EnqueueDelivery(a.ctx, id, string(repository.EVENT_PING), payload)
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.hooks.notify()
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_WEBHOOK_PING}, "webhook", id, "", nil, nil))
+	http.Redirect(rw, r, "/admin/webhooks/log/"+id, http.StatusSeeOther)
+}
+
+// WebhookLogPage - последние доставки вебхука с кодами ответа
+func (a app) WebhookLogPage(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	lp := filepath.Join("public", "html", "webhook-log.html")
+
+	hook, err := a.repo.GetWebhook(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	deliveries, err := a.repo.WebhookDeliveries(a.ctx, p.ByName("id"), webhookLogSize)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := parseTemplates(r, lp, head, headera)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type answer struct {
+		Webhook    repository.Webhook
+		Deliveries []repository.WebhookDelivery
+	}
+
+	err = tmpl.ExecuteTemplate(rw, "webhook-log", answer{hook, deliveries})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// RedeliverWebhook ставит доставку в очередь заново, например после исправления получателя
+func (a app) RedeliverWebhook(rw http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	webhookId, err := a.repo.RedeliverWebhook(a.ctx, p.ByName("id"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.hooks.notify()
+	a.audit(r, auditTarget(repository.AuditEntry{Action: repository.AUDIT_WEBHOOK_RESEND}, "delivery", p.ByName("id"), "",
+		nil, map[string]int64{"webhook_id": webhookId}))
+	http.Redirect(rw, r, "/admin/webhooks/log/"+strconv.FormatInt(webhookId, 10), http.StatusSeeOther)
+}
+
+// publishUserBlocked сообщает о блокировке пользователя, передавая его в том виде, в каком он сохранен
+func (a app) publishUserBlocked(id string) {
+	user, err := a.repo.GetUserById(a.ctx, id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	a.events.publish(string(repository.EVENT_USER_BLOCKED), user)
+}
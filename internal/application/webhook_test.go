@@ -0,0 +1,100 @@
+package application
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"biblio/internal/repository"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		50: 6 * time.Hour,
+	}
+	for attempts, want := range cases {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// verifySignature проверяет подпись так, как это должен делать получатель
+func verifySignature(secret, header string, body []byte) bool {
+	ts, sig, ok := strings.Cut(header, ",")
+	if !ok || !strings.HasPrefix(ts, "t=") || !strings.HasPrefix(sig, "v1=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.TrimPrefix(ts, "t=") + "."))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(strings.TrimPrefix(sig, "v1=")))
+}
+
+func TestSendWebhook(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/ok":
+			rw.Write([]byte("принято"))
+		case "/moved":
+			http.Redirect(rw, r, "/ok", http.StatusFound)
+		default:
+			http.Error(rw, "сломано", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	payload, err := newWebhookPayload(string(repository.EVENT_BOOK_CREATED), map[string]string{"name": "Война и мир"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := repository.WebhookDelivery{Delivery_Id: 7, Webhook_Id: 1, Event: string(repository.EVENT_BOOK_CREATED),
+		Payload: string(payload), URL: srv.URL + "/ok", Secret: "s3cret"}
+
+	code, response, err := sendWebhook(context.Background(), d)
+	if err != nil || code != http.StatusOK || response != "принято" {
+		t.Fatalf("sendWebhook = %d, %q, %v", code, response, err)
+	}
+	if string(body) != d.Payload {
+		t.Errorf("body = %s, want %s", body, d.Payload)
+	}
+	if got.Header.Get("X-Biblio-Event") != "book.created" || got.Header.Get("X-Biblio-Delivery") != "7" {
+		t.Errorf("headers = %v", got.Header)
+	}
+	if sig := got.Header.Get("X-Biblio-Signature"); !verifySignature("s3cret", sig, body) {
+		t.Errorf("signature %q does not verify", sig)
+	}
+	if verifySignature("other", got.Header.Get("X-Biblio-Signature"), body) {
+		t.Error("signature verifies with a wrong secret")
+	}
+
+	for path, want := range map[string]int{"/fail": http.StatusInternalServerError, "/moved": http.StatusFound} {
+		d.URL = srv.URL + path
+		code, _, err = sendWebhook(context.Background(), d)
+		if err == nil || code != want {
+			t.Errorf("%s: sendWebhook = %d, %v; want %d and an error", path, code, err, want)
+		}
+	}
+
+	d.URL = "http://127.0.0.1:1/closed"
+	if code, response, err = sendWebhook(context.Background(), d); err == nil || code != 0 || response == "" {
+		t.Errorf("closed port: sendWebhook = %d, %q, %v", code, response, err)
+	}
+}
@@ -0,0 +1,404 @@
+// Package citation описывает книги каталога для списков литературы: BibTeX, RIS, CSL-JSON и
+// библиографическая ссылка по ГОСТ Р 7.0.100-2018 на электронный ресурс. Каталог хранит автора
+// одной строкой, поэтому имена разбираются эвристически, см. Authors
+package citation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"biblio/internal/repository"
+)
+
+type Format struct {
+	Name        string
+	Title       string
+	ContentType string
+	Ext         string
+}
+
+var Formats = []Format{
+	{"bibtex", "BibTeX", "application/x-bibtex; charset=utf-8", ".bib"},
+	{"ris", "RIS", "application/x-research-info-systems; charset=utf-8", ".ris"},
+	{"csl", "CSL-JSON", "application/vnd.citationstyles.csl+json; charset=utf-8", ".json"},
+	{"gost", "ГОСТ Р 7.0.100-2018", "text/plain; charset=utf-8", ".txt"},
+}
+
+// Lookup находит формат по имени
+func Lookup(name string) (f Format, ok bool) {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return
+}
+
+// Options - сведения, которых нет в книге
+type Options struct {
+	// Library - название электронной библиотеки в ссылке ГОСТ и поле publisher
+	Library string
+	// URL возвращает адрес страницы книги
+	URL func(b repository.Book) string
+	// Accessed - дата обращения
+	Accessed time.Time
+}
+
+func (o Options) url(b repository.Book) string {
+	if o.URL == nil {
+		return ""
+	}
+	return o.URL(b)
+}
+
+// noSeries - значение серии у книг вне серий
+const noSeries = "-"
+
+// Person - автор: фамилия и имена (или инициалы) в том порядке, в каком записаны
+type Person struct {
+	Family string
+	Given  string
+}
+
+// Initials - "А. С." из "Александр Сергеевич" или "А.С."
+func (p Person) Initials() string {
+	var parts []string
+	for _, w := range strings.FieldsFunc(p.Given, func(r rune) bool { return r == ' ' || r == '.' }) {
+		if r, _ := utf8.DecodeRuneInString(w); r != utf8.RuneError {
+			// двойные имена через дефис сокращаются по обеим частям
+			if i := strings.IndexRune(w, '-'); i > 0 && i+1 < len(w) {
+				second, _ := utf8.DecodeRuneInString(w[i+1:])
+				parts = append(parts, string(r)+".-"+string(second)+".")
+				continue
+			}
+			parts = append(parts, string(r)+".")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Inverted - "Пушкин, А. С." для заголовка записи
+func (p Person) Inverted() string {
+	if in := p.Initials(); in != "" {
+		return p.Family + ", " + in
+	}
+	return p.Family
+}
+
+// Direct - "А. С. Пушкин" для сведений об ответственности
+func (p Person) Direct() string {
+	if in := p.Initials(); in != "" {
+		return in + " " + p.Family
+	}
+	return p.Family
+}
+
+// Authors разбирает поле автора книги. Авторы разделяются точкой с запятой, " & ", " и " или
+// запятыми; "Фамилия, Имя" с одним словом до запятой считается одним автором. В имени фамилия -
+// слово без точки рядом с инициалами ("Пушкин А. С.", "А. С. Пушкин"), иначе последнее слово
+// ("Аркадий Стругацкий")
+func Authors(s string) (people []Person) {
+	s = strings.NewReplacer(" & ", ";", " и ", ";").Replace(s)
+	for _, chunk := range strings.Split(s, ";") {
+		parts := strings.Split(chunk, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		if len(parts) == 2 && len(strings.Fields(parts[0])) == 1 && parts[1] != "" {
+			people = append(people, Person{Family: parts[0], Given: parts[1]})
+			continue
+		}
+		for _, p := range parts {
+			if p != "" {
+				people = append(people, parseName(p))
+			}
+		}
+	}
+	return
+}
+
+func parseName(s string) Person {
+	words := strings.Fields(s)
+	if len(words) == 1 {
+		return Person{Family: words[0]}
+	}
+	var family, given []string
+	initials := false
+	for _, w := range words {
+		if strings.HasSuffix(w, ".") {
+			initials = true
+			given = append(given, w)
+		} else {
+			family = append(family, w)
+		}
+	}
+	if initials && len(family) > 0 {
+		return Person{Family: strings.Join(family, " "), Given: strings.Join(given, " ")}
+	}
+	return Person{Family: words[len(words)-1], Given: strings.Join(words[:len(words)-1], " ")}
+}
+
+// gostDash - разделитель областей описания
+const gostDash = " – "
+
+// GOST - библиографическая ссылка на книгу как на электронный ресурс удаленного доступа:
+// Фамилия, И. О. Заглавие / И. О. Фамилия. – Год. – (Серия). – Текст : электронный // Библиотека : [сайт]. –
+// URL: адрес (дата обращения: ДД.ММ.ГГГГ). Заголовок с автором ставится при одном-трех авторах,
+// при четырех и более в сведениях об ответственности первый автор и [и др.]
+func GOST(b repository.Book, o Options) string {
+	people := Authors(b.Author)
+	var sb strings.Builder
+	if len(people) > 0 && len(people) <= 3 {
+		sb.WriteString(ending(people[0].Inverted()) + " ")
+	}
+	sb.WriteString(strings.TrimSpace(b.Name))
+
+	var resp []string
+	for i, p := range people {
+		if len(people) > 3 && i > 0 {
+			resp = append(resp, "[и др.]")
+			break
+		}
+		resp = append(resp, p.Direct())
+	}
+	if len(resp) > 0 {
+		sb.WriteString(" / " + strings.Replace(strings.Join(resp, ", "), ", [и др.]", " [и др.]", 1))
+	}
+
+	if !b.Publication.IsZero() {
+		sb.WriteString("." + gostDash + strconv.Itoa(b.Publication.Year()))
+	}
+	if b.Series != "" && b.Series != noSeries {
+		sb.WriteString("." + gostDash + "(" + b.Series + ")")
+	}
+	sb.WriteString("." + gostDash + "Текст : электронный")
+	if o.Library != "" {
+		sb.WriteString(" // " + o.Library + " : [сайт]")
+	}
+	if u := o.url(b); u != "" {
+		sb.WriteString("." + gostDash + "URL: " + u)
+		if !o.Accessed.IsZero() {
+			sb.WriteString(" (дата обращения: " + o.Accessed.Format("02.01.2006") + ")")
+		}
+	}
+	return sb.String() + "."
+}
+
+// ending убирает точку в конце, если за ней в описании последует своя
+func ending(s string) string {
+	return strings.TrimSuffix(s, ".") + "."
+}
+
+// Write записывает книги в формате name. Для BibTeX ключи записей уникальны в пределах файла
+func Write(w io.Writer, name string, books []repository.Book, o Options) error {
+	switch name {
+	case "bibtex":
+		return writeBibTeX(w, books, o)
+	case "ris":
+		return writeRIS(w, books, o)
+	case "csl":
+		return writeCSL(w, books, o)
+	case "gost":
+		for i, b := range books {
+			prefix := ""
+			if len(books) > 1 {
+				prefix = strconv.Itoa(i+1) + ". "
+			}
+			if _, err := fmt.Fprintln(w, prefix+GOST(b, o)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown citation format %q", name)
+}
+
+func writeBibTeX(w io.Writer, books []repository.Book, o Options) error {
+	keys := make(map[string]int)
+	for _, b := range books {
+		people := Authors(b.Author)
+		key := "book"
+		if len(people) > 0 {
+			key = Translit(people[0].Family)
+		}
+		if !b.Publication.IsZero() {
+			key += strconv.Itoa(b.Publication.Year())
+		}
+		keys[key]++
+		if n := keys[key]; n > 1 {
+			key += string(rune('a' + n - 2))
+		}
+
+		var authors []string
+		for _, p := range people {
+			if p.Given != "" {
+				authors = append(authors, bibEscape(p.Family)+", "+bibEscape(p.Given))
+			} else {
+				authors = append(authors, "{"+bibEscape(p.Family)+"}")
+			}
+		}
+
+		fields := [][2]string{
+			{"author", strings.Join(authors, " and ")},
+			{"title", "{" + bibEscape(b.Name) + "}"},
+		}
+		if b.Series != "" && b.Series != noSeries {
+			fields = append(fields, [2]string{"series", bibEscape(b.Series)})
+		}
+		if !b.Publication.IsZero() {
+			fields = append(fields, [2]string{"year", strconv.Itoa(b.Publication.Year())})
+		}
+		if o.Library != "" {
+			fields = append(fields, [2]string{"publisher", bibEscape(o.Library)})
+		}
+		if u := o.url(b); u != "" {
+			fields = append(fields, [2]string{"url", u})
+			if !o.Accessed.IsZero() {
+				fields = append(fields, [2]string{"urldate", o.Accessed.Format("2006-01-02")})
+			}
+		}
+		fields = append(fields, [2]string{"keywords", bibEscape(string(b.Category))}, [2]string{"language", "russian"})
+
+		var sb strings.Builder
+		sb.WriteString("@book{" + key + ",\n")
+		for i, f := range fields {
+			if f[1] == "" {
+				continue
+			}
+			sb.WriteString("  " + f[0] + " = {" + f[1] + "}")
+			if i < len(fields)-1 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("}\n\n")
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bibEscape экранирует символы, особые для TeX
+func bibEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+		"~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+	).Replace(strings.Join(strings.Fields(s), " "))
+}
+
+func writeRIS(w io.Writer, books []repository.Book, o Options) error {
+	for _, b := range books {
+		var sb strings.Builder
+		tag := func(t, v string) {
+			if v = strings.Join(strings.Fields(v), " "); v != "" {
+				sb.WriteString(t + "  - " + v + "\r\n")
+			}
+		}
+		tag("TY", "EBOOK")
+		for _, p := range Authors(b.Author) {
+			if p.Given != "" {
+				tag("AU", p.Family+", "+p.Given)
+			} else {
+				tag("AU", p.Family)
+			}
+		}
+		tag("TI", b.Name)
+		if b.Series != noSeries {
+			tag("T3", b.Series)
+		}
+		if !b.Publication.IsZero() {
+			tag("PY", strconv.Itoa(b.Publication.Year()))
+		}
+		tag("PB", o.Library)
+		tag("AB", b.Annotation)
+		tag("KW", string(b.Category))
+		tag("UR", o.url(b))
+		if !o.Accessed.IsZero() && o.url(b) != "" {
+			tag("Y2", o.Accessed.Format("2006/01/02"))
+		}
+		tag("LA", "ru")
+		sb.WriteString("ER  - \r\n\r\n")
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+type cslName struct {
+	Family string `json:"family"`
+	Given  string `json:"given,omitempty"`
+}
+
+type cslItem struct {
+	Id              string    `json:"id"`
+	Type            string    `json:"type"`
+	Title           string    `json:"title"`
+	Author          []cslName `json:"author,omitempty"`
+	CollectionTitle string    `json:"collection-title,omitempty"`
+	Issued          *cslDate  `json:"issued,omitempty"`
+	Publisher       string    `json:"publisher,omitempty"`
+	Abstract        string    `json:"abstract,omitempty"`
+	Genre           string    `json:"genre,omitempty"`
+	URL             string    `json:"URL,omitempty"`
+	Accessed        *cslDate  `json:"accessed,omitempty"`
+	Language        string    `json:"language"`
+}
+
+func writeCSL(w io.Writer, books []repository.Book, o Options) error {
+	items := make([]cslItem, 0, len(books))
+	for _, b := range books {
+		it := cslItem{
+			Id: b.Book_Id.String(), Type: "book", Title: b.Name, Publisher: o.Library, Abstract: b.Annotation,
+			Genre: string(b.Category), URL: o.url(b), Language: "ru",
+		}
+		for _, p := range Authors(b.Author) {
+			it.Author = append(it.Author, cslName{p.Family, p.Given})
+		}
+		if b.Series != noSeries {
+			it.CollectionTitle = b.Series
+		}
+		if !b.Publication.IsZero() {
+			it.Issued = &cslDate{[][]int{{b.Publication.Year()}}}
+		}
+		if a := o.Accessed; !a.IsZero() && it.URL != "" {
+			it.Accessed = &cslDate{[][]int{{a.Year(), int(a.Month()), a.Day()}}}
+		}
+		items = append(items, it)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+// translit - транслитерация для ключей BibTeX по упрощенной таблице ГОСТ 7.79-2000 (схема Б)
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "j", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "x", 'ц': "cz", 'ч': "ch", 'ш': "sh", 'щ': "shh", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// Translit возвращает строчные латинские буквы и цифры для ключа записи
+func Translit(s string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if t, ok := translit[r]; ok {
+			sb.WriteString(t)
+		} else if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,142 @@
+package citation
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/repository"
+)
+
+var testOptions = Options{
+	Library:  "Изба-читальня",
+	URL:      func(b repository.Book) string { return "https://biblio.example/books/" + b.Book_Id.String() },
+	Accessed: time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC),
+}
+
+var testBook = repository.Book{
+	Book_Id: uuid.MustParse("6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01"), Category: "Классика", Author: "Пушкин А. С.",
+	Series: "-", Name: "Капитанская дочка", Annotation: "Повесть", Link: "books/daughter.pdf",
+	Access: "Да", Publication: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestAuthors(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []Person
+	}{
+		{"Пушкин А. С.", []Person{{"Пушкин", "А. С."}}},
+		{"А.С. Пушкин", []Person{{"Пушкин", "А.С."}}},
+		{"Лев Николаевич Толстой", []Person{{"Толстой", "Лев Николаевич"}}},
+		{"Толстой, Лев Николаевич", []Person{{"Толстой", "Лев Николаевич"}}},
+		{"Аркадий Стругацкий и Борис Стругацкий", []Person{{"Стругацкий", "Аркадий"}, {"Стругацкий", "Борис"}}},
+		{"Ильф И.; Петров Е.", []Person{{"Ильф", "И."}, {"Петров", "Е."}}},
+		{"Гомер", []Person{{"Гомер", ""}}},
+		{"", nil},
+	} {
+		got := Authors(c.in)
+		if len(got) != len(c.want) {
+			t.Errorf("%q: got %v, want %v", c.in, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%q: got %v, want %v", c.in, got, c.want)
+			}
+		}
+	}
+}
+
+func TestGOST(t *testing.T) {
+	want := "Пушкин, А. С. Капитанская дочка / А. С. Пушкин. – 2024. – Текст : электронный // Изба-читальня : [сайт]. – " +
+		"URL: https://biblio.example/books/6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01 (дата обращения: 05.10.2026)."
+	if got := GOST(testBook, testOptions); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	b := testBook
+	b.Author, b.Series = "Иванов И. И.; Петров П. П.; Сидоров С. С.; Козлов К. К.", "Мир Полудня"
+	got := GOST(b, Options{})
+	want = "Капитанская дочка / И. И. Иванов [и др.]. – 2024. – (Мир Полудня). – Текст : электронный."
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBibTeX(t *testing.T) {
+	b := testBook
+	b.Name = "50% & {скобки}"
+	var buf bytes.Buffer
+	if err := Write(&buf, "bibtex", []repository.Book{testBook, b}, testOptions); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"@book{pushkin2024,", "@book{pushkin2024a,", `title = {{50\% \& \{скобки\}}}`,
+		"author = {Пушкин, А. С.}", "urldate = {2026-10-05}"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("missing %q in\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "series") {
+		t.Errorf("series of a book outside series:\n%s", out)
+	}
+}
+
+func TestRIS(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "ris", []repository.Book{testBook}, testOptions); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "TY  - EBOOK\r\n") || !strings.Contains(out, "ER  - \r\n") {
+		t.Errorf("bad record:\n%s", out)
+	}
+	if !strings.Contains(out, "AU  - Пушкин, А. С.\r\n") || !strings.Contains(out, "PY  - 2024\r\n") {
+		t.Errorf("missing fields:\n%s", out)
+	}
+}
+
+func TestCSL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "csl", []repository.Book{testBook}, testOptions); err != nil {
+		t.Fatal(err)
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0]["type"] != "book" || items[0]["title"] != "Капитанская дочка" {
+		t.Fatalf("bad item: %v", items)
+	}
+	authors, _ := items[0]["author"].([]interface{})
+	if len(authors) != 1 || authors[0].(map[string]interface{})["family"] != "Пушкин" {
+		t.Errorf("bad authors: %v", items[0]["author"])
+	}
+	if _, ok := items[0]["collection-title"]; ok {
+		t.Error("series of a book outside series")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, f := range Formats {
+		if _, ok := Lookup(f.Name); !ok {
+			t.Errorf("%s: lookup failed", f.Name)
+		}
+		if err := Write(&bytes.Buffer{}, f.Name, []repository.Book{testBook}, testOptions); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	if err := Write(&bytes.Buffer{}, "pdf", nil, testOptions); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestTranslit(t *testing.T) {
+	if got := Translit("Щедрин-Салтыков"); got != "shhedrinsaltykov" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,210 @@
+// Package export выгружает книги каталога в форматах обмена с другими библиотеками: MARC21 (ISO 2709
+// и MARCXML), Dublin Core, CSV и JSON Lines. Книги пишутся по одной, поэтому выгрузка любого размера
+// не держит каталог в памяти
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"biblio/internal/marc"
+	"biblio/internal/repository"
+)
+
+// Writer пишет книги в выбранном формате. Close дописывает окончание документа и сбрасывает буфер,
+// без него выгрузка неполна
+type Writer interface {
+	Write(b repository.Book) error
+	Close() error
+}
+
+type Format struct {
+	Name        string
+	Title       string
+	ContentType string
+	Ext         string
+}
+
+var Formats = []Format{
+	{"marc21", "MARC21 (ISO 2709)", "application/marc", ".mrc"},
+	{"marcxml", "MARCXML", "application/marcxml+xml", ".xml"},
+	{"dc", "Dublin Core XML", "application/xml", ".xml"},
+	{"csv", "CSV", "text/csv; charset=utf-8", ".csv"},
+	{"jsonl", "JSON Lines", "application/x-ndjson", ".jsonl"},
+}
+
+// Lookup находит формат по имени
+func Lookup(name string) (f Format, ok bool) {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return
+}
+
+// NewWriter возвращает Writer формата name поверх w
+func NewWriter(name string, w io.Writer) (Writer, error) {
+	switch name {
+	case "marc21":
+		return marcWriter{marc.NewWriter(w)}, nil
+	case "marcxml":
+		return marcWriter{marc.NewXMLWriter(w)}, nil
+	case "dc":
+		return &dcWriter{w: bufio.NewWriter(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case "jsonl":
+		bw := bufio.NewWriter(w)
+		return jsonlWriter{bw, json.NewEncoder(bw)}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", name)
+}
+
+// noSeries - значение серии у книг вне серий
+const noSeries = "-"
+
+// Access в книге - "Да" или "Нет"
+const openAccess = "Да"
+
+// annotationLimit оставляет в поле 520 место для индикаторов и разделителей до предела длины поля MARC
+const annotationLimit = marc.MaxFieldLength - 16
+
+// Record описывает книгу записью MARC21:
+// 001 - идентификатор книги, 005 и 008 - дата внесения в каталог, 100 $a - автор, 245 $a - название,
+// 490 $a - серия, 506 - доступ к файлу (первый индикатор 0 - открыт, 1 - ограничен), 520 $a - аннотация,
+// 655 $a - жанр. Путь к файлу в запись не попадает
+func Record(b repository.Book) *marc.Record {
+	r := marc.NewRecord()
+	r.AddControl("001", b.Book_Id.String())
+	pub := b.Publication.UTC()
+	r.AddControl("005", pub.Format("20060102150405")+".0")
+	// 008: дата внесения, дата издания неизвестна, остальные позиции не заполняются, язык русский
+	r.AddControl("008", pub.Format("060102")+"nuuuuuuuuxx |||||||||||||||||rus d")
+
+	r.AddData("100", '1', ' ', marc.Subfield{Code: 'a', Value: b.Author})
+	r.AddData("245", '1', '0', marc.Subfield{Code: 'a', Value: b.Name})
+	if b.Series != noSeries {
+		r.AddData("490", '0', ' ', marc.Subfield{Code: 'a', Value: b.Series})
+	}
+	if b.Access == openAccess {
+		r.AddData("506", '0', ' ', marc.Subfield{Code: 'a', Value: "Открытый доступ"})
+	} else {
+		r.AddData("506", '1', ' ', marc.Subfield{Code: 'a', Value: "Доступ ограничен"})
+	}
+	r.AddData("520", ' ', ' ', marc.Subfield{Code: 'a', Value: marc.Truncate(b.Annotation, annotationLimit)})
+	r.AddData("655", ' ', '4', marc.Subfield{Code: 'a', Value: string(b.Category)})
+	return r
+}
+
+type marcRecordWriter interface {
+	Write(r *marc.Record) error
+	Close() error
+}
+
+type marcWriter struct {
+	w marcRecordWriter
+}
+
+func (w marcWriter) Write(b repository.Book) error {
+	return w.w.Write(Record(b))
+}
+
+func (w marcWriter) Close() error {
+	return w.w.Close()
+}
+
+// dcWriter пишет записи oai_dc, как их отдают репозитории по протоколу OAI-PMH
+type dcWriter struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func (w *dcWriter) start() {
+	if !w.started {
+		w.started = true
+		w.w.WriteString(xml.Header + `<records xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	}
+}
+
+func (w *dcWriter) element(name, value string) {
+	if value == "" {
+		return
+	}
+	w.w.WriteString("    <dc:" + name + ">")
+	xml.EscapeText(w.w, []byte(value))
+	w.w.WriteString("</dc:" + name + ">\n")
+}
+
+func (w *dcWriter) Write(b repository.Book) error {
+	w.start()
+	w.w.WriteString("  <oai_dc:dc>\n")
+	w.element("identifier", "urn:uuid:"+b.Book_Id.String())
+	w.element("title", b.Name)
+	w.element("creator", b.Author)
+	w.element("subject", string(b.Category))
+	w.element("description", b.Annotation)
+	if b.Series != noSeries {
+		w.element("relation", b.Series)
+	}
+	w.element("date", b.Publication.Format("2006-01-02"))
+	w.element("type", "Text")
+	if b.Access == openAccess {
+		w.element("rights", "Открытый доступ")
+	} else {
+		w.element("rights", "Доступ ограничен")
+	}
+	_, err := w.w.WriteString("  </oai_dc:dc>\n")
+	return err
+}
+
+func (w *dcWriter) Close() error {
+	w.start()
+	w.w.WriteString("</records>\n")
+	return w.w.Flush()
+}
+
+// CSVHeader - колонки выгрузки CSV
+var CSVHeader = []string{"book_id", "category", "author", "series", "name", "annotation", "link", "access", "publication"}
+
+type csvWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func (w *csvWriter) Write(b repository.Book) error {
+	if !w.started {
+		w.started = true
+		w.w.Write(CSVHeader)
+	}
+	return w.w.Write([]string{b.Book_Id.String(), string(b.Category), b.Author, b.Series, b.Name, b.Annotation, b.Link, b.Access,
+		b.Publication.Format(time.RFC3339)})
+}
+
+func (w *csvWriter) Close() error {
+	if !w.started {
+		w.started = true
+		w.w.Write(CSVHeader)
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// jsonlWriter пишет каждую книгу строкой JSON в том же виде, что и API
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w jsonlWriter) Write(b repository.Book) error {
+	return w.enc.Encode(b)
+}
+
+func (w jsonlWriter) Close() error {
+	return w.w.Flush()
+}
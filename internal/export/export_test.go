@@ -0,0 +1,173 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/repository"
+)
+
+var testBooks = []repository.Book{
+	{
+		Book_Id: uuid.MustParse("6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01"), Category: "Классика", Author: "Пушкин А. С.",
+		Series: "-", Name: "Капитанская дочка", Annotation: "Повесть, \"в кавычках\", & <с тегами>", Link: "books/daughter.pdf",
+		Access: "Да", Publication: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		Book_Id: uuid.MustParse("6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a02"), Category: "Фантастика", Author: "Стругацкие",
+		Series: "Мир Полудня", Name: "Трудно быть богом", Annotation: strings.Repeat("аннотация ", 2000), Link: "books/god.pdf",
+		Access: "Нет", Publication: time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+func write(t *testing.T, format string, books []repository.Book) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range books {
+		if err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFormats(t *testing.T) {
+	for _, f := range Formats {
+		if _, ok := Lookup(f.Name); !ok {
+			t.Errorf("%s: lookup failed", f.Name)
+		}
+		if _, err := NewWriter(f.Name, &bytes.Buffer{}); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	if _, ok := Lookup("pdf"); ok {
+		t.Error("unknown format found")
+	}
+	if _, err := NewWriter("pdf", &bytes.Buffer{}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	r := Record(testBooks[0])
+	if f, _ := r.Field("001"); f.Value != testBooks[0].Book_Id.String() {
+		t.Errorf("001 = %q", f.Value)
+	}
+	if f, _ := r.Field("008"); len(f.Value) != 40 || !strings.HasPrefix(f.Value, "240301") {
+		t.Errorf("008 = %q", f.Value)
+	}
+	if f, _ := r.Field("245"); f.Get('a') != "Капитанская дочка" {
+		t.Errorf("245 = %+v", f)
+	}
+	if _, ok := r.Field("490"); ok {
+		t.Error("490 for a book without series")
+	}
+	if f, _ := r.Field("506"); f.Ind1 != '0' {
+		t.Errorf("506 = %+v", f)
+	}
+	for _, f := range r.Fields {
+		for _, s := range f.Subfields {
+			if strings.Contains(s.Value, "books/") {
+				t.Errorf("%s contains file path", f.Tag)
+			}
+		}
+	}
+
+	r = Record(testBooks[1])
+	if f, _ := r.Field("490"); f.Get('a') != "Мир Полудня" {
+		t.Errorf("490 = %+v", f)
+	}
+	if f, _ := r.Field("506"); f.Ind1 != '1' {
+		t.Errorf("506 = %+v", f)
+	}
+	// длинная аннотация обрезается, и запись остается в пределах ISO 2709
+	if _, err := r.Encode(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMARC21(t *testing.T) {
+	data := write(t, "marc21", testBooks)
+	if n := bytes.Count(data, []byte{0x1d}); n != 2 {
+		t.Errorf("%d records", n)
+	}
+	if len(write(t, "marc21", nil)) != 0 {
+		t.Error("empty export is not empty")
+	}
+}
+
+func TestDublinCore(t *testing.T) {
+	for _, books := range [][]repository.Book{testBooks, nil} {
+		var doc struct {
+			Records []struct {
+				Identifier string   `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+				Title      string   `xml:"http://purl.org/dc/elements/1.1/ title"`
+				Relation   []string `xml:"http://purl.org/dc/elements/1.1/ relation"`
+				Rights     string   `xml:"http://purl.org/dc/elements/1.1/ rights"`
+			} `xml:"http://www.openarchives.org/OAI/2.0/oai_dc/ dc"`
+		}
+		data := write(t, "dc", books)
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("%v\n%s", err, data)
+		}
+		if len(doc.Records) != len(books) {
+			t.Fatalf("%d records", len(doc.Records))
+		}
+		if len(books) == 0 {
+			continue
+		}
+		r := doc.Records[0]
+		if r.Identifier != "urn:uuid:"+books[0].Book_Id.String() || r.Title != books[0].Name || len(r.Relation) != 0 || r.Rights != "Открытый доступ" {
+			t.Errorf("record %+v", r)
+		}
+		if doc.Records[1].Relation[0] != "Мир Полудня" {
+			t.Errorf("record %+v", doc.Records[1])
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(write(t, "csv", testBooks))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(CSVHeader, ",") {
+		t.Fatalf("rows %q", rows)
+	}
+	if rows[1][5] != testBooks[0].Annotation || rows[1][8] != "2024-03-01T12:00:00Z" {
+		t.Errorf("row %q", rows[1])
+	}
+
+	rows, err = csv.NewReader(bytes.NewReader(write(t, "csv", nil))).ReadAll()
+	if err != nil || len(rows) != 1 {
+		t.Errorf("empty export: %q %v", rows, err)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(write(t, "jsonl", testBooks))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines", len(lines))
+	}
+	var b repository.Book
+	if err := json.Unmarshal([]byte(lines[1]), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Book_Id != testBooks[1].Book_Id || b.Series != "Мир Полудня" {
+		t.Errorf("book %+v", b)
+	}
+}
@@ -0,0 +1,197 @@
+// Package feed пишет ленты новостей RSS 2.0 и Atom 1.0 для обычных программ чтения лент.
+// Каталог для читалок книг (OPDS) собирается отдельно, в пакете opds
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type Format struct {
+	Name        string
+	ContentType string
+}
+
+var Formats = []Format{
+	{"rss", "application/rss+xml"},
+	{"atom", "application/atom+xml"},
+}
+
+// Lookup находит формат по имени
+func Lookup(name string) (Format, bool) {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// Channel - лента целиком. Link - страница сайта, Self - адрес самой ленты
+type Channel struct {
+	ID          string
+	Title       string
+	Description string
+	Link        string
+	Self        string
+	Updated     time.Time
+}
+
+type Item struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Category  string
+	Summary   string
+	Published time.Time
+}
+
+// Write пишет ленту в формате name: rss или atom
+func Write(w io.Writer, name string, ch Channel, items []Item) error {
+	var doc interface{}
+	switch name {
+	case "rss":
+		doc = rss(ch, items)
+	case "atom":
+		doc = atom(ch, items)
+	default:
+		return fmt.Errorf("unknown feed format %q", name)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+type rssLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description,omitempty"`
+	Author      string  `xml:"dc:creator,omitempty"`
+	Category    string  `xml:"category,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssDoc struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	XmlnsAtom string   `xml:"xmlns:atom,attr"`
+	XmlnsDC   string   `xml:"xmlns:dc,attr"`
+	Channel   struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		Self          rssLink   `xml:"atom:link"`
+		Language      string    `xml:"language"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// rss - лента RSS 2.0. Автор книги - dc:creator: в поле author RSS ждет адрес почты
+func rss(ch Channel, items []Item) *rssDoc {
+	d := &rssDoc{Version: "2.0", XmlnsAtom: "http://www.w3.org/2005/Atom", XmlnsDC: "http://purl.org/dc/elements/1.1/"}
+	c := &d.Channel
+	c.Title, c.Link, c.Description, c.Language = ch.Title, ch.Link, ch.Description, "ru"
+	c.Self = rssLink{Href: ch.Self, Rel: "self", Type: "application/rss+xml"}
+	c.LastBuildDate = ch.Updated.Format(time.RFC1123Z)
+	for _, it := range items {
+		c.Items = append(c.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			Description: it.Summary,
+			Author:      it.Author,
+			Category:    it.Category,
+			GUID:        rssGUID{Value: it.ID},
+			PubDate:     it.Published.Format(time.RFC1123Z),
+		})
+	}
+	return d
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Updated   string        `xml:"updated"`
+	Published string        `xml:"published"`
+	Author    *atomPerson   `xml:"author,omitempty"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Summary   string        `xml:"summary,omitempty"`
+	Link      atomLink      `xml:"link"`
+}
+
+type atomDoc struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// atom - лента Atom 1.0. Автор ленты обязателен, если его нет у каждой записи, поэтому он указан всегда
+func atom(ch Channel, items []Item) *atomDoc {
+	d := &atomDoc{
+		ID:       ch.ID,
+		Title:    ch.Title,
+		Subtitle: ch.Description,
+		Updated:  ch.Updated.UTC().Format(time.RFC3339),
+		Author:   atomPerson{Name: ch.Title},
+		Links: []atomLink{
+			{Href: ch.Self, Rel: "self", Type: "application/atom+xml"},
+			{Href: ch.Link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, it := range items {
+		e := atomEntry{
+			ID:        it.ID,
+			Title:     it.Title,
+			Updated:   it.Published.UTC().Format(time.RFC3339),
+			Published: it.Published.UTC().Format(time.RFC3339),
+			Summary:   it.Summary,
+			Link:      atomLink{Href: it.Link, Rel: "alternate", Type: "text/html"},
+		}
+		if it.Author != "" {
+			e.Author = &atomPerson{Name: it.Author}
+		}
+		if it.Category != "" {
+			e.Category = &atomCategory{Term: it.Category}
+		}
+		d.Entries = append(d.Entries, e)
+	}
+	return d
+}
@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testChannel = Channel{
+	ID:          "urn:biblio:feed:new",
+	Title:       "Новинки",
+	Description: "Книги, добавленные последними",
+	Link:        "https://biblio.example/user/new",
+	Self:        "https://biblio.example/feed/new.rss",
+	Updated:     time.Date(2026, 10, 5, 12, 0, 0, 0, time.UTC),
+}
+
+var testItems = []Item{{
+	ID:        "urn:uuid:6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01",
+	Title:     "Капитанская дочка & другие",
+	Link:      "https://biblio.example/user/books/open/6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01",
+	Author:    "Пушкин А. С.",
+	Category:  "Классика",
+	Summary:   "Повесть",
+	Published: time.Date(2026, 10, 4, 9, 30, 0, 0, time.UTC),
+}}
+
+func TestRSS(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "rss", testChannel, testItems); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Items   []struct {
+			Title   string `xml:"title"`
+			GUID    string `xml:"guid"`
+			PubDate string `xml:"pubDate"`
+			Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if doc.Version != "2.0" || len(doc.Items) != 1 {
+		t.Fatalf("bad feed:\n%s", buf.String())
+	}
+	it := doc.Items[0]
+	if it.Title != testItems[0].Title || it.GUID != testItems[0].ID || it.Creator != "Пушкин А. С." {
+		t.Errorf("bad item: %+v", it)
+	}
+	if it.PubDate != "Sun, 04 Oct 2026 09:30:00 +0000" {
+		t.Errorf("pubDate %q", it.PubDate)
+	}
+	if !strings.Contains(buf.String(), `rel="self"`) {
+		t.Errorf("no self link:\n%s", buf.String())
+	}
+}
+
+func TestAtom(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "atom", testChannel, testItems); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name
+		ID      string `xml:"id"`
+		Entries []struct {
+			ID       string `xml:"id"`
+			Updated  string `xml:"updated"`
+			Author   string `xml:"author>name"`
+			Category struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.ID != testChannel.ID || len(doc.Entries) != 1 {
+		t.Fatalf("bad feed:\n%s", buf.String())
+	}
+	e := doc.Entries[0]
+	if e.Updated != "2026-10-04T09:30:00Z" || e.Author != "Пушкин А. С." || e.Category.Term != "Классика" {
+		t.Errorf("bad entry: %+v", e)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, f := range Formats {
+		if _, ok := Lookup(f.Name); !ok {
+			t.Errorf("%s: lookup failed", f.Name)
+		}
+	}
+	if err := Write(&bytes.Buffer{}, "json", testChannel, nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
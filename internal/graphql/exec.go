@@ -0,0 +1,802 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Request - тело запроса GraphQL по HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error - ошибка в ответе GraphQL. Резолвер может вернуть *Error, чтобы передать Extensions клиенту
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response - ответ на запрос. Если запрос не прошел разбор или проверку, data в ответе нет
+type Response struct {
+	Data     interface{}
+	Errors   []*Error
+	executed bool
+}
+
+// Executed сообщает, дошел ли запрос до выполнения; иначе это ошибка самого запроса (HTTP 400)
+func (r *Response) Executed() bool {
+	return r.executed
+}
+
+func (r *Response) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	if len(r.Errors) > 0 {
+		errs, err := json.Marshal(r.Errors)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(`"errors":`)
+		b.Write(errs)
+	}
+	if r.executed {
+		data, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Errors) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`"data":`)
+		b.Write(data)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// orderedMap сохраняет порядок полей, в котором они перечислены в запросе
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(k string, v interface{}) {
+	if _, ok := m.values[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.values[k] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// enumLiteral - значение перечисления, записанное в запросе без кавычек
+type enumLiteral string
+
+// Exec разбирает, проверяет и выполняет запрос. Ошибки разбора, проверки и превышения лимитов
+// возвращаются без data, ошибки резолверов - рядом с частично заполненной data
+func (s *Schema) Exec(ctx context.Context, req Request) *Response {
+	fail := func(errs ...*Error) *Response {
+		return &Response{Errors: errs}
+	}
+
+	doc, err := parse(req.Query)
+	if err != nil {
+		return fail(asError(err))
+	}
+
+	var op *operation
+	for _, o := range doc.operations {
+		if req.OperationName == "" && len(doc.operations) > 1 {
+			return fail(&Error{Message: "Must provide operation name if query contains multiple operations."})
+		}
+		if req.OperationName == "" || o.name == req.OperationName {
+			op = o
+		}
+	}
+	if op == nil {
+		return fail(&Error{Message: fmt.Sprintf("Unknown operation named %q.", req.OperationName)})
+	}
+	if op.kind != "query" {
+		return fail(&Error{Message: fmt.Sprintf("Schema is not configured for %ss.", op.kind), Locations: []Location{location(req.Query, op.pos)}})
+	}
+
+	c := &checker{s: s, doc: doc, src: req.Query, vars: make(map[string]interface{}), varDefs: make(map[string]*varDef),
+		used: make(map[string]bool), visiting: make(map[string]bool), seen: make(map[string]bool)}
+	c.variables(op, req.Variables)
+	if len(c.errs) > 0 {
+		return fail(c.errs...)
+	}
+	c.directives(op.directives, "QUERY")
+	complexity, depth := c.selections(s.Query, op.selections, true)
+	for name, f := range doc.fragments {
+		if !c.used["fragment "+name] {
+			c.errorf(f.pos, "Fragment %q is never used.", name)
+		}
+	}
+	for _, v := range op.vars {
+		if !c.used["$"+v.name] {
+			c.errorf(v.pos, "Variable \"$%s\" is never used in operation %q.", v.name, op.name)
+		}
+	}
+	if len(c.errs) > 0 {
+		return fail(c.errs...)
+	}
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return fail(&Error{Message: fmt.Sprintf("Query depth %d exceeds the maximum allowed depth of %d.", depth, s.MaxDepth),
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_DEEP"}})
+	}
+	if s.MaxComplexity > 0 && complexity > s.MaxComplexity {
+		return fail(&Error{Message: fmt.Sprintf("Query complexity %d exceeds the maximum allowed complexity of %d.", complexity, s.MaxComplexity),
+			Extensions: map[string]interface{}{"code": "QUERY_TOO_COMPLEX"}})
+	}
+
+	e := &executor{s: s, doc: doc, src: req.Query, vars: c.vars, ctx: ctx}
+	data, failed := e.selectionSet(s.Query, nil, op.selections, nil)
+	resp := &Response{Errors: e.errs, executed: true}
+	if !failed {
+		resp.Data = data
+	}
+	return resp
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
+
+// поля интроспекции корневого типа
+var (
+	schemaField = &Field{Name: "__schema", Type: NonNullOf(schemaType)}
+	typeField   = &Field{Name: "__type", Type: typeType, Args: []*Arg{{Name: "name", Type: NonNullOf(String)}}}
+	nameField   = &Field{Name: "__typename", Type: NonNullOf(String)}
+)
+
+func (s *Schema) fieldDef(t *Object, name string) *Field {
+	switch {
+	case name == "__typename":
+		return nameField
+	case t == s.Query && name == "__schema":
+		return schemaField
+	case t == s.Query && name == "__type":
+		return typeField
+	}
+	return t.field(name)
+}
+
+// checker проверяет запрос по схеме и заодно считает его глубину и сложность
+type checker struct {
+	s        *Schema
+	doc      *document
+	src      string
+	vars     map[string]interface{}
+	varDefs  map[string]*varDef
+	used     map[string]bool
+	visiting map[string]bool
+	seen     map[string]bool
+	errs     []*Error
+}
+
+func (c *checker) errorf(pos int, format string, args ...interface{}) {
+	e := &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{location(c.src, pos)}}
+	// фрагмент проверяется при каждом использовании, одна и та же ошибка выводится один раз
+	key := fmt.Sprint(e.Message, e.Locations)
+	if !c.seen[key] {
+		c.seen[key] = true
+		c.errs = append(c.errs, e)
+	}
+}
+
+// inputType находит тип переменной; переменными передаются только скаляры, перечисления и их списки
+func (c *checker) inputType(r *typeRef) Type {
+	var t Type
+	if r.elem != nil {
+		elem := c.inputType(r.elem)
+		if elem == nil {
+			return nil
+		}
+		t = ListOf(elem)
+	} else {
+		switch nt := c.s.types[r.name].(type) {
+		case *Scalar, *Enum:
+			t = nt
+		default:
+			return nil
+		}
+	}
+	if r.nonNull {
+		t = NonNullOf(t)
+	}
+	return t
+}
+
+func (c *checker) variables(op *operation, input map[string]interface{}) {
+	for _, d := range op.vars {
+		if _, ok := c.varDefs[d.name]; ok {
+			c.errorf(d.pos, "There can be only one variable named \"$%s\".", d.name)
+			continue
+		}
+		c.varDefs[d.name] = d
+
+		t := c.inputType(d.typ)
+		if t == nil {
+			c.errorf(d.pos, "Variable \"$%s\" cannot be of non-input type %q.", d.name, d.typ)
+			continue
+		}
+
+		raw, ok := input[d.name]
+		if !ok && d.def != nil {
+			raw, ok, _ = literal(d.def, nil)
+		}
+		if !ok {
+			if d.typ.nonNull {
+				c.errorf(d.pos, "Variable \"$%s\" of required type %q was not provided.", d.name, d.typ)
+			}
+			continue
+		}
+		if _, err := coerceInput(t, raw); err != nil {
+			c.errorf(d.pos, "Variable \"$%s\" got invalid value: %v", d.name, err)
+			continue
+		}
+		c.vars[d.name] = raw
+	}
+}
+
+func (c *checker) directives(list []*directive, location string) {
+	for _, d := range list {
+		if d.name != "skip" && d.name != "include" {
+			c.errorf(d.pos, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		if location == "QUERY" {
+			c.errorf(d.pos, "Directive \"@%s\" may not be used on %s.", d.name, location)
+			continue
+		}
+		c.arguments(directiveArgs, d.args, d.pos, "@"+d.name)
+	}
+}
+
+// arguments проверяет аргументы поля или директивы и возвращает их значения
+func (c *checker) arguments(defs []*Arg, args []*argument, pos int, owner string) map[string]interface{} {
+	before := len(c.errs)
+	for _, a := range args {
+		var def *Arg
+		for _, d := range defs {
+			if d.Name == a.name {
+				def = d
+			}
+		}
+		if def == nil {
+			c.errorf(a.pos, "Unknown argument %q on %s.", a.name, owner)
+			continue
+		}
+		c.variableUsage(def.Type, a.val, def.Default != nil)
+	}
+	if len(c.errs) > before {
+		return nil
+	}
+
+	values, err := coerceArgs(defs, args, c.vars)
+	if err != nil {
+		c.errorf(pos, "%s: %v", owner, err)
+	}
+	return values
+}
+
+// variableUsage проверяет, что переменная подходит по типу к месту, где она использована
+func (c *checker) variableUsage(t Type, v *value, locationDefault bool) {
+	switch v.kind {
+	case valVariable:
+		c.used["$"+v.raw] = true
+		d, ok := c.varDefs[v.raw]
+		if !ok {
+			c.errorf(v.pos, "Variable \"$%s\" is not defined.", v.raw)
+			return
+		}
+		vt := c.inputType(d.typ)
+		if vt == nil {
+			return
+		}
+		if nn, ok := t.(*NonNull); ok {
+			if _, ok := vt.(*NonNull); !ok && (d.def != nil && d.def.kind != valNull || locationDefault) {
+				t = nn.OfType
+			}
+		}
+		if !isSubType(vt, t) {
+			c.errorf(v.pos, "Variable \"$%s\" of type %q used in position expecting type %q.", v.raw, vt, t)
+		}
+	case valList:
+		if nn, ok := t.(*NonNull); ok {
+			t = nn.OfType
+		}
+		if l, ok := t.(*List); ok {
+			t = l.OfType
+		}
+		for _, item := range v.list {
+			c.variableUsage(t, item, false)
+		}
+	}
+}
+
+func isSubType(v, loc Type) bool {
+	if ln, ok := loc.(*NonNull); ok {
+		vn, ok := v.(*NonNull)
+		return ok && isSubType(vn.OfType, ln.OfType)
+	}
+	if vn, ok := v.(*NonNull); ok {
+		return isSubType(vn.OfType, loc)
+	}
+	if ll, ok := loc.(*List); ok {
+		vl, ok := v.(*List)
+		return ok && isSubType(vl.OfType, ll.OfType)
+	}
+	if _, ok := v.(*List); ok {
+		return false
+	}
+	return v == loc
+}
+
+// selections проверяет выбор полей типа t и возвращает его стоимость и глубину.
+// Поля интроспекции (__schema, __type) проверяются, но в глубину и стоимость не входят
+func (c *checker) selections(t *Object, list []selection, count bool) (complexity, depth int) {
+	add := func(cost, d int) {
+		complexity += cost
+		if d > depth {
+			depth = d
+		}
+	}
+
+	for _, sel := range list {
+		switch sel := sel.(type) {
+		case *field:
+			c.directives(sel.directives, "FIELD")
+			def := c.s.fieldDef(t, sel.name)
+			if def == nil {
+				c.errorf(sel.pos, "Cannot query field %q on type %q.", sel.name, t.Name)
+				continue
+			}
+			args := c.arguments(def.Args, sel.args, sel.pos, fmt.Sprintf("field \"%s.%s\"", t.Name, sel.name))
+
+			fieldCount := count && !strings.HasPrefix(sel.name, "__")
+			var childCost, childDepth int
+			switch nt := named(def.Type).(type) {
+			case *Object:
+				if len(sel.selections) == 0 {
+					c.errorf(sel.pos, "Field %q of type %q must have a selection of subfields.", sel.name, def.Type)
+					continue
+				}
+				childCost, childDepth = c.selections(nt, sel.selections, fieldCount)
+			default:
+				if len(sel.selections) > 0 {
+					c.errorf(sel.pos, "Field %q must not have a selection since type %q has no subfields.", sel.name, def.Type)
+					continue
+				}
+			}
+			if !fieldCount || sel.name == "__typename" {
+				continue
+			}
+			cost := 1 + childCost
+			if def.Complexity != nil && args != nil {
+				cost = def.Complexity(args, childCost)
+			}
+			add(cost, 1+childDepth)
+
+		case *fragmentSpread:
+			c.directives(sel.directives, "FRAGMENT_SPREAD")
+			c.used["fragment "+sel.name] = true
+			f, ok := c.doc.fragments[sel.name]
+			if !ok {
+				c.errorf(sel.pos, "Unknown fragment %q.", sel.name)
+				continue
+			}
+			if c.visiting[sel.name] {
+				c.errorf(sel.pos, "Cannot spread fragment %q within itself.", sel.name)
+				continue
+			}
+			if !c.typeCondition(f.on, t, f.pos) {
+				continue
+			}
+			c.visiting[sel.name] = true
+			add(c.selections(t, f.selections, count))
+			c.visiting[sel.name] = false
+
+		case *inlineFragment:
+			c.directives(sel.directives, "INLINE_FRAGMENT")
+			if sel.on != "" && !c.typeCondition(sel.on, t, sel.pos) {
+				continue
+			}
+			add(c.selections(t, sel.selections, count))
+		}
+	}
+	return
+}
+
+// typeCondition: в схеме нет интерфейсов и объединений, поэтому фрагмент применим только к своему типу
+func (c *checker) typeCondition(on string, t *Object, pos int) bool {
+	switch c.s.types[on].(type) {
+	case *Object:
+	case nil:
+		c.errorf(pos, "Unknown type %q.", on)
+		return false
+	default:
+		c.errorf(pos, "Fragment cannot condition on non composite type %q.", on)
+		return false
+	}
+	if on != t.Name {
+		c.errorf(pos, "Fragment on %q cannot be spread here as objects of type %q can never be of type %q.", on, t.Name, on)
+		return false
+	}
+	return true
+}
+
+// literal переводит литерал запроса в значение того же вида, что и переменные из JSON.
+// present ложно, если значение - переменная, которую не передали
+func literal(v *value, vars map[string]interface{}) (raw interface{}, present bool, err error) {
+	switch v.kind {
+	case valVariable:
+		raw, present = vars[v.raw]
+		return
+	case valInt, valFloat:
+		raw, err = strconv.ParseFloat(v.raw, 64)
+	case valString:
+		raw = v.raw
+	case valBoolean:
+		raw = v.raw == "true"
+	case valNull:
+	case valEnum:
+		raw = enumLiteral(v.raw)
+	case valList:
+		list := make([]interface{}, 0, len(v.list))
+		for _, item := range v.list {
+			x, _, err := literal(item, vars)
+			if err != nil {
+				return nil, false, err
+			}
+			list = append(list, x)
+		}
+		raw = list
+	case valObject:
+		obj := make(map[string]interface{})
+		for _, f := range v.fields {
+			if obj[f.name], _, err = literal(f.val, vars); err != nil {
+				return
+			}
+		}
+		raw = obj
+	}
+	return raw, err == nil, err
+}
+
+// coerceInput проверяет входное значение по типу и переводит его в значение для резолвера
+func coerceInput(t Type, v interface{}) (interface{}, error) {
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected non-nullable type %q not to be null", t)
+		}
+		return coerceInput(nn.OfType, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch tt := t.(type) {
+	case *List:
+		list, ok := v.([]interface{})
+		if !ok {
+			// одно значение на месте списка считается списком из одного элемента
+			x, err := coerceInput(tt.OfType, v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{x}, nil
+		}
+		out := make([]interface{}, len(list))
+		for i, item := range list {
+			x, err := coerceInput(tt.OfType, item)
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+			out[i] = x
+		}
+		return out, nil
+	case *Enum:
+		var name string
+		switch s := v.(type) {
+		case enumLiteral:
+			name = string(s)
+		case string:
+			name = s
+		default:
+			return nil, fmt.Errorf("enum %q cannot represent non-enum value: %v", tt.Name, v)
+		}
+		if !tt.has(name) {
+			return nil, fmt.Errorf("value %q does not exist in %q enum", name, tt.Name)
+		}
+		return name, nil
+	case *Scalar:
+		return tt.ParseValue(v)
+	}
+	return nil, fmt.Errorf("type %q is not an input type", t)
+}
+
+// coerceArgs собирает значения аргументов: из запроса, из значений по умолчанию или ошибку
+func coerceArgs(defs []*Arg, args []*argument, vars map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(defs))
+	for _, d := range defs {
+		var found *argument
+		for _, a := range args {
+			if a.name == d.Name {
+				found = a
+			}
+		}
+		if found != nil {
+			raw, present, err := literal(found.val, vars)
+			if err != nil {
+				return nil, err
+			}
+			if present {
+				v, err := coerceInput(d.Type, raw)
+				if err != nil {
+					return nil, fmt.Errorf("argument %q: %w", d.Name, err)
+				}
+				values[d.Name] = v
+				continue
+			}
+		}
+		if d.Default != nil {
+			values[d.Name] = d.Default
+			continue
+		}
+		if _, ok := d.Type.(*NonNull); ok {
+			return nil, fmt.Errorf("argument %q of required type %q was not provided", d.Name, d.Type)
+		}
+	}
+	return values, nil
+}
+
+var directiveArgs = []*Arg{{Name: "if", Type: NonNullOf(Boolean)}}
+
+type executor struct {
+	s    *Schema
+	doc  *document
+	src  string
+	vars map[string]interface{}
+	ctx  context.Context
+	errs []*Error
+}
+
+func (e *executor) fail(err error, pos int, path []interface{}) {
+	ge := &Error{Message: err.Error()}
+	if src, ok := err.(*Error); ok {
+		ge.Message, ge.Extensions = src.Message, src.Extensions
+	}
+	ge.Locations = []Location{location(e.src, pos)}
+	ge.Path = append([]interface{}(nil), path...)
+	e.errs = append(e.errs, ge)
+}
+
+// included применяет @skip и @include
+func (e *executor) included(list []*directive) bool {
+	for _, d := range list {
+		args, _ := coerceArgs(directiveArgs, d.args, e.vars)
+		if v, _ := args["if"].(bool); v == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// collect раскрывает фрагменты и группирует поля по ключу ответа в порядке запроса
+func (e *executor) collect(list []selection, keys *[]string, groups map[string][]*field, visited map[string]bool) {
+	for _, sel := range list {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			k := sel.key()
+			if _, ok := groups[k]; !ok {
+				*keys = append(*keys, k)
+			}
+			groups[k] = append(groups[k], sel)
+		case *fragmentSpread:
+			if visited[sel.name] || !e.included(sel.directives) {
+				continue
+			}
+			visited[sel.name] = true
+			e.collect(e.doc.fragments[sel.name].selections, keys, groups, visited)
+		case *inlineFragment:
+			if e.included(sel.directives) {
+				e.collect(sel.selections, keys, groups, visited)
+			}
+		}
+	}
+}
+
+// selectionSet выполняет поля объекта. failed означает, что не-null поле осталось без значения
+// и null поднимается к ближайшему родителю, который может быть null
+func (e *executor) selectionSet(t *Object, source interface{}, list []selection, path []interface{}) (result *orderedMap, failed bool) {
+	var keys []string
+	groups := make(map[string][]*field)
+	e.collect(list, &keys, groups, make(map[string]bool))
+
+	result = &orderedMap{values: make(map[string]interface{}, len(keys))}
+	for _, k := range keys {
+		fields := groups[k]
+		v, fieldFailed := e.field(t, source, fields, append(path, k))
+		if fieldFailed {
+			return nil, true
+		}
+		result.set(k, v)
+	}
+	return result, false
+}
+
+func (e *executor) field(t *Object, source interface{}, fields []*field, path []interface{}) (result interface{}, failed bool) {
+	f := fields[0]
+	def := e.s.fieldDef(t, f.name)
+	if def == nameField {
+		return t.Name, false
+	}
+
+	// ошибка поля уже записана, null поднимается выше, если поле не может быть null
+	fieldError := func(err error) (interface{}, bool) {
+		e.fail(err, f.pos, path)
+		_, nonNull := def.Type.(*NonNull)
+		return nil, nonNull
+	}
+
+	args, err := coerceArgs(def.Args, f.args, e.vars)
+	if err != nil {
+		return fieldError(err)
+	}
+
+	var v interface{}
+	switch def {
+	case schemaField:
+		v = e.s
+	case typeField:
+		v = e.s.types[args["name"].(string)]
+	default:
+		v, err = e.resolve(def, Params{Context: e.ctx, Source: source, Args: args})
+		if err != nil {
+			return fieldError(err)
+		}
+	}
+	return e.complete(def.Type, fields, v, path)
+}
+
+func (e *executor) resolve(def *Field, p Params) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error in field %q: %v", def.Name, r)
+		}
+	}()
+
+	if def.Authorize != nil {
+		if err = def.Authorize(p); err != nil {
+			return nil, err
+		}
+	}
+	if def.Resolve != nil {
+		return def.Resolve(p)
+	}
+	if m, ok := p.Source.(map[string]interface{}); ok {
+		return m[def.Name], nil
+	}
+	return nil, nil
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// complete приводит значение резолвера к типу поля: проверяет null, обходит списки,
+// выполняет выбор полей объектов и сериализует скаляры
+func (e *executor) complete(t Type, fields []*field, v interface{}, path []interface{}) (interface{}, bool) {
+	if nn, ok := t.(*NonNull); ok {
+		before := len(e.errs)
+		r, failed := e.complete(nn.OfType, fields, v, path)
+		if failed || r == nil && len(e.errs) > before {
+			return nil, true
+		}
+		if r == nil {
+			e.fail(fmt.Errorf("Cannot return null for non-nullable field %s.", fields[0].name), fields[0].pos, path)
+			return nil, true
+		}
+		return r, false
+	}
+	if isNil(v) {
+		return nil, false
+	}
+
+	switch tt := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(fmt.Errorf("expected a list for field %q, got %T", fields[0].name, v), fields[0].pos, path)
+			return nil, false
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			item, failed := e.complete(tt.OfType, fields, rv.Index(i).Interface(), append(path, i))
+			if failed {
+				return nil, false
+			}
+			list[i] = item
+		}
+		return list, false
+
+	case *Object:
+		var list []selection
+		for _, f := range fields {
+			list = append(list, f.selections...)
+		}
+		r, failed := e.selectionSet(tt, v, list, path)
+		if failed {
+			return nil, false
+		}
+		return r, false
+
+	case *Enum:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.String || !tt.has(rv.String()) {
+			e.fail(fmt.Errorf("enum %q cannot represent value: %v", tt.Name, v), fields[0].pos, path)
+			return nil, false
+		}
+		return rv.String(), false
+
+	case *Scalar:
+		r, err := tt.Serialize(v)
+		if err != nil {
+			e.fail(err, fields[0].pos, path)
+			return nil, false
+		}
+		return r, false
+	}
+	return nil, false
+}
@@ -0,0 +1,303 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testBook struct {
+	ID     string
+	Name   string
+	Author string
+	Genre  string
+	Secret string
+}
+
+var testBooks = []testBook{
+	{"1", "Капитанская дочка", "Пушкин", "CLASSIC", "s1"},
+	{"2", "Дубровский", "Пушкин", "CLASSIC", "s2"},
+	{"3", "Собака Баскервилей", "Дойл", "DETECTIVE", "s3"},
+}
+
+type roleKey struct{}
+
+// testSchema - книги и авторы со ссылками друг на друга, поле с проверкой прав и поле с ошибкой
+func testSchema(t *testing.T) *Schema {
+	genre := &Enum{Name: "Genre", Values: enumValues("CLASSIC", "DETECTIVE")}
+	book := &Object{Name: "Book"}
+	author := &Object{Name: "Author"}
+
+	booksBy := func(name string) []testBook {
+		var list []testBook
+		for _, b := range testBooks {
+			if name == "" || b.Author == name {
+				list = append(list, b)
+			}
+		}
+		return list
+	}
+
+	book.Fields = []*Field{
+		{Name: "id", Type: NonNullOf(ID), Resolve: func(p Params) (interface{}, error) { return p.Source.(testBook).ID, nil }},
+		{Name: "name", Type: NonNullOf(String), Resolve: func(p Params) (interface{}, error) { return p.Source.(testBook).Name, nil }},
+		{Name: "genre", Type: NonNullOf(genre), Resolve: func(p Params) (interface{}, error) { return p.Source.(testBook).Genre, nil }},
+		{Name: "author", Type: NonNullOf(author), Resolve: func(p Params) (interface{}, error) { return p.Source.(testBook).Author, nil }},
+		{Name: "secret", Type: String,
+			Authorize: func(p Params) error {
+				if p.Context.Value(roleKey{}) != "ADMIN" {
+					return &Error{Message: "forbidden", Extensions: map[string]interface{}{"code": "FORBIDDEN"}}
+				}
+				return nil
+			},
+			Resolve: func(p Params) (interface{}, error) { return p.Source.(testBook).Secret, nil }},
+		{Name: "broken", Type: NonNullOf(String), Resolve: func(p Params) (interface{}, error) { return nil, errors.New("storage is down") }},
+	}
+	author.Fields = []*Field{
+		{Name: "name", Type: NonNullOf(String), Resolve: func(p Params) (interface{}, error) { return p.Source, nil }},
+		{Name: "books", Type: NonNullOf(ListOf(NonNullOf(book))),
+			Args:       []*Arg{{Name: "first", Type: Int, Default: 10}},
+			Complexity: func(args map[string]interface{}, child int) int { return 1 + args["first"].(int)*child },
+			Resolve:    func(p Params) (interface{}, error) { return booksBy(p.Source.(string)), nil }},
+	}
+
+	query := &Object{Name: "Query", Fields: []*Field{
+		{Name: "book", Type: book, Args: []*Arg{{Name: "id", Type: NonNullOf(ID)}}, Resolve: func(p Params) (interface{}, error) {
+			for _, b := range testBooks {
+				if b.ID == p.Args["id"] {
+					return b, nil
+				}
+			}
+			return nil, nil
+		}},
+		{Name: "books", Type: NonNullOf(ListOf(NonNullOf(book))),
+			Args: []*Arg{{Name: "genres", Type: ListOf(NonNullOf(genre))}, {Name: "first", Type: Int, Default: 10}},
+			Complexity: func(args map[string]interface{}, child int) int {
+				return 1 + args["first"].(int)*child
+			},
+			Resolve: func(p Params) (interface{}, error) {
+				var list []testBook
+				for _, b := range testBooks {
+					genres, _ := p.Args["genres"].([]interface{})
+					match := len(genres) == 0
+					for _, g := range genres {
+						match = match || g == b.Genre
+					}
+					if match && len(list) < p.Args["first"].(int) {
+						list = append(list, b)
+					}
+				}
+				return list, nil
+			}},
+		{Name: "authors", Type: NonNullOf(ListOf(NonNullOf(author))), Resolve: func(p Params) (interface{}, error) {
+			return []string{"Пушкин", "Дойл"}, nil
+		}},
+		{Name: "old", Type: String, Deprecated: "use books", Resolve: func(p Params) (interface{}, error) { return "old", nil }},
+	}}
+
+	s, err := NewSchema(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func run(t *testing.T, s *Schema, ctx context.Context, query string, vars map[string]interface{}) (string, *Response) {
+	t.Helper()
+	resp := s.Exec(ctx, Request{Query: query, Variables: vars})
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), resp
+}
+
+func TestExec(t *testing.T) {
+	s := testSchema(t)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		name, query string
+		vars        map[string]interface{}
+		want        string
+	}{
+		{"fields keep query order", `{ book(id: 1) { name id } }`, nil,
+			`{"data":{"book":{"name":"Капитанская дочка","id":"1"}}}`},
+		{"aliases and nested lists", `{ a: book(id: "3") { genre author { name } } b: book(id: "9") { name } }`, nil,
+			`{"data":{"a":{"genre":"DETECTIVE","author":{"name":"Дойл"}},"b":null}}`},
+		{"fragments merge fields", `query { book(id: 1) { ...F ... on Book { id } } } fragment F on Book { name, id }`, nil,
+			`{"data":{"book":{"name":"Капитанская дочка","id":"1"}}}`},
+		{"variables and defaults", `query Q($g: [Genre!] = [DETECTIVE], $n: Int) { books(genres: $g, first: $n) { id } }`, nil,
+			`{"data":{"books":[{"id":"3"}]}}`},
+		{"variables from request", `query Q($g: [Genre!], $n: Int) { books(genres: $g, first: $n) { id } }`,
+			map[string]interface{}{"g": []interface{}{"CLASSIC"}, "n": float64(1)},
+			`{"data":{"books":[{"id":"1"}]}}`},
+		{"single value coerced to list", `{ books(genres: CLASSIC) { id } }`, nil,
+			`{"data":{"books":[{"id":"1"},{"id":"2"}]}}`},
+		{"skip and include", `query ($yes: Boolean!) { book(id: 2) { id @skip(if: $yes) name @include(if: $yes) ... @include(if: false) { genre } } }`,
+			map[string]interface{}{"yes": true},
+			`{"data":{"book":{"name":"Дубровский"}}}`},
+		{"typename", `{ __typename authors { __typename name } }`, nil,
+			`{"data":{"__typename":"Query","authors":[{"__typename":"Author","name":"Пушкин"},{"__typename":"Author","name":"Дойл"}]}}`},
+		{"block string argument", "{ book(id: \"\"\"\n    1\n  \"\"\") { id } }", nil,
+			`{"data":{"book":{"id":"1"}}}`},
+	} {
+		got, _ := run(t, s, ctx, c.query, c.vars)
+		if got != c.want {
+			t.Errorf("%s:\n got %s\nwant %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	s := testSchema(t)
+
+	// ошибка не-null поля обнуляет ближайшего родителя, который может быть null
+	got, resp := run(t, s, context.Background(), `{ book(id: 1) { name broken } authors { name } }`, nil)
+	want := `{"errors":[{"message":"storage is down","locations":[{"line":1,"column":22}],"path":["book","broken"]}],` +
+		`"data":{"book":null,"authors":[{"name":"Пушкин"},{"name":"Дойл"}]}}`
+	if got != want || !resp.Executed() {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	got, _ = run(t, s, context.Background(), `{ authors { books { broken } } }`, nil)
+	want = `{"errors":[{"message":"storage is down","locations":[{"line":1,"column":21}],"path":["authors",0,"books",0,"broken"]}],"data":null}`
+	if got != want {
+		t.Errorf("null must propagate to data: %s", got)
+	}
+
+	got, _ = run(t, s, context.Background(), `{ book(id: 1) { secret } }`, nil)
+	want = `{"errors":[{"message":"forbidden","locations":[{"line":1,"column":17}],"path":["book","secret"],"extensions":{"code":"FORBIDDEN"}}],"data":{"book":{"secret":null}}}`
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	got, _ = run(t, s, context.WithValue(context.Background(), roleKey{}, "ADMIN"), `{ book(id: 1) { secret } }`, nil)
+	if got != `{"data":{"book":{"secret":"s1"}}}` {
+		t.Errorf("admin: %s", got)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	s := testSchema(t)
+
+	for _, c := range []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{`{ book(id: 1) { name }`, nil, `Syntax Error: expected name, found end of document`},
+		{`{ book(id: 01) { name } }`, nil, `Syntax Error: invalid number, unexpected digit after 0`},
+		{`{ book(id: "1) { name } }`, nil, `Syntax Error: unterminated string`},
+		{`{ nope }`, nil, `Cannot query field "nope" on type "Query".`},
+		{`{ book(id: 1) }`, nil, `Field "book" of type "Book" must have a selection of subfields.`},
+		{`{ book(id: 1) { name { x } } }`, nil, `Field "name" must not have a selection since type "String!" has no subfields.`},
+		{`{ book { name } }`, nil, `argument "id" of required type "ID!" was not provided`},
+		{`{ book(id: 1, isbn: 2) { name } }`, nil, `Unknown argument "isbn" on field "Query.book".`},
+		{`{ books(genres: [POETRY]) { id } }`, nil, `value "POETRY" does not exist in "Genre" enum`},
+		{`{ books(first: "2") { id } }`, nil, `Int cannot represent non-integer value: 2`},
+		{`{ book(id: $id) { name } }`, nil, `Variable "$id" is not defined.`},
+		{`query ($id: ID) { book(id: $id) { name } }`, nil, `Variable "$id" of type "ID" used in position expecting type "ID!".`},
+		{`query ($id: ID!) { book(id: $id) { name } }`, nil, `Variable "$id" of required type "ID!" was not provided.`},
+		{`query ($n: Int) { books(first: $n) { id } }`, map[string]interface{}{"n": "many"}, `Variable "$n" got invalid value`},
+		{`query ($b: Book) { authors { name } }`, nil, `Variable "$b" cannot be of non-input type "Book".`},
+		{`query ($n: Int) { authors { name } }`, nil, `Variable "$n" is never used`},
+		{`{ authors { ...A } } fragment A on Author { books { author { ...A } } }`, nil, `Cannot spread fragment "A" within itself.`},
+		{`{ authors { ...B } } fragment B on Book { id }`, nil, `Fragment on "Book" cannot be spread here`},
+		{`{ authors { name } } fragment U on Author { name }`, nil, `Fragment "U" is never used.`},
+		{`{ authors { name @deprecated } }`, nil, `Unknown directive "@deprecated".`},
+		{`mutation { authors { name } }`, nil, `Schema is not configured for mutations.`},
+		{`query A { authors { name } } query B { authors { name } }`, nil, `Must provide operation name`},
+	} {
+		got, resp := run(t, s, context.Background(), c.query, c.vars)
+		if resp.Executed() || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, c.want) || strings.Contains(got, `"data"`) {
+			t.Errorf("%s:\n got %s\nwant %s", c.query, got, c.want)
+		}
+	}
+
+	resp := s.Exec(context.Background(), Request{Query: "{\n  authors {\n    nope\n  }\n}"})
+	if len(resp.Errors) != 1 || len(resp.Errors[0].Locations) != 1 || resp.Errors[0].Locations[0] != (Location{3, 5}) {
+		t.Errorf("location: %+v", resp.Errors)
+	}
+
+	resp = s.Exec(context.Background(), Request{Query: `query A { authors { name } } query B { book(id: 2) { name } }`, OperationName: "B"})
+	if data, _ := json.Marshal(resp); string(data) != `{"data":{"book":{"name":"Дубровский"}}}` {
+		t.Errorf("operation name: %s", data)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := testSchema(t)
+	s.MaxDepth = 4
+	s.MaxComplexity = 50
+
+	for _, c := range []struct {
+		query string
+		want  string
+	}{
+		{`{ authors { books { author { books { id } } } } }`, `Query depth 5 exceeds the maximum allowed depth of 4.`},
+		// authors(1) + books: 1 + 10 * (id) = 12
+		{`{ authors { books { id } } }`, ``},
+		// books(first: 10): 1 + 10 * (author: 1 + books: 1 + 10*1) = 121
+		{`{ books { author { books { id } } } }`, `Query complexity 121 exceeds the maximum allowed complexity of 50.`},
+		{`{ books(first: 2) { author { books(first: 1) { id } } } }`, ``},
+		// фрагменты считаются так же, как поля, записанные на их месте
+		{`{ ...Q } fragment Q on Query { books { author { books { id } } } }`, `Query complexity 121 exceeds`},
+		// интроспекция в глубину и стоимость не входит
+		{`{ __schema { types { fields { type { ofType { ofType { name } } } } } } }`, ``},
+	} {
+		got, _ := run(t, s, context.Background(), c.query, nil)
+		if c.want == "" && strings.Contains(got, "errors") || c.want != "" && !strings.Contains(got, c.want) {
+			t.Errorf("%s:\n got %s\nwant %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	s := testSchema(t)
+
+	got, _ := run(t, s, context.Background(), `{
+		__schema { queryType { name } mutationType { name } directives { name locations } }
+		book: __type(name: "Book") { kind name fields { name type { kind name ofType { kind name } } } }
+		genre: __type(name: "Genre") { kind enumValues { name } }
+		list: __type(name: "Query") { fields(includeDeprecated: true) { name isDeprecated deprecationReason args { name defaultValue } } }
+		missing: __type(name: "Nope") { name }
+	}`, nil)
+
+	for _, want := range []string{
+		`"queryType":{"name":"Query"},"mutationType":null`,
+		`{"name":"skip","locations":["FIELD","FRAGMENT_SPREAD","INLINE_FRAGMENT"]}`,
+		`"book":{"kind":"OBJECT","name":"Book","fields":[{"name":"id","type":{"kind":"NON_NULL","name":null,"ofType":{"kind":"SCALAR","name":"ID"}}}`,
+		`"genre":{"kind":"ENUM","enumValues":[{"name":"CLASSIC"},{"name":"DETECTIVE"}]}`,
+		`{"name":"books","isDeprecated":false,"deprecationReason":null,"args":[{"name":"genres","defaultValue":null},{"name":"first","defaultValue":"10"}]}`,
+		`{"name":"old","isDeprecated":true,"deprecationReason":"use books","args":[]}`,
+		`"missing":null`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("introspection must contain %s\n got %s", want, got)
+		}
+	}
+
+	got, _ = run(t, s, context.Background(), `{ __type(name: "Query") { fields { name } } }`, nil)
+	if strings.Contains(got, `"old"`) {
+		t.Errorf("deprecated fields are hidden by default: %s", got)
+	}
+
+	got, _ = run(t, s, context.Background(), `{ __schema { types { name } } }`, nil)
+	for _, name := range []string{"Author", "Book", "Genre", "Query", "String", "__Schema", "__TypeKind"} {
+		if !strings.Contains(got, `{"name":"`+name+`"}`) {
+			t.Errorf("types must contain %s: %s", name, got)
+		}
+	}
+}
+
+func TestNewSchemaRejectsDuplicateTypes(t *testing.T) {
+	a := &Object{Name: "Book", Fields: []*Field{{Name: "id", Type: ID}}}
+	b := &Object{Name: "Book", Fields: []*Field{{Name: "name", Type: String}}}
+	query := &Object{Name: "Query", Fields: []*Field{{Name: "a", Type: a}, {Name: "b", Type: b}}}
+	if _, err := NewSchema(query); err == nil {
+		t.Error("two types named Book must be rejected")
+	}
+}
@@ -0,0 +1,252 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// типы интроспекции (раздел 4 спецификации), по ним клиенты и редакторы запросов узнают схему
+
+var (
+	schemaType     = &Object{Name: "__Schema", Description: "Схема: типы, корневые операции и директивы"}
+	typeType       = &Object{Name: "__Type", Description: "Тип схемы или обертка списка и не-null"}
+	fieldType      = &Object{Name: "__Field", Description: "Поле объекта"}
+	inputValueType = &Object{Name: "__InputValue", Description: "Аргумент поля или директивы"}
+	enumValueType  = &Object{Name: "__EnumValue", Description: "Значение перечисления"}
+	directiveType  = &Object{Name: "__Directive", Description: "Директива запроса"}
+
+	typeKindEnum = &Enum{Name: "__TypeKind", Values: enumValues(
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL")}
+	directiveLocationEnum = &Enum{Name: "__DirectiveLocation", Values: enumValues(
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT",
+		"VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE",
+		"UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION")}
+)
+
+func enumValues(names ...string) []EnumValue {
+	values := make([]EnumValue, len(names))
+	for i, n := range names {
+		values[i] = EnumValue{Name: n}
+	}
+	return values
+}
+
+type directiveDef struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []*Arg
+}
+
+var directiveDefs = []*directiveDef{
+	{Name: "include", Description: "Поле или фрагмент попадает в ответ, только если if истинно",
+		Locations: []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, Args: directiveArgs},
+	{Name: "skip", Description: "Поле или фрагмент пропускается, если if истинно",
+		Locations: []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, Args: directiveArgs},
+}
+
+// optional превращает пустую строку в null
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func includeDeprecatedArg() []*Arg {
+	return []*Arg{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+}
+
+func init() {
+	str := func(f func(p Params) string) ResolveFunc {
+		return func(p Params) (interface{}, error) { return f(p), nil }
+	}
+	falseValue := func(p Params) (interface{}, error) { return false, nil }
+	nothing := func(p Params) (interface{}, error) { return nil, nil }
+
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: nothing},
+		{Name: "types", Type: NonNullOf(ListOf(NonNullOf(typeType))), Resolve: func(p Params) (interface{}, error) {
+			s := p.Source.(*Schema)
+			names := append([]string(nil), s.names...)
+			sort.Strings(names)
+			types := make([]Type, len(names))
+			for i, n := range names {
+				types[i] = s.types[n]
+			}
+			return types, nil
+		}},
+		{Name: "queryType", Type: NonNullOf(typeType), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*Schema).Query, nil
+		}},
+		{Name: "mutationType", Type: typeType, Resolve: nothing},
+		{Name: "subscriptionType", Type: typeType, Resolve: nothing},
+		{Name: "directives", Type: NonNullOf(ListOf(NonNullOf(directiveType))), Resolve: func(p Params) (interface{}, error) {
+			return directiveDefs, nil
+		}},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NonNullOf(typeKindEnum), Resolve: str(func(p Params) string {
+			switch p.Source.(type) {
+			case *Scalar:
+				return "SCALAR"
+			case *Enum:
+				return "ENUM"
+			case *Object:
+				return "OBJECT"
+			case *List:
+				return "LIST"
+			}
+			return "NON_NULL"
+		})},
+		{Name: "name", Type: String, Resolve: func(p Params) (interface{}, error) {
+			switch t := p.Source.(type) {
+			case *List, *NonNull:
+				return nil, nil
+			default:
+				return t.(Type).String(), nil
+			}
+		}},
+		{Name: "description", Type: String, Resolve: func(p Params) (interface{}, error) {
+			switch t := p.Source.(type) {
+			case *Scalar:
+				return optional(t.Description), nil
+			case *Enum:
+				return optional(t.Description), nil
+			case *Object:
+				return optional(t.Description), nil
+			}
+			return nil, nil
+		}},
+		{Name: "specifiedByURL", Type: String, Resolve: nothing},
+		{Name: "fields", Type: ListOf(NonNullOf(fieldType)), Args: includeDeprecatedArg(), Resolve: func(p Params) (interface{}, error) {
+			o, ok := p.Source.(*Object)
+			if !ok {
+				return nil, nil
+			}
+			fields := []*Field{}
+			for _, f := range o.Fields {
+				if f.Deprecated == "" || p.Args["includeDeprecated"] == true {
+					fields = append(fields, f)
+				}
+			}
+			return fields, nil
+		}},
+		{Name: "interfaces", Type: ListOf(NonNullOf(typeType)), Resolve: func(p Params) (interface{}, error) {
+			if _, ok := p.Source.(*Object); ok {
+				return []Type{}, nil
+			}
+			return nil, nil
+		}},
+		{Name: "possibleTypes", Type: ListOf(NonNullOf(typeType)), Resolve: nothing},
+		{Name: "enumValues", Type: ListOf(NonNullOf(enumValueType)), Args: includeDeprecatedArg(), Resolve: func(p Params) (interface{}, error) {
+			if e, ok := p.Source.(*Enum); ok {
+				return e.Values, nil
+			}
+			return nil, nil
+		}},
+		{Name: "inputFields", Type: ListOf(NonNullOf(inputValueType)), Resolve: nothing},
+		{Name: "ofType", Type: typeType, Resolve: func(p Params) (interface{}, error) {
+			switch t := p.Source.(type) {
+			case *List:
+				return t.OfType, nil
+			case *NonNull:
+				return t.OfType, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: NonNullOf(String), Resolve: str(func(p Params) string { return p.Source.(*Field).Name })},
+		{Name: "description", Type: String, Resolve: func(p Params) (interface{}, error) {
+			return optional(p.Source.(*Field).Description), nil
+		}},
+		{Name: "args", Type: NonNullOf(ListOf(NonNullOf(inputValueType))), Args: includeDeprecatedArg(), Resolve: func(p Params) (interface{}, error) {
+			return append([]*Arg{}, p.Source.(*Field).Args...), nil
+		}},
+		{Name: "type", Type: NonNullOf(typeType), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*Field).Type, nil
+		}},
+		{Name: "isDeprecated", Type: NonNullOf(Boolean), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*Field).Deprecated != "", nil
+		}},
+		{Name: "deprecationReason", Type: String, Resolve: func(p Params) (interface{}, error) {
+			return optional(p.Source.(*Field).Deprecated), nil
+		}},
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: NonNullOf(String), Resolve: str(func(p Params) string { return p.Source.(*Arg).Name })},
+		{Name: "description", Type: String, Resolve: func(p Params) (interface{}, error) {
+			return optional(p.Source.(*Arg).Description), nil
+		}},
+		{Name: "type", Type: NonNullOf(typeType), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*Arg).Type, nil
+		}},
+		{Name: "defaultValue", Type: String, Resolve: func(p Params) (interface{}, error) {
+			a := p.Source.(*Arg)
+			if a.Default == nil {
+				return nil, nil
+			}
+			return printValue(a.Type, a.Default), nil
+		}},
+		{Name: "isDeprecated", Type: NonNullOf(Boolean), Resolve: falseValue},
+		{Name: "deprecationReason", Type: String, Resolve: nothing},
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: NonNullOf(String), Resolve: str(func(p Params) string { return p.Source.(EnumValue).Name })},
+		{Name: "description", Type: String, Resolve: func(p Params) (interface{}, error) {
+			return optional(p.Source.(EnumValue).Description), nil
+		}},
+		{Name: "isDeprecated", Type: NonNullOf(Boolean), Resolve: falseValue},
+		{Name: "deprecationReason", Type: String, Resolve: nothing},
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NonNullOf(String), Resolve: str(func(p Params) string { return p.Source.(*directiveDef).Name })},
+		{Name: "description", Type: String, Resolve: func(p Params) (interface{}, error) {
+			return optional(p.Source.(*directiveDef).Description), nil
+		}},
+		{Name: "locations", Type: NonNullOf(ListOf(NonNullOf(directiveLocationEnum))), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*directiveDef).Locations, nil
+		}},
+		{Name: "args", Type: NonNullOf(ListOf(NonNullOf(inputValueType))), Args: includeDeprecatedArg(), Resolve: func(p Params) (interface{}, error) {
+			return p.Source.(*directiveDef).Args, nil
+		}},
+		{Name: "isRepeatable", Type: NonNullOf(Boolean), Resolve: falseValue},
+	}
+}
+
+// printValue записывает значение по умолчанию в синтаксисе GraphQL
+func printValue(t Type, v interface{}) string {
+	if nn, ok := t.(*NonNull); ok {
+		t = nn.OfType
+	}
+	if v == nil {
+		return "null"
+	}
+	switch tt := t.(type) {
+	case *List:
+		list, ok := v.([]interface{})
+		if !ok {
+			return printValue(tt.OfType, v)
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = printValue(tt.OfType, item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *Enum:
+		return fmt.Sprint(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,765 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// разбор исполняемого документа GraphQL (October 2021, раздел 2): операции, фрагменты, переменные,
+// директивы и литералы. Определения типов в запросе не поддерживаются - схема задается в Go
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+	tok token
+}
+
+// location переводит смещение в строку и колонку, как их показывают ошибки GraphQL
+func location(src string, pos int) Location {
+	line, col := 1, 1
+	for i, r := range src {
+		if i >= pos {
+			break
+		}
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return Location{Line: line, Column: col}
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	return &Error{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []Location{location(l.src, pos)}}
+}
+
+func (l *lexer) next() error {
+	// пропускаем пробелы, запятые, комментарии и BOM
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+			continue
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.pos += len("\uFEFF")
+			continue
+		}
+		break
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		l.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		l.pos++
+		l.tok = token{kind: tokPunct, value: string(c), pos: start}
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		l.tok = token{kind: tokPunct, value: "...", pos: start}
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		l.tok = token{kind: tokName, value: l.src[start:l.pos], pos: start}
+	case c == '-' || isDigit(c):
+		return l.number()
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		return l.blockString()
+	case c == '"':
+		return l.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+		return l.errorf(start, "unexpected character %q", r)
+	}
+	return nil
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+func (l *lexer) number() error {
+	start := l.pos
+	digits := func() bool {
+		from := l.pos
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.pos > from
+	}
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	intStart := l.pos
+	if !digits() {
+		return l.errorf(start, "invalid number, expected digit")
+	}
+	if l.pos-intStart > 1 && l.src[intStart] == '0' {
+		return l.errorf(start, "invalid number, unexpected digit after 0")
+	}
+
+	kind := tokInt
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		if !digits() {
+			return l.errorf(start, "invalid number, expected digit after '.'")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !digits() {
+			return l.errorf(start, "invalid number, expected digit in exponent")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == '.' || l.src[l.pos] == '_' || isLetter(l.src[l.pos])) {
+		return l.errorf(start, "invalid number, unexpected %q", l.src[l.pos])
+	}
+
+	l.tok = token{kind: kind, value: l.src[start:l.pos], pos: start}
+	return nil
+}
+
+func (l *lexer) string() error {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' || l.src[l.pos] == '\r' {
+			return l.errorf(start, "unterminated string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			l.tok = token{kind: tokString, value: b.String(), pos: start}
+			return nil
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return l.errorf(start, "unterminated string")
+			}
+			e := l.src[l.pos+1]
+			l.pos += 2
+			switch e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return l.errorf(l.pos-2, "invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return l.errorf(l.pos-2, "invalid unicode escape \\u%s", l.src[l.pos:l.pos+4])
+				}
+				b.WriteRune(rune(n))
+				l.pos += 4
+			default:
+				return l.errorf(l.pos-2, "invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// blockString разбирает """блочную строку""", убирая общий отступ строк, как требует спецификация
+func (l *lexer) blockString() error {
+	start := l.pos
+	l.pos += 3
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return l.errorf(start, "unterminated block string")
+		}
+		if strings.HasPrefix(l.src[l.pos:], `\"""`) {
+			b.WriteString(`"""`)
+			l.pos += 4
+			continue
+		}
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			l.pos += 3
+			break
+		}
+		b.WriteByte(l.src[l.pos])
+		l.pos++
+	}
+
+	lines := strings.Split(strings.ReplaceAll(strings.ReplaceAll(b.String(), "\r\n", "\n"), "\r", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	l.tok = token{kind: tokString, value: strings.Join(lines, "\n"), pos: start}
+	return nil
+}
+
+// документ запроса
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	vars       []*varDef
+	directives []*directive
+	selections []selection
+	pos        int
+}
+
+type varDef struct {
+	name string
+	typ  *typeRef
+	def  *value
+	pos  int
+}
+
+// typeRef - тип переменной в записи запроса: Name, [Type] или Type!
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type selection interface {
+	position() int
+}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+	pos        int
+}
+
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	pos        int
+}
+
+type inlineFragment struct {
+	on         string
+	directives []*directive
+	selections []selection
+	pos        int
+}
+
+type fragment struct {
+	name       string
+	on         string
+	directives []*directive
+	selections []selection
+	pos        int
+}
+
+func (f *field) position() int          { return f.pos }
+func (f *fragmentSpread) position() int { return f.pos }
+func (f *inlineFragment) position() int { return f.pos }
+
+type argument struct {
+	name string
+	val  *value
+	pos  int
+}
+
+type directive struct {
+	name string
+	args []*argument
+	pos  int
+}
+
+type valueKind int
+
+const (
+	valVariable valueKind = iota
+	valInt
+	valFloat
+	valString
+	valBoolean
+	valNull
+	valEnum
+	valList
+	valObject
+)
+
+type value struct {
+	kind   valueKind
+	raw    string
+	list   []*value
+	fields []*argument
+	pos    int
+}
+
+type parser struct {
+	lexer
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lexer{src: src}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			op := &operation{kind: "query", pos: p.tok.pos}
+			var err error
+			if op.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && p.tok.value == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q.", f.name), Locations: []Location{location(src, f.pos)}}
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "Document does not contain an operation."}
+	}
+	return doc, nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return p.errorf(p.tok.pos, "unexpected end of document")
+	}
+	return p.errorf(p.tok.pos, "unexpected %q", p.tok.value)
+}
+
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.next()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokEOF {
+			return p.errorf(p.tok.pos, "expected %q, found end of document", punct)
+		}
+		return p.errorf(p.tok.pos, "expected %q, found %q", punct, p.tok.value)
+	}
+	return p.next()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		if p.tok.kind == tokEOF {
+			return "", p.errorf(p.tok.pos, "expected name, found end of document")
+		}
+		return "", p.errorf(p.tok.pos, "expected name, found %q", p.tok.value)
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) operation() (op *operation, err error) {
+	op = &operation{kind: p.tok.value, pos: p.tok.pos}
+	if err = p.next(); err != nil {
+		return
+	}
+	if p.tok.kind == tokName {
+		if op.name, err = p.name(); err != nil {
+			return
+		}
+	}
+
+	if p.peek("(") {
+		if op.vars, err = p.varDefs(); err != nil {
+			return
+		}
+	}
+
+	if op.directives, err = p.directives(); err != nil {
+		return
+	}
+	op.selections, err = p.selectionSet()
+	return
+}
+
+func (p *parser) varDefs() (list []*varDef, err error) {
+	if err = p.expect("("); err != nil {
+		return
+	}
+	for !p.peek(")") {
+		v := &varDef{pos: p.tok.pos}
+		if err = p.expect("$"); err != nil {
+			return
+		}
+		if v.name, err = p.name(); err != nil {
+			return
+		}
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		if v.typ, err = p.typeRef(); err != nil {
+			return
+		}
+		if p.peek("=") {
+			if err = p.next(); err != nil {
+				return
+			}
+			if v.def, err = p.value(true); err != nil {
+				return
+			}
+		}
+		list = append(list, v)
+	}
+	if len(list) == 0 {
+		return nil, p.errorf(p.tok.pos, "expected variable definition, found \")\"")
+	}
+	err = p.next()
+	return
+}
+
+func (p *parser) typeRef() (t *typeRef, err error) {
+	t = &typeRef{}
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.elem, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else if t.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	t.nonNull, err = p.skip("!")
+	return
+}
+
+func (p *parser) fragment() (f *fragment, err error) {
+	f = &fragment{pos: p.tok.pos}
+	if err = p.next(); err != nil {
+		return
+	}
+	if f.name, err = p.name(); err != nil {
+		return
+	}
+	if f.name == "on" {
+		return nil, p.errorf(f.pos, "fragment cannot be named \"on\"")
+	}
+	if p.tok.kind != tokName || p.tok.value != "on" {
+		return nil, p.errorf(p.tok.pos, "expected \"on\", found %q", p.tok.value)
+	}
+	if err = p.next(); err != nil {
+		return
+	}
+	if f.on, err = p.name(); err != nil {
+		return
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return
+	}
+	f.selections, err = p.selectionSet()
+	return
+}
+
+func (p *parser) selectionSet() (list []selection, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+	for !p.peek("}") {
+		var s selection
+		if s, err = p.selection(); err != nil {
+			return
+		}
+		list = append(list, s)
+	}
+	if len(list) == 0 {
+		return nil, p.errorf(p.tok.pos, "expected selection, found \"}\"")
+	}
+	err = p.next()
+	return
+}
+
+func (p *parser) selection() (selection, error) {
+	pos := p.tok.pos
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			s := &fragmentSpread{name: p.tok.value, pos: pos}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			s.directives, err = p.directives()
+			return s, err
+		}
+		s := &inlineFragment{pos: pos}
+		if p.tok.kind == tokName {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if s.on, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		s.selections, err = p.selectionSet()
+		return s, err
+	}
+
+	f := &field{pos: pos}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.alias = name
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	f.name = name
+	if f.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		f.selections, err = p.selectionSet()
+	}
+	return f, err
+}
+
+func (p *parser) arguments(constant bool) (args []*argument, err error) {
+	ok, err := p.skip("(")
+	if err != nil || !ok {
+		return
+	}
+	for !p.peek(")") {
+		a := &argument{pos: p.tok.pos}
+		if a.name, err = p.name(); err != nil {
+			return
+		}
+		for _, other := range args {
+			if other.name == a.name {
+				return nil, p.errorf(a.pos, "there can be only one argument named %q", a.name)
+			}
+		}
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		if a.val, err = p.value(constant); err != nil {
+			return
+		}
+		args = append(args, a)
+	}
+	if len(args) == 0 {
+		return nil, p.errorf(p.tok.pos, "expected argument, found \")\"")
+	}
+	err = p.next()
+	return
+}
+
+func (p *parser) directives() (list []*directive, err error) {
+	for p.peek("@") {
+		d := &directive{pos: p.tok.pos}
+		if err = p.next(); err != nil {
+			return
+		}
+		if d.name, err = p.name(); err != nil {
+			return
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return
+		}
+		list = append(list, d)
+	}
+	return
+}
+
+// value разбирает литерал; в значениях по умолчанию (constant) переменные запрещены
+func (p *parser) value(constant bool) (v *value, err error) {
+	v = &value{pos: p.tok.pos, raw: p.tok.value}
+	switch p.tok.kind {
+	case tokInt:
+		v.kind = valInt
+	case tokFloat:
+		v.kind = valFloat
+	case tokString:
+		v.kind = valString
+	case tokName:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind = valBoolean
+		case "null":
+			v.kind = valNull
+		default:
+			v.kind = valEnum
+		}
+	case tokPunct:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				return nil, p.errorf(p.tok.pos, "unexpected variable in constant value")
+			}
+			if err = p.next(); err != nil {
+				return
+			}
+			v.kind = valVariable
+			v.raw, err = p.name()
+			return
+		case "[":
+			v.kind = valList
+			if err = p.next(); err != nil {
+				return
+			}
+			for !p.peek("]") {
+				var item *value
+				if item, err = p.value(constant); err != nil {
+					return
+				}
+				v.list = append(v.list, item)
+			}
+			err = p.next()
+			return
+		case "{":
+			v.kind = valObject
+			if err = p.next(); err != nil {
+				return
+			}
+			for !p.peek("}") {
+				f := &argument{pos: p.tok.pos}
+				if f.name, err = p.name(); err != nil {
+					return
+				}
+				if err = p.expect(":"); err != nil {
+					return
+				}
+				if f.val, err = p.value(constant); err != nil {
+					return
+				}
+				v.fields = append(v.fields, f)
+			}
+			err = p.next()
+			return
+		default:
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	err = p.next()
+	return
+}
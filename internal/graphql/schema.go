@@ -0,0 +1,320 @@
+// Package graphql - небольшой исполнитель запросов GraphQL поверх схемы, описанной в Go.
+// Поддерживаются запросы с фрагментами, переменными, директивами @skip и @include и интроспекция.
+// Схема строится из объектов, перечислений и скаляров без интерфейсов, объединений и входных объектов,
+// этого хватает для чтения каталога. Перед выполнением запрос проверяется по схеме, а его глубина
+// и сложность ограничиваются полями MaxDepth и MaxComplexity
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Type - тип поля или аргумента: *Scalar, *Enum, *Object, *List или *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar - листовой тип. Serialize переводит значение резолвера в JSON, ParseValue - входное значение
+// (строку, число float64 или bool) в значение для резолвера; у встроенных скаляров они уже заданы
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(v interface{}) (interface{}, error)
+	ParseValue  func(v interface{}) (interface{}, error)
+}
+
+type Enum struct {
+	Name        string
+	Description string
+	Values      []EnumValue
+}
+
+type EnumValue struct {
+	Name        string
+	Description string
+}
+
+// Object - тип с полями. Поля задаются после создания объекта, чтобы типы могли ссылаться друг на друга
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+// ResolveFunc возвращает значение поля. Для списков подходит любой срез, для объектов - значение,
+// которое получат резолверы его полей в Params.Source
+type ResolveFunc func(p Params) (interface{}, error)
+
+type Params struct {
+	Context context.Context
+	Source  interface{}
+	Args    map[string]interface{}
+}
+
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Arg
+	// Resolve по умолчанию берет значение по имени поля из map[string]interface{}
+	Resolve ResolveFunc
+	// Authorize вызывается перед Resolve; ошибка становится ошибкой поля, а значение - null
+	Authorize func(p Params) error
+	// Complexity - стоимость поля с учетом стоимости выбранных в нем полей (child).
+	// По умолчанию 1 + child; для списков стоит умножать child на число запрошенных элементов
+	Complexity func(args map[string]interface{}, child int) int
+	// Deprecated - причина, по которой поле не стоит использовать
+	Deprecated string
+}
+
+type Arg struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     interface{}
+}
+
+type List struct {
+	OfType Type
+}
+
+type NonNull struct {
+	OfType Type
+}
+
+func ListOf(t Type) *List       { return &List{OfType: t} }
+func NonNullOf(t Type) *NonNull { return &NonNull{OfType: t} }
+
+func (t *Scalar) String() string  { return t.Name }
+func (t *Enum) String() string    { return t.Name }
+func (t *Object) String() string  { return t.Name }
+func (t *List) String() string    { return "[" + t.OfType.String() + "]" }
+func (t *NonNull) String() string { return t.OfType.String() + "!" }
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (e *Enum) has(name string) bool {
+	for _, v := range e.Values {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// встроенные скаляры
+
+var Int = &Scalar{
+	Name:        "Int",
+	Description: "Целое число со знаком, 32 бита",
+	Serialize: func(v interface{}) (interface{}, error) {
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n := rv.Int(); n >= math.MinInt32 && n <= math.MaxInt32 {
+				return n, nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n := rv.Uint(); n <= math.MaxInt32 {
+				return int64(n), nil
+			}
+		}
+		return nil, fmt.Errorf("Int cannot represent value: %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if f, ok := v.(float64); ok && f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+			return int(f), nil
+		}
+		return nil, fmt.Errorf("Int cannot represent non-integer value: %v", v)
+	},
+}
+
+var Float = &Scalar{
+	Name:        "Float",
+	Description: "Число с плавающей точкой двойной точности",
+	Serialize: func(v interface{}) (interface{}, error) {
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if f := rv.Float(); !math.IsInf(f, 0) && !math.IsNaN(f) {
+				return f, nil
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		}
+		return nil, fmt.Errorf("Float cannot represent value: %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if f, ok := v.(float64); ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("Float cannot represent non numeric value: %v", v)
+	},
+}
+
+var String = &Scalar{
+	Name:        "String",
+	Description: "Текст в UTF-8",
+	Serialize: func(v interface{}) (interface{}, error) {
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case fmt.Stringer:
+			return s.String(), nil
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+		return nil, fmt.Errorf("String cannot represent value: %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("String cannot represent a non string value: %v", v)
+	},
+}
+
+var Boolean = &Scalar{
+	Name:        "Boolean",
+	Description: "true или false",
+	Serialize: func(v interface{}) (interface{}, error) {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent value: %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %v", v)
+	},
+}
+
+var ID = &Scalar{
+	Name:        "ID",
+	Description: "Уникальный идентификатор, передается строкой",
+	Serialize: func(v interface{}) (interface{}, error) {
+		switch id := v.(type) {
+		case string:
+			return id, nil
+		case fmt.Stringer:
+			return id.String(), nil
+		case int:
+			return strconv.Itoa(id), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent value: %v", v)
+	},
+	ParseValue: func(v interface{}) (interface{}, error) {
+		switch id := v.(type) {
+		case string:
+			return id, nil
+		case float64:
+			if id == math.Trunc(id) {
+				return strconv.FormatFloat(id, 'f', -1, 64), nil
+			}
+		}
+		return nil, fmt.Errorf("ID cannot represent value: %v", v)
+	},
+}
+
+// Schema - корневой тип запросов и все достижимые из него типы
+type Schema struct {
+	Query *Object
+	// MaxDepth - наибольшая вложенность полей в запросе, 0 - без ограничения. Поля интроспекции не считаются
+	MaxDepth int
+	// MaxComplexity - наибольшая суммарная стоимость полей запроса, 0 - без ограничения
+	MaxComplexity int
+
+	types map[string]Type
+	names []string
+}
+
+// NewSchema собирает типы, достижимые из query, и проверяет, что имена типов не повторяются
+func NewSchema(query *Object) (*Schema, error) {
+	s := &Schema{Query: query, types: make(map[string]Type)}
+	for _, t := range []Type{query, String, Int, Float, Boolean, ID, schemaType} {
+		if err := s.collect(t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) collect(t Type) error {
+	switch tt := t.(type) {
+	case *List:
+		return s.collect(tt.OfType)
+	case *NonNull:
+		if _, ok := tt.OfType.(*NonNull); ok {
+			return fmt.Errorf("graphql: non-null of non-null type %s", tt)
+		}
+		return s.collect(tt.OfType)
+	case nil:
+		return fmt.Errorf("graphql: nil type")
+	}
+
+	name := t.String()
+	if prev, ok := s.types[name]; ok {
+		if prev != t {
+			return fmt.Errorf("graphql: two different types named %s", name)
+		}
+		return nil
+	}
+	s.types[name] = t
+	s.names = append(s.names, name)
+
+	if o, ok := t.(*Object); ok {
+		if len(o.Fields) == 0 {
+			return fmt.Errorf("graphql: type %s has no fields", o.Name)
+		}
+		for _, f := range o.Fields {
+			if f.Type == nil {
+				return fmt.Errorf("graphql: field %s.%s has no type", o.Name, f.Name)
+			}
+			if err := s.collect(f.Type); err != nil {
+				return err
+			}
+			for _, a := range f.Args {
+				if _, ok := named(a.Type).(*Object); ok {
+					return fmt.Errorf("graphql: argument %s.%s(%s) must be a scalar or an enum", o.Name, f.Name, a.Name)
+				}
+				if err := s.collect(a.Type); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// named снимает обертки List и NonNull
+func named(t Type) Type {
+	for {
+		switch tt := t.(type) {
+		case *List:
+			t = tt.OfType
+		case *NonNull:
+			t = tt.OfType
+		default:
+			return t
+		}
+	}
+}
+
+// Type возвращает тип схемы по имени или nil
+func (s *Schema) Type(name string) Type {
+	return s.types[name]
+}
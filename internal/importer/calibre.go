@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+
+	"biblio/internal/sqlite"
+)
+
+// calibreFormats - форматы файлов Calibre в порядке предпочтения: те, что умеет отдавать каталог
+var calibreFormats = []string{"TXT", "FB2", "EPUB", "PDF"}
+
+type calibreFile struct {
+	format string
+	name   string
+}
+
+// ReadCalibre читает библиотеку Calibre из каталога dir. Ссылкой книги становится путь к ее файлу
+// в этом каталоге, поэтому библиотека должна лежать там, где ее видит сервер. Calibre на время
+// импорта нужно закрыть, иначе последние изменения могут остаться в журнале WAL
+func ReadCalibre(dir string) (records []Record, err error) {
+	db, err := sqlite.Open(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open calibre library: %w", err)
+	}
+	defer db.Close()
+
+	names := func(table string) (m map[int64]string, err error) {
+		m = make(map[int64]string)
+		err = db.Each(table, func(row sqlite.Row) error {
+			m[row.Int("id")] = row.Text("name")
+			return nil
+		})
+		return
+	}
+	links := func(table, column string) (m map[int64][]int64, err error) {
+		m = make(map[int64][]int64)
+		err = db.Each(table, func(row sqlite.Row) error {
+			b := row.Int("book")
+			m[b] = append(m[b], row.Int(column))
+			return nil
+		})
+		return
+	}
+
+	authors, err := names("authors")
+	if err != nil {
+		return nil, err
+	}
+	series, err := names("series")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := names("tags")
+	if err != nil {
+		return nil, err
+	}
+	bookAuthors, err := links("books_authors_link", "author")
+	if err != nil {
+		return nil, err
+	}
+	bookSeries, err := links("books_series_link", "series")
+	if err != nil {
+		return nil, err
+	}
+	bookTags, err := links("books_tags_link", "tag")
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make(map[int64]string)
+	err = db.Each("comments", func(row sqlite.Row) error {
+		comments[row.Int("book")] = plainText(row.Text("text"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[int64][]calibreFile)
+	err = db.Each("data", func(row sqlite.Row) error {
+		b := row.Int("book")
+		files[b] = append(files[b], calibreFile{strings.ToUpper(row.Text("format")), row.Text("name")})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Each("books", func(row sqlite.Row) error {
+		id := row.Int("id")
+		rec := Record{Line: int(id), Name: strings.TrimSpace(row.Text("title")), Annotation: comments[id]}
+
+		var list []string
+		for _, a := range bookAuthors[id] {
+			list = append(list, authors[a])
+		}
+		rec.Author = strings.Join(list, ", ")
+		if s := bookSeries[id]; len(s) > 0 {
+			rec.Series = series[s[0]]
+		}
+		list = nil
+		for _, t := range bookTags[id] {
+			list = append(list, tags[t])
+		}
+		rec.Category = pickCategory(list)
+
+		if f, ok := pickFile(files[id]); ok {
+			rec.Link = filepath.Join(dir, filepath.FromSlash(row.Text("path")), f.name+"."+strings.ToLower(f.format))
+		}
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calibre books: %w", err)
+	}
+	return
+}
+
+func pickFile(files []calibreFile) (calibreFile, bool) {
+	for _, format := range calibreFormats {
+		for _, f := range files {
+			if f.format == format {
+				return f, true
+			}
+		}
+	}
+	if len(files) > 0 {
+		return files[0], true
+	}
+	return calibreFile{}, false
+}
+
+// plainText превращает HTML аннотации Calibre в текст: теги убираются, абзацы и переносы
+// становятся переводами строк
+func plainText(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			break
+		}
+		tag := strings.ToLower(strings.Trim(s[1:j], "/ "))
+		if f := strings.Fields(tag); len(f) > 0 {
+			tag = f[0]
+		}
+		if tag == "p" || tag == "br" || tag == "div" || tag == "li" {
+			b.WriteString("\n")
+		}
+		s = s[j+1:]
+	}
+
+	var lines []string
+	for _, l := range strings.Split(html.UnescapeString(b.String()), "\n") {
+		if l = strings.Join(strings.Fields(l), " "); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
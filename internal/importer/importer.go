@@ -0,0 +1,369 @@
+// Package importer загружает книги в каталог из записей MARC21 (ISO 2709 и MARCXML), CSV и библиотек
+// Calibre. Импорт идет в два шага: Plan сопоставляет записи источника с книгами каталога и решает,
+// что с каждой сделать, а Apply выполняет план. Предпросмотр - это план без Apply
+package importer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/repository"
+)
+
+// Record - книга из источника до сопоставления с каталогом. Пустые поля источник не задает
+type Record struct {
+	// Line - номер записи в источнике: строка файла CSV, порядковый номер записи MARC, id книги Calibre
+	Line int
+	// Id - идентификатор в источнике. Если это UUID книги каталога, запись сопоставляется по нему
+	Id string
+	// Category - жанр каталога или, если среди рубрик источника жанра не нашлось, первая рубрика
+	Category   string
+	Author     string
+	Series     string
+	Name       string
+	Annotation string
+	Link       string
+	Access     string
+	// Error - запись не удалось разобрать
+	Error string
+}
+
+type action string
+
+const (
+	ACTION_CREATE  action = "create"
+	ACTION_UPDATE  action = "update"
+	ACTION_MATCH   action = "match"
+	ACTION_SKIP    action = "skip"
+	ACTION_INVALID action = "invalid"
+)
+
+type rule string
+
+// правила для книг, которые уже есть в каталоге, но отличаются от записи источника
+const (
+	// RULE_SKIP оставляет книгу каталога без изменений
+	RULE_SKIP rule = "skip"
+	// RULE_UPDATE заменяет поля книги каталога значениями, которые задает источник
+	RULE_UPDATE rule = "update"
+	// RULE_DUPLICATE создает рядом новую книгу
+	RULE_DUPLICATE rule = "duplicate"
+)
+
+var Rules = []rule{RULE_SKIP, RULE_UPDATE, RULE_DUPLICATE}
+
+// ParseRule проверяет имя правила
+func ParseRule(s string) (r rule, ok bool) {
+	for _, r := range Rules {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return
+}
+
+type Options struct {
+	Rule rule
+	// Category - жанр для записей, рубрики которых не совпали ни с одним жанром каталога
+	Category string
+	// Access - доступ к новым книгам с файлом, если источник его не задает; по умолчанию закрыт
+	Access string
+}
+
+// Item - строка плана: что будет сделано с записью источника
+type Item struct {
+	Record Record
+	Action action
+	// Book - книга после импорта: новая для create, измененная для update, книга каталога для match и skip
+	Book repository.Book
+	// Changes - поля, которые отличаются от книги каталога
+	Changes []string
+	// Notes - замечания к записи: подставленные значения, причина пропуска или ошибки
+	Notes []string
+}
+
+// Summary - число записей плана по действиям
+type Summary map[action]int
+
+// Summarize считает записи плана по действиям
+func Summarize(items []Item) Summary {
+	s := make(Summary)
+	for _, it := range items {
+		s[it.Action]++
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("создать %d, обновить %d, без изменений %d, пропустить %d, с ошибками %d",
+		s[ACTION_CREATE], s[ACTION_UPDATE], s[ACTION_MATCH], s[ACTION_SKIP], s[ACTION_INVALID])
+}
+
+// Applied - итоги выполненного плана
+func (s Summary) Applied() string {
+	return fmt.Sprintf("создано %d, обновлено %d, без изменений %d, пропущено %d, с ошибками %d",
+		s[ACTION_CREATE], s[ACTION_UPDATE], s[ACTION_MATCH], s[ACTION_SKIP], s[ACTION_INVALID])
+}
+
+// Index - книги каталога для сопоставления: по идентификатору и по автору с названием
+type Index struct {
+	byId  map[uuid.UUID]repository.Book
+	byKey map[string]repository.Book
+	// planned - книги, которые создаст этот же импорт, по автору с названием и номеру записи
+	planned map[string]int
+}
+
+func NewIndex() *Index {
+	return &Index{byId: make(map[uuid.UUID]repository.Book), byKey: make(map[string]repository.Book)}
+}
+
+// Add добавляет книгу каталога. Подходит как обработчик repository.EachBook
+func (x *Index) Add(b repository.Book) error {
+	x.byId[b.Book_Id] = b
+	key := bookKey(b.Author, b.Name)
+	if _, ok := x.byKey[key]; !ok {
+		x.byKey[key] = b
+	}
+	return nil
+}
+
+// LoadIndex читает весь каталог вместе со скрытыми книгами, чтобы импорт не создал их второй раз
+func LoadIndex(ctx context.Context, repo *repository.Repository) (*Index, error) {
+	x := NewIndex()
+	err := repo.EachBook(ctx, repository.BookFilter{Hidden: true}, x.Add)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *Index) find(rec Record) (b repository.Book, ok bool) {
+	if id, err := uuid.Parse(rec.Id); err == nil {
+		if b, ok = x.byId[id]; ok {
+			return
+		}
+	}
+	b, ok = x.byKey[bookKey(rec.Author, rec.Name)]
+	return
+}
+
+// bookKey сравнивает автора и название без учета регистра, лишних пробелов и разницы е/ё
+func bookKey(author, name string) string {
+	return normalize(author) + "\x00" + normalize(name)
+}
+
+func normalize(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// noSeries - значение серии у книг вне серий
+const noSeries = "-"
+
+// Access: файл книги открыт или закрыт для читателей
+const (
+	accessOpen   = "Да"
+	accessClosed = "Нет"
+)
+
+// Plan сопоставляет записи с каталогом. Каталог не меняется
+func Plan(records []Record, x *Index, opts Options) []Item {
+	x.planned = make(map[string]int)
+	items := make([]Item, 0, len(records))
+	for _, rec := range records {
+		items = append(items, x.plan(rec, opts))
+	}
+	return items
+}
+
+func (x *Index) plan(rec Record, opts Options) (it Item) {
+	it.Record = rec
+	if rec.Error != "" {
+		it.Action = ACTION_INVALID
+		it.Notes = append(it.Notes, rec.Error)
+		return
+	}
+
+	category, ok := Category(rec.Category)
+	if !ok {
+		category, _ = Category(opts.Category)
+		if category == "" {
+			it.Action = ACTION_INVALID
+			it.Notes = append(it.Notes, "Не задан жанр, и жанр по умолчанию не выбран")
+			return
+		}
+		if rec.Category != "" {
+			it.Notes = append(it.Notes, fmt.Sprintf("Рубрика «%s» заменена жанром «%s»", rec.Category, category))
+		}
+		rec.Category = category
+	}
+	if rec.Access != "" {
+		access, ok := Access(rec.Access)
+		if !ok {
+			it.Notes = append(it.Notes, fmt.Sprintf("Неизвестное значение доступа «%s»", rec.Access))
+		}
+		rec.Access = access
+	}
+
+	existing, found := x.find(rec)
+	if found && opts.Rule != RULE_DUPLICATE {
+		it.Book = existing
+		// жанр, подставленный по умолчанию, не заменяет жанр книги каталога
+		if ok && string(it.Book.Category) != rec.Category {
+			it.Changes = append(it.Changes, "category")
+			setCategory(&it.Book, rec.Category)
+		}
+		// отличия только в регистре и пробелах изменением не считаются, кроме пути к файлу
+		for _, c := range []struct {
+			name string
+			from string
+			to   *string
+		}{
+			{"author", rec.Author, &it.Book.Author},
+			{"series", rec.Series, &it.Book.Series},
+			{"name", rec.Name, &it.Book.Name},
+			{"annotation", rec.Annotation, &it.Book.Annotation},
+			{"link", rec.Link, &it.Book.Link},
+			{"access", rec.Access, &it.Book.Access},
+		} {
+			differs := normalize(c.from) != normalize(*c.to)
+			if c.name == "link" {
+				differs = c.from != *c.to
+			}
+			if c.from != "" && differs {
+				it.Changes = append(it.Changes, c.name)
+				*c.to = c.from
+			}
+		}
+		switch {
+		case len(it.Changes) == 0:
+			it.Action = ACTION_MATCH
+		case opts.Rule == RULE_UPDATE:
+			it.Action = ACTION_UPDATE
+		default:
+			it.Action = ACTION_SKIP
+			it.Book = existing
+			it.Notes = append(it.Notes, "Книга уже есть в каталоге и отличается: "+strings.Join(it.Changes, ", "))
+		}
+		return
+	}
+
+	if rec.Author == "" || rec.Name == "" {
+		it.Action = ACTION_INVALID
+		it.Notes = append(it.Notes, "Нужны автор и название")
+		return
+	}
+	key := bookKey(rec.Author, rec.Name)
+	if line, ok := x.planned[key]; ok && opts.Rule != RULE_DUPLICATE {
+		it.Action = ACTION_SKIP
+		it.Notes = append(it.Notes, fmt.Sprintf("Повтор записи %d", line))
+		return
+	}
+	x.planned[key] = rec.Line
+
+	it.Action = ACTION_CREATE
+	it.Book = repository.Book{
+		Author: rec.Author, Series: rec.Series, Name: rec.Name,
+		Annotation: rec.Annotation, Link: rec.Link, Access: rec.Access,
+	}
+	setCategory(&it.Book, rec.Category)
+	if it.Book.Series == "" {
+		it.Book.Series = noSeries
+	}
+	switch {
+	case it.Book.Link == "" && it.Book.Access == accessOpen:
+		it.Notes = append(it.Notes, "Файла нет, доступ закрыт")
+		it.Book.Access = accessClosed
+	case it.Book.Link == "":
+		it.Book.Access = accessClosed
+	case it.Book.Access == "":
+		it.Book.Access, _ = Access(opts.Access)
+		if it.Book.Access == "" {
+			it.Book.Access = accessClosed
+		}
+	}
+	if found {
+		it.Notes = append(it.Notes, "Копия книги каталога")
+	}
+	return
+}
+
+// Category находит жанр каталога по названию без учета регистра
+func Category(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	for _, c := range repository.Categories {
+		if strings.EqualFold(string(c), s) {
+			return string(c), true
+		}
+	}
+	return "", false
+}
+
+// setCategory задает книге жанр каталога по его точному названию
+func setCategory(b *repository.Book, s string) {
+	for _, c := range repository.Categories {
+		if string(c) == s {
+			b.Category = c
+		}
+	}
+}
+
+// Access приводит отметку доступа источника к значениям каталога "Да" и "Нет"
+func Access(s string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "да", "yes", "true", "1", "открыт", "открытый доступ":
+		return accessOpen, true
+	case "нет", "no", "false", "0", "закрыт", "доступ ограничен":
+		return accessClosed, true
+	}
+	return "", false
+}
+
+// pickCategory выбирает из рубрик источника первую, совпавшую с жанром каталога, иначе первую непустую
+func pickCategory(subjects []string) string {
+	first := ""
+	for _, s := range subjects {
+		s = strings.TrimSpace(s)
+		if c, ok := Category(s); ok {
+			return c
+		}
+		if first == "" {
+			first = s
+		}
+	}
+	return first
+}
+
+// Apply выполняет план: создает и обновляет книги. done вызывается после каждой записанной книги,
+// old - книга до изменения, nil для новой. Ошибка останавливает импорт, уже записанные книги остаются
+func Apply(ctx context.Context, repo *repository.Repository, items []Item, done func(old *repository.Book, b repository.Book)) (s Summary, err error) {
+	s = make(Summary)
+	for _, it := range items {
+		b := it.Book
+		var old *repository.Book
+		switch it.Action {
+		case ACTION_CREATE:
+			b, err = repo.AddNewBook(ctx, string(b.Category), b.Author, b.Series, b.Name, b.Annotation, b.Link, b.Access, time.Now(), nil)
+		case ACTION_UPDATE:
+			var prev repository.Book
+			prev, err = repo.GetBookById(ctx, b.Book_Id.String())
+			if err == nil {
+				old = &prev
+				err = repo.PutBookById(ctx, b.Book_Id.String(), string(b.Category), b.Author, b.Series, b.Name, b.Annotation, b.Link, b.Access, b.Publication, b.UnpublishAt)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to import record %d: %w", it.Record.Line, err)
+			return
+		}
+		s[it.Action]++
+		if done != nil && (it.Action == ACTION_CREATE || it.Action == ACTION_UPDATE) {
+			done(old, b)
+		}
+	}
+	return
+}
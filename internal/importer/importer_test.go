@@ -0,0 +1,205 @@
+package importer
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/export"
+	"biblio/internal/repository"
+)
+
+var catalog = []repository.Book{
+	{
+		Book_Id: uuid.MustParse("6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a01"), Category: repository.CLASSIC, Author: "Пушкин А. С.",
+		Series: "-", Name: "Капитанская дочка", Annotation: "Повесть", Link: "books/daughter.txt", Access: "Да",
+		Publication: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		Book_Id: uuid.MustParse("6f1c1f64-3b55-4d7e-9a44-0c1f4f0d3a02"), Category: repository.FANTASY, Author: "Стругацкие",
+		Series: "Мир Полудня", Name: "Трудно быть богом", Annotation: "Роман", Link: "books/god.txt", Access: "Нет",
+		Publication: time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+func testIndex() *Index {
+	x := NewIndex()
+	for _, b := range catalog {
+		x.Add(b)
+	}
+	return x
+}
+
+func TestPlan(t *testing.T) {
+	records := []Record{
+		{Line: 1, Author: "пушкин  а. с.", Name: "Капитанская дочка"},
+		{Line: 2, Id: catalog[1].Book_Id.String(), Author: "Стругацкие", Name: "Трудно быть богом", Annotation: "Новая аннотация"},
+		{Line: 3, Author: "Гоголь", Name: "Нос", Category: "Повесть", Access: "да"},
+		{Line: 4, Author: "Гоголь", Name: "нос"},
+		{Line: 5, Name: "Без автора"},
+		{Line: 6, Error: "Запись не разобрана"},
+	}
+
+	cases := []struct {
+		rule    rule
+		actions []action
+	}{
+		{RULE_SKIP, []action{ACTION_MATCH, ACTION_SKIP, ACTION_CREATE, ACTION_SKIP, ACTION_INVALID, ACTION_INVALID}},
+		{RULE_UPDATE, []action{ACTION_MATCH, ACTION_UPDATE, ACTION_CREATE, ACTION_SKIP, ACTION_INVALID, ACTION_INVALID}},
+		{RULE_DUPLICATE, []action{ACTION_CREATE, ACTION_CREATE, ACTION_CREATE, ACTION_CREATE, ACTION_INVALID, ACTION_INVALID}},
+	}
+	for _, c := range cases {
+		x := testIndex()
+		// план можно строить повторно на том же каталоге
+		Plan(records, x, Options{Rule: c.rule, Category: string(repository.MODERN)})
+		items := Plan(records, x, Options{Rule: c.rule, Category: string(repository.MODERN)})
+		for i, it := range items {
+			if it.Action != c.actions[i] {
+				t.Errorf("%s: record %d: %s, want %s (%v)", c.rule, it.Record.Line, it.Action, c.actions[i], it.Notes)
+			}
+		}
+		if c.rule == RULE_UPDATE {
+			b := items[1].Book
+			if b.Book_Id != catalog[1].Book_Id || b.Annotation != "Новая аннотация" || b.Link != catalog[1].Link ||
+				b.Category != repository.FANTASY || strings.Join(items[1].Changes, ",") != "annotation" {
+				t.Errorf("update %+v %v", b, items[1].Changes)
+			}
+		}
+		if c.rule == RULE_SKIP && items[1].Book.Annotation != "Роман" {
+			t.Errorf("skipped book changed: %+v", items[1].Book)
+		}
+	}
+
+	items := Plan(records, testIndex(), Options{Rule: RULE_SKIP, Category: string(repository.MODERN)})
+	created := items[2].Book
+	// рубрика не совпала с жанром, файла нет - доступ закрывается
+	if created.Category != repository.MODERN || created.Series != "-" || created.Access != "Нет" || len(items[2].Notes) != 2 {
+		t.Errorf("created %+v %v", created, items[2].Notes)
+	}
+
+	items = Plan(records[2:3], testIndex(), Options{Rule: RULE_SKIP})
+	if items[0].Action != ACTION_INVALID {
+		t.Errorf("record without category: %s", items[0].Action)
+	}
+	if s := Summarize(Plan(records, testIndex(), Options{Rule: RULE_SKIP, Category: "Юмор"})); s[ACTION_SKIP] != 2 || s[ACTION_INVALID] != 2 {
+		t.Errorf("summary %v", s)
+	}
+}
+
+// Выгрузка каталога, загруженная обратно, совпадает с каталогом
+func TestExportRoundTrip(t *testing.T) {
+	for _, format := range []string{"marc21", "marcxml", "csv"} {
+		var buf bytes.Buffer
+		w, _ := export.NewWriter(format, &buf)
+		for _, b := range catalog {
+			w.Write(b)
+		}
+		w.Close()
+
+		var records []Record
+		var err error
+		switch format {
+		case "marc21":
+			records, err = ReadMARC(&buf)
+		case "marcxml":
+			records, err = ReadMARCXML(&buf)
+		case "csv":
+			columns, _ := CSVColumns(buf.Bytes())
+			records, err = ReadCSV(buf.Bytes(), GuessMapping(columns))
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(records) != len(catalog) {
+			t.Fatalf("%s: %d records", format, len(records))
+		}
+		for _, it := range Plan(records, testIndex(), Options{Rule: RULE_UPDATE}) {
+			if it.Action != ACTION_MATCH {
+				t.Errorf("%s: record %d: %s %v %v", format, it.Record.Line, it.Action, it.Changes, it.Notes)
+			}
+		}
+		if records[1].Series != "Мир Полудня" || records[1].Access != "Нет" || records[0].Id != catalog[0].Book_Id.String() {
+			t.Errorf("%s: %+v", format, records)
+		}
+	}
+}
+
+func TestReadMARCErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := export.NewWriter("marc21", &buf)
+	w.Write(catalog[0])
+	w.Close()
+	good := buf.Bytes()
+	data := append(append(append([]byte{}, good...), []byte("00010xxxxx\x1d")...), good[:len(good)-10]...)
+
+	records, err := ReadMARC(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Error != "" || records[1].Error == "" || records[2].Error == "" {
+		t.Errorf("records %+v", records)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	data := []byte("\xef\xbb\xbfНазвание;Автор;Жанр;Лишняя\n" +
+		"Нос;Гоголь;Классика;x\n" +
+		"\"Мертвые; души\";Гоголь;\"Роман, Классика\"\n" +
+		"Ревизор\n")
+	columns, err := CSVColumns(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := GuessMapping(columns)
+	if len(columns) != 4 || m["name"] != 0 || m["author"] != 1 || m["category"] != 2 || len(m) != 3 {
+		t.Fatalf("columns %q, mapping %v", columns, m)
+	}
+
+	records, err := ReadCSV(data, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("records %+v", records)
+	}
+	if r := records[1]; r.Line != 3 || r.Name != "Мертвые; души" || r.Category != "Классика" {
+		t.Errorf("record %+v", r)
+	}
+	if r := records[2]; r.Name != "Ревизор" || r.Author != "" {
+		t.Errorf("short row %+v", r)
+	}
+}
+
+func TestReadCalibre(t *testing.T) {
+	dir := filepath.Join("testdata", "calibre")
+	records, err := ReadCalibre(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("records %+v", records)
+	}
+
+	r := records[0]
+	if r.Name != "Трудно быть богом" || r.Author != "Аркадий Стругацкий, Борис Стругацкий" || r.Series != "Мир Полудня" ||
+		r.Category != "Фантастика" || r.Annotation != "Дон Румата & Арканар.\nВторая часть\nстрока" {
+		t.Errorf("record %+v", r)
+	}
+	if want := filepath.Join(dir, "Arkadii i Boris Strugatskie", "Trudno byt bogom (1)", "Trudno byt bogom - Arkadii i Boris Strugatskie.txt"); r.Link != want {
+		t.Errorf("link %q, want %q", r.Link, want)
+	}
+	if r := records[1]; r.Category != "Классика" || !strings.HasSuffix(r.Link, ".mobi") || r.Annotation != "" {
+		t.Errorf("record %+v", r)
+	}
+	if r := records[2]; r.Link != "" || r.Category != "" || r.Series != "" {
+		t.Errorf("record %+v", r)
+	}
+
+	if _, err = ReadCalibre(t.TempDir()); err == nil {
+		t.Error("empty directory read without error")
+	}
+}
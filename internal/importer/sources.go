@@ -0,0 +1,218 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"biblio/internal/marc"
+)
+
+// Formats - источники импорта и их названия для форм
+var Formats = []struct {
+	Name  string
+	Title string
+}{
+	{"marc21", "MARC21 (ISO 2709)"},
+	{"marcxml", "MARCXML"},
+	{"csv", "CSV"},
+	{"calibre", "Библиотека Calibre"},
+}
+
+// ReadMARC читает записи ISO 2709. Испорченная запись попадает в результат с ошибкой,
+// и чтение продолжается со следующей
+func ReadMARC(r io.Reader) ([]Record, error) {
+	return readMARC(marc.NewReader(r).Read)
+}
+
+// ReadMARCXML читает коллекцию MARCXML. После ошибки разметки дальше файл не читается
+func ReadMARCXML(r io.Reader) ([]Record, error) {
+	return readMARC(marc.NewXMLReader(r).Read)
+}
+
+func readMARC(read func() (*marc.Record, error)) (records []Record, err error) {
+	for n := 1; ; n++ {
+		r, err := read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if errors.Is(err, marc.ErrFormat) || errors.Is(err, marc.ErrEncoding) {
+			records = append(records, Record{Line: n, Error: "Запись не разобрана: " + err.Error()})
+			continue
+		}
+		if err != nil {
+			records = append(records, Record{Line: n, Error: "Файл не дочитан: " + err.Error()})
+			return records, nil
+		}
+		records = append(records, fromMARC(n, r))
+	}
+}
+
+// fromMARC - обратное отображение export.Record: 001 - идентификатор, 100 (110, 700) $a - автор,
+// 245 $a и $b - название, 490 (830) $a - серия, 520 $a - аннотация, 655 и 650 $a - рубрики,
+// первый индикатор 506 - доступ
+func fromMARC(n int, r *marc.Record) Record {
+	rec := Record{Line: n}
+	if f, ok := r.Field("001"); ok {
+		rec.Id = strings.TrimSpace(f.Value)
+	}
+	rec.Author = firstSubfield(r, 'a', "100", "110", "700")
+	if f, ok := r.Field("245"); ok {
+		rec.Name = trimISBD(f.Get('a'))
+		if sub := trimISBD(f.Get('b')); sub != "" {
+			rec.Name += ": " + sub
+		}
+	}
+	rec.Series = firstSubfield(r, 'a', "490", "830")
+	if f, ok := r.Field("520"); ok {
+		rec.Annotation = strings.TrimSpace(f.Get('a'))
+	}
+
+	var subjects []string
+	for _, tag := range []string{"655", "650"} {
+		for _, f := range r.All(tag) {
+			subjects = append(subjects, trimISBD(f.Get('a')))
+		}
+	}
+	rec.Category = pickCategory(subjects)
+
+	if f, ok := r.Field("506"); ok {
+		switch f.Ind1 {
+		case '0':
+			rec.Access = accessOpen
+		case '1':
+			rec.Access = accessClosed
+		}
+	}
+	return rec
+}
+
+func firstSubfield(r *marc.Record, code byte, tags ...string) string {
+	for _, tag := range tags {
+		if f, ok := r.Field(tag); ok {
+			if v := trimISBD(f.Get(code)); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// trimISBD убирает знаки предписанной пунктуации ISBD в конце подполя: " /", " :", " ;", " =", ","
+func trimISBD(s string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), " /:;=,"))
+}
+
+// Fields - поля книги, которым можно сопоставить колонки CSV
+var Fields = []string{"book_id", "category", "author", "series", "name", "annotation", "link", "access"}
+
+// Mapping - номер колонки CSV для поля книги. Поля без колонки источник не задает
+type Mapping map[string]int
+
+// columnAliases - заголовки колонок, по которым поле угадывается: названия полей выгрузки,
+// русские подписи и колонки выгрузки Calibre
+var columnAliases = map[string][]string{
+	"book_id":    {"book_id", "id", "uuid", "идентификатор"},
+	"category":   {"category", "жанр", "genre", "tags", "рубрика", "subject"},
+	"author":     {"author", "автор", "authors", "creator"},
+	"series":     {"series", "серия"},
+	"name":       {"name", "название", "title", "заглавие"},
+	"annotation": {"annotation", "аннотация", "описание", "comments", "description"},
+	"link":       {"link", "ссылка", "файл", "file", "path"},
+	"access":     {"access", "доступ"},
+}
+
+// CSVColumns возвращает заголовки колонок
+func CSVColumns(data []byte) ([]string, error) {
+	header, err := csvReader(data).Read()
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// GuessMapping сопоставляет полям колонки с известными заголовками
+func GuessMapping(columns []string) Mapping {
+	m := make(Mapping)
+	for field, aliases := range columnAliases {
+		for i, c := range columns {
+			c = strings.ToLower(strings.TrimSpace(c))
+			for _, alias := range aliases {
+				if _, ok := m[field]; !ok && c == alias {
+					m[field] = i
+				}
+			}
+		}
+	}
+	return m
+}
+
+// ReadCSV читает строки CSV после заголовка. Line записи - номер строки файла
+func ReadCSV(data []byte, m Mapping) (records []Record, err error) {
+	r := csvReader(data)
+	_, err = r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				line = pe.Line
+			}
+			records = append(records, Record{Line: line, Error: "Строка не разобрана: " + err.Error()})
+			if pe == nil {
+				return records, nil
+			}
+			continue
+		}
+
+		get := func(field string) string {
+			i, ok := m[field]
+			if !ok || i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		rec := Record{
+			Line: line, Id: get("book_id"), Author: get("author"), Series: get("series"), Name: get("name"),
+			Annotation: get("annotation"), Link: get("link"), Access: get("access"),
+		}
+		// в выгрузке Calibre рубрики перечислены через запятую
+		rec.Category = get("category")
+		if _, ok := Category(rec.Category); !ok && strings.Contains(rec.Category, ",") {
+			rec.Category = pickCategory(strings.Split(rec.Category, ","))
+		}
+		records = append(records, rec)
+	}
+}
+
+// csvReader читает CSV с разделителем из первой строки: запятая, точка с запятой (так сохраняет
+// русский Excel) или табуляция. Метка порядка байтов UTF-8 пропускается
+func csvReader(data []byte) *csv.Reader {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	first := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		first = data[:i]
+	}
+	comma, best := ',', bytes.Count(first, []byte{','})
+	for _, c := range []rune{';', '\t'} {
+		if n := bytes.Count(first, []byte(string(c))); n > best {
+			comma, best = c, n
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return r
+}
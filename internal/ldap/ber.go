@@ -0,0 +1,187 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// классы тегов BER
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+)
+
+// универсальные теги, которые встречаются в LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10
+	tagSet         = 0x11
+)
+
+// максимальный размер одного сообщения, чтобы сервер не мог заставить выделить много памяти
+const maxPacketSize = 16 << 20
+
+// packet - элемент BER: примитивный со значением или составной с дочерними элементами
+type packet struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSequence, children: children}
+}
+
+func newConstructed(class, tag byte, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+func newString(class, tag byte, s string) *packet {
+	return &packet{class: class, tag: tag, value: []byte(s)}
+}
+
+func newOctetString(s string) *packet {
+	return newString(classUniversal, tagOctetString, s)
+}
+
+func newInteger(class, tag byte, v int64) *packet {
+	// минимальное дополнительное представление
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v < 0x80 && v >= -0x80) || len(b) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return &packet{class: class, tag: tag, value: b}
+}
+
+func newBoolean(v bool) *packet {
+	b := byte(0)
+	if v {
+		b = 0xff
+	}
+	return &packet{class: classUniversal, tag: tagBoolean, value: []byte{b}}
+}
+
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+
+	id := p.class | p.tag
+	if p.constructed {
+		id |= 0x20
+	}
+	out := []byte{id}
+
+	n := len(content)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var l []byte
+		for ; n > 0; n >>= 8 {
+			l = append([]byte{byte(n)}, l...)
+		}
+		out = append(out, 0x80|byte(len(l)))
+		out = append(out, l...)
+	}
+	return append(out, content...)
+}
+
+func (p *packet) int() int64 {
+	var v int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+func (p *packet) child(i int) (*packet, error) {
+	if i >= len(p.children) {
+		return nil, fmt.Errorf("ldap: malformed packet: no element %d", i)
+	}
+	return p.children[i], nil
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	p, _, err := readElement(r, maxPacketSize)
+	return p, err
+}
+
+func readElement(r *bufio.Reader, limit int) (p *packet, read int, err error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	if id&0x1f == 0x1f {
+		return nil, 0, errors.New("ldap: multi-byte tags are not supported")
+	}
+	p = &packet{class: id & 0xc0, constructed: id&0x20 != 0, tag: id & 0x1f}
+
+	lb, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	read = 2
+	length := int(lb)
+	if lb&0x80 != 0 {
+		n := int(lb & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, 0, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, e := r.ReadByte()
+			if e != nil {
+				return nil, 0, e
+			}
+			length = length<<8 | int(b)
+		}
+		read += n
+	}
+	if length > limit {
+		return nil, 0, errors.New("ldap: packet too large")
+	}
+	read += length
+
+	if !p.constructed {
+		p.value = make([]byte, length)
+		_, err = io.ReadFull(r, p.value)
+		return
+	}
+
+	for length > 0 {
+		c, n, e := readElement(r, length)
+		if e != nil {
+			return nil, 0, e
+		}
+		p.children = append(p.children, c)
+		length -= n
+	}
+	if length < 0 {
+		return nil, 0, errors.New("ldap: malformed packet length")
+	}
+	return
+}
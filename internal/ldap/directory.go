@@ -0,0 +1,215 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Config struct {
+	URL string
+	// StartTLS включает TLS на соединении ldap:// до первого bind; для ldaps:// не нужен
+	StartTLS bool
+	// TLSConfig - настройки проверки сертификата сервера для StartTLS, nil - системные
+	TLSConfig    *tls.Config
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter - фильтр поиска сотрудника, %s заменяется экранированным логином
+	UserFilter string
+	LoginAttr  string
+	NameAttr   string
+	MailAttr   string
+	// GroupAttr - атрибут записи пользователя со списком групп (memberOf).
+	// Если задан GroupFilter, группы ищутся отдельно в GroupBaseDN, %s заменяется DN пользователя
+	GroupAttr   string
+	GroupBaseDN string
+	GroupFilter string
+	// ActiveFilter - дополнительное условие, которому должна соответствовать активная запись,
+	// например (!(nsAccountLock=TRUE))
+	ActiveFilter string
+}
+
+// Account - сотрудник из каталога
+type Account struct {
+	DN       string
+	Login    string
+	FullName string
+	Email    string
+	// Groups содержит и полные DN групп, и значение первого RDN (обычно cn)
+	Groups []string
+	Active bool
+}
+
+type Directory struct {
+	cfg Config
+}
+
+func NewDirectory(cfg Config) *Directory {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.LoginAttr == "" {
+		cfg.LoginAttr = "uid"
+	}
+	if cfg.NameAttr == "" {
+		cfg.NameAttr = "cn"
+	}
+	if cfg.MailAttr == "" {
+		cfg.MailAttr = "mail"
+	}
+	if cfg.GroupAttr == "" && cfg.GroupFilter == "" {
+		cfg.GroupAttr = "memberOf"
+	}
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	return &Directory{cfg: cfg}
+}
+
+// connect открывает соединение от имени служебной учетной записи
+func (d *Directory) connect(ctx context.Context) (c *Conn, err error) {
+	c, err = Dial(ctx, d.cfg.URL)
+	if err != nil {
+		return
+	}
+	if d.cfg.StartTLS {
+		err = c.StartTLS(d.cfg.TLSConfig)
+		if err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	if d.cfg.BindDN != "" {
+		err = c.Bind(d.cfg.BindDN, d.cfg.BindPassword)
+		if err != nil {
+			c.Close()
+			err = fmt.Errorf("ldap: service bind failed: %w", err)
+			return nil, err
+		}
+	}
+	return
+}
+
+// Authenticate находит сотрудника по логину и проверяет пароль повторным bind от его имени
+func (d *Directory) Authenticate(ctx context.Context, login, password string) (a Account, err error) {
+	if password == "" {
+		err = ErrInvalidCredentials
+		return
+	}
+
+	c, err := d.connect(ctx)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	a, err = d.find(c, login)
+	if err != nil {
+		return
+	}
+
+	err = c.Bind(a.DN, password)
+	if err != nil {
+		return
+	}
+
+	// после bind от имени пользователя служебные права могут быть нужны для поиска групп
+	if d.cfg.BindDN != "" {
+		err = c.Bind(d.cfg.BindDN, d.cfg.BindPassword)
+		if err != nil {
+			return
+		}
+	}
+	return d.complete(c, a)
+}
+
+// Lookup ищет сотрудника без проверки пароля, для синхронизации
+func (d *Directory) Lookup(ctx context.Context, login string) (a Account, err error) {
+	c, err := d.connect(ctx)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	a, err = d.find(c, login)
+	if err != nil {
+		return
+	}
+	return d.complete(c, a)
+}
+
+func (d *Directory) find(c *Conn, login string) (a Account, err error) {
+	filter := strings.ReplaceAll(d.cfg.UserFilter, "%s", EscapeFilter(login))
+	attrs := []string{d.cfg.LoginAttr, d.cfg.NameAttr, d.cfg.MailAttr}
+	if d.cfg.GroupAttr != "" {
+		attrs = append(attrs, d.cfg.GroupAttr)
+	}
+
+	entries, err := c.Search(d.cfg.BaseDN, ScopeSubtree, filter, attrs)
+	if err != nil {
+		return
+	}
+	switch {
+	case len(entries) == 0:
+		err = ErrNoSuchUser
+		return
+	case len(entries) > 1:
+		err = errors.New("ldap: user filter matches several entries")
+		return
+	}
+
+	e := entries[0]
+	a = Account{
+		DN:       e.DN,
+		Login:    e.Get(d.cfg.LoginAttr),
+		FullName: e.Get(d.cfg.NameAttr),
+		Email:    e.Get(d.cfg.MailAttr),
+		Active:   true,
+	}
+	if a.Login == "" {
+		a.Login = login
+	}
+	if d.cfg.GroupAttr != "" {
+		a.Groups = groupNames(e.GetAll(d.cfg.GroupAttr))
+	}
+	return
+}
+
+// complete дочитывает группы и признак активности
+func (d *Directory) complete(c *Conn, a Account) (Account, error) {
+	if d.cfg.GroupFilter != "" {
+		filter := strings.ReplaceAll(d.cfg.GroupFilter, "%s", EscapeFilter(a.DN))
+		entries, err := c.Search(d.cfg.GroupBaseDN, ScopeSubtree, filter, []string{"cn"})
+		if err != nil {
+			return a, err
+		}
+		var dns []string
+		for _, e := range entries {
+			dns = append(dns, e.DN)
+		}
+		a.Groups = groupNames(dns)
+	}
+
+	if d.cfg.ActiveFilter != "" {
+		entries, err := c.Search(a.DN, ScopeBase, d.cfg.ActiveFilter, []string{"1.1"})
+		if err != nil {
+			return a, err
+		}
+		a.Active = len(entries) == 1
+	}
+	return a, nil
+}
+
+func groupNames(dns []string) (groups []string) {
+	for _, dn := range dns {
+		groups = append(groups, dn)
+		first, _, _ := strings.Cut(dn, ",")
+		if _, value, ok := strings.Cut(first, "="); ok && value != dn {
+			groups = append(groups, value)
+		}
+	}
+	return
+}
@@ -0,0 +1,171 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// теги фильтров поиска (RFC 4511, 4.5.1)
+const (
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEqualityMatch  = 3
+	filterSubstrings     = 4
+	filterGreaterOrEqual = 5
+	filterLessOrEqual    = 6
+	filterPresent        = 7
+	filterApproxMatch    = 8
+)
+
+// EscapeFilter экранирует значение для подстановки в фильтр, например логин пользователя
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter переводит строковый фильтр RFC 4515 в BER.
+// Поддерживаются &, |, !, =, >=, <=, ~=, проверка наличия (attr=*) и подстроки (attr=a*b*c)
+func compileFilter(s string) (*packet, error) {
+	p, rest, err := parseFilter(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return p, nil
+}
+
+func parseFilter(s string) (p *packet, rest string, err error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unexpected end of filter")
+	}
+
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		p = newConstructed(classContext, tag)
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			var c *packet
+			c, s, err = parseFilter(s)
+			if err != nil {
+				return
+			}
+			p.children = append(p.children, c)
+		}
+	case '!':
+		var c *packet
+		c, s, err = parseFilter(s[1:])
+		if err != nil {
+			return
+		}
+		p = newConstructed(classContext, filterNot, c)
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("ldap: unterminated filter %q", s)
+		}
+		p, err = parseItem(s[:end])
+		if err != nil {
+			return
+		}
+		s = s[end:]
+	}
+
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", fmt.Errorf("ldap: missing ')' in filter")
+	}
+	return p, s[1:], nil
+}
+
+func parseItem(s string) (*packet, error) {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", s)
+	}
+	attr, value := s[:eq], s[eq+1:]
+
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApproxMatch, attr[:len(attr)-1]
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return newString(classContext, filterPresent, attr), nil
+	}
+
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := newSequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeFilter(part)
+			if err != nil {
+				return nil, err
+			}
+			kind := byte(1)
+			switch i {
+			case 0:
+				kind = 0
+			case len(parts) - 1:
+				kind = 2
+			}
+			subs.children = append(subs.children, newString(classContext, kind, v))
+		}
+		return newConstructed(classContext, filterSubstrings, newOctetString(attr), subs), nil
+	}
+
+	v, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return newConstructed(classContext, tag, newOctetString(attr), newOctetString(v)), nil
+}
+
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", s)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,311 @@
+// Package ldap - минимальный клиент LDAP v3 для проверки сотрудников по каталогу:
+// простой bind и поиск. Протокол реализован здесь же, чтобы не тянуть тяжелые зависимости.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// коды результата (RFC 4511, 4.1.9), которые разбираются отдельно
+const (
+	resultSuccess            = 0
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+)
+
+// номера операций (RFC 4511, 4.2 - 4.5)
+const (
+	opBindRequest     = 0
+	opBindResponse    = 1
+	opUnbindRequest   = 2
+	opSearchRequest   = 3
+	opSearchEntry     = 4
+	opSearchDone      = 5
+	opSearchReference = 19
+	opExtendedRequest = 23
+	opExtendedResp    = 24
+)
+
+// oidStartTLS - расширенная операция StartTLS (RFC 4511, 4.14)
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+const (
+	ScopeBase    = 0
+	ScopeOne     = 1
+	ScopeSubtree = 2
+)
+
+var (
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+	ErrNoSuchUser         = errors.New("ldap: no such user")
+)
+
+// ResultError - ответ сервера с кодом ошибки
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get возвращает первое значение атрибута; имена атрибутов сравниваются без учета регистра
+func (e Entry) Get(name string) string {
+	if v := e.GetAll(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (e Entry) GetAll(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+type Conn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int64
+	// host - имя сервера из адреса, с ним сверяется сертификат
+	host string
+}
+
+// Dial подключается по адресу вида ldap://host:389 или ldaps://host:636
+func Dial(ctx context.Context, rawURL string) (c *Conn, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		err = fmt.Errorf("ldap: invalid url: %w", err)
+		return
+	}
+
+	host := u.Host
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = d.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = (&tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	default:
+		err = fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	return &Conn{conn: conn, r: bufio.NewReader(conn), host: u.Hostname()}, nil
+}
+
+// StartTLS переводит открытое соединение ldap:// на TLS до того, как по нему пойдут пароли.
+// cfg может быть nil, тогда сертификат проверяется по системным корневым сертификатам и имени сервера
+func (c *Conn) StartTLS(cfg *tls.Config) error {
+	id, err := c.send(newConstructed(classApplication, opExtendedRequest, newString(classContext, 0, oidStartTLS)))
+	if err != nil {
+		return fmt.Errorf("ldap: failed to send starttls: %w", err)
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opExtendedResp {
+		return errors.New("ldap: unexpected response to starttls")
+	}
+	if err = result(op); err != nil {
+		return fmt.Errorf("ldap: starttls refused: %w", err)
+	}
+
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = c.host
+	}
+	conn := tls.Client(c.conn, cfg)
+	if err = conn.Handshake(); err != nil {
+		return fmt.Errorf("ldap: tls handshake failed: %w", err)
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+func (c *Conn) Close() error {
+	c.send(&packet{class: classApplication, tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+func (c *Conn) send(op *packet) (id int64, err error) {
+	c.msgID++
+	msg := newSequence(newInteger(classUniversal, tagInteger, c.msgID), op)
+	_, err = c.conn.Write(msg.bytes())
+	return c.msgID, err
+}
+
+// receive читает ответ на запрос id и возвращает саму операцию
+func (c *Conn) receive(id int64) (*packet, error) {
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: failed to read response: %w", err)
+		}
+		if len(msg.children) < 2 {
+			return nil, errors.New("ldap: malformed response")
+		}
+		if msg.children[0].int() != id {
+			continue
+		}
+		return msg.children[1], nil
+	}
+}
+
+func result(op *packet) error {
+	code, err := op.child(0)
+	if err != nil {
+		return err
+	}
+	if code.int() == resultSuccess {
+		return nil
+	}
+	e := &ResultError{Code: code.int()}
+	if d, err := op.child(2); err == nil {
+		e.Message = d.str()
+	}
+	if e.Code == resultInvalidCredentials {
+		return fmt.Errorf("%w: %s", ErrInvalidCredentials, e.Message)
+	}
+	return e
+}
+
+// Bind выполняет простую аутентификацию. Пустой пароль запрещен: сервер принял бы его
+// как анонимный вход и ответил бы успехом для любого DN
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	id, err := c.send(newConstructed(classApplication, opBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newOctetString(dn),
+		newString(classContext, 0, password),
+	))
+	if err != nil {
+		return fmt.Errorf("ldap: failed to send bind: %w", err)
+	}
+
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return errors.New("ldap: unexpected response to bind")
+	}
+	return result(op)
+}
+
+func (c *Conn) Search(baseDN string, scope int, filter string, attributes []string) (entries []Entry, err error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return
+	}
+	attrs := newSequence()
+	for _, a := range attributes {
+		attrs.children = append(attrs.children, newOctetString(a))
+	}
+
+	id, err := c.send(newConstructed(classApplication, opSearchRequest,
+		newOctetString(baseDN),
+		newInteger(classUniversal, tagEnumerated, int64(scope)),
+		newInteger(classUniversal, tagEnumerated, 0),
+		newInteger(classUniversal, tagInteger, 0),
+		newInteger(classUniversal, tagInteger, 0),
+		newBoolean(false),
+		f,
+		attrs,
+	))
+	if err != nil {
+		err = fmt.Errorf("ldap: failed to send search: %w", err)
+		return
+	}
+
+	for {
+		var op *packet
+		op, err = c.receive(id)
+		if err != nil {
+			return
+		}
+		switch op.tag {
+		case opSearchEntry:
+			var e Entry
+			e, err = parseEntry(op)
+			if err != nil {
+				return
+			}
+			entries = append(entries, e)
+		case opSearchReference:
+			// ссылки на другие серверы не обрабатываются
+		case opSearchDone:
+			err = result(op)
+			var re *ResultError
+			if errors.As(err, &re) && re.Code == resultNoSuchObject {
+				err = nil
+			}
+			return
+		default:
+			err = errors.New("ldap: unexpected response to search")
+			return
+		}
+	}
+}
+
+func parseEntry(op *packet) (e Entry, err error) {
+	dn, err := op.child(0)
+	if err != nil {
+		return
+	}
+	attrs, err := op.child(1)
+	if err != nil {
+		return
+	}
+
+	e = Entry{DN: dn.str(), Attributes: make(map[string][]string)}
+	for _, a := range attrs.children {
+		if len(a.children) < 2 {
+			continue
+		}
+		name := a.children[0].str()
+		for _, v := range a.children[1].children {
+			e.Attributes[name] = append(e.Attributes[name], v.str())
+		}
+	}
+	return
+}
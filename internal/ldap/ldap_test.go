@@ -0,0 +1,339 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testServer - LDAP-сервер в памяти: понимает простой bind и поиск с фильтрами.
+// Как и настоящие серверы, принимает анонимный bind с пустым паролем
+type testServer struct {
+	ln      net.Listener
+	entries map[string]map[string][]string
+	// tls - если задан, сервер принимает StartTLS с этими настройками
+	tls *tls.Config
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{ln: ln, entries: map[string]map[string][]string{
+		"cn=service,dc=lib,dc=test": {"cn": {"service"}, "userPassword": {"svc"}},
+		"uid=ivanov,ou=people,dc=lib,dc=test": {
+			"objectClass":  {"person"},
+			"uid":          {"ivanov"},
+			"cn":           {"Иван Иванов"},
+			"mail":         {"ivanov@lib.test"},
+			"userPassword": {"secret"},
+			"memberOf":     {"cn=library-admins,ou=groups,dc=lib,dc=test", "cn=staff,ou=groups,dc=lib,dc=test"},
+		},
+		"uid=petrov,ou=people,dc=lib,dc=test": {
+			"objectClass":   {"person"},
+			"uid":           {"petrov"},
+			"cn":            {"Петр Петров"},
+			"userPassword":  {"qwerty"},
+			"nsAccountLock": {"TRUE"},
+		},
+		"cn=readers,ou=groups,dc=lib,dc=test": {
+			"objectClass": {"groupOfNames"},
+			"cn":          {"readers"},
+			"member":      {"uid=petrov,ou=people,dc=lib,dc=test"},
+		},
+	}}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(id int64, op *packet) {
+		conn.Write(newSequence(newInteger(classUniversal, tagInteger, id), op).bytes())
+	}
+	done := func(tag byte, code int64, msg string) *packet {
+		return newConstructed(classApplication, tag,
+			newInteger(classUniversal, tagEnumerated, code), newOctetString(""), newOctetString(msg))
+	}
+
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.children[0].int(), msg.children[1]
+
+		switch op.tag {
+		case opUnbindRequest:
+			return
+		case opExtendedRequest:
+			if s.tls == nil || op.children[0].str() != oidStartTLS {
+				reply(id, done(opExtendedResp, 2, "unsupported extended operation"))
+				continue
+			}
+			reply(id, done(opExtendedResp, resultSuccess, ""))
+			conn = tls.Server(conn, s.tls)
+			r = bufio.NewReader(conn)
+		case opBindRequest:
+			dn, password := op.children[1].str(), op.children[2].str()
+			e, ok := s.entries[dn]
+			switch {
+			case password == "":
+				reply(id, done(opBindResponse, resultSuccess, ""))
+			case ok && len(e["userPassword"]) > 0 && e["userPassword"][0] == password:
+				reply(id, done(opBindResponse, resultSuccess, ""))
+			default:
+				reply(id, done(opBindResponse, resultInvalidCredentials, "invalid credentials"))
+			}
+		case opSearchRequest:
+			base, scope, filter := op.children[0].str(), op.children[1].int(), op.children[6]
+			var want []string
+			for _, a := range op.children[7].children {
+				want = append(want, a.str())
+			}
+			for dn, attrs := range s.entries {
+				if !inScope(dn, base, scope) || !match(filter, attrs) {
+					continue
+				}
+				list := newSequence()
+				for name, values := range attrs {
+					if !wanted(name, want) {
+						continue
+					}
+					set := &packet{class: classUniversal, constructed: true, tag: tagSet}
+					for _, v := range values {
+						set.children = append(set.children, newOctetString(v))
+					}
+					list.children = append(list.children, newSequence(newOctetString(name), set))
+				}
+				reply(id, newConstructed(classApplication, opSearchEntry, newOctetString(dn), list))
+			}
+			reply(id, done(opSearchDone, resultSuccess, ""))
+		}
+	}
+}
+
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case ScopeBase:
+		return strings.EqualFold(dn, base)
+	case ScopeOne:
+		_, parent, _ := strings.Cut(dn, ",")
+		return strings.EqualFold(parent, base)
+	}
+	return strings.HasSuffix(strings.ToLower(dn), strings.ToLower(base))
+}
+
+func wanted(name string, want []string) bool {
+	if name == "userPassword" {
+		return false
+	}
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		if strings.EqualFold(w, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func values(attrs map[string][]string, name string) []string {
+	for k, v := range attrs {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func match(f *packet, attrs map[string][]string) bool {
+	switch f.tag {
+	case filterAnd:
+		for _, c := range f.children {
+			if !match(c, attrs) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if match(c, attrs) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !match(f.children[0], attrs)
+	case filterPresent:
+		return len(values(attrs, f.str())) > 0
+	case filterEqualityMatch:
+		for _, v := range values(attrs, f.children[0].str()) {
+			if strings.EqualFold(v, f.children[1].str()) {
+				return true
+			}
+		}
+		return false
+	case filterSubstrings:
+		for _, v := range values(attrs, f.children[0].str()) {
+			v = strings.ToLower(v)
+			ok := true
+			for _, sub := range f.children[1].children {
+				part := strings.ToLower(sub.str())
+				switch sub.tag {
+				case 0:
+					ok = ok && strings.HasPrefix(v, part)
+				case 1:
+					ok = ok && strings.Contains(v, part)
+				case 2:
+					ok = ok && strings.HasSuffix(v, part)
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func testConfig(s *testServer) Config {
+	return Config{
+		URL:          s.url(),
+		BindDN:       "cn=service,dc=lib,dc=test",
+		BindPassword: "svc",
+		BaseDN:       "dc=lib,dc=test",
+		UserFilter:   "(&(objectClass=person)(uid=%s))",
+		ActiveFilter: "(!(nsAccountLock=TRUE))",
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := NewDirectory(testConfig(newTestServer(t)))
+
+	a, err := d.Authenticate(context.Background(), "ivanov", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Login != "ivanov" || a.FullName != "Иван Иванов" || a.Email != "ivanov@lib.test" || !a.Active {
+		t.Fatalf("unexpected account %+v", a)
+	}
+	found := false
+	for _, g := range a.Groups {
+		found = found || g == "library-admins"
+	}
+	if !found {
+		t.Fatalf("groups %v do not contain library-admins", a.Groups)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	d := NewDirectory(testConfig(newTestServer(t)))
+
+	for name, c := range map[string]struct {
+		login, password string
+		want            error
+	}{
+		"wrong password": {"ivanov", "nope", ErrInvalidCredentials},
+		"empty password": {"ivanov", "", ErrInvalidCredentials},
+		"unknown user":   {"sidorov", "secret", ErrNoSuchUser},
+		"wildcard login": {"*", "secret", ErrNoSuchUser},
+		"filter escape":  {"x)(uid=ivanov", "secret", ErrNoSuchUser},
+	} {
+		if _, err := d.Authenticate(context.Background(), c.login, c.password); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, c.want)
+		}
+	}
+}
+
+func TestLookupActiveAndGroupSearch(t *testing.T) {
+	cfg := testConfig(newTestServer(t))
+	cfg.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	d := NewDirectory(cfg)
+
+	a, err := d.Lookup(context.Background(), "petrov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Active {
+		t.Error("locked account reported as active")
+	}
+	if len(a.Groups) != 2 || a.Groups[1] != "readers" {
+		t.Errorf("groups = %v, want readers", a.Groups)
+	}
+}
+
+func TestServiceBindFailure(t *testing.T) {
+	cfg := testConfig(newTestServer(t))
+	cfg.BindPassword = "wrong"
+
+	if _, err := NewDirectory(cfg).Lookup(context.Background(), "ivanov"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want invalid credentials", err)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	s := newTestServer(t)
+	s.tls = ts.TLS
+	cfg := testConfig(s)
+	cfg.StartTLS = true
+	cfg.TLSConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+	if _, err := NewDirectory(cfg).Authenticate(context.Background(), "ivanov", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// сервер без StartTLS: пароль по открытому соединению не отправляется
+	cfg = testConfig(newTestServer(t))
+	cfg.StartTLS = true
+	if _, err := NewDirectory(cfg).Authenticate(context.Background(), "ivanov", "secret"); err == nil {
+		t.Fatal("authenticated without tls")
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	for _, f := range []string{
+		"(uid=ivanov)",
+		"(&(objectClass=person)(|(uid=a)(mail=b*)))",
+		"(!(nsAccountLock=TRUE))",
+		"(cn=*Иван*)",
+		"(uid=\\2a)",
+		"(createTimestamp>=20240101000000Z)",
+	} {
+		if _, err := compileFilter(f); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+	for _, f := range []string{"uid=ivanov", "(uid=ivanov", "(&(uid=a)", "(=x)", "(uid=\\2)"} {
+		if _, err := compileFilter(f); err == nil {
+			t.Errorf("%s: expected an error", f)
+		}
+	}
+}
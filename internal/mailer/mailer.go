@@ -0,0 +1,122 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer отправляет письма пользователям: подтверждение адреса, сброс пароля
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// FromEnv выбирает реализацию по переменным окружения:
+// MAIL_SMTP_ADDR - адрес SMTP-сервера (например localhost:1025 для MailHog),
+// MAIL_FROM, MAIL_USER, MAIL_PASSWORD - отправитель и учетные данные,
+// MAIL_FILE - файл, куда складываются письма, если SMTP не настроен.
+// Без настроек письма пишутся в стандартный лог.
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "biblio@localhost"
+	}
+
+	if addr := os.Getenv("MAIL_SMTP_ADDR"); addr != "" {
+		return NewSMTPMailer(addr, from, os.Getenv("MAIL_USER"), os.Getenv("MAIL_PASSWORD"))
+	}
+
+	return NewFileMailer(os.Getenv("MAIL_FILE"), from)
+}
+
+type SMTPMailer struct {
+	addr     string
+	from     string
+	user     string
+	password string
+}
+
+func NewSMTPMailer(addr, from, user, password string) *SMTPMailer {
+	return &SMTPMailer{addr: addr, from: from, user: user, password: password}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) (err error) {
+	var auth smtp.Auth
+	if m.user != "" {
+		host, _, _ := net.SplitHostPort(m.addr)
+		auth = smtp.PlainAuth("", m.user, m.password, host)
+	}
+
+	err = smtp.SendMail(m.addr, auth, m.from, []string{to}, message(m.from, to, subject, body))
+	if err != nil {
+		err = fmt.Errorf("failed to send mail: %w", err)
+		return
+	}
+
+	return
+}
+
+// FileMailer не отправляет письма, а дописывает их в файл или в лог - для разработки и тестов
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, to, subject, body string) (err error) {
+	msg := message(m.from, to, subject, body)
+
+	if m.path == "" {
+		log.Printf("mail:\n%s", msg)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		err = fmt.Errorf("failed to open mail file: %w", err)
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(msg, '\n'))
+	if err != nil {
+		err = fmt.Errorf("failed to write mail: %w", err)
+		return
+	}
+
+	return
+}
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + headerValue(to) + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", headerValue(subject)) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// headerValue не дает подставить в заголовок письма перевод строки
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	m := NewFileMailer(path, "biblio@test")
+
+	err := m.Send(context.Background(), "reader@test", "Смена пароля", "Ссылка:\nhttp://biblio/reset")
+	if err == nil {
+		err = m.Send(context.Background(), "second@test\r\nBcc: evil@test", "Второе", "тело")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"From: biblio@test\r\n",
+		"To: reader@test\r\n",
+		"Subject: =?UTF-8?b?",
+		"\r\n\r\nСсылка:\r\nhttp://biblio/reset\r\n",
+		"To: second@testBcc: evil@test\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("mail file does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\r\nBcc:") {
+		t.Error("header injected through recipient")
+	}
+}
@@ -0,0 +1,296 @@
+// Package marc читает и записывает библиографические записи MARC21 в двоичном формате ISO 2709 и в MARCXML.
+// Пакет знает только структуру записи, а значения полей разбирает и заполняет вызывающий код
+package marc
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// разделители ISO 2709
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+)
+
+// ограничения формата: длина поля - 4 цифры в справочнике, длина записи - 5 цифр в маркере
+const (
+	MaxFieldLength  = 9999
+	MaxRecordLength = 99999
+)
+
+var ErrTooLong = errors.New("marc: record is too long")
+
+type Subfield struct {
+	Code  byte
+	Value string
+}
+
+// Field - управляющее поле (теги 001-009, только Value) или поле данных с индикаторами и подполями
+type Field struct {
+	Tag       string
+	Value     string
+	Ind1      byte
+	Ind2      byte
+	Subfields []Subfield
+}
+
+// Control сообщает, что поле управляющее
+func (f Field) Control() bool {
+	return strings.HasPrefix(f.Tag, "00")
+}
+
+// Get возвращает первое подполе с кодом code или пустую строку
+func (f Field) Get(code byte) string {
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// Record - запись MARC. В Leader важны позиции 5-9 и 17-19 (статус, тип, уровень, кодировка,
+// уровень кодирования, форма описания); длина записи и базовый адрес вычисляются при записи
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// DefaultLeader - новая запись текстового монографического издания в UTF-8
+const DefaultLeader = "00000nam a2200000 i 4500"
+
+func NewRecord() *Record {
+	return &Record{Leader: DefaultLeader}
+}
+
+func (r *Record) AddControl(tag, value string) {
+	r.Fields = append(r.Fields, Field{Tag: tag, Value: value})
+}
+
+// AddData добавляет поле данных; подполя с пустым значением пропускаются, поле без подполей не добавляется
+func (r *Record) AddData(tag string, ind1, ind2 byte, subfields ...Subfield) {
+	var list []Subfield
+	for _, s := range subfields {
+		if s.Value != "" {
+			list = append(list, s)
+		}
+	}
+	if len(list) == 0 {
+		return
+	}
+	r.Fields = append(r.Fields, Field{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: list})
+}
+
+// Field возвращает первое поле с тегом tag
+func (r *Record) Field(tag string) (f Field, ok bool) {
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			return f, true
+		}
+	}
+	return
+}
+
+// All возвращает все поля с тегом tag
+func (r *Record) All(tag string) (fields []Field) {
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			fields = append(fields, f)
+		}
+	}
+	return
+}
+
+func (r *Record) leader() string {
+	l := r.Leader
+	if len(l) != 24 {
+		l = DefaultLeader
+	}
+	return l
+}
+
+// validTag - три цифры или латинские буквы
+func validTag(tag string) bool {
+	if len(tag) != 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		c := tag[i]
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// clean убирает из значения разделители ISO 2709, которые сломали бы структуру записи
+func clean(s string) string {
+	if !strings.ContainsAny(s, "\x1d\x1e\x1f") {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r == subfieldDelimiter || r == fieldTerminator || r == recordTerminator {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func indicator(b byte) byte {
+	if b == 0 {
+		return ' '
+	}
+	return b
+}
+
+// Truncate обрезает строку до n байт по границе символа, добавляя многоточие, если текст обрезан
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	n -= len("…")
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// Encode возвращает запись в формате ISO 2709
+func (r *Record) Encode() ([]byte, error) {
+	var dir, data []byte
+	for _, f := range r.Fields {
+		if !validTag(f.Tag) {
+			return nil, fmt.Errorf("marc: invalid tag %q", f.Tag)
+		}
+		start := len(data)
+		if f.Control() {
+			data = append(data, clean(f.Value)...)
+		} else {
+			data = append(data, indicator(f.Ind1), indicator(f.Ind2))
+			for _, s := range f.Subfields {
+				data = append(data, subfieldDelimiter, s.Code)
+				data = append(data, clean(s.Value)...)
+			}
+		}
+		data = append(data, fieldTerminator)
+		length := len(data) - start
+		if length > MaxFieldLength {
+			return nil, fmt.Errorf("%w: field %s has %d bytes", ErrTooLong, f.Tag, length)
+		}
+		dir = append(dir, fmt.Sprintf("%s%04d%05d", f.Tag, length, start)...)
+	}
+	dir = append(dir, fieldTerminator)
+	data = append(data, recordTerminator)
+
+	base := 24 + len(dir)
+	total := base + len(data)
+	if total > MaxRecordLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, total)
+	}
+
+	leader := []byte(r.leader())
+	copy(leader[0:5], fmt.Sprintf("%05d", total))
+	copy(leader[10:12], "22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	out := make([]byte, 0, total)
+	out = append(out, leader...)
+	out = append(out, dir...)
+	return append(out, data...), nil
+}
+
+// Writer пишет записи ISO 2709 одну за другой
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(r *Record) error {
+	data, err := r.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
+
+func (w *Writer) Close() error {
+	return w.w.Flush()
+}
+
+// XMLWriter пишет записи в коллекцию MARCXML. Close закрывает коллекцию
+type XMLWriter struct {
+	w       *bufio.Writer
+	started bool
+}
+
+const xmlNamespace = "http://www.loc.gov/MARC21/slim"
+
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	return &XMLWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *XMLWriter) start() {
+	if !w.started {
+		w.started = true
+		w.w.WriteString(xml.Header + `<collection xmlns="` + xmlNamespace + `">` + "\n")
+	}
+}
+
+func (w *XMLWriter) Write(r *Record) error {
+	for _, f := range r.Fields {
+		if !validTag(f.Tag) {
+			return fmt.Errorf("marc: invalid tag %q", f.Tag)
+		}
+	}
+	w.start()
+	b := w.w
+	b.WriteString("<record>\n  <leader>")
+	escape(b, r.leader())
+	b.WriteString("</leader>\n")
+	for _, f := range r.Fields {
+		if f.Control() {
+			fmt.Fprintf(b, `  <controlfield tag="%s">`, f.Tag)
+			escape(b, clean(f.Value))
+			b.WriteString("</controlfield>\n")
+			continue
+		}
+		fmt.Fprintf(b, `  <datafield tag="%s" ind1="`, f.Tag)
+		escape(b, string(indicator(f.Ind1)))
+		b.WriteString(`" ind2="`)
+		escape(b, string(indicator(f.Ind2)))
+		b.WriteString("\">\n")
+		for _, s := range f.Subfields {
+			b.WriteString(`    <subfield code="`)
+			escape(b, string(s.Code))
+			b.WriteString(`">`)
+			escape(b, clean(s.Value))
+			b.WriteString("</subfield>\n")
+		}
+		b.WriteString("  </datafield>\n")
+	}
+	_, err := b.WriteString("</record>\n")
+	return err
+}
+
+func (w *XMLWriter) Close() error {
+	w.start()
+	w.w.WriteString("</collection>\n")
+	return w.w.Flush()
+}
+
+// escape записывает текст с заменой специальных символов XML
+func escape(w io.Writer, s string) {
+	xml.EscapeText(w, []byte(s))
+}
@@ -0,0 +1,222 @@
+package marc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testRecord() *Record {
+	r := NewRecord()
+	r.AddControl("001", "42")
+	r.AddData("100", '1', ' ', Subfield{Code: 'a', Value: "Пушкин, А. С."})
+	r.AddData("245", '1', '0', Subfield{Code: 'a', Value: "Капитанская дочка"}, Subfield{Code: 'b', Value: ""})
+	r.AddData("490", '0', ' ', Subfield{Code: 'a', Value: ""})
+	r.AddData("520", ' ', ' ', Subfield{Code: 'a', Value: "Текст с \x1f разделителем & <тегом>"})
+	return r
+}
+
+func TestEncode(t *testing.T) {
+	data, err := testRecord().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total, _ := strconv.Atoi(string(data[0:5]))
+	if total != len(data) {
+		t.Fatalf("record length %d, want %d", total, len(data))
+	}
+	if data[len(data)-1] != recordTerminator {
+		t.Fatal("record is not terminated")
+	}
+	if string(data[10:12]) != "22" || string(data[20:24]) != "4500" {
+		t.Fatalf("leader %q", data[:24])
+	}
+
+	base, _ := strconv.Atoi(string(data[12:17]))
+	dir := data[24 : base-1]
+	if data[base-1] != fieldTerminator || len(dir)%12 != 0 {
+		t.Fatalf("directory %q", dir)
+	}
+	// поле без подполей (490) и пустое подполе (245 $b) не записываются
+	var tags []string
+	fields := map[string]string{}
+	for i := 0; i < len(dir); i += 12 {
+		tag := string(dir[i : i+3])
+		length, _ := strconv.Atoi(string(dir[i+3 : i+7]))
+		start, _ := strconv.Atoi(string(dir[i+7 : i+12]))
+		tags = append(tags, tag)
+		fields[tag] = string(data[base+start : base+start+length])
+	}
+	if got := strings.Join(tags, " "); got != "001 100 245 520" {
+		t.Fatalf("tags %q", got)
+	}
+	if fields["001"] != "42\x1e" {
+		t.Errorf("001 = %q", fields["001"])
+	}
+	if fields["245"] != "10\x1faКапитанская дочка\x1e" {
+		t.Errorf("245 = %q", fields["245"])
+	}
+	if fields["520"] != "  \x1faТекст с   разделителем & <тегом>\x1e" {
+		t.Errorf("520 = %q", fields["520"])
+	}
+}
+
+func TestEncodeLimits(t *testing.T) {
+	r := NewRecord()
+	r.AddControl("1", "x")
+	if _, err := r.Encode(); err == nil {
+		t.Error("invalid tag accepted")
+	}
+
+	r = NewRecord()
+	r.AddData("520", ' ', ' ', Subfield{Code: 'a', Value: strings.Repeat("x", MaxFieldLength)})
+	if _, err := r.Encode(); !errors.Is(err, ErrTooLong) {
+		t.Errorf("long field: %v", err)
+	}
+
+	r = NewRecord()
+	for i := 0; i < 11; i++ {
+		r.AddData("520", ' ', ' ', Subfield{Code: 'a', Value: strings.Repeat("x", MaxFieldLength-10)})
+	}
+	if _, err := r.Encode(); !errors.Is(err, ErrTooLong) {
+		t.Errorf("long record: %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate("короткий", 100); got != "короткий" {
+		t.Errorf("got %q", got)
+	}
+	got := Truncate("ёжик в тумане", 10)
+	if len(got) > 10 || !strings.HasSuffix(got, "…") || !strings.HasPrefix(got, "ёж") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestXMLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf)
+	for i := 0; i < 2; i++ {
+		if err := w.Write(testRecord()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct {
+		XMLName xml.Name `xml:"http://www.loc.gov/MARC21/slim collection"`
+		Records []struct {
+			Leader  string `xml:"leader"`
+			Control []struct {
+				Tag   string `xml:"tag,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"controlfield"`
+			Data []struct {
+				Tag       string `xml:"tag,attr"`
+				Ind1      string `xml:"ind1,attr"`
+				Ind2      string `xml:"ind2,attr"`
+				Subfields []struct {
+					Code  string `xml:"code,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"subfield"`
+			} `xml:"datafield"`
+		} `xml:"record"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if len(c.Records) != 2 {
+		t.Fatalf("%d records", len(c.Records))
+	}
+	rec := c.Records[0]
+	if rec.Leader != DefaultLeader || len(rec.Control) != 1 || rec.Control[0].Value != "42" || len(rec.Data) != 3 {
+		t.Fatalf("record %+v", rec)
+	}
+	if d := rec.Data[0]; d.Tag != "100" || d.Ind1 != "1" || d.Ind2 != " " || d.Subfields[0].Code != "a" {
+		t.Errorf("100 = %+v", d)
+	}
+	if v := rec.Data[2].Subfields[0].Value; v != "Текст с   разделителем & <тегом>" {
+		t.Errorf("520 = %q", v)
+	}
+}
+
+func TestXMLWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewXMLWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	var c struct {
+		XMLName xml.Name `xml:"collection"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var bin, xmlBuf bytes.Buffer
+	w, xw := NewWriter(&bin), NewXMLWriter(&xmlBuf)
+	for i := 0; i < 2; i++ {
+		w.Write(testRecord())
+		xw.Write(testRecord())
+	}
+	w.Close()
+	xw.Close()
+	// переводы строк между записями встречаются в файлах других программ
+	data := bytes.Replace(bin.Bytes(), []byte{recordTerminator}, []byte{recordTerminator, '\n'}, 1)
+
+	want := testRecord()
+	want.Fields[3].Subfields[0].Value = "Текст с   разделителем & <тегом>"
+	for name, read := range map[string]func() (*Record, error){
+		"iso2709": NewReader(bytes.NewReader(data)).Read,
+		"marcxml": NewXMLReader(&xmlBuf).Read,
+	} {
+		for i := 0; i < 2; i++ {
+			r, err := read()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(r.Fields) != len(want.Fields) {
+				t.Fatalf("%s: fields %+v", name, r.Fields)
+			}
+			for j, f := range r.Fields {
+				w := want.Fields[j]
+				if f.Tag != w.Tag || f.Value != w.Value || f.Ind1 != w.Ind1 || f.Ind2 != w.Ind2 || len(f.Subfields) != len(w.Subfields) {
+					t.Fatalf("%s: field %+v, want %+v", name, f, w)
+				}
+				for k, s := range f.Subfields {
+					if s != w.Subfields[k] {
+						t.Errorf("%s: subfield %+v, want %+v", name, s, w.Subfields[k])
+					}
+				}
+			}
+		}
+		if _, err := read(); err != io.EOF {
+			t.Errorf("%s: got %v, want EOF", name, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	data, _ := testRecord().Encode()
+	if _, err := NewReader(bytes.NewReader(data[:len(data)-1])).Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: %v", err)
+	}
+	broken := append([]byte{}, data...)
+	copy(broken[12:17], "99999")
+	if _, err := Decode(broken); err == nil {
+		t.Error("invalid base address accepted")
+	}
+	cp1251 := append([]byte{}, data...)
+	cp1251[len(cp1251)-3] = 0xE0
+	if _, err := Decode(cp1251); !errors.Is(err, ErrEncoding) {
+		t.Errorf("cp1251: %v", err)
+	}
+}
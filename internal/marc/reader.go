@@ -0,0 +1,179 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+var (
+	// ErrFormat - запись испорчена; следующие записи файла можно читать дальше
+	ErrFormat   = errors.New("marc: invalid record")
+	ErrEncoding = errors.New("marc: record is not in UTF-8")
+)
+
+// Decode разбирает одну запись ISO 2709. Поддерживается только кодировка UTF-8: записи в MARC-8
+// (пробел в позиции 9 маркера) принимаются, если их текст оказался корректным UTF-8
+func Decode(data []byte) (*Record, error) {
+	if len(data) < 25 {
+		return nil, fmt.Errorf("%w: record is too short", ErrFormat)
+	}
+	total, err := strconv.Atoi(string(data[0:5]))
+	if err != nil || total > len(data) || total < 25 {
+		return nil, fmt.Errorf("%w: invalid record length %q", ErrFormat, data[0:5])
+	}
+	data = data[:total]
+	base, err := strconv.Atoi(string(data[12:17]))
+	if err != nil || base < 25 || base > total {
+		return nil, fmt.Errorf("%w: invalid base address %q", ErrFormat, data[12:17])
+	}
+	if !utf8.Valid(data) {
+		return nil, ErrEncoding
+	}
+
+	r := &Record{Leader: string(data[:24])}
+	dir := data[24 : base-1]
+	if data[base-1] != fieldTerminator || len(dir)%12 != 0 {
+		return nil, fmt.Errorf("%w: invalid directory", ErrFormat)
+	}
+	for i := 0; i < len(dir); i += 12 {
+		e := dir[i : i+12]
+		tag := string(e[0:3])
+		length, err1 := strconv.Atoi(string(e[3:7]))
+		start, err2 := strconv.Atoi(string(e[7:12]))
+		if err1 != nil || err2 != nil || !validTag(tag) || base+start+length > total {
+			return nil, fmt.Errorf("%w: invalid directory entry %q", ErrFormat, e)
+		}
+		value := bytes.TrimSuffix(data[base+start:base+start+length], []byte{fieldTerminator})
+
+		f := Field{Tag: tag}
+		if f.Control() {
+			f.Value = string(value)
+			r.Fields = append(r.Fields, f)
+			continue
+		}
+		if len(value) < 2 {
+			return nil, fmt.Errorf("%w: field %s has no indicators", ErrFormat, tag)
+		}
+		f.Ind1, f.Ind2 = value[0], value[1]
+		for _, s := range bytes.Split(value[2:], []byte{subfieldDelimiter}) {
+			if len(s) == 0 {
+				continue
+			}
+			f.Subfields = append(f.Subfields, Subfield{Code: s[0], Value: string(s[1:])})
+		}
+		r.Fields = append(r.Fields, f)
+	}
+	return r, nil
+}
+
+// Reader читает записи ISO 2709 одну за другой
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read возвращает следующую запись или io.EOF. Переводы строк между записями, которые добавляют
+// некоторые программы, пропускаются
+func (r *Reader) Read() (*Record, error) {
+	for {
+		data, err := r.r.ReadBytes(recordTerminator)
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			if err == nil {
+				continue
+			}
+			return nil, err
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Decode(data)
+	}
+}
+
+// XMLReader читает записи MARCXML: коллекцию <collection> или одиночные <record>
+type XMLReader struct {
+	d *xml.Decoder
+}
+
+func NewXMLReader(r io.Reader) *XMLReader {
+	return &XMLReader{d: xml.NewDecoder(r)}
+}
+
+type xmlRecord struct {
+	Leader   string `xml:"leader"`
+	Controls []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	Data []struct {
+		Tag       string `xml:"tag,attr"`
+		Ind1      string `xml:"ind1,attr"`
+		Ind2      string `xml:"ind2,attr"`
+		Subfields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+// Read возвращает следующую запись или io.EOF. Порядок полей в записи сохраняется
+// отдельно для управляющих полей и полей данных, как в обычном MARCXML
+func (r *XMLReader) Read() (*Record, error) {
+	for {
+		t, err := r.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		var x xmlRecord
+		err = r.d.DecodeElement(&x, &start)
+		if err != nil {
+			return nil, err
+		}
+		rec := &Record{Leader: x.Leader}
+		for _, c := range x.Controls {
+			if !validTag(c.Tag) {
+				return nil, fmt.Errorf("%w: invalid tag %q", ErrFormat, c.Tag)
+			}
+			rec.Fields = append(rec.Fields, Field{Tag: c.Tag, Value: c.Value})
+		}
+		for _, d := range x.Data {
+			if !validTag(d.Tag) {
+				return nil, fmt.Errorf("%w: invalid tag %q", ErrFormat, d.Tag)
+			}
+			f := Field{Tag: d.Tag, Ind1: xmlIndicator(d.Ind1), Ind2: xmlIndicator(d.Ind2)}
+			for _, s := range d.Subfields {
+				if s.Code == "" {
+					continue
+				}
+				f.Subfields = append(f.Subfields, Subfield{Code: s.Code[0], Value: s.Value})
+			}
+			rec.Fields = append(rec.Fields, f)
+		}
+		return rec, nil
+	}
+}
+
+func xmlIndicator(s string) byte {
+	if s == "" {
+		return ' '
+	}
+	return s[0]
+}
@@ -0,0 +1,340 @@
+// Package oidc реализует вход через OpenID Connect по схеме authorization code с PKCE.
+// Поддерживается только то, что нужно библиотеке: discovery, обмен кода на токены
+// и проверка ID-токена, подписанного RS256.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// допустимое расхождение часов с провайдером при проверке exp/iat
+const clockSkew = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg  Config
+	meta metadata
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// NewProvider читает документ discovery провайдера
+func NewProvider(ctx context.Context, cfg Config) (p *Provider, err error) {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	p = &Provider{cfg: cfg}
+
+	wellKnown := strings.TrimRight(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	err = p.getJSON(ctx, wellKnown, &p.meta)
+	if err != nil {
+		err = fmt.Errorf("failed to discover provider: %w", err)
+		return
+	}
+	if p.meta.Issuer != cfg.Issuer {
+		err = fmt.Errorf("issuer mismatch: configured %q, provider reports %q", cfg.Issuer, p.meta.Issuer)
+		return
+	}
+
+	return
+}
+
+// NewVerifier генерирует code_verifier для PKCE; им же удобно генерировать state и nonce
+func NewVerifier() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("failed to read random bytes: %w", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange меняет код авторизации на ID-токен и сразу проверяет его
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (claims Claims, err error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		err = fmt.Errorf("failed to build token request: %w", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("failed to request token: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		err = fmt.Errorf("failed to decode token response: %w", err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		err = fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+		return
+	}
+	if token.IDToken == "" {
+		err = errors.New("token response has no id_token")
+		return
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify проверяет подпись, издателя, получателя, срок действия и nonce ID-токена
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (claims Claims, err error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		err = errors.New("malformed id_token")
+		return
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = decodeSegment(parts[0], &header)
+	if err != nil {
+		return
+	}
+	if header.Alg != "RS256" {
+		err = fmt.Errorf("unsupported id_token algorithm %q", header.Alg)
+		return
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		err = fmt.Errorf("failed to decode signature: %w", err)
+		return
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		err = fmt.Errorf("invalid id_token signature: %w", err)
+		return
+	}
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case claims.String("iss") != p.cfg.Issuer:
+		err = fmt.Errorf("unexpected issuer %q", claims.String("iss"))
+	case !claims.hasAudience(p.cfg.ClientID):
+		err = errors.New("id_token is not issued for this client")
+	case now.After(claims.time("exp").Add(clockSkew)):
+		err = errors.New("id_token expired")
+	case claims.time("iat").After(now.Add(clockSkew)):
+		err = errors.New("id_token issued in the future")
+	case claims.String("nonce") != nonce:
+		err = errors.New("id_token nonce mismatch")
+	case claims.String("sub") == "":
+		err = errors.New("id_token has no subject")
+	}
+
+	return
+}
+
+// key ищет открытый ключ по kid; при незнакомом kid набор ключей перечитывается,
+// так как провайдер мог их сменить
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k := p.lookup(kid); k != nil {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.meta.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	if k := p.lookup(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no key %q in provider jwks", kid)
+}
+
+func (p *Provider) lookup(kid string) *rsa.PublicKey {
+	if k, ok := p.keys[kid]; ok {
+		return k
+	}
+	// токен без kid допустим, если у провайдера единственный ключ
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("failed to decode id_token: %w", err)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to decode id_token: %w", err)
+	}
+	return nil
+}
+
+// Claims - утверждения из ID-токена
+type Claims map[string]any
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (c Claims) Bool(name string) bool {
+	b, _ := c[name].(bool)
+	return b
+}
+
+// Strings читает утверждение-список, например группы; одиночная строка тоже принимается
+func (c Claims) Strings(name string) (list []string) {
+	switch v := c[name].(type) {
+	case string:
+		list = append(list, v)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return
+}
+
+func (c Claims) hasAudience(clientID string) bool {
+	for _, aud := range c.Strings("aud") {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Claims) time(name string) time.Time {
+	f, _ := c[name].(float64)
+	return time.Unix(int64(f), 0)
+}
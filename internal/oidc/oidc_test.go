@@ -0,0 +1,229 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockProvider - минимальный OIDC-провайдер: выдает код на /authorize без формы входа,
+// проверяет PKCE на /token и подписывает ID-токен своим RSA-ключом
+type mockProvider struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	redirect  string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, codes: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(rw http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "biblio" {
+			http.Error(rw, "bad request", http.StatusBadRequest)
+			return
+		}
+		code := NewVerifier()
+		m.mu.Lock()
+		m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), redirect: q.Get("redirect_uri")}
+		m.mu.Unlock()
+		http.Redirect(rw, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "biblio" || secret != "s3cret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		m.mu.Lock()
+		grant, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok || challenge(r.FormValue("code_verifier")) != grant.challenge || r.FormValue("redirect_uri") != grant.redirect {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{
+			"iss":   m.srv.URL,
+			"aud":   "biblio",
+			"sub":   "user-42",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": grant.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(rw).Encode(map[string]string{"id_token": m.sign(claims), "token_type": "Bearer"})
+	})
+
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login проходит весь путь браузера: переход на провайдер и возврат с кодом на redirect_uri
+func login(t *testing.T, p *Provider, verifier string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(p.AuthCodeURL("st", "nn", verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), "http://biblio.test/login/oidc/callback") {
+		t.Fatalf("unexpected redirect %q: %v", resp.Header.Get("Location"), err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func newTestProvider(t *testing.T, m *mockProvider) *Provider {
+	p, err := NewProvider(context.Background(), Config{
+		Issuer:       m.srv.URL,
+		ClientID:     "biblio",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://biblio.test/login/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = map[string]any{"preferred_username": "ivanov", "groups": []string{"staff", "library-admins"}}
+	p := newTestProvider(t, m)
+
+	verifier := NewVerifier()
+	code, state := login(t, p, verifier)
+	if state != "st" {
+		t.Fatalf("state = %q, want st", state)
+	}
+
+	claims, err := p.Exchange(context.Background(), code, verifier, "nn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "user-42" || claims.String("preferred_username") != "ivanov" {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[1] != "library-admins" {
+		t.Fatalf("groups = %v", groups)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	code, _ := login(t, p, NewVerifier())
+	if _, err := p.Exchange(context.Background(), code, NewVerifier(), "nn"); err == nil {
+		t.Fatal("exchange with a foreign code_verifier must fail")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	verifier := NewVerifier()
+	code, _ := login(t, p, verifier)
+	if _, err := p.Exchange(context.Background(), code, verifier, "other"); err == nil {
+		t.Fatal("id_token with a foreign nonce must be rejected")
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	valid := map[string]any{"iss": m.srv.URL, "aud": "biblio", "sub": "x", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix()}
+
+	with := func(k string, v any) map[string]any {
+		c := make(map[string]any)
+		for key, val := range valid {
+			c[key] = val
+		}
+		c[k] = v
+		return c
+	}
+
+	if _, err := p.Verify(context.Background(), m.sign(valid), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	for name, claims := range map[string]map[string]any{
+		"issuer":   with("iss", "http://evil.test"),
+		"audience": with("aud", []string{"someone-else"}),
+		"expired":  with("exp", time.Now().Add(-time.Hour).Unix()),
+	} {
+		if _, err := p.Verify(context.Background(), m.sign(claims), "n"); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	tampered := m.sign(valid)
+	parts := strings.Split(tampered, ".")
+	payload, _ := json.Marshal(with("sub", "admin"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := p.Verify(context.Background(), strings.Join(parts, "."), "n"); err == nil {
+		t.Error("token with a modified payload accepted")
+	}
+}
@@ -0,0 +1,137 @@
+// Package opds - элементы каталога OPDS 1.2 (Atom) и описания OpenSearch для приложений-читалок
+package opds
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// типы ссылок каталога
+const (
+	NavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	OpenSearchType  = "application/opensearchdescription+xml"
+)
+
+// отношения ссылок (OPDS 1.2, раздел 5)
+const (
+	RelStart       = "start"
+	RelSelf        = "self"
+	RelUp          = "up"
+	RelNext        = "next"
+	RelPrevious    = "previous"
+	RelSearch      = "search"
+	RelSubsection  = "subsection"
+	RelNew         = "http://opds-spec.org/sort/new"
+	RelAcquisition = "http://opds-spec.org/acquisition"
+)
+
+type Feed struct {
+	XMLName      xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	XmlnsDC      string   `xml:"xmlns:dc,attr"`
+	XmlnsOS      string   `xml:"xmlns:opensearch,attr"`
+	XmlnsThr     string   `xml:"xmlns:thr,attr"`
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       *Person  `xml:"author,omitempty"`
+	Links        []Link   `xml:"link"`
+	TotalResults int      `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int      `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int      `xml:"opensearch:startIndex,omitempty"`
+	Entries      []Entry  `xml:"entry"`
+}
+
+type Entry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Authors    []Person   `xml:"author,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Categories []Category `xml:"category,omitempty"`
+	Summary    *Text      `xml:"summary,omitempty"`
+	Content    *Text      `xml:"content,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+type Person struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type Link struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	// Count - число записей в разделе (thr:count используют многие читалки)
+	Count int `xml:"thr:count,attr,omitempty"`
+}
+
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type Text struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+// NewFeed создает ленту с обязательными для Atom полями
+func NewFeed(id, title string, updated time.Time) *Feed {
+	return &Feed{
+		XmlnsDC:  "http://purl.org/dc/terms/",
+		XmlnsOS:  "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsThr: "http://purl.org/syndication/thread/1.0",
+		ID:       id,
+		Title:    title,
+		Updated:  Time(updated),
+	}
+}
+
+// Time форматирует время так, как требует Atom (RFC 3339)
+func Time(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (f *Feed) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(f)
+}
+
+// OpenSearchDescription - описание поиска для кнопки поиска в читалке.
+// В Template подстановка {searchTerms} заменяется запросом пользователя
+type OpenSearchDescription struct {
+	XMLName     xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName   string   `xml:"ShortName"`
+	Description string   `xml:"Description"`
+	InputEnc    string   `xml:"InputEncoding"`
+	OutputEnc   string   `xml:"OutputEncoding"`
+	URL         struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"Url"`
+}
+
+func NewOpenSearch(name, description, template string) *OpenSearchDescription {
+	d := &OpenSearchDescription{ShortName: name, Description: description, InputEnc: "UTF-8", OutputEnc: "UTF-8"}
+	d.URL.Type = AcquisitionType
+	d.URL.Template = template
+	return d
+}
+
+func (d *OpenSearchDescription) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(d)
+}
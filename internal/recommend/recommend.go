@@ -0,0 +1,249 @@
+// Package recommend рассчитывает близость книг для рекомендаций. Близость складывается из совместного
+// чтения (книги, интересные одним и тем же читателям) и сходства самих книг: автор, серия, жанр и
+// общие слова аннотации. Расчет идет целиком в памяти и рассчитан на фоновую задачу
+package recommend
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/citation"
+	"biblio/internal/repository"
+)
+
+// веса составляющих близости. Содержательная часть в сумме дает 1
+const (
+	CollaborativeWeight = 0.6
+	ContentWeight       = 0.4
+
+	authorWeight     = 0.35
+	seriesWeight     = 0.25
+	categoryWeight   = 0.1
+	annotationWeight = 0.3
+)
+
+const (
+	// shrink приглушает совместное чтение, за которым стоит мало читателей
+	shrink = 2.0
+	// minScore - более далекие книги похожими не считаются
+	minScore = 0.05
+	// слова короче minTermLength не учитываются, длиннее termLength - обрезаются: грубая замена стемминга
+	minTermLength = 4
+	termLength    = 6
+	// слово из большей доли аннотаций, чем commonTerm, ничего не говорит о сходстве
+	commonTerm = 0.05
+	minCommon  = 10
+)
+
+var stopWords = make(map[string]bool)
+
+func init() {
+	for _, w := range strings.Fields(`который которая которое которые которых которого этого этот этой этом этих если может
+		более также после когда только очень свой своей своих чтобы книга книги книге роман автор автора всех всего
+		даже будет было была были него ними себя тебе меня однако между через тоже есть весь всегда здесь`) {
+		stopWords[w] = true
+	}
+}
+
+// Terms - значимые слова аннотации, обрезанные до общей основы
+func Terms(s string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(w) < minTermLength || stopWords[w] {
+			continue
+		}
+		if r := []rune(w); len(r) > termLength {
+			w = string(r[:termLength])
+		}
+		terms[w] = true
+	}
+	return terms
+}
+
+// authorKeys - авторы книги в виде "фамилия и первая буква имени", чтобы "Пушкин А. С." и
+// "А.С. Пушкин" совпали
+func authorKeys(s string) []string {
+	var keys []string
+	for _, p := range citation.Authors(s) {
+		key := strings.ToLower(p.Family)
+		if r, _ := utf8.DecodeRuneInString(p.Given); r != utf8.RuneError {
+			key += " " + strings.ToLower(string(r))
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// seriesKey - серия книги или пустая строка для книги вне серии
+func seriesKey(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "-", "—", "–", "нет", "без серии":
+		return ""
+	}
+	return s
+}
+
+type features struct {
+	authors  []string
+	series   string
+	category string
+	terms    map[string]bool
+}
+
+// link - книга читателя или читатель книги с весом интереса
+type link struct {
+	id     int
+	weight float64
+}
+
+// Similarities возвращает для каждой книги не больше limit самых близких к ней, по убыванию близости
+func Similarities(books []repository.Book, signals []repository.Signal, limit int) []repository.Similarity {
+	index := make(map[uuid.UUID]int, len(books))
+	for i, b := range books {
+		index[b.Book_Id] = i
+	}
+
+	f := make([]features, len(books))
+	byAuthor := make(map[string][]int)
+	bySeries := make(map[string][]int)
+	byTerm := make(map[string][]int)
+	for i, b := range books {
+		f[i] = features{authorKeys(b.Author), seriesKey(b.Series), strings.ToLower(strings.TrimSpace(string(b.Category))), Terms(b.Annotation)}
+		for _, a := range f[i].authors {
+			byAuthor[a] = append(byAuthor[a], i)
+		}
+		if f[i].series != "" {
+			bySeries[f[i].series] = append(bySeries[f[i].series], i)
+		}
+		for t := range f[i].terms {
+			byTerm[t] = append(byTerm[t], i)
+		}
+	}
+	common := int(commonTerm * float64(len(books)))
+	if common < minCommon {
+		common = minCommon
+	}
+
+	// книги каждого читателя и читатели каждой книги
+	users := make(map[uuid.UUID]int)
+	var userBooks [][]link
+	bookUsers := make([][]link, len(books))
+	norm := make([]float64, len(books))
+	for _, s := range signals {
+		i, ok := index[s.Book_Id]
+		if !ok || s.Weight <= 0 {
+			continue
+		}
+		u, ok := users[s.User_Id]
+		if !ok {
+			u = len(userBooks)
+			users[s.User_Id] = u
+			userBooks = append(userBooks, nil)
+		}
+		userBooks[u] = append(userBooks[u], link{i, s.Weight})
+		bookUsers[i] = append(bookUsers[i], link{u, s.Weight})
+		norm[i] += s.Weight * s.Weight
+	}
+
+	var result []repository.Similarity
+	for i := range books {
+		// совместное чтение - косинус между книгами по векторам читателей
+		dot := make(map[int]float64)
+		together := make(map[int]int)
+		for _, u := range bookUsers[i] {
+			for _, b := range userBooks[u.id] {
+				if b.id != i {
+					dot[b.id] += u.weight * b.weight
+					together[b.id]++
+				}
+			}
+		}
+
+		// кандидаты - книги того же автора, серии, с общими словами или читателями. Одного жанра
+		// для кандидата мало: в жанре сотни книг
+		candidates := make(map[int]bool)
+		for _, a := range f[i].authors {
+			for _, j := range byAuthor[a] {
+				candidates[j] = true
+			}
+		}
+		for _, j := range bySeries[f[i].series] {
+			candidates[j] = true
+		}
+		shared := make(map[int]int)
+		for t := range f[i].terms {
+			if len(byTerm[t]) > common {
+				continue
+			}
+			for _, j := range byTerm[t] {
+				shared[j]++
+			}
+		}
+		for j := range shared {
+			candidates[j] = true
+		}
+		for j := range dot {
+			candidates[j] = true
+		}
+		delete(candidates, i)
+
+		var near []repository.Similarity
+		for j := range candidates {
+			score := ContentWeight * contentScore(f[i], f[j], shared[j])
+			if d := dot[j]; d > 0 {
+				n := float64(together[j])
+				score += CollaborativeWeight * d / math.Sqrt(norm[i]*norm[j]) * n / (n + shrink)
+			}
+			if score >= minScore {
+				near = append(near, repository.Similarity{Book_Id: books[i].Book_Id, Similar_Id: books[j].Book_Id, Score: score})
+			}
+		}
+		sort.Slice(near, func(a, b int) bool {
+			if near[a].Score != near[b].Score {
+				return near[a].Score > near[b].Score
+			}
+			return near[a].Similar_Id.String() < near[b].Similar_Id.String()
+		})
+		if len(near) > limit {
+			near = near[:limit]
+		}
+		result = append(result, near...)
+	}
+	return result
+}
+
+// contentScore - сходство двух книг по описанию, от 0 до 1. shared - число общих слов аннотаций
+func contentScore(a, b features, shared int) (score float64) {
+	if sameAuthor(a.authors, b.authors) {
+		score += authorWeight
+	}
+	if a.series != "" && a.series == b.series {
+		score += seriesWeight
+	}
+	if a.category != "" && a.category == b.category {
+		score += categoryWeight
+	}
+	if union := len(a.terms) + len(b.terms) - shared; shared > 0 && union > 0 {
+		score += annotationWeight * float64(shared) / float64(union)
+	}
+	return
+}
+
+func sameAuthor(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
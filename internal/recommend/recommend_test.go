@@ -0,0 +1,89 @@
+package recommend
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"biblio/internal/repository"
+)
+
+func book(n byte, author, series, annotation string) repository.Book {
+	return repository.Book{Book_Id: uuid.UUID{15: n}, Author: author, Series: series, Annotation: annotation}
+}
+
+func similar(list []repository.Similarity, id uuid.UUID) (near []uuid.UUID, scores []float64) {
+	for _, s := range list {
+		if s.Book_Id == id {
+			near = append(near, s.Similar_Id)
+			scores = append(scores, s.Score)
+		}
+	}
+	return
+}
+
+func TestTerms(t *testing.T) {
+	terms := Terms("Путешествие капитана, который был в путешествиях и снова в путешествии!")
+	if len(terms) != 3 || !terms["путеше"] || !terms["капита"] || !terms["снова"] {
+		t.Errorf("got %v", terms)
+	}
+}
+
+func TestContentSimilarity(t *testing.T) {
+	books := []repository.Book{
+		book(1, "Пушкин А. С.", "-", "Повесть о капитане и его дочери"),
+		book(2, "А.С. Пушкин", "-", "Роман в стихах"),
+		book(3, "Стругацкий А. Н.", "Мир Полудня", "Полдень, XXII век"),
+		book(4, "Стругацкий Б. Н.", "Мир Полудня", "Обитаемый остров"),
+		book(5, "Толстой Л. Н.", "-", "Война и мир"),
+	}
+	for _, i := range []int{0, 1, 4} {
+		books[i].Category = repository.CLASSIC
+	}
+	list := Similarities(books, nil, 10)
+
+	near, _ := similar(list, books[0].Book_Id)
+	if len(near) != 1 || near[0] != books[1].Book_Id {
+		t.Errorf("same author: got %v", near)
+	}
+	near, _ = similar(list, books[2].Book_Id)
+	if len(near) != 1 || near[0] != books[3].Book_Id {
+		t.Errorf("same series: got %v", near)
+	}
+	// один жанр без других совпадений - не повод считать книги похожими
+	if near, _ = similar(list, books[4].Book_Id); len(near) != 0 {
+		t.Errorf("same category only: got %v", near)
+	}
+}
+
+func TestCollaborative(t *testing.T) {
+	books := []repository.Book{
+		book(1, "Ильф И.", "-", ""),
+		book(2, "Кристи А.", "-", ""),
+		book(3, "Лем С.", "-", ""),
+	}
+	u1, u2, u3 := uuid.UUID{1}, uuid.UUID{2}, uuid.UUID{3}
+	signals := []repository.Signal{
+		{User_Id: u1, Book_Id: books[0].Book_Id, Weight: 1},
+		{User_Id: u1, Book_Id: books[1].Book_Id, Weight: 1},
+		{User_Id: u2, Book_Id: books[0].Book_Id, Weight: 1},
+		{User_Id: u2, Book_Id: books[1].Book_Id, Weight: 1},
+		{User_Id: u3, Book_Id: books[0].Book_Id, Weight: 0.5},
+		{User_Id: u3, Book_Id: books[2].Book_Id, Weight: 1},
+		{User_Id: u3, Book_Id: uuid.New(), Weight: 1},
+	}
+	list := Similarities(books, signals, 1)
+
+	near, scores := similar(list, books[0].Book_Id)
+	if len(near) != 1 || near[0] != books[1].Book_Id {
+		t.Fatalf("co-read: got %v", near)
+	}
+	if scores[0] <= 0 || scores[0] > CollaborativeWeight {
+		t.Errorf("score out of range: %v", scores[0])
+	}
+	for _, s := range list {
+		if s.Book_Id == s.Similar_Id {
+			t.Errorf("book similar to itself: %v", s)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type scope string
+
+// области действия API-токенов
+const (
+	SCOPE_READ  scope = "read"
+	SCOPE_WRITE scope = "write"
+	SCOPE_ADMIN scope = "admin"
+)
+
+var Scopes = []scope{SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN}
+
+type APIToken struct {
+	Token_Id   int64      `json:"token_id" db:"token_id"`
+	User_Id    uuid.UUID  `json:"user_id" db:"user_id"`
+	Username   string     `json:"username" db:"username"`
+	Name       string     `json:"name" db:"name"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+// HasScope проверяет, разрешена ли токену область действия
+func (t APIToken) HasScope(s string) bool {
+	for _, v := range t.Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+const apiTokenColumns = `t.token_id, t.user_id, u.username, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at, t.revoked_at`
+
+func (r *Repository) AddAPIToken(ctx context.Context, userId, name, tokenHash string, scopes []string, expires *time.Time) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into api_tokens (user_id, name, token_hash, scopes, expires_at) values ($1, $2, $3, $4, $5)`, userId, name, tokenHash, scopes, expires)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// UseAPIToken находит действующий токен с владельцем и отмечает время использования.
+// Отозванный или просроченный токен дает ошибку
+func (r *Repository) UseAPIToken(ctx context.Context, tokenHash string) (t APIToken, u User, err error) {
+	row := r.pool.QueryRow(ctx, `update api_tokens t set last_used_at = now() from users u
+		where u.user_id = t.user_id and u.deleted_at is null and t.token_hash = $1 and t.revoked_at is null and (t.expires_at is null or t.expires_at > now())
+		returning `+apiTokenColumns+`, u.role, u.full_name, u.active, u.totp_enabled`, tokenHash)
+
+	err = row.Scan(&t.Token_Id, &t.User_Id, &t.Username, &t.Name, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt,
+		&u.Role, &u.FullName, &u.Active, &u.TOTPEnabled)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	u.User_Id, u.Username = t.User_Id, t.Username
+
+	return
+}
+
+// APITokens возвращает токены пользователя, а для пустого userId - токены всех пользователей
+func (r *Repository) APITokens(ctx context.Context, userId string) (tokens []APIToken, err error) {
+	rows, err := r.pool.Query(ctx, `select `+apiTokenColumns+` from api_tokens t join users u on u.user_id = t.user_id
+		where u.deleted_at is null and ($1 = '' or t.user_id::text = $1) order by t.created_at desc`, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t APIToken
+		err = rows.Scan(&t.Token_Id, &t.User_Id, &t.Username, &t.Name, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		tokens = append(tokens, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// RevokeAPIToken отзывает токен; для пустого userId - токен любого пользователя
+func (r *Repository) RevokeAPIToken(ctx context.Context, id, userId string) (err error) {
+	_, err = r.pool.Exec(ctx, `update api_tokens set revoked_at = now() where token_id::text = $1 and ($2 = '' or user_id::text = $2) and revoked_at is null`, id, userId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
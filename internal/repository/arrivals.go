@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type followKind string
+
+// на что можно подписаться: новые книги автора или серии
+const (
+	FOLLOW_AUTHOR followKind = "author"
+	FOLLOW_SERIES followKind = "series"
+)
+
+type Follow struct {
+	Follow_Id int64      `json:"follow_id" db:"follow_id"`
+	User_Id   uuid.UUID  `json:"user_id" db:"user_id"`
+	Kind      followKind `json:"kind" db:"kind"`
+	Value     string     `json:"value" db:"value"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// FollowNotice - новая книга по подписке читателя
+type FollowNotice struct {
+	User_Id uuid.UUID
+	Email   string
+	// Mailable - адрес подтвержден и пользователь не заблокирован
+	Mailable bool
+	Kind     followKind
+	Value    string
+	Book     Book
+}
+
+// followMatch - книга b подходит под подписку f. Автор и серия сравниваются целиком без учета регистра
+const followMatch = `(f.kind = 'author' and lower(b.author) = lower(f.value) or f.kind = 'series' and lower(b.series) = lower(f.value))`
+
+// NewArrivals возвращает книги, опубликованные с момента since, последние первыми. Пустой category - все жанры
+func (r *Repository) NewArrivals(ctx context.Context, since time.Time, category string, limit int) (books []Book, err error) {
+	return r.queryBooks(ctx, `select `+bookColumns+` from books where visible and publication >= $1
+		and ($2 = '' or category = $2) order by publication desc, book_id limit $3`, since, category, limit)
+}
+
+// Follows возвращает подписки пользователя: сначала авторы, потом серии
+func (r *Repository) Follows(ctx context.Context, userId string) (follows []Follow, err error) {
+	rows, err := r.pool.Query(ctx, `select follow_id, user_id, kind, value, created_at from follows where user_id = $1 order by kind, lower(value)`, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f Follow
+		err = rows.Scan(&f.Follow_Id, &f.User_Id, &f.Kind, &f.Value, &f.CreatedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		follows = append(follows, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// AddFollow подписывает пользователя на автора или серию и возвращает подписку. Повторная подписка
+// возвращает прежнюю. Уведомления придут только о книгах, добавленных после подписки
+func (r *Repository) AddFollow(ctx context.Context, userId string, kind followKind, value string) (f Follow, err error) {
+	row := r.pool.QueryRow(ctx, `insert into follows (user_id, kind, value) values ($1, $2, $3)
+		on conflict (user_id, kind, lower(value)) do update set kind = excluded.kind
+		returning follow_id, user_id, kind, value, created_at`, userId, kind, value)
+
+	err = row.Scan(&f.Follow_Id, &f.User_Id, &f.Kind, &f.Value, &f.CreatedAt)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) DeleteFollow(ctx context.Context, userId string, id int64) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from follows where follow_id = $1 and user_id = $2`, id, userId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// FollowedBooks - книги авторов и серий, на которые подписан пользователь, последние первыми
+func (r *Repository) FollowedBooks(ctx context.Context, userId string, limit int) (books []Book, err error) {
+	return r.queryBooks(ctx, `select `+bookColumns+` from books b where b.visible
+		and exists (select 1 from follows f where f.user_id = $1 and `+followMatch+`)
+		order by b.publication desc, b.book_id limit $2`, userId, limit)
+}
+
+// FollowNotices возвращает книги по подпискам, опубликованные после прошлого уведомления и не позже until,
+// по пользователям. Момент публикации - announced_at: книга с отложенной публикацией попадает в уведомление,
+// когда планировщик ее откроет. Книга, подходящая под несколько подписок, повторяется для каждой
+func (r *Repository) FollowNotices(ctx context.Context, until time.Time) (notices []FollowNotice, err error) {
+	rows, err := r.pool.Query(ctx, `select f.user_id, u.email, u.email_verified and u.active and u.email <> '', f.kind, f.value,
+		b.book_id, b.category, b.author, b.series, b.name, b.annotation, b.link, b.access, b.publication, b.unpublish_at, b.created_at, b.updated_at, b.visible
+		from follows f join users u on u.user_id = f.user_id join books b on `+followMatch+`
+		where b.visible and u.deleted_at is null and b.announced_at > f.notified_at and b.announced_at <= $1 order by f.user_id, b.announced_at, b.book_id`, until)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n FollowNotice
+		b := &n.Book
+		err = rows.Scan(append([]interface{}{&n.User_Id, &n.Email, &n.Mailable, &n.Kind, &n.Value}, b.fields()...)...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		notices = append(notices, n)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// MarkFollowsNotified отмечает, что о книгах до until пользователь уже уведомлен
+func (r *Repository) MarkFollowsNotified(ctx context.Context, userId string, until time.Time) (err error) {
+	_, err = r.pool.Exec(ctx, `update follows set notified_at = $2 where user_id = $1 and notified_at < $2`, userId, until)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+type auditAction string
+
+// действия, которые попадают в журнал аудита: вход и учетные данные, затем действия администратора.
+// Неудачные входы сюда не пишутся: их может порождать кто угодно без входа, а журнал нельзя чистить.
+// Они остаются в login_attempts
+const (
+	AUDIT_LOGIN           auditAction = "auth.login"
+	AUDIT_LOGOUT          auditAction = "auth.logout"
+	AUDIT_SIGNUP          auditAction = "auth.signup"
+	AUDIT_PASSWORD_CHANGE auditAction = "auth.password_change"
+	AUDIT_PASSWORD_RESET  auditAction = "auth.password_reset"
+	AUDIT_2FA_ENABLE      auditAction = "auth.2fa_enable"
+	AUDIT_2FA_DISABLE     auditAction = "auth.2fa_disable"
+	AUDIT_2FA_RECOVERY    auditAction = "auth.2fa_recovery"
+	AUDIT_SESSION_END     auditAction = "auth.session_end"
+	AUDIT_TOKEN_CREATE    auditAction = "token.create"
+	AUDIT_TOKEN_REVOKE    auditAction = "token.revoke"
+	AUDIT_USER_UPDATE     auditAction = "user.update"
+	AUDIT_USER_UNLOCK     auditAction = "user.unlock"
+	AUDIT_USER_DELETE     auditAction = "user.delete"
+	AUDIT_USER_RESTORE    auditAction = "user.restore"
+	AUDIT_USER_PURGE      auditAction = "user.purge"
+	AUDIT_SETTINGS_UPDATE auditAction = "settings.update"
+	AUDIT_BOOK_CREATE     auditAction = "book.create"
+	AUDIT_BOOK_UPDATE     auditAction = "book.update"
+	AUDIT_BOOK_REVERT     auditAction = "book.revert"
+	AUDIT_BOOK_DELETE     auditAction = "book.delete"
+	AUDIT_BOOK_RESTORE    auditAction = "book.restore"
+	AUDIT_BOOK_PURGE      auditAction = "book.purge"
+	AUDIT_BOOK_IMPORT     auditAction = "book.import"
+	AUDIT_REVIEW_APPROVE  auditAction = "review.approve"
+	AUDIT_REVIEW_REJECT   auditAction = "review.reject"
+	AUDIT_WEBHOOK_CREATE  auditAction = "webhook.create"
+	AUDIT_WEBHOOK_TOGGLE  auditAction = "webhook.toggle"
+	AUDIT_WEBHOOK_DELETE  auditAction = "webhook.delete"
+	AUDIT_WEBHOOK_PING    auditAction = "webhook.ping"
+	AUDIT_WEBHOOK_RESEND  auditAction = "webhook.redeliver"
+)
+
+var AuditActions = []auditAction{AUDIT_LOGIN, AUDIT_LOGOUT, AUDIT_SIGNUP, AUDIT_PASSWORD_CHANGE, AUDIT_PASSWORD_RESET,
+	AUDIT_2FA_ENABLE, AUDIT_2FA_DISABLE, AUDIT_2FA_RECOVERY, AUDIT_SESSION_END, AUDIT_TOKEN_CREATE, AUDIT_TOKEN_REVOKE,
+	AUDIT_USER_UPDATE, AUDIT_USER_UNLOCK, AUDIT_USER_DELETE, AUDIT_USER_RESTORE, AUDIT_USER_PURGE, AUDIT_SETTINGS_UPDATE,
+	AUDIT_BOOK_CREATE, AUDIT_BOOK_UPDATE, AUDIT_BOOK_REVERT, AUDIT_BOOK_DELETE, AUDIT_BOOK_RESTORE, AUDIT_BOOK_PURGE, AUDIT_BOOK_IMPORT,
+	AUDIT_REVIEW_APPROVE, AUDIT_REVIEW_REJECT, AUDIT_WEBHOOK_CREATE, AUDIT_WEBHOOK_TOGGLE, AUDIT_WEBHOOK_DELETE, AUDIT_WEBHOOK_PING, AUDIT_WEBHOOK_RESEND}
+
+// AuditEntry - запись журнала аудита. Actor - логин исполнителя на момент действия, пусто для анонимного входа.
+// Target - объект действия: TargetType ("book", "user", ...), его идентификатор и название для людей.
+// Before и After - состояние объекта до и после в JSON, пусто, если состояния нет.
+// Hash сцепляет запись с предыдущей через PrevHash, см. ChainHash
+type AuditEntry struct {
+	Entry_Id   int64       `json:"entry_id" db:"entry_id"`
+	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
+	Actor_Id   *uuid.UUID  `json:"actor_id" db:"actor_id"`
+	Actor      string      `json:"actor" db:"actor"`
+	Action     auditAction `json:"action" db:"action"`
+	TargetType string      `json:"target_type" db:"target_type"`
+	TargetId   string      `json:"target_id" db:"target_id"`
+	TargetName string      `json:"target_name" db:"target_name"`
+	Before     string      `json:"before" db:"before"`
+	After      string      `json:"after" db:"after"`
+	IP         string      `json:"ip" db:"ip"`
+	PrevHash   string      `json:"prev_hash" db:"prev_hash"`
+	Hash       string      `json:"hash" db:"hash"`
+}
+
+// ChainHash - SHA-256 от хэша предыдущей записи и всех полей записи, кроме номера. Изменение, удаление
+// или вставка записи задним числом нарушает совпадение хэшей у нее и у всех следующих
+func (e AuditEntry) ChainHash() string {
+	actorId := ""
+	if e.Actor_Id != nil {
+		actorId = e.Actor_Id.String()
+	}
+	h := sha256.New()
+	for _, v := range []string{e.PrevHash, e.CreatedAt.UTC().Format(time.RFC3339Nano), actorId, e.Actor, string(e.Action),
+		e.TargetType, e.TargetId, e.TargetName, e.Before, e.After, e.IP} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// auditLock - ключ advisory-блокировки, под которой добавляются записи: цепочка хэшей требует,
+// чтобы записи шли строго одна за другой
+const auditLock = 7301
+
+const auditColumns = `entry_id, created_at, actor_id, actor, action, target_type, target_id, target_name, before, after, ip, prev_hash, hash`
+
+func (e *AuditEntry) fields(extra ...interface{}) []interface{} {
+	return append([]interface{}{&e.Entry_Id, &e.CreatedAt, &e.Actor_Id, &e.Actor, &e.Action, &e.TargetType, &e.TargetId, &e.TargetName,
+		&e.Before, &e.After, &e.IP, &e.PrevHash, &e.Hash}, extra...)
+}
+
+// AddAuditEntry дописывает запись в конец журнала. Время, PrevHash и Hash заполняются здесь
+func (r *Repository) AddAuditEntry(ctx context.Context, e AuditEntry) (saved AuditEntry, err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock($1)`, auditLock)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	err = tx.QueryRow(ctx, `select hash from audit_log order by entry_id desc limit 1`).Scan(&e.PrevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	// в базе время хранится с точностью до микросекунды, хэш считается от уже округленного
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = e.ChainHash()
+	row := tx.QueryRow(ctx, `insert into audit_log (created_at, actor_id, actor, action, target_type, target_id, target_name, before, after, ip, prev_hash, hash)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning `+auditColumns,
+		e.CreatedAt, e.Actor_Id, e.Actor, e.Action, e.TargetType, e.TargetId, e.TargetName, e.Before, e.After, e.IP, e.PrevHash, e.Hash)
+	err = row.Scan(saved.fields()...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	return
+}
+
+// AuditFilter - отбор записей журнала. Actor и Target ищутся по вхождению, Action - точное действие,
+// From и To - границы времени, нулевые не ограничивают
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+func auditQuery(f AuditFilter) (where string, args []interface{}) {
+	var conds []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Actor != "" {
+		conds = append(conds, "actor ilike "+arg("%"+f.Actor+"%"))
+	}
+	if f.Action != "" {
+		conds = append(conds, "action = "+arg(f.Action))
+	}
+	if f.Target != "" {
+		n := arg("%" + f.Target + "%")
+		conds = append(conds, "(target_id ilike "+n+" or target_name ilike "+n+")")
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(f.To))
+	}
+	if len(conds) > 0 {
+		where = ` where ` + strings.Join(conds, " and ")
+	}
+	return
+}
+
+// AuditEntries возвращает страницу журнала, последние записи первыми, и общее число подходящих записей
+func (r *Repository) AuditEntries(ctx context.Context, f AuditFilter) (entries []AuditEntry, total int, err error) {
+	where, args := auditQuery(f)
+	args = append(args, f.Limit, f.Offset)
+	rows, err := r.pool.Query(ctx, `select `+auditColumns+`, count(*) over () from audit_log`+where+
+		fmt.Sprintf(` order by entry_id desc limit $%d offset $%d`, len(args)-1, len(args)), args...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		err = rows.Scan(e.fields(&total)...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// EachAuditEntry передает в fn подходящие записи по одной в порядке добавления, не загружая журнал в память.
+// Limit и Offset не учитываются. Ошибка fn прекращает обход и возвращается
+func (r *Repository) EachAuditEntry(ctx context.Context, f AuditFilter, fn func(e AuditEntry) error) (err error) {
+	where, args := auditQuery(f)
+	rows, err := r.pool.Query(ctx, `select `+auditColumns+` from audit_log`+where+` order by entry_id`, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		err = rows.Scan(e.fields()...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		err = fn(e)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
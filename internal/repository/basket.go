@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddToBasket кладет книгу в список литературы пользователя. Повторное добавление ничего не меняет
+func (r *Repository) AddToBasket(ctx context.Context, userId, bookId string) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into basket_items (user_id, book_id) values ($1, $2) on conflict do nothing`, userId, bookId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) RemoveFromBasket(ctx context.Context, userId, bookId string) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from basket_items where user_id = $1 and book_id = $2`, userId, bookId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) ClearBasket(ctx context.Context, userId string) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from basket_items where user_id = $1`, userId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// Basket возвращает книги списка литературы в порядке добавления. Удаленные из каталога и скрытые
+// от читателей книги пропускаются
+func (r *Repository) Basket(ctx context.Context, userId string) (books []Book, err error) {
+	rows, err := r.pool.Query(ctx, `select `+bookColumns+` from basket_items join books using (book_id) where user_id = $1 and visible order by added_at, book_id`, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields()...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		books = append(books, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
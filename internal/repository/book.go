@@ -22,18 +22,46 @@ const (
 	MODERN     ganr = "Современная литература"
 )
 
+// Categories перечисляет жанры в том порядке, в каком они показываются в формах
+var Categories = []ganr{DETECTIVE, CLASSIC, ADVENTURES, FANTASY, HUMOR, KIND, LOVE, MODERN}
+
+// Book - книга каталога. Publication - дата публикации: до нее книга скрыта от читателей,
+// UnpublishAt - когда снять книгу с публикации, nil - не снимать. Visible - книгу видят читатели,
+// флаг переключают сохранение книги и планировщик публикаций. DeletedAt - когда книгу убрали в корзину,
+// заполняется только в списке корзины: остальные запросы удаленных книг не возвращают
 type Book struct {
-	Book_Id     uuid.UUID `json:"book_id" db:"book_id"`
-	Category    ganr      `json:"category" db:"category"`
-	Author      string    `json:"author" db:"author"`
-	Series      string    `json:"series" db:"series"`
-	Name        string    `json:"name" db:"name"`
-	Annotation  string    `json:"annotation" db:"annotation"`
-	Link        string    `json:"link" db:"link"`
-	Access      string    `json:"access" db:"access"`
-	Publication time.Time `json:"publication" db:"publication"`
+	Book_Id     uuid.UUID  `json:"book_id" db:"book_id"`
+	Category    ganr       `json:"category" db:"category"`
+	Author      string     `json:"author" db:"author"`
+	Series      string     `json:"series" db:"series"`
+	Name        string     `json:"name" db:"name"`
+	Annotation  string     `json:"annotation" db:"annotation"`
+	Link        string     `json:"link" db:"link"`
+	Access      string     `json:"access" db:"access"`
+	Publication time.Time  `json:"publication" db:"publication"`
+	UnpublishAt *time.Time `json:"unpublish_at" db:"unpublish_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	Visible     bool       `json:"visible" db:"visible"`
+	DeletedAt   *time.Time `json:"-" db:"deleted_at"`
+}
+
+const bookColumns = `book_id, category, author, series, name, annotation, link, access, publication, unpublish_at, created_at, updated_at, visible`
+
+// fields - указатели на поля книги в порядке bookColumns для Scan
+func (b *Book) fields(extra ...interface{}) []interface{} {
+	return append([]interface{}{&b.Book_Id, &b.Category, &b.Author, &b.Series, &b.Name, &b.Annotation, &b.Link, &b.Access,
+		&b.Publication, &b.UnpublishAt, &b.CreatedAt, &b.UpdatedAt, &b.Visible}, extra...)
 }
 
+// PublishedAt сообщает, должны ли читатели видеть книгу в момент now: дата публикации наступила,
+// а дата снятия с публикации - еще нет. То же условие в SQL - publishedNow
+func (b Book) PublishedAt(now time.Time) bool {
+	return !b.Publication.After(now) && (b.UnpublishAt == nil || b.UnpublishAt.After(now))
+}
+
+const publishedNow = `(publication <= now() and (unpublish_at is null or unpublish_at > now()))`
+
 type Page struct {
 	Books      []Book
 	Str        []int
@@ -42,6 +70,14 @@ type Page struct {
 	NextNumber int
 	PrevNumber int
 	PageUrl    string
+	// Ratings - оценки книг страницы
+	Ratings map[uuid.UUID]Rating
+	// Sort - порядок списка: пусто - по жанру и автору, "rating" - по средней оценке
+	Sort string
+	// SortUrl - тот же список в другом порядке
+	SortUrl string
+	// Shelves - полки пользователя для кнопок в строках списка
+	Shelves []Shelf
 }
 
 type BookM struct {
@@ -49,10 +85,14 @@ type BookM struct {
 	Message string
 }
 
-func (r *Repository) AddNewBook(ctx context.Context, CategoryS, Author, Series, Name, Annotation, Link, Access string, Publication time.Time) (err error) {
+// AddNewBook создает книгу. Книга с датой публикации в будущем остается скрытой до этой даты
+func (r *Repository) AddNewBook(ctx context.Context, CategoryS, Author, Series, Name, Annotation, Link, Access string, Publication time.Time, UnpublishAt *time.Time) (b Book, err error) {
+	visible := Book{Publication: Publication, UnpublishAt: UnpublishAt}.PublishedAt(time.Now())
 
-	_, err = r.pool.Exec(ctx, `insert into books (category, author, series, name, annotation, link, access, publication) values ($1, $2,$3, $4, $5,$6,$7,$8)`, CategoryS, Author, Series, Name, Annotation, Link, Access, Publication)
+	row := r.pool.QueryRow(ctx, `insert into books (category, author, series, name, annotation, link, access, publication, unpublish_at, visible, announced_at)
+		values ($1, $2,$3, $4, $5,$6,$7,$8,$9,$10, case when $10 then now() end) returning `+bookColumns, CategoryS, Author, Series, Name, Annotation, Link, Access, Publication, UnpublishAt, visible)
 
+	err = row.Scan(b.fields()...)
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
 		return
@@ -61,32 +101,29 @@ func (r *Repository) AddNewBook(ctx context.Context, CategoryS, Author, Series,
 	return
 }
 
+// AllBook - страница каталога. s - фильтры по жанру, автору, серии, названию и ссылке (все заданные сразу),
+// необязательный шестой элемент - порядок "rating", седьмой - "hidden", чтобы показать и скрытые
+// от читателей книги (список администратора)
 func (r *Repository) AllBook(ctx context.Context, pageNumber, pageSize int, s ...string) (page Page, err error) {
-	var qwery string = ""
+	var qwery string = "select book_id, category, author, name, publication, visible from books where visible and deleted_at is null"
 	var p Page
-	if s[0] == "" && s[1] == "" && s[2] == "" && s[3] == "" && s[4] == "" {
-		qwery = "select book_id, category, author, name from books order by category, author"
+	order := " order by category, author"
+	if len(s) > 5 && s[5] == "rating" {
+		order = " order by " + ratingOrder + " desc, category, author"
 	}
-
-	if s[0] != "" && s[1] == "" && s[2] == "" && s[3] == "" && s[4] == "" {
-		qwery = "select book_id, category, author, name from books where category ilike '%" + s[0] + "%' order by category, author"
-	}
-
-	if s[0] == "" && s[1] != "" && s[2] == "" && s[3] == "" && s[4] == "" {
-		qwery = "select book_id, category, author, name from books where author ilike '%" + s[1] + "%' order by category, author"
+	if len(s) > 6 && s[6] == "hidden" {
+		qwery = "select book_id, category, author, name, publication, visible from books where deleted_at is null"
 	}
 
-	if s[0] == "" && s[1] == "" && s[2] != "" && s[3] == "" && s[4] == "" {
-		qwery = "select book_id, category, author, name from books where series ilike '%" + s[2] + "%' order by category, author"
-	}
-
-	if s[0] == "" && s[1] == "" && s[2] == "" && s[3] != "" && s[4] == "" {
-		qwery = "select book_id, category, author, name from books where name ilike '%" + s[3] + "%' order by category, author"
-	}
-	if s[0] == "" && s[1] == "" && s[2] == "" && s[3] == "" && s[4] != "" {
-		qwery = "select book_id, category, author, name from books where link ilike '%" + s[4] + "%' order by category, author"
+	// заданные фильтры сочетаются через and; значения передаются параметрами, а не вставляются в запрос
+	var args []interface{}
+	for i, column := range []string{"category", "author", "series", "name", "link"} {
+		if i < len(s) && s[i] != "" {
+			args = append(args, "%"+s[i]+"%")
+			qwery += fmt.Sprintf(" and %s ilike $%d", column, len(args))
+		}
 	}
-	rows, err := r.pool.Query(ctx, qwery)
+	rows, err := r.pool.Query(ctx, qwery+order, args...)
 	if err != nil {
 		err = fmt.Errorf("failed to query data: %w", err)
 		return
@@ -95,7 +132,7 @@ func (r *Repository) AllBook(ctx context.Context, pageNumber, pageSize int, s ..
 
 	for rows.Next() {
 		var b Book
-		err = rows.Scan(&b.Book_Id, &b.Category, &b.Author, &b.Name)
+		err = rows.Scan(&b.Book_Id, &b.Category, &b.Author, &b.Name, &b.Publication, &b.Visible)
 
 		if err != nil {
 			err = fmt.Errorf("failed to scan data: %w", err)
@@ -121,6 +158,14 @@ func (r *Repository) AllBook(ctx context.Context, pageNumber, pageSize int, s ..
 		end = len(p.Books)
 	}
 	p.Books = p.Books[start:end]
+	ids := make([]uuid.UUID, len(p.Books))
+	for i, b := range p.Books {
+		ids[i] = b.Book_Id
+	}
+	p.Ratings, err = r.BookRatings(ctx, ids)
+	if err != nil {
+		return
+	}
 	p.Number = pageNumber
 	p.NextNumber = pageNumber + 1
 	p.PrevNumber = pageNumber - 1
@@ -129,8 +174,9 @@ func (r *Repository) AllBook(ctx context.Context, pageNumber, pageSize int, s ..
 	return
 }
 
+// DeleteBookById убирает книгу в корзину: она скрывается от всех, пока ее не восстановят или не удалят навсегда
 func (r *Repository) DeleteBookById(ctx context.Context, id string) (err error) {
-	_, err = r.pool.Exec(ctx, `delete from books where book_id = $1`, id)
+	_, err = r.pool.Exec(ctx, `update books set deleted_at = now(), visible = false, version = version + 1 where book_id = $1 and deleted_at is null`, id)
 
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
@@ -141,9 +187,9 @@ func (r *Repository) DeleteBookById(ctx context.Context, id string) (err error)
 }
 
 func (r *Repository) GetBookById(ctx context.Context, id string) (b Book, err error) {
-	rows := r.pool.QueryRow(ctx, `select * from books where book_id = $1`, id)
+	rows := r.pool.QueryRow(ctx, `select `+bookColumns+` from books where book_id = $1 and deleted_at is null`, id)
 
-	err = rows.Scan(&b.Book_Id, &b.Category, &b.Author, &b.Series, &b.Name, &b.Annotation, &b.Link, &b.Access, &b.Publication)
+	err = rows.Scan(b.fields()...)
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
 		return
@@ -152,8 +198,12 @@ func (r *Repository) GetBookById(ctx context.Context, id string) (b Book, err er
 	return b, err
 }
 
-func (r *Repository) PutBookById(ctx context.Context, id, CategoryS, Author, Series, Name, Annotation, Link, Access string, Publication time.Time) (err error) {
-	_, err = r.pool.Exec(ctx, `update books set category = $2, author = $3, series=$4, name=$5, annotation=$6, link=$7, access=$8, publication=$9 where book_id = $1`, id, CategoryS, Author, Series, Name, Annotation, Link, Access, Publication)
+// PutBookById обновляет книгу. Видимость пересчитывается по новым датам публикации
+func (r *Repository) PutBookById(ctx context.Context, id, CategoryS, Author, Series, Name, Annotation, Link, Access string, Publication time.Time, UnpublishAt *time.Time) (err error) {
+	visible := Book{Publication: Publication, UnpublishAt: UnpublishAt}.PublishedAt(time.Now())
+
+	_, err = r.pool.Exec(ctx, `update books set category = $2, author = $3, series=$4, name=$5, annotation=$6, link=$7, access=$8, publication=$9, unpublish_at=$10,
+		visible=$11, announced_at = case when $11 then coalesce(announced_at, now()) else announced_at end, updated_at = now(), version = version + 1 where book_id = $1 and deleted_at is null`, id, CategoryS, Author, Series, Name, Annotation, Link, Access, Publication, UnpublishAt, visible)
 
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
@@ -162,3 +212,16 @@ func (r *Repository) PutBookById(ctx context.Context, id, CategoryS, Author, Ser
 
 	return
 }
+
+// PublishScheduled переключает видимость книг, у которых наступила дата публикации или снятия с публикации,
+// и возвращает открытые и скрытые книги
+func (r *Repository) PublishScheduled(ctx context.Context) (published, withdrawn []Book, err error) {
+	published, err = r.queryBooks(ctx, `update books set visible = true, announced_at = coalesce(announced_at, now())
+		where not visible and deleted_at is null and `+publishedNow+` returning `+bookColumns)
+	if err != nil {
+		return
+	}
+	withdrawn, err = r.queryBooks(ctx, `update books set visible = false where visible and not `+publishedNow+` returning `+bookColumns)
+
+	return
+}
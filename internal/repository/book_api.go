@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrVersionConflict - книгу изменили после того, как клиент ее прочитал
+var ErrVersionConflict = errors.New("version conflict")
+
+// BookFilter - условия выборки книг для API. Текстовые поля ищутся по вхождению без учета регистра,
+// Query - сразу по названию, автору, серии и аннотации
+type BookFilter struct {
+	Category string
+	Author   string
+	Series   string
+	Name     string
+	Query    string
+	// Exact - Category, Author и Series сравниваются целиком, а не по вхождению
+	Exact bool
+	// Hidden - вместе с книгами, скрытыми от читателей: еще не опубликованными и снятыми с публикации
+	Hidden bool
+	// Sort - поле сортировки, с минусом впереди - по убыванию
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// BookSortFields - поля, по которым разрешена сортировка
+var BookSortFields = []string{"name", "author", "series", "category", "publication", "rating"}
+
+// bookQuery строит условие where и порядок сортировки для фильтра
+func bookQuery(f BookFilter) (where string, order string, args []interface{}) {
+	var conds []string
+	like := func(cond string, v string) {
+		args = append(args, "%"+v+"%")
+		conds = append(conds, strings.ReplaceAll(cond, "$", fmt.Sprintf("$%d", len(args))))
+	}
+	field := like
+	if f.Exact {
+		field = func(cond string, v string) {
+			args = append(args, v)
+			cond = strings.Replace(cond, " ilike $", " = $", 1)
+			conds = append(conds, strings.ReplaceAll(cond, "$", fmt.Sprintf("$%d", len(args))))
+		}
+	}
+	if f.Category != "" {
+		field("category ilike $", f.Category)
+	}
+	if f.Author != "" {
+		field("author ilike $", f.Author)
+	}
+	if f.Series != "" {
+		field("series ilike $", f.Series)
+	}
+	if f.Name != "" {
+		like("name ilike $", f.Name)
+	}
+	if f.Query != "" {
+		like("(name ilike $ or author ilike $ or series ilike $ or annotation ilike $)", f.Query)
+	}
+	if !f.Hidden {
+		conds = append(conds, "visible")
+	}
+	conds = append(conds, "deleted_at is null")
+	if len(conds) > 0 {
+		where = ` where ` + strings.Join(conds, " and ")
+	}
+
+	order = "category, author"
+	if field := strings.TrimPrefix(f.Sort, "-"); field != "" {
+		for _, s := range BookSortFields {
+			if s == field {
+				order = field
+			}
+		}
+		if order == "rating" {
+			order = ratingOrder
+		}
+		if strings.HasPrefix(f.Sort, "-") {
+			order += " desc"
+		}
+		order += ", book_id"
+	}
+	return
+}
+
+// FindBooks возвращает страницу книг и общее число книг, подходящих под фильтр
+func (r *Repository) FindBooks(ctx context.Context, f BookFilter) (books []Book, total int, err error) {
+	where, order, args := bookQuery(f)
+	args = append(args, f.Limit, f.Offset)
+	qwery := `select ` + bookColumns + `, count(*) over () from books` + where +
+		fmt.Sprintf(` order by %s limit $%d offset $%d`, order, len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, qwery, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields(&total)...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		books = append(books, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// EachBook передает в fn книги, подходящие под фильтр, по одной, не загружая выборку в память.
+// Limit и Offset учитываются, если заданы. Ошибка fn прекращает обход и возвращается
+func (r *Repository) EachBook(ctx context.Context, f BookFilter, fn func(b Book) error) (err error) {
+	where, order, args := bookQuery(f)
+	qwery := `select ` + bookColumns + ` from books` + where + ` order by ` + order
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		qwery += fmt.Sprintf(` limit $%d`, len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		qwery += fmt.Sprintf(` offset $%d`, len(args))
+	}
+
+	rows, err := r.pool.Query(ctx, qwery, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields()...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		err = fn(b)
+		if err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// GetBookVersion возвращает книгу вместе с номером версии, который растет при каждом изменении
+func (r *Repository) GetBookVersion(ctx context.Context, id string) (b Book, version int, err error) {
+	row := r.pool.QueryRow(ctx, `select `+bookColumns+`, version from books where book_id = $1 and deleted_at is null`, id)
+
+	err = row.Scan(b.fields(&version)...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// AddBook создает книгу и возвращает ее вместе с присвоенным идентификатором. Видимость книги
+// определяется датами публикации
+func (r *Repository) AddBook(ctx context.Context, b Book) (created Book, version int, err error) {
+	row := r.pool.QueryRow(ctx, `insert into books (category, author, series, name, annotation, link, access, publication, unpublish_at, visible, announced_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, case when $10 then now() end) returning `+bookColumns+`, version`,
+		b.Category, b.Author, b.Series, b.Name, b.Annotation, b.Link, b.Access, b.Publication, b.UnpublishAt, b.PublishedAt(time.Now()))
+
+	err = row.Scan(created.fields(&version)...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// PutBookVersion обновляет книгу, только если ее версия не изменилась, иначе возвращает ErrVersionConflict.
+// Возвращает книгу в сохраненном виде
+func (r *Repository) PutBookVersion(ctx context.Context, b Book, version int) (saved Book, newVersion int, err error) {
+	row := r.pool.QueryRow(ctx, `update books set category = $3, author = $4, series = $5, name = $6, annotation = $7, link = $8, access = $9,
+		publication = $10, unpublish_at = $11, visible = $12, announced_at = case when $12 then coalesce(announced_at, now()) else announced_at end,
+		updated_at = now(), version = version + 1
+		where book_id = $1 and version = $2 and deleted_at is null returning `+bookColumns+`, version`,
+		b.Book_Id, version, b.Category, b.Author, b.Series, b.Name, b.Annotation, b.Link, b.Access, b.Publication, b.UnpublishAt, b.PublishedAt(time.Now()))
+
+	err = row.Scan(saved.fields(&newVersion)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrVersionConflict
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteBookVersion убирает книгу в корзину, только если ее версия не изменилась
+func (r *Repository) DeleteBookVersion(ctx context.Context, id string, version int) (err error) {
+	tag, err := r.pool.Exec(ctx, `update books set deleted_at = now(), visible = false, version = version + 1
+		where book_id = $1 and version = $2 and deleted_at is null`, id, version)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		err = ErrVersionConflict
+	}
+
+	return
+}
+
+// Facet - значение поля книги и число книг с ним
+type Facet struct {
+	Value string
+	Count int
+}
+
+// BookFacets возвращает различные значения поля category, author или series с числом опубликованных книг
+func (r *Repository) BookFacets(ctx context.Context, field string) (facets []Facet, err error) {
+	switch field {
+	case "category", "author", "series":
+	default:
+		err = fmt.Errorf("unknown book field %q", field)
+		return
+	}
+
+	rows, err := r.pool.Query(ctx, `select `+field+`, count(*) from books where visible and `+field+` <> '' group by `+field+` order by `+field)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f Facet
+		err = rows.Scan(&f.Value, &f.Count)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		facets = append(facets, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetUserByIdentity ищет пользователя, связанного с учетной записью внешнего провайдера
+func (r *Repository) GetUserByIdentity(ctx context.Context, provider, subject string) (u User, err error) {
+	row := r.pool.QueryRow(ctx, `select u.user_id, u.username, u.role, u.full_name, u.active, u.totp_enabled from users u join user_identities i on i.user_id = u.user_id where i.provider = $1 and i.subject = $2 and u.deleted_at is null`, provider, subject)
+
+	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.TOTPEnabled)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// AddExternalUser создает пользователя при первом входе через внешний провайдер.
+// Пароль не задается, поэтому войти по логину и паролю такой пользователь не может
+func (r *Repository) AddExternalUser(ctx context.Context, provider, subject, username, fullName, email string, emailVerified bool, role string) (u User, err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx, `insert into users (username, role, full_name, hashed_password, active, email, email_verified) values ($1, $2, $3, '', true, $4, $5) returning user_id, username, role, full_name, active`, username, role, fullName, email, emailVerified)
+	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	_, err = tx.Exec(ctx, `insert into user_identities (provider, subject, user_id) values ($1, $2, $3)`, provider, subject, u.User_Id)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) PutUserRole(ctx context.Context, id, role string) (err error) {
+	_, err = r.pool.Exec(ctx, `update users set role = $1 where user_id = $2`, role, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// ExternalUsers возвращает пользователей провайдера по их идентификаторам у провайдера
+func (r *Repository) ExternalUsers(ctx context.Context, provider string) (users map[string]User, err error) {
+	rows, err := r.pool.Query(ctx, `select i.subject, u.user_id, u.username, u.role, u.full_name, u.active from users u join user_identities i on i.user_id = u.user_id where i.provider = $1 and u.deleted_at is null`, provider)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	users = make(map[string]User)
+	for rows.Next() {
+		var subject string
+		var u User
+		err = rows.Scan(&subject, &u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		users[subject] = u
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
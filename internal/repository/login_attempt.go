@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type LoginAttempt struct {
+	Id          int64     `json:"id" db:"id"`
+	Username    string    `json:"login" db:"username"`
+	IP          string    `json:"ip" db:"ip"`
+	Success     bool      `json:"success" db:"success"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+func (r *Repository) AddLoginAttempt(ctx context.Context, username, ip string, success bool) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into login_attempts (username, ip, success) values ($1, $2, $3)`, username, ip, success)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) FailedLoginAttempts(ctx context.Context, username string, limit int) (attempts []LoginAttempt, err error) {
+	rows, err := r.pool.Query(ctx, `select id, username, ip, success, attempted_at from login_attempts where username = $1 and not success order by attempted_at desc limit $2`, username, limit)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var la LoginAttempt
+		err = rows.Scan(&la.Id, &la.Username, &la.IP, &la.Success, &la.AttemptedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		attempts = append(attempts, la)
+	}
+
+	return
+}
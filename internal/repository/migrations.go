@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// migrations создают таблицы и колонки, которых нет в исходной схеме books/users/motivations.
+// Каждый оператор должен быть идемпотентным, так как выполняется при каждом запуске.
+var migrations = []string{
+	`create table if not exists login_attempts (
+		id bigserial primary key,
+		username text not null,
+		ip text not null,
+		success boolean not null,
+		attempted_at timestamptz not null default now()
+	)`,
+	`create index if not exists login_attempts_username_idx on login_attempts (username, attempted_at desc)`,
+	`alter table users add column if not exists email text not null default ''`,
+	`alter table users add column if not exists email_verified boolean not null default false`,
+	`create table if not exists user_tokens (
+		token_hash text primary key,
+		user_id uuid not null,
+		purpose text not null,
+		expires_at timestamptz not null,
+		used_at timestamptz
+	)`,
+	`alter table users add column if not exists created_at timestamptz not null default now()`,
+	`alter table users add column if not exists preferences text[] not null default '{}'`,
+	`alter table users add column if not exists deletion_requested_at timestamptz`,
+	`alter table users add column if not exists totp_secret text not null default ''`,
+	`alter table users add column if not exists totp_enabled boolean not null default false`,
+	`alter table users add column if not exists totp_last_step bigint not null default 0`,
+	`create table if not exists recovery_codes (
+		user_id uuid not null,
+		code_hash text not null,
+		used_at timestamptz,
+		primary key (user_id, code_hash)
+	)`,
+	`create table if not exists settings (
+		key text primary key,
+		value text not null
+	)`,
+	`create table if not exists user_identities (
+		provider text not null,
+		subject text not null,
+		user_id uuid not null,
+		primary key (provider, subject)
+	)`,
+	`create table if not exists api_tokens (
+		token_id bigserial primary key,
+		user_id uuid not null,
+		name text not null,
+		token_hash text not null unique,
+		scopes text[] not null default '{}',
+		created_at timestamptz not null default now(),
+		expires_at timestamptz,
+		last_used_at timestamptz,
+		revoked_at timestamptz
+	)`,
+	`create index if not exists api_tokens_user_idx on api_tokens (user_id)`,
+	`alter table books add column if not exists version integer not null default 1`,
+	`create table if not exists webhooks (
+		webhook_id bigserial primary key,
+		url text not null,
+		secret text not null,
+		events text[] not null default '{}',
+		active boolean not null default true,
+		created_at timestamptz not null default now()
+	)`,
+	`create table if not exists webhook_deliveries (
+		delivery_id bigserial primary key,
+		webhook_id bigint not null,
+		event text not null,
+		payload text not null,
+		status text not null default 'pending',
+		attempts integer not null default 0,
+		response_code integer not null default 0,
+		response text not null default '',
+		created_at timestamptz not null default now(),
+		next_attempt_at timestamptz not null default now(),
+		delivered_at timestamptz
+	)`,
+	`create index if not exists webhook_deliveries_queue_idx on webhook_deliveries (next_attempt_at) where status = 'pending'`,
+	`create index if not exists webhook_deliveries_webhook_idx on webhook_deliveries (webhook_id, created_at desc)`,
+	`create table if not exists basket_items (
+		user_id uuid not null,
+		book_id uuid not null,
+		added_at timestamptz not null default now(),
+		primary key (user_id, book_id)
+	)`,
+	`create table if not exists reviews (
+		review_id bigserial primary key,
+		user_id uuid not null,
+		book_id uuid not null,
+		rating smallint not null check (rating between 1 and 5),
+		body text not null default '',
+		status text not null default 'pending',
+		created_at timestamptz not null default now(),
+		updated_at timestamptz not null default now(),
+		unique (user_id, book_id)
+	)`,
+	`create index if not exists reviews_book_idx on reviews (book_id) where status = 'approved'`,
+	`create index if not exists reviews_pending_idx on reviews (updated_at) where status = 'pending'`,
+	`create table if not exists shelves (
+		shelf_id bigserial primary key,
+		user_id uuid not null,
+		name text not null,
+		kind text not null default 'custom',
+		share_token text unique,
+		created_at timestamptz not null default now()
+	)`,
+	`create unique index if not exists shelves_name_idx on shelves (user_id, lower(name))`,
+	`create unique index if not exists shelves_builtin_idx on shelves (user_id, kind) where kind <> 'custom'`,
+	`create table if not exists shelf_books (
+		shelf_id bigint not null,
+		book_id uuid not null,
+		added_at timestamptz not null default now(),
+		primary key (shelf_id, book_id)
+	)`,
+	`create table if not exists book_similarities (
+		book_id uuid not null,
+		similar_id uuid not null,
+		score double precision not null,
+		primary key (book_id, similar_id)
+	)`,
+	`create index if not exists books_publication_idx on books (publication desc)`,
+	`create table if not exists follows (
+		follow_id bigserial primary key,
+		user_id uuid not null,
+		kind text not null,
+		value text not null,
+		created_at timestamptz not null default now(),
+		notified_at timestamptz not null default now()
+	)`,
+	`create unique index if not exists follows_value_idx on follows (user_id, kind, lower(value))`,
+	`alter table books add column if not exists created_at timestamptz`,
+	`update books set created_at = publication where created_at is null`,
+	`alter table books alter column created_at set default now()`,
+	`alter table books alter column created_at set not null`,
+	`alter table books add column if not exists updated_at timestamptz`,
+	`update books set updated_at = publication where updated_at is null`,
+	`alter table books alter column updated_at set default now()`,
+	`alter table books alter column updated_at set not null`,
+	`alter table books add column if not exists unpublish_at timestamptz`,
+	`alter table books add column if not exists visible boolean`,
+	`update books set visible = true where visible is null`,
+	`alter table books alter column visible set default false`,
+	`alter table books alter column visible set not null`,
+	`alter table books add column if not exists announced_at timestamptz`,
+	`update books set announced_at = publication where announced_at is null and visible`,
+	`create index if not exists books_schedule_idx on books (publication) where not visible`,
+	`create table if not exists book_revisions (
+		revision_id bigserial primary key,
+		book_id uuid not null,
+		user_id uuid,
+		action text not null,
+		source bigint,
+		changed text[] not null default '{}',
+		data jsonb not null,
+		created_at timestamptz not null default now()
+	)`,
+	`create index if not exists book_revisions_book_idx on book_revisions (book_id, revision_id desc)`,
+	`alter table books add column if not exists deleted_at timestamptz`,
+	`alter table users add column if not exists deleted_at timestamptz`,
+	`create index if not exists books_deleted_idx on books (deleted_at) where deleted_at is not null`,
+	`create index if not exists users_deleted_idx on users (deleted_at) where deleted_at is not null`,
+	`create table if not exists audit_log (
+		entry_id bigserial primary key,
+		created_at timestamptz not null,
+		actor_id uuid,
+		actor text not null default '',
+		action text not null,
+		target_type text not null default '',
+		target_id text not null default '',
+		target_name text not null default '',
+		before text not null default '',
+		after text not null default '',
+		ip text not null default '',
+		prev_hash text not null,
+		hash text not null
+	)`,
+	`create index if not exists audit_log_created_idx on audit_log (created_at)`,
+	// журнал аудита только дописывается: изменить или удалить записи нельзя даже прямым запросом
+	`create or replace function audit_log_append_only() returns trigger language plpgsql as $$
+	begin
+		raise exception 'audit_log is append-only';
+	end
+	$$`,
+	`drop trigger if exists audit_log_append_only on audit_log`,
+	`create trigger audit_log_append_only before update or delete or truncate on audit_log
+		for each statement execute procedure audit_log_append_only()`,
+}
+
+func Migrate(ctx context.Context, dbpool *pgxpool.Pool) (err error) {
+	for _, m := range migrations {
+		_, err = dbpool.Exec(ctx, m)
+		if err != nil {
+			err = fmt.Errorf("failed to migrate: %w", err)
+			return
+		}
+	}
+
+	return
+}
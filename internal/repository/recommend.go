@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Signal - насколько читателю интересна книга: 1 - прочитал, добавил в избранное или оценил на 4-5,
+// 0.5 - хочет прочитать или оценил на 3
+type Signal struct {
+	User_Id uuid.UUID
+	Book_Id uuid.UUID
+	Weight  float64
+}
+
+// Similarity - близость книги Similar_Id к книге Book_Id, от 0 до 1
+type Similarity struct {
+	Book_Id    uuid.UUID
+	Similar_Id uuid.UUID
+	Score      float64
+}
+
+// readingSignals - интерес читателей к книгам по полкам и оценкам. Низкая оценка перевешивает
+// полки: книгу, которая не понравилась, не считаем интересной
+const readingSignals = `select user_id, book_id, max(weight) as weight from (
+		select s.user_id, sb.book_id, case s.kind when 'want' then 0.5 else 1 end as weight
+		from shelf_books sb join shelves s on s.shelf_id = sb.shelf_id
+		union all
+		select user_id, book_id, case when rating >= 4 then 1 when rating = 3 then 0.5 else -1 end from reviews
+	) t group by user_id, book_id having min(weight) > 0`
+
+// knownBooks - книги, которые читатель $1 уже видел: стоят на его полках или оценены им
+const knownBooks = `select sb.book_id from shelf_books sb join shelves s on s.shelf_id = sb.shelf_id where s.user_id = $1
+	union select book_id from reviews where user_id = $1`
+
+// ReadingSignals возвращает интерес всех читателей ко всем книгам для расчета рекомендаций
+func (r *Repository) ReadingSignals(ctx context.Context) (signals []Signal, err error) {
+	rows, err := r.pool.Query(ctx, `select user_id, book_id, weight::float8 from (`+readingSignals+`) t join books using (book_id)`)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Signal
+		err = rows.Scan(&s.User_Id, &s.Book_Id, &s.Weight)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		signals = append(signals, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// SaveSimilarities заменяет рассчитанные близости книг целиком, читатели видят либо старый расчет, либо новый
+func (r *Repository) SaveSimilarities(ctx context.Context, list []Similarity) (err error) {
+	books := make([]string, len(list))
+	similar := make([]string, len(list))
+	scores := make([]float64, len(list))
+	for i, s := range list {
+		books[i], similar[i], scores[i] = s.Book_Id.String(), s.Similar_Id.String(), s.Score
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `delete from book_similarities`)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	_, err = tx.Exec(ctx, `insert into book_similarities (book_id, similar_id, score)
+		select * from unnest($1::uuid[], $2::uuid[], $3::float8[])`, books, similar, scores)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	return
+}
+
+// SimilarBooks возвращает книги, похожие на данную, самые близкие первыми
+func (r *Repository) SimilarBooks(ctx context.Context, bookId string, limit int) (books []Book, err error) {
+	return r.queryBooks(ctx, `select `+bookColumns+` from books join (
+		select similar_id as book_id, score from book_similarities where book_id = $1
+	) s using (book_id) where visible order by s.score desc, book_id limit $2`, bookId, limit)
+}
+
+// Recommendations подбирает читателю книги, похожие на интересные ему, кроме тех, что он уже видел
+func (r *Repository) Recommendations(ctx context.Context, userId string, limit int) (books []Book, err error) {
+	return r.queryBooks(ctx, `select `+bookColumns+` from books join (
+		select s.similar_id as book_id, sum(s.score * m.weight) as score
+		from book_similarities s join (`+readingSignals+`) m on m.book_id = s.book_id
+		where m.user_id = $1 and s.similar_id not in (`+knownBooks+`)
+		group by s.similar_id
+	) r using (book_id) where visible order by r.score desc, book_id limit $2`, userId, limit)
+}
+
+// PopularBooks - книги, интересные наибольшему числу читателей, кроме уже виденных читателем.
+// Нужны тем, о ком еще ничего не известно
+func (r *Repository) PopularBooks(ctx context.Context, userId string, limit int) (books []Book, err error) {
+	return r.queryBooks(ctx, `select `+bookColumns+` from books join (
+		select book_id, sum(weight) as score from (`+readingSignals+`) m group by book_id
+	) p using (book_id) where visible and book_id not in (`+knownBooks+`) order by p.score desc, book_id limit $2`, userId, limit)
+}
+
+func (r *Repository) queryBooks(ctx context.Context, qwery string, args ...interface{}) (books []Book, err error) {
+	rows, err := r.pool.Query(ctx, qwery, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields()...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		books = append(books, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type reviewStatus string
+
+// состояния отзыва. Оценка без текста публикуется сразу, отзыв с текстом ждет модерации
+const (
+	REVIEW_PENDING  reviewStatus = "pending"
+	REVIEW_APPROVED reviewStatus = "approved"
+	REVIEW_REJECTED reviewStatus = "rejected"
+)
+
+type Review struct {
+	Review_Id int64        `json:"review_id" db:"review_id"`
+	Book_Id   uuid.UUID    `json:"book_id" db:"book_id"`
+	User_Id   uuid.UUID    `json:"user_id" db:"user_id"`
+	Reviewer  string       `json:"reviewer" db:"reviewer"`
+	Rating    int          `json:"rating" db:"rating"`
+	Body      string       `json:"body" db:"body"`
+	Status    reviewStatus `json:"status" db:"status"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+	// BookName заполняется только в очереди модерации
+	BookName string `json:"-" db:"book_name"`
+}
+
+// Rating - средняя оценка книги по опубликованным отзывам
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// ratingOrder - выражение сортировки книг по средней оценке; книги без оценок идут как с нулевой
+const ratingOrder = `coalesce((select avg(v.rating) from reviews v where v.book_id = books.book_id and v.status = 'approved'), 0)`
+
+// имя автора отзыва видно всем, поэтому логин вместо пустого имени не подставляется
+const reviewColumns = `v.review_id, v.book_id, v.user_id, coalesce(nullif(u.full_name, ''), 'Читатель'), v.rating, v.body, v.status, v.created_at, v.updated_at`
+
+// SaveReview создает или заменяет отзыв пользователя о книге
+func (r *Repository) SaveReview(ctx context.Context, userId, bookId string, rating int, body string, status reviewStatus) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into reviews (user_id, book_id, rating, body, status) values ($1, $2, $3, $4, $5)
+		on conflict (user_id, book_id) do update set rating = excluded.rating, body = excluded.body, status = excluded.status, updated_at = now()`,
+		userId, bookId, rating, body, status)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) DeleteReview(ctx context.Context, userId, bookId string) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from reviews where user_id = $1 and book_id = $2`, userId, bookId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// UserReview возвращает отзыв пользователя о книге в любом состоянии
+func (r *Repository) UserReview(ctx context.Context, userId, bookId string) (v Review, err error) {
+	row := r.pool.QueryRow(ctx, `select `+reviewColumns+` from reviews v left join users u on u.user_id = v.user_id where v.user_id = $1 and v.book_id = $2`, userId, bookId)
+
+	err = row.Scan(&v.Review_Id, &v.Book_Id, &v.User_Id, &v.Reviewer, &v.Rating, &v.Body, &v.Status, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// BookReviews возвращает опубликованные отзывы о книге, новые первыми
+func (r *Repository) BookReviews(ctx context.Context, bookId string) (reviews []Review, err error) {
+	rows, err := r.pool.Query(ctx, `select `+reviewColumns+` from reviews v left join users u on u.user_id = v.user_id
+		where v.book_id = $1 and v.status = 'approved' order by v.updated_at desc`, bookId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v Review
+		err = rows.Scan(&v.Review_Id, &v.Book_Id, &v.User_Id, &v.Reviewer, &v.Rating, &v.Body, &v.Status, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		reviews = append(reviews, v)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// PendingReviews - очередь модерации, старые отзывы первыми
+func (r *Repository) PendingReviews(ctx context.Context) (reviews []Review, err error) {
+	rows, err := r.pool.Query(ctx, `select `+reviewColumns+`, b.name from reviews v left join users u on u.user_id = v.user_id
+		join books b on b.book_id = v.book_id where v.status = 'pending' and b.deleted_at is null order by v.updated_at`)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v Review
+		err = rows.Scan(&v.Review_Id, &v.Book_Id, &v.User_Id, &v.Reviewer, &v.Rating, &v.Body, &v.Status, &v.CreatedAt, &v.UpdatedAt, &v.BookName)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		reviews = append(reviews, v)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// ModerateReview публикует или отклоняет отзыв из очереди. Отзыв, который автор успел изменить или удалить, не меняется
+func (r *Repository) ModerateReview(ctx context.Context, id int64, approve bool, seen time.Time) (ok bool, err error) {
+	status := REVIEW_REJECTED
+	if approve {
+		status = REVIEW_APPROVED
+	}
+	tag, err := r.pool.Exec(ctx, `update reviews set status = $2 where review_id = $1 and status = 'pending' and updated_at <= $3`, id, status, seen)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) BookRating(ctx context.Context, bookId string) (rating Rating, err error) {
+	row := r.pool.QueryRow(ctx, `select coalesce(avg(rating)::float8, 0), count(*) from reviews where book_id = $1 and status = 'approved'`, bookId)
+
+	err = row.Scan(&rating.Average, &rating.Count)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// BookRatings возвращает оценки книг списка. Книг без оценок в ответе нет
+func (r *Repository) BookRatings(ctx context.Context, ids []uuid.UUID) (ratings map[uuid.UUID]Rating, err error) {
+	ratings = make(map[uuid.UUID]Rating)
+	if len(ids) == 0 {
+		return
+	}
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = id.String()
+	}
+	rows, err := r.pool.Query(ctx, `select book_id, avg(rating)::float8, count(*) from reviews where book_id = any($1::uuid[]) and status = 'approved' group by book_id`, list)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var rating Rating
+		err = rows.Scan(&id, &rating.Average, &rating.Count)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		ratings[id] = rating
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
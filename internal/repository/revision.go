@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type revisionAction string
+
+// откуда взялась ревизия книги
+const (
+	// REVISION_INITIAL - состояние книги до первого изменения, записанного в историю
+	REVISION_INITIAL revisionAction = "initial"
+	REVISION_CREATE  revisionAction = "create"
+	REVISION_UPDATE  revisionAction = "update"
+	REVISION_IMPORT  revisionAction = "import"
+	REVISION_REVERT  revisionAction = "revert"
+)
+
+// Revision - состояние книги после изменения. Editor - кто изменил, пусто для исходного состояния,
+// Changed - поля, отличающиеся от предыдущей ревизии, Source - ревизия, к которой вернули книгу, для REVISION_REVERT
+type Revision struct {
+	Revision_Id int64          `json:"revision_id" db:"revision_id"`
+	Book_Id     uuid.UUID      `json:"book_id" db:"book_id"`
+	User_Id     *uuid.UUID     `json:"user_id" db:"user_id"`
+	Editor      string         `json:"editor" db:"editor"`
+	Action      revisionAction `json:"action" db:"action"`
+	Source      *int64         `json:"source,omitempty" db:"source"`
+	Changed     []string       `json:"changed" db:"changed"`
+	Book        Book           `json:"book" db:"data"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+}
+
+// RevisionFields - поля книги, изменения которых попадают в историю, в порядке показа
+var RevisionFields = []string{"category", "author", "series", "name", "annotation", "link", "access", "publication", "unpublish_at"}
+
+// FieldValue - значение поля книги из RevisionFields в виде для истории
+func (b Book) FieldValue(field string) string {
+	switch field {
+	case "category":
+		return string(b.Category)
+	case "author":
+		return b.Author
+	case "series":
+		return b.Series
+	case "name":
+		return b.Name
+	case "annotation":
+		return b.Annotation
+	case "link":
+		return b.Link
+	case "access":
+		return b.Access
+	case "publication":
+		return b.Publication.Local().Format("02.01.2006 15:04")
+	case "unpublish_at":
+		if b.UnpublishAt == nil {
+			return ""
+		}
+		return b.UnpublishAt.Local().Format("02.01.2006 15:04")
+	}
+	return ""
+}
+
+// BookChanges - поля из RevisionFields, в которых книги различаются
+func BookChanges(old, b Book) (changed []string) {
+	for _, f := range RevisionFields {
+		if old.FieldValue(f) != b.FieldValue(f) {
+			changed = append(changed, f)
+		}
+	}
+	return
+}
+
+const revisionColumns = `v.revision_id, v.book_id, v.user_id, coalesce(nullif(u.full_name, ''), u.username, ''), v.action, v.source, v.changed, v.data, v.created_at`
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (rev Revision, err error) {
+	var data []byte
+	err = row.Scan(&rev.Revision_Id, &rev.Book_Id, &rev.User_Id, &rev.Editor, &rev.Action, &rev.Source, &rev.Changed, &data, &rev.CreatedAt)
+	if err != nil {
+		err = fmt.Errorf("failed to scan data: %w", err)
+		return
+	}
+	err = json.Unmarshal(data, &rev.Book)
+	if err != nil {
+		err = fmt.Errorf("failed to decode revision %d: %w", rev.Revision_Id, err)
+	}
+	return
+}
+
+// AddRevision записывает ревизию книги. Если истории у книги еще нет, а old задан, сначала записывается
+// исходное состояние old, чтобы к нему можно было вернуться
+func (r *Repository) AddRevision(ctx context.Context, old *Book, rev Revision) (err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if old != nil {
+		data, _ := json.Marshal(old)
+		_, err = tx.Exec(ctx, `insert into book_revisions (book_id, action, changed, data, created_at)
+			select $1, $2, '{}', $3, $4 where not exists (select 1 from book_revisions where book_id = $1)`,
+			rev.Book_Id, REVISION_INITIAL, data, old.UpdatedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to exec data: %w", err)
+			return
+		}
+	}
+
+	if rev.Changed == nil {
+		rev.Changed = []string{}
+	}
+	data, _ := json.Marshal(rev.Book)
+	_, err = tx.Exec(ctx, `insert into book_revisions (book_id, user_id, action, source, changed, data) values ($1, $2, $3, $4, $5, $6)`,
+		rev.Book_Id, rev.User_Id, rev.Action, rev.Source, rev.Changed, data)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	return
+}
+
+// BookRevisions возвращает историю книги, последние изменения первыми
+func (r *Repository) BookRevisions(ctx context.Context, bookId string) (revisions []Revision, err error) {
+	rows, err := r.pool.Query(ctx, `select `+revisionColumns+` from book_revisions v left join users u on u.user_id = v.user_id
+		where v.book_id = $1 order by v.revision_id desc`, bookId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rev Revision
+		rev, err = scanRevision(rows)
+		if err != nil {
+			return
+		}
+		revisions = append(revisions, rev)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// GetRevision возвращает ревизию книги bookId
+func (r *Repository) GetRevision(ctx context.Context, bookId string, id int64) (rev Revision, err error) {
+	row := r.pool.QueryRow(ctx, `select `+revisionColumns+` from book_revisions v left join users u on u.user_id = v.user_id
+		where v.book_id = $1 and v.revision_id = $2`, bookId, id)
+
+	return scanRevision(row)
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// настройки, которые администратор меняет из интерфейса
+const (
+	REQUIRE_ADMIN_2FA = "require_admin_2fa"
+)
+
+// GetSetting возвращает значение настройки или пустую строку, если она не задана
+func (r *Repository) GetSetting(ctx context.Context, key string) (value string, err error) {
+	row := r.pool.QueryRow(ctx, `select value from settings where key = $1`, key)
+
+	err = row.Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) PutSetting(ctx context.Context, key, value string) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into settings (key, value) values ($1, $2) on conflict (key) do update set value = excluded.value`, key, value)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type shelfKind string
+
+// виды полок. Встроенные полки есть у каждого пользователя, их нельзя удалить
+const (
+	SHELF_FAVORITES shelfKind = "favorites"
+	SHELF_WANT      shelfKind = "want"
+	SHELF_READ      shelfKind = "read"
+	SHELF_CUSTOM    shelfKind = "custom"
+)
+
+type Shelf struct {
+	Shelf_Id  int64     `json:"shelf_id" db:"shelf_id"`
+	User_Id   uuid.UUID `json:"user_id" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	Kind      shelfKind `json:"kind" db:"kind"`
+	Shared    bool      `json:"shared" db:"-"`
+	Token     string    `json:"-" db:"share_token"`
+	Count     int       `json:"count" db:"-"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Builtin - встроенная полка
+func (s Shelf) Builtin() bool {
+	return s.Kind != SHELF_CUSTOM
+}
+
+const shelfColumns = `s.shelf_id, s.user_id, s.name, s.kind, coalesce(s.share_token, ''), s.created_at,
+	(select count(*) from shelf_books sb join books b on b.book_id = sb.book_id where sb.shelf_id = s.shelf_id)`
+
+func scanShelf(row interface{ Scan(...interface{}) error }) (s Shelf, err error) {
+	err = row.Scan(&s.Shelf_Id, &s.User_Id, &s.Name, &s.Kind, &s.Token, &s.CreatedAt, &s.Count)
+	s.Shared = s.Token != ""
+	return
+}
+
+// Shelves возвращает полки пользователя: сначала встроенные, потом свои по названию.
+// Встроенные полки создаются при первом обращении
+func (r *Repository) Shelves(ctx context.Context, userId string) (shelves []Shelf, err error) {
+	_, err = r.pool.Exec(ctx, `insert into shelves (user_id, name, kind) values
+		($1, 'Избранное', 'favorites'), ($1, 'Хочу прочитать', 'want'), ($1, 'Прочитано', 'read') on conflict do nothing`, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	rows, err := r.pool.Query(ctx, `select `+shelfColumns+` from shelves s where s.user_id = $1
+		order by array_position(array['favorites', 'want', 'read', 'custom'], s.kind), lower(s.name)`, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Shelf
+		s, err = scanShelf(rows)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		shelves = append(shelves, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// UserShelf возвращает полку, если она принадлежит пользователю
+func (r *Repository) UserShelf(ctx context.Context, userId string, id int64) (s Shelf, err error) {
+	s, err = scanShelf(r.pool.QueryRow(ctx, `select `+shelfColumns+` from shelves s where s.shelf_id = $1 and s.user_id = $2`, id, userId))
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// SharedShelf находит полку по ссылке, которой с ней поделились
+func (r *Repository) SharedShelf(ctx context.Context, token string) (s Shelf, err error) {
+	s, err = scanShelf(r.pool.QueryRow(ctx, `select `+shelfColumns+` from shelves s where s.share_token = $1`, token))
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// AddShelf создает свою полку. Название уникально у пользователя без учета регистра
+func (r *Repository) AddShelf(ctx context.Context, userId, name string) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into shelves (user_id, name, kind) values ($1, $2, 'custom')`, userId, name)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// RenameShelf переименовывает свою полку. Встроенные полки не переименовываются
+func (r *Repository) RenameShelf(ctx context.Context, userId string, id int64, name string) (err error) {
+	_, err = r.pool.Exec(ctx, `update shelves set name = $3 where shelf_id = $1 and user_id = $2 and kind = 'custom'`, id, userId, name)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteShelf удаляет свою полку вместе со списком книг. Встроенные полки не удаляются
+func (r *Repository) DeleteShelf(ctx context.Context, userId string, id int64) (err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `delete from shelves where shelf_id = $1 and user_id = $2 and kind = 'custom'`, id, userId)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		return
+	}
+	_, err = tx.Exec(ctx, `delete from shelf_books where shelf_id = $1`, id)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+	}
+
+	return
+}
+
+// ShareShelf открывает полку по ссылке с токеном или, если token пустой, закрывает ее
+func (r *Repository) ShareShelf(ctx context.Context, userId string, id int64, token string) (err error) {
+	_, err = r.pool.Exec(ctx, `update shelves set share_token = nullif($3, '') where shelf_id = $1 and user_id = $2`, id, userId, token)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// AddToShelf ставит книгу на полку пользователя. Повторное добавление ничего не меняет
+func (r *Repository) AddToShelf(ctx context.Context, userId string, id int64, bookId string) (ok bool, err error) {
+	tag, err := r.pool.Exec(ctx, `insert into shelf_books (shelf_id, book_id)
+		select shelf_id, $3 from shelves where shelf_id = $1 and user_id = $2 on conflict do nothing`, id, userId, bookId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) RemoveFromShelf(ctx context.Context, userId string, id int64, bookId string) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from shelf_books where shelf_id = (select shelf_id from shelves where shelf_id = $1 and user_id = $2) and book_id = $3`, id, userId, bookId)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// BookShelves возвращает номера полок пользователя, на которых стоит книга
+func (r *Repository) BookShelves(ctx context.Context, userId, bookId string) (ids map[int64]bool, err error) {
+	ids = make(map[int64]bool)
+	rows, err := r.pool.Query(ctx, `select sb.shelf_id from shelf_books sb join shelves s on s.shelf_id = sb.shelf_id where s.user_id = $1 and sb.book_id = $2`, userId, bookId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		ids[id] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// ShelfBooks - страница книг полки, последние добавленные первыми. total - всего книг на полке
+func (r *Repository) ShelfBooks(ctx context.Context, id int64, pageNumber, pageSize int) (p Page, total int, err error) {
+	if pageNumber < 1 {
+		pageNumber = 1
+	}
+	rows, err := r.pool.Query(ctx, `select `+bookColumns+`, count(*) over () from shelf_books join books using (book_id)
+		where shelf_id = $1 and visible order by added_at desc, book_id limit $2 offset $3`, id, pageSize, (pageNumber-1)*pageSize)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields(&total)...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		p.Books = append(p.Books, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+		return
+	}
+
+	p.PageCount = int(math.Ceil(float64(total) / float64(pageSize)))
+	for i := 1; i <= p.PageCount; i++ {
+		p.Str = append(p.Str, i)
+	}
+	p.Number = pageNumber
+	p.NextNumber = pageNumber + 1
+	p.PrevNumber = pageNumber - 1
+
+	ids := make([]uuid.UUID, len(p.Books))
+	for i, b := range p.Books {
+		ids[i] = b.Book_Id
+	}
+	p.Ratings, err = r.BookRatings(ctx, ids)
+
+	return
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type tokenPurpose string
+
+const (
+	VERIFY_EMAIL   tokenPurpose = "verify_email"
+	RESET_PASSWORD tokenPurpose = "reset_password"
+)
+
+func (r *Repository) AddUserToken(ctx context.Context, tokenHash, userId string, purpose string, expires time.Time) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into user_tokens (token_hash, user_id, purpose, expires_at) values ($1, $2, $3, $4)`, tokenHash, userId, purpose, expires)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// ConsumeUserToken помечает токен использованным и возвращает владельца.
+// Просроченный, уже использованный или чужого назначения токен дает ошибку.
+func (r *Repository) ConsumeUserToken(ctx context.Context, tokenHash string, purpose string) (userId string, err error) {
+	row := r.pool.QueryRow(ctx, `update user_tokens set used_at = now() where token_hash = $1 and purpose = $2 and used_at is null and expires_at > now() returning user_id::text`, tokenHash, purpose)
+
+	err = row.Scan(&userId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// purgeBooksSQL удаляет навсегда книги, подходящие под условие, вместе с тем, что на них ссылается,
+// и возвращает их число. Таблицы связаны без внешних ключей, поэтому чистятся явно
+const purgeBooksSQL = `with gone as (delete from books where deleted_at is not null and %s returning book_id),
+	basket as (delete from basket_items where book_id in (select book_id from gone)),
+	shelved as (delete from shelf_books where book_id in (select book_id from gone)),
+	reviewed as (delete from reviews where book_id in (select book_id from gone)),
+	similar as (delete from book_similarities where book_id in (select book_id from gone) or similar_id in (select book_id from gone)),
+	history as (delete from book_revisions where book_id in (select book_id from gone))
+	select count(*) from gone`
+
+// purgeUsersSQL - то же для пользователей: с ними удаляются входы, токены, полки, корзина, подписки и отзывы
+const purgeUsersSQL = `with gone as (delete from users where deleted_at is not null and %s returning user_id),
+	identities as (delete from user_identities where user_id in (select user_id from gone)),
+	api as (delete from api_tokens where user_id in (select user_id from gone)),
+	tokens as (delete from user_tokens where user_id in (select user_id from gone)),
+	recovery as (delete from recovery_codes where user_id in (select user_id from gone)),
+	basket as (delete from basket_items where user_id in (select user_id from gone)),
+	shelved as (delete from shelf_books where shelf_id in (select shelf_id from shelves where user_id in (select user_id from gone))),
+	shelved_lists as (delete from shelves where user_id in (select user_id from gone)),
+	followed as (delete from follows where user_id in (select user_id from gone)),
+	reviewed as (delete from reviews where user_id in (select user_id from gone))
+	select count(*) from gone`
+
+// deletedBooksSQL выбирает только книги в корзине, последние удаленные первыми
+const deletedBooksSQL = `select ` + bookColumns + `, deleted_at from books where deleted_at is not null order by deleted_at desc, book_id`
+
+// restoreBookSQL возвращает книгу из корзины; видимость считается тем же условием, что и Book.PublishedAt
+const restoreBookSQL = `update books set deleted_at = null, visible = ` + publishedNow + `,
+	announced_at = case when ` + publishedNow + ` then coalesce(announced_at, now()) else announced_at end, version = version + 1
+	where book_id = $1 and deleted_at is not null returning ` + bookColumns
+
+// DeletedBooks - книги в корзине, последние удаленные первыми
+func (r *Repository) DeletedBooks(ctx context.Context) (books []Book, err error) {
+	rows, err := r.pool.Query(ctx, deletedBooksSQL)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		err = rows.Scan(b.fields(&b.DeletedAt)...)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		books = append(books, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// RestoreBook возвращает книгу из корзины. Видимость пересчитывается по датам публикации,
+// как при сохранении. Книги нет в корзине - pgx.ErrNoRows
+func (r *Repository) RestoreBook(ctx context.Context, id string) (b Book, err error) {
+	row := r.pool.QueryRow(ctx, restoreBookSQL, id)
+
+	err = row.Scan(b.fields()...)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// PurgeBook удаляет книгу из корзины навсегда. Книги нет в корзине - pgx.ErrNoRows
+func (r *Repository) PurgeBook(ctx context.Context, id string) (err error) {
+	var n int
+	err = r.pool.QueryRow(ctx, fmt.Sprintf(purgeBooksSQL, `book_id = $1`), id).Scan(&n)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	if n == 0 {
+		err = fmt.Errorf("book %s is not in trash: %w", id, pgx.ErrNoRows)
+	}
+
+	return
+}
+
+// DeletedUsers - пользователи в корзине, последние удаленные первыми
+func (r *Repository) DeletedUsers(ctx context.Context) (users []User, err error) {
+	rows, err := r.pool.Query(ctx, `select user_id, username, role, full_name, email, deleted_at from users where deleted_at is not null order by deleted_at desc, user_id`)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Email, &u.DeletedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return
+}
+
+// RestoreUser возвращает пользователя из корзины. Пользователя нет в корзине - pgx.ErrNoRows
+func (r *Repository) RestoreUser(ctx context.Context, id string) (u User, err error) {
+	row := r.pool.QueryRow(ctx, `update users set deleted_at = null where user_id = $1 and deleted_at is not null
+		returning user_id, username, role, full_name, active, email`, id)
+
+	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.Email)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// PurgeUser удаляет пользователя из корзины навсегда. Пользователя нет в корзине - pgx.ErrNoRows
+func (r *Repository) PurgeUser(ctx context.Context, id string) (err error) {
+	var n int
+	err = r.pool.QueryRow(ctx, fmt.Sprintf(purgeUsersSQL, `user_id = $1`), id).Scan(&n)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	if n == 0 {
+		err = fmt.Errorf("user %s is not in trash: %w", id, pgx.ErrNoRows)
+	}
+
+	return
+}
+
+// PurgeTrash удаляет навсегда книги и пользователей, убранных в корзину раньше before
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) (books, users int, err error) {
+	err = r.pool.QueryRow(ctx, fmt.Sprintf(purgeBooksSQL, `deleted_at < $1`), before).Scan(&books)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+	err = r.pool.QueryRow(ctx, fmt.Sprintf(purgeUsersSQL, `deleted_at < $1`), before).Scan(&users)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+	}
+
+	return
+}
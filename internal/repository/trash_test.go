@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var createTable = regexp.MustCompile(`^create table if not exists (\w+) \(`)
+
+// referencing - таблицы из миграций, в которых есть столбец, подходящий под column
+func referencing(column *regexp.Regexp) (tables []string) {
+	for _, m := range migrations {
+		name := createTable.FindStringSubmatch(m)
+		if name != nil && column.MatchString(m) {
+			tables = append(tables, name[1])
+		}
+	}
+	return
+}
+
+func TestPurgeSQLCoversReferences(t *testing.T) {
+	for _, c := range []struct {
+		name, sql string
+		owner     string
+		column    *regexp.Regexp
+		// kept - таблицы, где ссылка намеренно остается: история правок и журнал аудита
+		kept map[string]bool
+	}{
+		{"books", purgeBooksSQL, "books", regexp.MustCompile(`\s(book_id|similar_id) uuid`), nil},
+		{"users", purgeUsersSQL, "users", regexp.MustCompile(`\suser_id uuid`), map[string]bool{"book_revisions": true}},
+	} {
+		tables := referencing(c.column)
+		if len(tables) == 0 {
+			t.Fatalf("%s: no referencing tables found in migrations", c.name)
+		}
+		for _, table := range tables {
+			if table == c.owner || c.kept[table] {
+				continue
+			}
+			if !strings.Contains(c.sql, "delete from "+table+" where") {
+				t.Errorf("purging %s leaves rows in %s", c.name, table)
+			}
+		}
+
+		sql := fmt.Sprintf(c.sql, "id = $1")
+		if !strings.Contains(sql, "delete from "+c.owner+" where deleted_at is not null and id = $1 returning") {
+			t.Errorf("purging %s is not limited to rows in trash: %s", c.name, sql)
+		}
+		if strings.Count(c.sql, "%") != 1 {
+			t.Errorf("%s: condition placeholder used %d times", c.name, strings.Count(c.sql, "%"))
+		}
+	}
+
+	if !strings.Contains(purgeUsersSQL, "delete from shelf_books where shelf_id in (select shelf_id from shelves where user_id in") {
+		t.Error("purging users leaves books on their shelves")
+	}
+}
+
+func TestTrashQueries(t *testing.T) {
+	if !strings.Contains(deletedBooksSQL, "where deleted_at is not null") {
+		t.Errorf("DeletedBooks lists live books: %s", deletedBooksSQL)
+	}
+	if !strings.Contains(restoreBookSQL, "visible = "+publishedNow) || !strings.Contains(restoreBookSQL, "where book_id = $1 and deleted_at is not null") {
+		t.Errorf("RestoreBook does not recompute visibility for books in trash: %s", restoreBookSQL)
+	}
+	// publishedNow и PublishedAt должны совпадать на границах: публикация в now видна, снятие в now - уже нет
+	if !strings.Contains(publishedNow, "publication <= now()") || !strings.Contains(publishedNow, "unpublish_at > now()") {
+		t.Errorf("publishedNow differs from PublishedAt: %s", publishedNow)
+	}
+}
+
+func TestRestoredBookVisibility(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	past, future := now.AddDate(0, -1, 0), now.AddDate(0, 1, 0)
+	for _, c := range []struct {
+		name string
+		b    Book
+		want bool
+	}{
+		{"published before deletion", Book{Publication: past, Visible: true}, true},
+		{"deleted while hidden, publication date passed in trash", Book{Publication: past, Visible: false}, true},
+		{"publication still ahead", Book{Publication: future, Visible: true}, false},
+		{"unpublish date passed in trash", Book{Publication: past.AddDate(0, -1, 0), UnpublishAt: &past, Visible: true}, false},
+		{"unpublish date ahead", Book{Publication: past, UnpublishAt: &future}, true},
+	} {
+		// при восстановлении прежнее значение visible не учитывается, только даты
+		if got := c.b.PublishedAt(now); got != c.want {
+			t.Errorf("%s: visible %v, want %v", c.name, got, c.want)
+		}
+	}
+}
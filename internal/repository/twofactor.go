@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+func (r *Repository) PutTOTP(ctx context.Context, id, secret string, enabled bool) (err error) {
+	_, err = r.pool.Exec(ctx, `update users set totp_secret = $1, totp_enabled = $2, totp_last_step = 0 where user_id = $3`, secret, enabled, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// UseTOTPStep запоминает последний принятый шаг TOTP; повторный или более старый код отклоняется
+func (r *Repository) UseTOTPStep(ctx context.Context, id string, step int64) (ok bool, err error) {
+	tag, err := r.pool.Exec(ctx, `update users set totp_last_step = $1 where user_id = $2 and totp_last_step < $1`, step, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// PutRecoveryCodes заменяет все резервные коды пользователя новыми хешами
+func (r *Repository) PutRecoveryCodes(ctx context.Context, id string, hashes []string) (err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `delete from recovery_codes where user_id = $1`, id)
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	for _, h := range hashes {
+		_, err = tx.Exec(ctx, `insert into recovery_codes (user_id, code_hash) values ($1, $2)`, id, h)
+		if err != nil {
+			err = fmt.Errorf("failed to exec data: %w", err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to commit tx: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, id, hash string) (ok bool, err error) {
+	tag, err := r.pool.Exec(ctx, `update recovery_codes set used_at = now() where user_id = $1 and code_hash = $2 and used_at is null`, id, hash)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Repository) CountRecoveryCodes(ctx context.Context, id string) (count int, err error) {
+	row := r.pool.QueryRow(ctx, `select count(*) from recovery_codes where user_id = $1 and used_at is null`, id)
+
+	err = row.Scan(&count)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,18 +16,26 @@ const (
 )
 
 type User struct {
-	User_Id        uuid.UUID `json:"user_id" db:"user_id"`
-	Username       string    `json:"login" db:"usernamen"`
-	Role           roles     `json:"role" db:"role"`
-	FullName       string    `json:"full_name" db:"full_name"`
-	HashedPassword string    `json:"hashed_password" db:"hashed_password"`
-	Active         bool      `json:"activ" db:"active"`
+	User_Id             uuid.UUID  `json:"user_id" db:"user_id"`
+	Username            string     `json:"login" db:"usernamen"`
+	Role                roles      `json:"role" db:"role"`
+	FullName            string     `json:"full_name" db:"full_name"`
+	HashedPassword      string     `json:"-" db:"hashed_password"`
+	Active              bool       `json:"activ" db:"active"`
+	Email               string     `json:"email" db:"email"`
+	EmailVerified       bool       `json:"email_verified" db:"email_verified"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	Preferences         []string   `json:"preferences" db:"preferences"`
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at" db:"deletion_requested_at"`
+	TOTPSecret          string     `json:"-" db:"totp_secret"`
+	TOTPEnabled         bool       `json:"totp_enabled" db:"totp_enabled"`
+	DeletedAt           *time.Time `json:"-" db:"deleted_at"`
 }
 
 func (r *Repository) Login(ctx context.Context, login, hashedPassword string) (u User, err error) {
-	row := r.pool.QueryRow(ctx, `select user_id, username, role, full_name, active from users where username = $1 AND hashed_password = $2`, login, hashedPassword)
+	row := r.pool.QueryRow(ctx, `select user_id, username, role, full_name, active, totp_enabled from users where username = $1 AND hashed_password = $2 and deleted_at is null`, login, hashedPassword)
 
-	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active)
+	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.TOTPEnabled)
 
 	if err != nil {
 		err = fmt.Errorf("failed to query data: %w", err)
@@ -36,10 +45,10 @@ func (r *Repository) Login(ctx context.Context, login, hashedPassword string) (u
 	return
 }
 
-func (r *Repository) AddNewUser(ctx context.Context, username, full_name, hashedPassword string) (err error) {
+func (r *Repository) AddNewUser(ctx context.Context, username, full_name, hashedPassword, email string) (err error) {
 	roles := USER
 	active := true
-	_, err = r.pool.Exec(ctx, `insert into users (username, role, full_name, hashed_password, active) values ($1, $2,$3, $4, $5)`, username, roles, full_name, hashedPassword, active)
+	_, err = r.pool.Exec(ctx, `insert into users (username, role, full_name, hashed_password, active, email) values ($1, $2,$3, $4, $5, $6)`, username, roles, full_name, hashedPassword, active, email)
 
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
@@ -50,7 +59,7 @@ func (r *Repository) AddNewUser(ctx context.Context, username, full_name, hashed
 }
 
 func (r *Repository) AllUser(ctx context.Context) (users []User, err error) {
-	rows, err := r.pool.Query(ctx, `select user_id, username, role, full_name, active from users`)
+	rows, err := r.pool.Query(ctx, `select user_id, username, role, full_name, active, deletion_requested_at, totp_enabled from users where deleted_at is null`)
 	if err != nil {
 		err = fmt.Errorf("failed to query data: %w", err)
 		return
@@ -59,7 +68,7 @@ func (r *Repository) AllUser(ctx context.Context) (users []User, err error) {
 
 	for rows.Next() {
 		var u User
-		err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active)
+		err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.DeletionRequestedAt, &u.TOTPEnabled)
 		if err != nil {
 			err = fmt.Errorf("failed to scan data: %w", err)
 			return
@@ -69,8 +78,36 @@ func (r *Repository) AllUser(ctx context.Context) (users []User, err error) {
 	return
 }
 
+// FindUsers возвращает страницу пользователей по порядку логинов и их общее число
+func (r *Repository) FindUsers(ctx context.Context, limit, offset int) (users []User, total int, err error) {
+	rows, err := r.pool.Query(ctx, `select user_id, username, role, full_name, active, deletion_requested_at, totp_enabled, count(*) over () from users
+		where deleted_at is null order by username limit $1 offset $2`, limit, offset)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.DeletionRequestedAt, &u.TOTPEnabled, &total)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// DeleteUserById убирает пользователя в корзину: войти он не может, пока его не восстановят.
+// Логин остается занятым до окончательного удаления
 func (r *Repository) DeleteUserById(ctx context.Context, id string) (err error) {
-	_, err = r.pool.Exec(ctx, `delete from users where user_id = $1`, id)
+	_, err = r.pool.Exec(ctx, `update users set deleted_at = now() where user_id = $1 and deleted_at is null`, id)
 
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
@@ -81,9 +118,9 @@ func (r *Repository) DeleteUserById(ctx context.Context, id string) (err error)
 }
 
 func (r *Repository) GetUserById(ctx context.Context, id string) (u User, err error) {
-	rows := r.pool.QueryRow(ctx, `select * from users where user_id = $1`, id)
+	rows := r.pool.QueryRow(ctx, `select user_id, username, role, full_name, hashed_password, active, email, email_verified, created_at, preferences, deletion_requested_at, totp_secret, totp_enabled from users where user_id = $1 and deleted_at is null`, id)
 
-	err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.HashedPassword, &u.Active)
+	err = rows.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.HashedPassword, &u.Active, &u.Email, &u.EmailVerified, &u.CreatedAt, &u.Preferences, &u.DeletionRequestedAt, &u.TOTPSecret, &u.TOTPEnabled)
 	if err != nil {
 		err = fmt.Errorf("failed to exec data: %w", err)
 		return
@@ -102,3 +139,83 @@ func (r *Repository) PutUserById(ctx context.Context, role, act, id string) (err
 
 	return
 }
+
+// GetUserByLoginOrEmail ищет пользователя по логину, а если такого логина нет - по адресу почты.
+// Адрес, указанный у нескольких пользователей, не определяет пользователя: возвращается ошибка
+func (r *Repository) GetUserByLoginOrEmail(ctx context.Context, login string) (u User, err error) {
+	var matches int
+	row := r.pool.QueryRow(ctx, `select user_id, username, role, full_name, active, email, email_verified, count(*) over () from users
+		where deleted_at is null and (username = $1 or (email <> '' and lower(email) = lower($1))) order by username = $1 desc limit 1`, login)
+
+	err = row.Scan(&u.User_Id, &u.Username, &u.Role, &u.FullName, &u.Active, &u.Email, &u.EmailVerified, &matches)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	if u.Username != login && matches > 1 {
+		u = User{}
+		err = fmt.Errorf("email %q belongs to %d users", login, matches)
+		return
+	}
+
+	return
+}
+
+// EmailInUse сообщает, указан ли адрес у какого-либо пользователя, включая удаленных
+func (r *Repository) EmailInUse(ctx context.Context, email string) (used bool, err error) {
+	err = r.pool.QueryRow(ctx, `select exists (select 1 from users where email <> '' and lower(email) = lower($1))`, email).Scan(&used)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+	}
+
+	return
+}
+
+func (r *Repository) SetEmailVerified(ctx context.Context, id string) (err error) {
+	_, err = r.pool.Exec(ctx, `update users set email_verified = true where user_id = $1`, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) SetPassword(ctx context.Context, id, hashedPassword string) (err error) {
+	_, err = r.pool.Exec(ctx, `update users set hashed_password = $1 where user_id = $2`, hashedPassword, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) PutProfile(ctx context.Context, id, fullName string, preferences []string) (err error) {
+	_, err = r.pool.Exec(ctx, `update users set full_name = $1, preferences = $2 where user_id = $3`, fullName, preferences, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// RequestDeletion отмечает, что пользователь просит удалить аккаунт; удаляет администратор
+func (r *Repository) RequestDeletion(ctx context.Context, id string, requested bool) (err error) {
+	if requested {
+		_, err = r.pool.Exec(ctx, `update users set deletion_requested_at = now() where user_id = $1`, id)
+	} else {
+		_, err = r.pool.Exec(ctx, `update users set deletion_requested_at = null where user_id = $1`, id)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
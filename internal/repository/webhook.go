@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type event string
+
+// события, на которые можно подписать вебхук
+const (
+	EVENT_BOOK_CREATED event = "book.created"
+	EVENT_BOOK_UPDATED event = "book.updated"
+	EVENT_BOOK_DELETED event = "book.deleted"
+	// книга стала видна читателям: сразу при сохранении или когда наступила дата публикации
+	EVENT_BOOK_PUBLISHED event = "book.published"
+	// книга скрыта от читателей: наступила дата снятия с публикации или дату публикации перенесли
+	EVENT_BOOK_UNPUBLISHED event = "book.unpublished"
+	EVENT_USER_CREATED     event = "user.created"
+	EVENT_USER_BLOCKED     event = "user.blocked"
+	// EVENT_PING отправляется кнопкой проверки и доставляется независимо от подписки
+	EVENT_PING event = "ping"
+	// события только для живой ленты администратора, на них нельзя подписать вебхук
+	EVENT_LOGIN_FAILED event = "login.failed"
+	EVENT_BOOK_OPENED  event = "book.opened"
+)
+
+var Events = []event{EVENT_BOOK_CREATED, EVENT_BOOK_UPDATED, EVENT_BOOK_DELETED, EVENT_BOOK_PUBLISHED, EVENT_BOOK_UNPUBLISHED, EVENT_USER_CREATED, EVENT_USER_BLOCKED}
+
+// состояния доставки
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+type Webhook struct {
+	Webhook_Id int64     `json:"webhook_id" db:"webhook_id"`
+	URL        string    `json:"url" db:"url"`
+	Secret     string    `json:"-" db:"secret"`
+	Events     []string  `json:"events" db:"events"`
+	Active     bool      `json:"active" db:"active"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery - одна отправка события одному вебхуку. URL и Secret заполняются только в ClaimDeliveries
+type WebhookDelivery struct {
+	Delivery_Id   int64      `json:"delivery_id" db:"delivery_id"`
+	Webhook_Id    int64      `json:"webhook_id" db:"webhook_id"`
+	Event         string     `json:"event" db:"event"`
+	Payload       string     `json:"payload" db:"payload"`
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	ResponseCode  int        `json:"response_code" db:"response_code"`
+	Response      string     `json:"response" db:"response"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at" db:"delivered_at"`
+	URL           string     `json:"-"`
+	Secret        string     `json:"-"`
+}
+
+const webhookColumns = `webhook_id, url, secret, events, active, created_at`
+
+const deliveryColumns = `d.delivery_id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.response_code, d.response, d.created_at, d.next_attempt_at, d.delivered_at`
+
+func (r *Repository) AddWebhook(ctx context.Context, url, secret string, events []string) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into webhooks (url, secret, events) values ($1, $2, $3)`, url, secret, events)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+func (r *Repository) Webhooks(ctx context.Context) (hooks []Webhook, err error) {
+	rows, err := r.pool.Query(ctx, `select `+webhookColumns+` from webhooks order by created_at`)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h Webhook
+		err = rows.Scan(&h.Webhook_Id, &h.URL, &h.Secret, &h.Events, &h.Active, &h.CreatedAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		hooks = append(hooks, h)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+func (r *Repository) GetWebhook(ctx context.Context, id string) (h Webhook, err error) {
+	row := r.pool.QueryRow(ctx, `select `+webhookColumns+` from webhooks where webhook_id::text = $1`, id)
+
+	err = row.Scan(&h.Webhook_Id, &h.URL, &h.Secret, &h.Events, &h.Active, &h.CreatedAt)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// SetWebhookActive включает или приостанавливает вебхук. У приостановленного события не копятся,
+// но уже поставленные в очередь доставки продолжаются
+func (r *Repository) SetWebhookActive(ctx context.Context, id string, active bool) (err error) {
+	_, err = r.pool.Exec(ctx, `update webhooks set active = $2 where webhook_id::text = $1`, id, active)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// DeleteWebhook удаляет вебхук вместе с журналом его доставок
+func (r *Repository) DeleteWebhook(ctx context.Context, id string) (err error) {
+	_, err = r.pool.Exec(ctx, `with d as (delete from webhook_deliveries where webhook_id::text = $1)
+		delete from webhooks where webhook_id::text = $1`, id)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// EnqueueEvent ставит событие в очередь всем включенным вебхукам, подписанным на него.
+// Тело хранится текстом, а не jsonb, чтобы при повторах подпись считалась от тех же байтов
+func (r *Repository) EnqueueEvent(ctx context.Context, event string, payload []byte) (queued int64, err error) {
+	tag, err := r.pool.Exec(ctx, `insert into webhook_deliveries (webhook_id, event, payload)
+		select webhook_id, $1, $2 from webhooks where active and $1 = any(events)`, event, string(payload))
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// EnqueueDelivery ставит событие в очередь одному вебхуку независимо от подписки
+func (r *Repository) EnqueueDelivery(ctx context.Context, webhookId, event string, payload []byte) (err error) {
+	_, err = r.pool.Exec(ctx, `insert into webhook_deliveries (webhook_id, event, payload)
+		select webhook_id, $2, $3 from webhooks where webhook_id::text = $1`, webhookId, event, string(payload))
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// ClaimDeliveries выбирает доставки, время которых пришло, и откладывает их на lease, чтобы
+// их не взял другой экземпляр приложения. Если процесс упадет во время отправки, доставка
+// повторится после lease
+func (r *Repository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []WebhookDelivery, err error) {
+	rows, err := r.pool.Query(ctx, `update webhook_deliveries d set attempts = d.attempts + 1, next_attempt_at = now() + $2 * interval '1 second'
+		from webhooks w
+		where w.webhook_id = d.webhook_id and d.delivery_id in (
+			select delivery_id from webhook_deliveries where status = 'pending' and next_attempt_at <= now()
+			order by next_attempt_at limit $1 for update skip locked)
+		returning `+deliveryColumns+`, w.url, w.secret`, limit, lease.Seconds())
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d WebhookDelivery
+		err = rows.Scan(&d.Delivery_Id, &d.Webhook_Id, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.Response,
+			&d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt, &d.URL, &d.Secret)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// FinishDelivery записывает результат попытки: статус, код ответа и время следующей попытки
+func (r *Repository) FinishDelivery(ctx context.Context, d WebhookDelivery) (err error) {
+	_, err = r.pool.Exec(ctx, `update webhook_deliveries set status = $2, response_code = $3, response = $4, next_attempt_at = $5,
+		delivered_at = case when $2 = 'delivered' then now() end where delivery_id = $1`,
+		d.Delivery_Id, d.Status, d.ResponseCode, d.Response, d.NextAttemptAt)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
+
+// WebhookDeliveries возвращает последние доставки вебхука, новые первыми
+func (r *Repository) WebhookDeliveries(ctx context.Context, webhookId string, limit int) (deliveries []WebhookDelivery, err error) {
+	rows, err := r.pool.Query(ctx, `select `+deliveryColumns+` from webhook_deliveries d
+		where d.webhook_id::text = $1 order by d.created_at desc, d.delivery_id desc limit $2`, webhookId, limit)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d WebhookDelivery
+		err = rows.Scan(&d.Delivery_Id, &d.Webhook_Id, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.Response,
+			&d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt)
+		if err != nil {
+			err = fmt.Errorf("failed to scan data: %w", err)
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = fmt.Errorf("failed to read rows: %w", err)
+	}
+	return
+}
+
+// RedeliverWebhook возвращает доставку в очередь с обнуленным счетчиком попыток
+func (r *Repository) RedeliverWebhook(ctx context.Context, deliveryId string) (webhookId int64, err error) {
+	row := r.pool.QueryRow(ctx, `update webhook_deliveries set status = 'pending', attempts = 0, next_attempt_at = now()
+		where delivery_id::text = $1 returning webhook_id`, deliveryId)
+
+	err = row.Scan(&webhookId)
+	if err != nil {
+		err = fmt.Errorf("failed to query data: %w", err)
+		return
+	}
+
+	return
+}
+
+// PruneDeliveries удаляет завершенные доставки старше before
+func (r *Repository) PruneDeliveries(ctx context.Context, before time.Time) (err error) {
+	_, err = r.pool.Exec(ctx, `delete from webhook_deliveries where status <> 'pending' and created_at < $1`, before)
+
+	if err != nil {
+		err = fmt.Errorf("failed to exec data: %w", err)
+		return
+	}
+
+	return
+}
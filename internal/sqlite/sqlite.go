@@ -0,0 +1,442 @@
+// Package sqlite читает таблицы файла базы SQLite без драйвера и cgo. Поддерживается только то,
+// что нужно для импорта: обход таблиц-деревьев в кодировке UTF-8 с переполнением записей.
+// Индексы, WITHOUT ROWID-таблицы и журнал WAL не читаются, поэтому файл должен быть закрыт
+// программой, которая его пишет
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+var (
+	ErrFormat   = errors.New("sqlite: not a database file")
+	ErrNoTable  = errors.New("sqlite: no such table")
+	ErrCorrupt  = errors.New("sqlite: database is corrupt")
+	ErrWAL      = errors.New("sqlite: database has an uncommitted write-ahead log")
+	ErrEncoding = errors.New("sqlite: only UTF-8 databases are supported")
+)
+
+const headerMagic = "SQLite format 3\x00"
+
+// типы страниц B-дерева
+const (
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+)
+
+// maxDepth ограничивает глубину дерева, чтобы испорченный файл не зациклил обход
+const maxDepth = 64
+
+type table struct {
+	root    uint32
+	columns []string
+	// rowidColumn - колонка INTEGER PRIMARY KEY, которая хранится как rowid, или -1
+	rowidColumn int
+}
+
+type DB struct {
+	f        *os.File
+	pageSize int
+	usable   int
+	pages    uint32
+	tables   map[string]table
+}
+
+// Open открывает файл базы только для чтения и читает схему из sqlite_master
+func Open(path string) (db *DB, err error) {
+	if fi, err := os.Stat(path + "-wal"); err == nil && fi.Size() > 0 {
+		return nil, ErrWAL
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	header := make([]byte, 100)
+	_, err = io.ReadFull(f, header)
+	if err != nil || string(header[:16]) != headerMagic {
+		return nil, ErrFormat
+	}
+	if enc := binary.BigEndian.Uint32(header[56:]); enc != 0 && enc != 1 {
+		return nil, ErrEncoding
+	}
+
+	db = &DB{f: f, tables: make(map[string]table)}
+	db.pageSize = int(binary.BigEndian.Uint16(header[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, ErrFormat
+	}
+	db.usable = db.pageSize - int(header[20])
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	db.pages = uint32(fi.Size() / int64(db.pageSize))
+
+	master := table{root: 1, columns: []string{"type", "name", "tbl_name", "rootpage", "sql"}, rowidColumn: -1}
+	err = db.scan(master, func(row Row) error {
+		if row.Text("type") != "table" {
+			return nil
+		}
+		t := table{root: uint32(row.Int("rootpage")), rowidColumn: -1}
+		t.columns, t.rowidColumn = parseColumns(row.Text("sql"))
+		db.tables[strings.ToLower(row.Text("name"))] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) Close() error {
+	return db.f.Close()
+}
+
+// Row - строка таблицы: значения int64, float64, string, []byte или nil по именам колонок
+type Row map[string]interface{}
+
+// Int возвращает целое значение колонки или 0
+func (r Row) Int(column string) int64 {
+	switch v := r[column].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// Text возвращает текстовое значение колонки или пустую строку
+func (r Row) Text(column string) string {
+	switch v := r[column].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Each вызывает fn для каждой строки таблицы в порядке rowid. Строка в колонке "rowid" содержит rowid
+func (db *DB) Each(name string, fn func(row Row) error) error {
+	t, ok := db.tables[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoTable, name)
+	}
+	return db.scan(t, fn)
+}
+
+// HasTable сообщает, есть ли в базе таблица
+func (db *DB) HasTable(name string) bool {
+	_, ok := db.tables[strings.ToLower(name)]
+	return ok
+}
+
+func (db *DB) page(n uint32) ([]byte, error) {
+	if n < 1 || n > db.pages {
+		return nil, ErrCorrupt
+	}
+	p := make([]byte, db.pageSize)
+	_, err := db.f.ReadAt(p, int64(n-1)*int64(db.pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return p[:db.usable], nil
+}
+
+func (db *DB) scan(t table, fn func(row Row) error) error {
+	return db.walk(t, t.root, 0, fn)
+}
+
+func (db *DB) walk(t table, n uint32, depth int, fn func(row Row) error) error {
+	if depth > maxDepth {
+		return ErrCorrupt
+	}
+	p, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	// у первой страницы B-дерево начинается после заголовка файла
+	h := 0
+	if n == 1 {
+		h = 100
+	}
+	if len(p) < h+8 {
+		return ErrCorrupt
+	}
+	kind := p[h]
+	cells := int(binary.BigEndian.Uint16(p[h+3:]))
+	switch kind {
+	case pageLeafTable:
+		ptrs := h + 8
+		if len(p) < ptrs+2*cells {
+			return ErrCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(p[ptrs+2*i:]))
+			rowid, payload, err := db.leafCell(p, off)
+			if err != nil {
+				return err
+			}
+			row, err := decodeRecord(payload, t, rowid)
+			if err != nil {
+				return err
+			}
+			if err = fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case pageInteriorTable:
+		ptrs := h + 12
+		if len(p) < ptrs+2*cells {
+			return ErrCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(p[ptrs+2*i:]))
+			if off+4 > len(p) {
+				return ErrCorrupt
+			}
+			err = db.walk(t, binary.BigEndian.Uint32(p[off:]), depth+1, fn)
+			if err != nil {
+				return err
+			}
+		}
+		return db.walk(t, binary.BigEndian.Uint32(p[h+8:]), depth+1, fn)
+	}
+	return ErrCorrupt
+}
+
+// leafCell читает ячейку листа таблицы вместе с продолжением на страницах переполнения
+func (db *DB) leafCell(p []byte, off int) (rowid int64, payload []byte, err error) {
+	if off >= len(p) {
+		return 0, nil, ErrCorrupt
+	}
+	size, n := varint(p[off:])
+	off += n
+	if off >= len(p) {
+		return 0, nil, ErrCorrupt
+	}
+	r, n := varint(p[off:])
+	off += n
+	rowid = int64(r)
+	if size > uint64(db.pages)*uint64(db.usable) {
+		return 0, nil, ErrCorrupt
+	}
+
+	local := db.localSize(int(size))
+	if off+local > len(p) {
+		return 0, nil, ErrCorrupt
+	}
+	payload = make([]byte, 0, size)
+	payload = append(payload, p[off:off+local]...)
+	if local == int(size) {
+		return
+	}
+	if off+local+4 > len(p) {
+		return 0, nil, ErrCorrupt
+	}
+	next := binary.BigEndian.Uint32(p[off+local:])
+	for visited := uint32(0); len(payload) < int(size); visited++ {
+		if next == 0 || visited > db.pages {
+			return 0, nil, ErrCorrupt
+		}
+		op, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = binary.BigEndian.Uint32(op)
+		chunk := op[4:]
+		if rest := int(size) - len(payload); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+	}
+	return
+}
+
+// localSize - сколько байт записи хранится в самой ячейке листа таблицы
+func (db *DB) localSize(size int) int {
+	u := db.usable
+	x := u - 35
+	if size <= x {
+		return size
+	}
+	m := (u-12)*32/255 - 23
+	k := m + (size-m)%(u-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+func decodeRecord(payload []byte, t table, rowid int64) (Row, error) {
+	hsize, n := varint(payload)
+	if hsize > uint64(len(payload)) || hsize < uint64(n) || n == 0 {
+		return nil, ErrCorrupt
+	}
+	header := payload[n:hsize]
+	body := payload[hsize:]
+
+	row := Row{"rowid": rowid}
+	for i := 0; len(header) > 0; i++ {
+		st, n := varint(header)
+		header = header[n:]
+		v, size, err := value(st, body)
+		if err != nil {
+			return nil, err
+		}
+		body = body[size:]
+		if i < len(t.columns) {
+			row[t.columns[i]] = v
+		}
+	}
+	// колонки, добавленные ALTER TABLE после записи строки, в ней отсутствуют
+	for _, c := range t.columns {
+		if _, ok := row[c]; !ok {
+			row[c] = nil
+		}
+	}
+	if t.rowidColumn >= 0 {
+		row[t.columns[t.rowidColumn]] = rowid
+	}
+	return row, nil
+}
+
+// value декодирует значение по типу из заголовка записи
+func value(st uint64, body []byte) (v interface{}, size int, err error) {
+	switch {
+	case st == 0:
+		return nil, 0, nil
+	case st >= 1 && st <= 6:
+		size = []int{0, 1, 2, 3, 4, 6, 8}[st]
+		if len(body) < size {
+			return nil, 0, ErrCorrupt
+		}
+		var x int64
+		for _, b := range body[:size] {
+			x = x<<8 | int64(b)
+		}
+		// знаковое расширение
+		shift := 64 - 8*uint(size)
+		return x << shift >> shift, size, nil
+	case st == 7:
+		if len(body) < 8 {
+			return nil, 0, ErrCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(body)), 8, nil
+	case st == 8:
+		return int64(0), 0, nil
+	case st == 9:
+		return int64(1), 0, nil
+	case st >= 12:
+		size = int((st - 12) / 2)
+		if len(body) < size {
+			return nil, 0, ErrCorrupt
+		}
+		if st%2 == 0 {
+			return append([]byte{}, body[:size]...), size, nil
+		}
+		return string(body[:size]), size, nil
+	}
+	return nil, 0, ErrCorrupt
+}
+
+// varint читает целое переменной длины SQLite: до 9 байт, старший бит - продолжение
+func varint(b []byte) (v uint64, n int) {
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, len(b)
+}
+
+// parseColumns достает имена колонок из CREATE TABLE. Ограничения таблицы пропускаются,
+// колонка INTEGER PRIMARY KEY запоминается, потому что ее значение хранится как rowid
+func parseColumns(sql string) (columns []string, rowidColumn int) {
+	rowidColumn = -1
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return
+	}
+
+	var defs []string
+	depth, from := 0, start+1
+	var quote byte
+	for i := start + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[from:i])
+			from = i + 1
+		}
+	}
+	defs = append(defs, sql[from:end])
+
+	for _, d := range defs {
+		d = strings.TrimSpace(d)
+		upper := strings.ToUpper(d)
+		constraint := false
+		for _, k := range []string{"PRIMARY ", "UNIQUE", "CHECK", "FOREIGN ", "CONSTRAINT "} {
+			constraint = constraint || strings.HasPrefix(upper, k)
+		}
+		if constraint || d == "" {
+			continue
+		}
+		name, rest := columnName(d)
+		rest = strings.Join(strings.Fields(strings.ToUpper(rest)), " ")
+		if strings.HasPrefix(rest, "INTEGER PRIMARY KEY") && !strings.HasPrefix(rest, "INTEGER PRIMARY KEY DESC") {
+			rowidColumn = len(columns)
+		}
+		columns = append(columns, name)
+	}
+	return
+}
+
+func columnName(def string) (name, rest string) {
+	if def == "" {
+		return
+	}
+	if end := map[byte]byte{'"': '"', '`': '`', '[': ']', '\'': '\''}[def[0]]; end != 0 {
+		i := bytes.IndexByte([]byte(def[1:]), end)
+		if i >= 0 {
+			return def[1 : i+1], def[i+2:]
+		}
+	}
+	fields := strings.Fields(def)
+	return fields[0], strings.TrimPrefix(def, fields[0])
+}
@@ -0,0 +1,118 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/test.db создана sqlite3 со страницами по 512 байт, чтобы таблица заняла несколько
+// уровней дерева, а длинная строка ушла на страницы переполнения
+func TestEach(t *testing.T) {
+	db, err := Open(filepath.Join("testdata", "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var rows []Row
+	err = db.Each("items", func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 301 {
+		t.Fatalf("%d rows", len(rows))
+	}
+
+	first := rows[0]
+	if first.Int("id") != 3 || first.Int("rowid") != 3 || first.Text("name") != "строка 1" || first["score"] != 0.25 ||
+		first.Int("n") != -100000 || string(first["data"].([]byte)) != "\x01" || first["note"] != nil {
+		t.Errorf("first row %v", first)
+	}
+	if rows[1].Int("n") != 2 {
+		t.Errorf("second row %v", rows[1])
+	}
+	if long := rows[9]; long.Int("id") != 30 || long.Text("name") != strings.Repeat("длинная ", 400) {
+		t.Errorf("overflow row %d: %d bytes", long.Int("id"), len(long.Text("name")))
+	}
+	if last := rows[300]; last.Int("id") != 1000 || last.Text("note") != "заметка" {
+		t.Errorf("last row %v", last)
+	}
+
+	err = db.Each("empty", func(row Row) error {
+		t.Errorf("row in empty table: %v", row)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !db.HasTable("ITEMS") || db.HasTable("items_n") {
+		t.Error("HasTable")
+	}
+	if err = db.Each("missing", nil); !errors.Is(err, ErrNoTable) {
+		t.Errorf("missing table: %v", err)
+	}
+
+	stop := errors.New("stop")
+	if err = db.Each("items", func(Row) error { return stop }); err != stop {
+		t.Errorf("callback error: %v", err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bad.db")
+	os.WriteFile(path, []byte(strings.Repeat("x", 200)), 0o600)
+	if _, err := Open(path); !errors.Is(err, ErrFormat) {
+		t.Errorf("not a database: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join("testdata", "test.db"))
+	path = filepath.Join(dir, "wal.db")
+	os.WriteFile(path, data, 0o600)
+	os.WriteFile(path+"-wal", []byte("wal"), 0o600)
+	if _, err := Open(path); !errors.Is(err, ErrWAL) {
+		t.Errorf("wal: %v", err)
+	}
+
+	// обрезанный файл не должен ронять обход
+	os.WriteFile(path, data[:len(data)/3], 0o600)
+	os.Remove(path + "-wal")
+	if db, err := Open(path); err == nil {
+		err = db.Each("items", func(Row) error { return nil })
+		db.Close()
+		if err == nil {
+			t.Error("truncated database read without error")
+		}
+	}
+}
+
+func TestDecodeRecordCorrupt(t *testing.T) {
+	tbl := table{columns: []string{"id", "title"}, rowidColumn: -1}
+	for name, payload := range map[string][]byte{
+		"empty":                  {},
+		"header past payload":    {0x05, 0x01},
+		"header inside its size": {0x80, 0x01, 0x01},
+		"zero header size":       {0x00, 0x01},
+	} {
+		if _, err := decodeRecord(payload, tbl, 1); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, rowid := parseColumns(`CREATE TABLE books ( id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		title     TEXT NOT NULL DEFAULT 'Unknown' COLLATE NOCASE,
+		"sort" TEXT COLLATE NOCASE, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		series_index REAL NOT NULL DEFAULT 1.0, path TEXT NOT NULL DEFAULT "", [uuid] TEXT,
+		CONSTRAINT c UNIQUE (title, path), CHECK (series_index > 0))`)
+	if got := strings.Join(columns, ","); got != "id,title,sort,timestamp,series_index,path,uuid" || rowid != 0 {
+		t.Errorf("columns %q, rowid %d", got, rowid)
+	}
+}
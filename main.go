@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"biblio/internal/application"
+	"biblio/internal/mailer"
 	"biblio/internal/repository"
 
 	"github.com/julienschmidt/httprouter"
@@ -17,15 +19,36 @@ func main() {
 
 	dbpool, err := repository.InitDBConn(ctx)
 	if err != nil {
-		log.Fatalf("%w failed to init DB connection", err)
+		log.Fatalf("%v failed to init DB connection", err)
 	}
 	defer dbpool.Close()
 
-	a := application.NewApp(ctx, dbpool)
+	err = repository.Migrate(ctx, dbpool)
+	if err != nil {
+		log.Fatalf("%v failed to migrate DB", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err = runExport(ctx, repository.NewRepository(dbpool), os.Args[2:])
+		if err != nil {
+			log.Fatalf("%v failed to export catalog", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err = runImport(ctx, repository.NewRepository(dbpool), os.Args[2:])
+		if err != nil {
+			log.Fatalf("%v failed to import catalog", err)
+		}
+		return
+	}
+
+	a := application.NewApp(ctx, dbpool, mailer.FromEnv())
 	r := httprouter.New()
 	a.Routes(r)
+	a.StartJobs()
 
-	srv := &http.Server{Addr: "0.0.0.0:9090", Handler: r}
+	srv := &http.Server{Addr: "0.0.0.0:9090", Handler: a.CSRFProtect(r)}
 	fmt.Println("It is alive! Try http://localhost:9090")
 	srv.ListenAndServe()
 }
@@ -0,0 +1,223 @@
+// Просмотр спецификации /api/openapi.json: операции по разделам, параметры, схемы
+// и отправка пробных запросов. Внешних зависимостей нет, чтобы страница работала без интернета.
+(function () {
+    "use strict";
+
+    var spec;
+
+    function el(tag, attrs, children) {
+        var e = document.createElement(tag);
+        Object.keys(attrs || {}).forEach(function (k) {
+            if (k === "text") {
+                e.textContent = attrs[k];
+            } else {
+                e.setAttribute(k, attrs[k]);
+            }
+        });
+        (children || []).forEach(function (c) {
+            if (c) {
+                e.appendChild(c);
+            }
+        });
+        return e;
+    }
+
+    // resolve раскрывает ссылки вида #/components/... внутри документа
+    function resolve(obj, depth) {
+        depth = depth || 0;
+        if (!obj || typeof obj !== "object" || depth > 10) {
+            return obj;
+        }
+        if (obj.$ref) {
+            var target = obj.$ref.replace(/^#\//, "").split("/").reduce(function (o, k) {
+                return o && o[k];
+            }, spec);
+            return resolve(target, depth + 1);
+        }
+        if (Array.isArray(obj)) {
+            return obj.map(function (v) {
+                return resolve(v, depth + 1);
+            });
+        }
+        var out = {};
+        Object.keys(obj).forEach(function (k) {
+            out[k] = resolve(obj[k], depth + 1);
+        });
+        return out;
+    }
+
+    function schemaBlock(content) {
+        if (!content) {
+            return null;
+        }
+        var type = Object.keys(content)[0];
+        return el("div", {}, [
+            el("div", {class: "text-muted", text: type}),
+            el("pre", {text: JSON.stringify(resolve(content[type].schema), null, 2)})
+        ]);
+    }
+
+    function parametersTable(params, inputs) {
+        if (!params.length) {
+            return null;
+        }
+        var rows = params.map(function (p) {
+            var input = el("input", {class: "form-control input-sm", placeholder: p.name});
+            inputs.push({param: p, input: input});
+            return el("tr", {}, [
+                el("td", {text: p.name + (p.required ? " *" : "")}),
+                el("td", {text: p.in}),
+                el("td", {text: p.description || ""}),
+                el("td", {}, [input])
+            ]);
+        });
+        return el("table", {class: "table table-bordered"}, [
+            el("thead", {}, [el("tr", {}, ["Параметр", "Где", "Описание", "Значение"].map(function (h) {
+                return el("th", {text: h});
+            }))]),
+            el("tbody", {}, rows)
+        ]);
+    }
+
+    function tryIt(method, path, inputs, body, output) {
+        var url = path;
+        var query = [];
+        var headers = {};
+        inputs.forEach(function (i) {
+            var v = i.input.value;
+            if (v === "") {
+                return;
+            }
+            if (i.param.in === "path") {
+                url = url.replace("{" + i.param.name + "}", encodeURIComponent(v));
+            } else if (i.param.in === "query") {
+                query.push(encodeURIComponent(i.param.name) + "=" + encodeURIComponent(v));
+            } else if (i.param.in === "header") {
+                headers[i.param.name] = v;
+            }
+        });
+        if (query.length) {
+            url += "?" + query.join("&");
+        }
+        var token = document.getElementById("token").value;
+        if (token) {
+            headers.Authorization = "Bearer " + token;
+        }
+        var init = {method: method.toUpperCase(), headers: headers, credentials: "same-origin"};
+        if (body && body.value) {
+            headers["Content-Type"] = "application/json";
+            init.body = body.value;
+        }
+
+        output.textContent = "...";
+        fetch(url, init).then(function (resp) {
+            return resp.text().then(function (text) {
+                var lines = [resp.status + " " + resp.statusText];
+                ["ETag", "Location"].forEach(function (h) {
+                    if (resp.headers.get(h)) {
+                        lines.push(h + ": " + resp.headers.get(h));
+                    }
+                });
+                try {
+                    text = JSON.stringify(JSON.parse(text), null, 2);
+                } catch (e) {
+                    // не JSON - показываем как есть
+                }
+                output.textContent = lines.join("\n") + "\n\n" + text;
+            });
+        }).catch(function (err) {
+            output.textContent = String(err);
+        });
+    }
+
+    function operation(method, path, op) {
+        var inputs = [];
+        var body = null;
+        var output = el("pre", {});
+        var children = [];
+
+        if (op.description) {
+            children.push(el("p", {text: op.description}));
+        }
+        children.push(parametersTable((op.parameters || []).map(function (p) {
+            return resolve(p);
+        }), inputs));
+        if (op.requestBody) {
+            children.push(el("h5", {text: "Тело запроса"}));
+            children.push(schemaBlock(op.requestBody.content));
+            if (op.requestBody.content["application/json"]) {
+                body = el("textarea", {class: "form-control", rows: "6", placeholder: "{}"});
+                children.push(body);
+            }
+        }
+        children.push(el("h5", {text: "Ответы"}));
+        Object.keys(op.responses).forEach(function (code) {
+            var r = resolve(op.responses[code]);
+            children.push(el("div", {}, [el("b", {text: code + " "}), el("span", {text: r.description})]));
+            children.push(schemaBlock(r.content));
+        });
+
+        var send = el("button", {class: "btn btn-primary", type: "button", text: "Выполнить"});
+        send.addEventListener("click", function () {
+            tryIt(method, path, inputs, body, output);
+        });
+        children.push(send, output);
+
+        var head = el("div", {class: "api-op-head"}, [
+            el("span", {class: "api-method api-" + method, text: method.toUpperCase()}),
+            el("span", {class: "api-path", text: path}),
+            el("span", {text: op.summary || ""})
+        ]);
+        var box = el("div", {class: "api-op"}, [head, el("div", {class: "api-op-body"}, children)]);
+        head.addEventListener("click", function () {
+            box.classList.toggle("open");
+        });
+        return box;
+    }
+
+    function render() {
+        document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+        document.getElementById("description").textContent = spec.info.description || "";
+
+        var groups = {};
+        Object.keys(spec.paths).forEach(function (path) {
+            Object.keys(spec.paths[path]).forEach(function (method) {
+                var op = spec.paths[path][method];
+                var tag = (op.tags || ["Прочее"])[0];
+                (groups[tag] = groups[tag] || []).push(operation(method, path, op));
+            });
+        });
+
+        var root = document.getElementById("operations");
+        (spec.tags || []).map(function (t) {
+            return t.name;
+        }).concat(Object.keys(groups)).forEach(function (tag) {
+            if (!groups[tag]) {
+                return;
+            }
+            root.appendChild(el("h3", {text: tag}));
+            groups[tag].forEach(function (op) {
+                root.appendChild(op);
+            });
+            delete groups[tag];
+        });
+    }
+
+    var saved = sessionStorage.getItem("biblio-api-token");
+    var tokenInput = document.getElementById("token");
+    if (saved) {
+        tokenInput.value = saved;
+    }
+    tokenInput.addEventListener("change", function () {
+        sessionStorage.setItem("biblio-api-token", tokenInput.value);
+    });
+
+    fetch("/api/openapi.json").then(function (resp) {
+        return resp.json();
+    }).then(function (doc) {
+        spec = doc;
+        render();
+    }).catch(function (err) {
+        document.getElementById("operations").textContent = "Не удалось загрузить спецификацию: " + err;
+    });
+})();
@@ -0,0 +1,64 @@
+// Живая лента: читает поток /api/events и показывает события новыми сверху
+(function () {
+    var maxRows = 200;
+    var titles = {
+        "book.created": "Новая книга",
+        "book.updated": "Книга изменена",
+        "book.deleted": "Книга удалена",
+        "book.published": "Книга опубликована",
+        "book.unpublished": "Книга снята с публикации",
+        "user.created": "Регистрация",
+        "user.blocked": "Пользователь заблокирован",
+        "login.failed": "Неудачный вход",
+        "book.opened": "Книга открыта"
+    };
+    var reasons = {
+        "locked": "вход временно заблокирован",
+        "captcha": "неверный ответ на проверочный вопрос",
+        "denied": "доступ запрещен каталогом",
+        "password": "неверный логин или пароль",
+        "totp": "неверный код второго фактора"
+    };
+
+    function book(d) {
+        return "«" + d.name + "», " + d.author;
+    }
+
+    var details = {
+        "book.created": book,
+        "book.updated": book,
+        "book.deleted": book,
+        "book.published": book,
+        "book.unpublished": book,
+        "user.created": function (d) { return d.login + " (" + d.full_name + ")"; },
+        "user.blocked": function (d) { return d.login + " (" + d.full_name + ")"; },
+        "login.failed": function (d) { return d.login + " с адреса " + d.ip + ": " + (reasons[d.reason] || d.reason); },
+        "book.opened": function (d) { return book(d) + ", читатель " + d.login + (d.via === "opds" ? ", через OPDS" : ""); }
+    };
+
+    var status = document.getElementById("status");
+    var rows = document.getElementById("events");
+
+    function show(e) {
+        var ev = JSON.parse(e.data);
+        var tr = document.createElement("tr");
+        [new Date(ev.time).toLocaleString("ru-RU"), titles[ev.type] || ev.type, details[ev.type](ev.data)].forEach(function (text) {
+            var td = document.createElement("td");
+            td.textContent = text;
+            tr.appendChild(td);
+        });
+        rows.insertBefore(tr, rows.firstChild);
+        while (rows.children.length > maxRows) {
+            rows.removeChild(rows.lastChild);
+        }
+        var counter = document.getElementById("count-" + ev.type);
+        counter.textContent = Number(counter.textContent) + 1;
+    }
+
+    var source = new EventSource("/api/events");
+    Object.keys(titles).forEach(function (type) {
+        source.addEventListener(type, show);
+    });
+    source.onopen = function () { status.textContent = "подключено"; };
+    source.onerror = function () { status.textContent = "переподключение..."; };
+})();